@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/urfave/cli/v2"
+)
+
+var configCmd = &cli.Command{
+	Name:  "config",
+	Usage: "Work with the config.toml of the cp repo",
+	Subcommands: []*cli.Command{
+		configCheck,
+		configShow,
+		configSet,
+	},
+}
+
+var configCheck = &cli.Command{
+	Name:  "check",
+	Usage: "Report every problem of config.toml",
+	Action: func(cctx *cli.Context) error {
+		cpRepoPath := cctx.String(FlagCpRepo)
+		configFile := filepath.Join(cpRepoPath, "config.toml")
+		if _, err := conf.LoadConfig(cpRepoPath); err != nil {
+			return printConfigProblems(configFile, err)
+		}
+		fmt.Printf("%s is valid\n", configFile)
+		return nil
+	},
+}
+
+var configShow = &cli.Command{
+	Name:  "show",
+	Usage: "Print the config as the provider reads it, with the environment variables applied and secrets redacted",
+	Action: func(cctx *cli.Context) error {
+		cpRepoPath := cctx.String(FlagCpRepo)
+		c, err := conf.LoadConfig(cpRepoPath)
+		if err != nil {
+			return printConfigProblems(filepath.Join(cpRepoPath, "config.toml"), err)
+		}
+		overrides := conf.EnvOverrides()
+		var fields []string
+		for field := range overrides {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Printf("# %s is set by %s\n", field, overrides[field])
+		}
+		return toml.NewEncoder(os.Stdout).Encode(conf.Redact(c))
+	},
+}
+
+var configSet = &cli.Command{
+	Name:      "set",
+	Usage:     "Set a field of config.toml, a running provider picks it up",
+	ArgsUsage: "<Section.Field> <value>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "write the config even if it has problems",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return fmt.Errorf("incorrect number of arguments, got %d, expected 2", cctx.NArg())
+		}
+		key, value := cctx.Args().Get(0), cctx.Args().Get(1)
+
+		configFile := filepath.Join(cctx.String(FlagCpRepo), "config.toml")
+		info, err := os.Stat(configFile)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return err
+		}
+		updated, err := conf.SetValue(data, key, value)
+		if err != nil {
+			return err
+		}
+		// the problems the config already had don't block the fix of one of them
+		if problems := newConfigProblems(data, updated); problems != nil && !cctx.Bool("force") {
+			return printConfigProblems(configFile, problems)
+		}
+		data = updated
+
+		// the file is replaced at once, so a running provider never reads half of it
+		tmpFile := configFile + ".tmp"
+		if err = os.WriteFile(tmpFile, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err = os.Rename(tmpFile, configFile); err != nil {
+			return err
+		}
+
+		if conf.IsSecret(key) {
+			value = "********"
+		}
+		fmt.Printf("%s = %s\n", key, value)
+		for field, variable := range conf.EnvOverrides() {
+			if strings.EqualFold(field, key) {
+				fmt.Printf("%s is set, it overrides config.toml\n", variable)
+			}
+		}
+		return nil
+	},
+}
+
+func printConfigProblems(configFile string, err error) error {
+	validationErrors, ok := err.(conf.ValidationErrors)
+	if !ok {
+		return err
+	}
+	for _, validationError := range validationErrors {
+		fmt.Printf("%s: %s\n", configFile, validationError.Error())
+	}
+	return fmt.Errorf("%s has %d problem(s)", configFile, len(validationErrors))
+}
+
+// newConfigProblems returns the problems of the updated config that the config didn't have.
+func newConfigProblems(data, updated []byte) error {
+	_, err := conf.ParseConfig(updated)
+	if err == nil {
+		return nil
+	}
+	problems, ok := err.(conf.ValidationErrors)
+	if !ok {
+		return err
+	}
+	known := make(map[string]bool)
+	if _, err = conf.ParseConfig(data); err != nil {
+		if validationErrors, ok := err.(conf.ValidationErrors); ok {
+			for _, validationError := range validationErrors {
+				known[validationError.Error()] = true
+			}
+		}
+	}
+	var added conf.ValidationErrors
+	for _, problem := range problems {
+		if !known[problem.Error()] {
+			added = append(added, problem)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return added
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/computing"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/urfave/cli/v2"
+)
+
+var doctorCmd = &cli.Command{
+	Name:  "doctor",
+	Usage: "Check the config and the services the provider depends on",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the results as json",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		cpRepoPath := cctx.String(FlagCpRepo)
+
+		var results []models.CheckResult
+		if err := conf.InitConfig(cpRepoPath); err != nil {
+			// the other checks need the config, its problems are reported alone
+			results = configResults(err)
+		} else {
+			results = append(results, models.CheckResult{Name: "config", Status: models.CheckPass, Message: "config.toml is valid"})
+			results = append(results, computing.RunDoctorChecks(context.Background())...)
+		}
+
+		if cctx.Bool("json") {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(results); err != nil {
+				return err
+			}
+		} else {
+			printChecks(results)
+		}
+
+		var failed int
+		for _, result := range results {
+			if result.Status == models.CheckFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d check(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+func configResults(err error) []models.CheckResult {
+	validationErrors, ok := err.(conf.ValidationErrors)
+	if !ok {
+		return []models.CheckResult{{Name: "config", Status: models.CheckFail, Message: err.Error(), Hint: "computing-provider init"}}
+	}
+	var results []models.CheckResult
+	for _, validationError := range validationErrors {
+		results = append(results, models.CheckResult{
+			Name:    "config",
+			Status:  models.CheckFail,
+			Message: validationError.Error(),
+			Hint:    "computing-provider config set",
+		})
+	}
+	return results
+}
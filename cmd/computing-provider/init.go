@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	computingprovider "github.com/lagrangedao/go-computing-provider"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/bidding"
+	"github.com/lagrangedao/go-computing-provider/internal/computing"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// initField is a field of config.toml asked by init, Default is offered when nothing is given.
+type initField struct {
+	Flag    string
+	Key     string
+	Prompt  string
+	Default string
+	Secret  bool
+}
+
+var initFields = []initField{
+	{Flag: "multi-address", Key: "API.MultiAddress", Prompt: "The multiaddress of the provider, /ip4/<public_ip>/tcp/<port>"},
+	{Flag: "domain", Key: "API.Domain", Prompt: "The domain of the spaces, e.g. .cp.example.org"},
+	{Flag: "node-name", Key: "API.NodeName", Prompt: "The name of the provider node, empty for the host name"},
+	{Flag: "redis-url", Key: "API.RedisUrl", Prompt: "The redis server", Default: "redis://127.0.0.1:6379"},
+	{Flag: "redis-password", Key: "API.RedisPassword", Prompt: "The redis password", Secret: true},
+	{Flag: "lag-access-token", Key: "LAG.AccessToken", Prompt: "The Lagrange access token, from https://lagrangedao.org -> setting -> Access Tokens", Secret: true},
+	{Flag: "mcs-api-key", Key: "MCS.ApiKey", Prompt: "The MCS api key, from https://www.multichain.storage -> setting -> Create API Key", Secret: true},
+	{Flag: "mcs-bucket", Key: "MCS.BucketName", Prompt: "The MCS bucket"},
+	{Flag: "mcs-network", Key: "MCS.Network", Prompt: "The MCS network, polygon.mainnet or polygon.mumbai", Default: "polygon.mainnet"},
+	{Flag: "registry", Key: "Registry.ServerAddress", Prompt: "The image registry, empty for a single node"},
+	{Flag: "registry-user", Key: "Registry.UserName", Prompt: "The registry user"},
+	{Flag: "registry-password", Key: "Registry.Password", Prompt: "The registry password", Secret: true},
+	{Flag: "crt-file", Key: "LOG.CrtFile", Prompt: "The certificate file of the domain"},
+	{Flag: "key-file", Key: "LOG.KeyFile", Prompt: "The key file of the certificate"},
+}
+
+var initCmd = &cli.Command{
+	Name:  "init",
+	Usage: "Create a cp repo: its config, resource policy, bid policy and node key",
+	Flags: initFlags(),
+	Action: func(cctx *cli.Context) error {
+		cpRepoPath := cctx.String(FlagCpRepo)
+		if err := os.MkdirAll(cpRepoPath, 0755); err != nil {
+			return fmt.Errorf("failed create the cp repo %s, error: %w", cpRepoPath, err)
+		}
+		fmt.Printf("Initializing the cp repo %s\n", cpRepoPath)
+
+		ask := newAsker(!cctx.Bool("yes") && term.IsTerminal(int(os.Stdin.Fd())))
+		force := cctx.Bool("force")
+
+		configFile := filepath.Join(cpRepoPath, "config.toml")
+		var configData []byte
+		var err error
+		if keep(configFile, force) {
+			if configData, err = os.ReadFile(configFile); err != nil {
+				return err
+			}
+		} else {
+			if configData, err = initConfig(cctx, ask); err != nil {
+				return err
+			}
+			// the config holds the secrets of the provider
+			if err = os.WriteFile(configFile, configData, 0600); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", configFile)
+		}
+		c, err := conf.ParseConfig(configData)
+		if err != nil {
+			fmt.Printf("%s is not complete yet, fix it with `computing-provider config set`:\n", configFile)
+			if validationErrors, ok := err.(conf.ValidationErrors); ok {
+				for _, validationError := range validationErrors {
+					fmt.Printf("  %s\n", validationError.Error())
+				}
+			} else {
+				fmt.Printf("  %v\n", err)
+			}
+		}
+
+		policyFile := filepath.Join(cpRepoPath, computing.ResourcePolicyFile)
+		if !keep(policyFile, force) {
+			policy, err := initResourcePolicy(cctx, ask)
+			if err != nil {
+				return err
+			}
+			if err = os.WriteFile(policyFile, policy, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", policyFile)
+		}
+
+		bidPolicyFile := filepath.Join(cpRepoPath, computing.BidPolicyFile)
+		if !keep(bidPolicyFile, force) {
+			if err = os.WriteFile(bidPolicyFile, []byte(bidding.SamplePolicy), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", bidPolicyFile)
+		}
+
+		nodeID, _, address := computing.GenerateNodeID(cpRepoPath)
+		fmt.Printf("Node key %s, node id %s, address %s\n", filepath.Join(cpRepoPath, "private_key"), nodeID, address)
+
+		if cctx.Bool("skip-preflight") {
+			return nil
+		}
+		// a config that is not complete yet is still checked, with the environment overrides
+		if c == nil {
+			if c, err = conf.DecodeConfig(configData); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Preflight checks:")
+		results := []models.CheckResult{
+			computing.CheckRedis(c.API.RedisUrl, c.API.RedisPassword),
+			computing.CheckKubernetes(),
+			computing.CheckDocker(),
+		}
+		printChecks(results)
+		for _, result := range results {
+			if result.Status == models.CheckFail {
+				return errors.New("some preflight checks failed, fix them before `computing-provider run`")
+			}
+		}
+		return nil
+	},
+}
+
+func initFlags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "don't prompt, take the flags and the defaults"},
+		&cli.BoolFlag{Name: "force", Usage: "overwrite the config and the policies of an existing repo, the node key is kept"},
+		&cli.BoolFlag{Name: "skip-preflight", Usage: "don't check redis, kubernetes and docker"},
+		&cli.Int64Flag{Name: "cpu-quota", Usage: "the vCPUs that must stay free for the provider to be active"},
+		&cli.Int64Flag{Name: "memory-quota", Usage: "the GiB of memory that must stay free for the provider to be active"},
+		&cli.Int64Flag{Name: "storage-quota", Usage: "the GiB of storage that must stay free for the provider to be active"},
+		&cli.StringSliceFlag{Name: "gpu-quota", Usage: "the GPUs of a model that must stay free, e.g. Nvidia-A100=1"},
+	}
+	for _, field := range initFields {
+		flags = append(flags, &cli.StringFlag{Name: field.Flag, Usage: fmt.Sprintf("%s (%s)", field.Prompt, field.Key)})
+	}
+	return flags
+}
+
+// initConfig fills config.toml.sample from the flags and the answers, its comments are kept.
+func initConfig(cctx *cli.Context, ask asker) ([]byte, error) {
+	data := computingprovider.ConfigSample
+	for _, field := range initFields {
+		value := field.Default
+		if cctx.IsSet(field.Flag) {
+			value = cctx.String(field.Flag)
+		} else if ask.interactive {
+			value = ask.string(field.Prompt, field.Default, field.Secret)
+		}
+		if value == "" {
+			continue
+		}
+		var err error
+		if data, err = conf.SetValue(data, field.Key, value); err != nil {
+			return nil, fmt.Errorf("--%s: %w", field.Flag, err)
+		}
+	}
+	return data, nil
+}
+
+// initResourcePolicy sets the quotas of resource_policy.json, the resources that must stay free.
+func initResourcePolicy(cctx *cli.Context, ask asker) ([]byte, error) {
+	var policy models.ResourcePolicy
+	if err := json.Unmarshal(computingprovider.ResourcePolicySample, &policy); err != nil {
+		return nil, err
+	}
+
+	quota := func(flag, prompt string) (int64, error) {
+		if cctx.IsSet(flag) || !ask.interactive {
+			return cctx.Int64(flag), nil
+		}
+		answer := ask.string(prompt, "0", false)
+		n, err := strconv.ParseInt(answer, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a quota", answer)
+		}
+		return n, nil
+	}
+	var err error
+	if policy.Cpu.Quota, err = quota("cpu-quota", "The vCPUs that must stay free"); err != nil {
+		return nil, err
+	}
+	if policy.Memory.Quota, err = quota("memory-quota", "The GiB of memory that must stay free"); err != nil {
+		return nil, err
+	}
+	if policy.Storage.Quota, err = quota("storage-quota", "The GiB of storage that must stay free"); err != nil {
+		return nil, err
+	}
+
+	for _, gpuQuota := range cctx.StringSlice("gpu-quota") {
+		name, count, ok := strings.Cut(gpuQuota, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("--gpu-quota %q is not like Nvidia-A100=1", gpuQuota)
+		}
+		name = strings.TrimSpace(name)
+		found := false
+		for i := range policy.Gpu {
+			if strings.EqualFold(policy.Gpu[i].Name, name) {
+				policy.Gpu[i].Quota, found = n, true
+			}
+		}
+		if !found {
+			policy.Gpu = append(policy.Gpu, models.GpuQuota{Name: name, Quota: n})
+		}
+	}
+	return json.MarshalIndent(policy, "", "  ")
+}
+
+// keep tells whether an existing file of the repo stays as it is.
+func keep(file string, force bool) bool {
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	if force {
+		return false
+	}
+	fmt.Printf("Kept %s, --force to overwrite it\n", file)
+	return true
+}
+
+type asker struct {
+	interactive bool
+	reader      *bufio.Reader
+}
+
+func newAsker(interactive bool) asker {
+	return asker{interactive: interactive, reader: bufio.NewReader(os.Stdin)}
+}
+
+// string prompts for a value, an empty answer takes the default. A secret is not echoed.
+func (a asker) string(prompt, defaultValue string, secret bool) string {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", prompt, defaultValue)
+	} else {
+		fmt.Printf("%s: ", prompt)
+	}
+
+	var answer string
+	if secret {
+		bytes, _ := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		answer = string(bytes)
+	} else {
+		answer, _ = a.reader.ReadString('\n')
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue
+	}
+	return answer
+}
+
+// printChecks prints the results of checks as a table, with the status colored.
+func printChecks(results []models.CheckResult) {
+	var data [][]string
+	var rowColors []RowColor
+	for i, result := range results {
+		data = append(data, []string{result.Name, strings.ToUpper(string(result.Status)), result.Message, result.Hint})
+
+		color := tablewriter.Colors{tablewriter.Bold, tablewriter.FgGreenColor}
+		switch result.Status {
+		case models.CheckWarn:
+			color = tablewriter.Colors{tablewriter.Bold, tablewriter.FgYellowColor}
+		case models.CheckFail:
+			color = tablewriter.Colors{tablewriter.Bold, tablewriter.FgRedColor}
+		}
+		rowColors = append(rowColors, RowColor{row: i, column: []int{1}, color: []tablewriter.Colors{color}})
+	}
+
+	header := []string{"CHECK", "STATUS", "MESSAGE", "HINT"}
+	NewVisualTable(header, data, rowColors).Generate()
+}
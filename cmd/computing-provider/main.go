@@ -4,6 +4,8 @@ import (
 	"github.com/lagrangedao/go-computing-provider/build"
 	"github.com/urfave/cli/v2"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
@@ -24,9 +26,24 @@ func main() {
 				Value:   "~/.swan/computing",
 			},
 		},
+		// every command reads the same repo, also the tasks reading CP_PATH
+		Before: func(cctx *cli.Context) error {
+			cpRepoPath, err := expandHome(cctx.String(FlagCpRepo))
+			if err != nil {
+				return err
+			}
+			if err = cctx.Set(FlagCpRepo, cpRepoPath); err != nil {
+				return err
+			}
+			return os.Setenv("CP_PATH", cpRepoPath)
+		},
 		Commands: []*cli.Command{
 			runCmd,
 			taskCmd,
+			yamlCmd,
+			configCmd,
+			initCmd,
+			doctorCmd,
 		},
 	}
 	app.Setup()
@@ -35,3 +52,15 @@ func main() {
 		os.Stderr.WriteString("Error: " + err.Error() + "\n")
 	}
 }
+
+// expandHome resolves a leading ~ of the cp repo path, the default is in the home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
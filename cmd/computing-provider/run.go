@@ -38,6 +38,9 @@ var runCmd = &cli.Command{
 
 		v1 := r.Group("/api/v1")
 		cpManager(v1.Group("/computing"))
+		if conf.GetConfig().Gateway.Enable {
+			computing.NewGateway().Register(r.Group("/v1"))
+		}
 
 		shutdownChan := make(chan struct{})
 		httpStopper, err := util.ServeHttp(r, "cp-api", ":"+strconv.Itoa(conf.GetConfig().API.Port))
@@ -61,7 +64,19 @@ func cpManager(router *gin.RouterGroup) {
 	router.POST("/lagrange/jobs/redeploy", computing.RedeployJob)
 	router.DELETE("/lagrange/jobs", computing.DeleteJob)
 	router.GET("/lagrange/cp", computing.StatisticalSources)
+	router.POST("/lagrange/batch", computing.ReceiveBatchJob)
+	router.GET("/lagrange/batch", computing.GetBatchJob)
+	router.GET("/lagrange/runtimes", computing.GetRuntimes)
 	router.POST("/lagrange/jobs/renew", computing.ReNewJob)
 	router.GET("/lagrange/spaces/log", computing.GetSpaceLog)
 	router.POST("/lagrange/cp/proof", computing.DoProof)
+	router.GET("/lagrange/cp/proof", computing.GetProof)
+	router.GET("/lagrange/cp/doctor", computing.GetDoctor)
+	router.POST("/lagrange/spaces/domain", computing.AddCustomDomain)
+	router.GET("/lagrange/spaces/domain", computing.GetCustomDomain)
+	router.POST("/lagrange/spaces/domain/verify", computing.VerifyCustomDomain)
+	router.DELETE("/lagrange/spaces/domain", computing.DeleteCustomDomain)
+	router.POST("/lagrange/spaces/apikey", computing.CreateSpaceApiKey)
+	router.GET("/lagrange/spaces/usage", computing.GetSpaceUsage)
+	router.Any("/lagrange/spaces/activate/:space_uuid/*path", computing.ActivateSpace)
 }
@@ -28,6 +28,8 @@ func NewVisualTable(header []string, data [][]string, rowColor []RowColor) *Visu
 
 func (v *VisualTable) Generate() {
 	table := tablewriter.NewWriter(os.Stdout)
+	// rows are wrapped as they are added
+	table.SetAutoWrapText(false)
 
 	for index, datum := range v.Data {
 		var rowColors []tablewriter.Colors
@@ -51,7 +53,6 @@ func (v *VisualTable) Generate() {
 	}
 
 	table.SetHeader(v.Header)
-	table.SetAutoWrapText(false)
 	table.SetAutoFormatHeaders(true)
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetHeaderLine(false)
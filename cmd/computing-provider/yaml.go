@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+var yamlCmd = &cli.Command{
+	Name:  "yaml",
+	Usage: "Work with deploy.yaml files",
+	Subcommands: []*cli.Command{
+		yamlValidate,
+		yamlSchema,
+	},
+}
+
+var yamlValidate = &cli.Command{
+	Name:      "validate",
+	Usage:     "Check a deploy.yaml against the schema of its version, or a Docker Compose file",
+	ArgsUsage: "<file>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("incorrect number of arguments, got %d, expected 1", cctx.NArg())
+		}
+		file := cctx.Args().First()
+
+		if yaml.IsComposeFile(file) {
+			_, warnings, err := yaml.LoadCompose(file)
+			for _, warning := range warnings {
+				fmt.Printf("%s: warning: %s\n", file, warning.Error())
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s is a valid compose file, %d key(s) will be ignored\n", file, len(warnings))
+			return nil
+		}
+
+		version, err := yaml.ValidateFile(file)
+		if err != nil {
+			if validationErrors, ok := err.(yaml.ValidationErrors); ok {
+				for _, validationError := range validationErrors {
+					fmt.Printf("%s: %s\n", file, validationError.Error())
+				}
+				return fmt.Errorf("%s has %d problem(s)", file, len(validationErrors))
+			}
+			return err
+		}
+		fmt.Printf("%s is a valid deploy.yaml of version %s\n", file, version)
+		return nil
+	},
+}
+
+var yamlSchema = &cli.Command{
+	Name:      "schema",
+	Usage:     "Print the JSON Schema of a deploy.yaml version",
+	ArgsUsage: "[version]",
+	Action: func(cctx *cli.Context) error {
+		versions := yaml.SchemaVersions()
+		version := versions[len(versions)-1]
+		if cctx.NArg() > 0 {
+			version = cctx.Args().First()
+		}
+
+		schema, err := yaml.JsonSchema(version)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(schema)
+		return err
+	},
+}
+
+func init() {
+	yamlValidate.Description = "Supported versions: " + strings.Join(yaml.SchemaVersions(), ", ")
+}
@@ -1,31 +1,50 @@
 package conf
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 )
 
-var config *ComputeNode
+var config atomic.Value
 
 // ComputeNode is a compute node config
 type ComputeNode struct {
-	API      API
-	LOG      LOG
-	LAG      LAG
-	MCS      MCS
-	Registry Registry
+	API         API
+	LOG         LOG
+	LAG         LAG
+	MCS         MCS
+	Registry    Registry
+	ACME        ACME
+	Manifest    Manifest
+	ModelCache  ModelCache
+	Inference   Inference
+	Gateway     Gateway
+	ScaleToZero ScaleToZero
+	Batch       Batch
+	Proof       Proof
+	Hardware    Hardware
 }
 
 type API struct {
 	Port          int
 	MultiAddress  string
-	RedisUrl      string
-	RedisPassword string
+	RedisUrl      string `secret:"url"`
+	RedisPassword string `secret:"true"`
 	Domain        string
 	NodeName      string
+	// AdminWallets may sign the requests to the admin routes of the provider, next to LAG.AccessToken.
+	AdminWallets []string
+
+	ExposeServiceType string
+	PortRangeStart    int32
+	PortRangeEnd      int32
 }
 
 type LOG struct {
@@ -35,12 +54,12 @@ type LOG struct {
 
 type LAG struct {
 	ServerUrl   string
-	AccessToken string
+	AccessToken string `secret:"true"`
 }
 
 type MCS struct {
-	ApiKey        string
-	AccessToken   string
+	ApiKey        string `secret:"true"`
+	AccessToken   string `secret:"true"`
 	BucketName    string
 	Network       string
 	FileCachePath string
@@ -49,30 +68,220 @@ type MCS struct {
 type Registry struct {
 	ServerAddress string
 	UserName      string
-	Password      string
+	Password      string `secret:"true"`
+}
+
+// Manifest lets trusted wallets deploy raw Kubernetes manifests (k8s/*.yaml) or a Helm chart.
+type Manifest struct {
+	Enable  bool
+	Wallets []string
+	Kinds   []string
+}
+
+// ModelCache is where the models of deploy.yaml are kept, shared by the spaces of a node. Without
+// PvcSize it is the directory HostPath on every node, otherwise a ReadWriteMany claim per wallet namespace.
+type ModelCache struct {
+	FetcherImage string
+	HostPath     string
+	PvcSize      string
+	StorageClass string
+}
+
+// Inference configures the spaces serving a Hugging Face model. Runtimes is the catalog of their
+// images, frameworks without a runtime are built from the docker_images of $CP_PATH/inference-model.
+type Inference struct {
+	HubEndpoint  string
+	HubToken     string `secret:"true"`
+	WarmInterval int
+	Runtimes     []Runtime
+}
+
+// Runtime maps a framework, a task and an accelerator to a versioned image. An empty Task or
+// Accelerator matches any, Accelerator is "cpu", "gpu" or a GPU model. Without Image the image of
+// the framework is built by the provider. OpenAI declares that the image serves the OpenAI api, only
+// such spaces are served by the gateway.
+type Runtime struct {
+	Framework   string
+	Task        string
+	Accelerator string
+	Image       string
+	OpenAI      bool
+}
+
+// Gateway serves the OpenAI api of the model spaces at /v1 of the provider api.
+type Gateway struct {
+	Enable bool
+}
+
+// ScaleToZero scales model spaces down after IdleMinutes without ingress requests, as counted by the
+// ingress-nginx metrics in Prometheus. ActivatorUrl is the public url of the provider api, where
+// requests to a scaled down space wait for it to be back.
+type ScaleToZero struct {
+	Enable            bool
+	IdleMinutes       int
+	PrometheusUrl     string
+	ActivatorUrl      string
+	ActivationTimeout int
+}
+
+// Batch limits the batch jobs, which run a container to completion and upload its /output.
+type Batch struct {
+	CollectorImage string
+	MaxTimeout     int64
+}
+
+// Proof runs the proof tasks in Namespace. Images replaces the image of a proof method by its name.
+type Proof struct {
+	Namespace string
+	Images    map[string]string
+}
+
+// Hardware is how the GPUs of the nodes are found. Collectors are asked in order, "exporter" (the
+// HTTP endpoint of resource-exporter), "dcgm" (the metrics of dcgm-exporter) and "labels" (node
+// labels and the nvidia.com/gpu resource). The result is kept CacheTTL seconds.
+type Hardware struct {
+	Collectors    []string
+	ExporterPort  int
+	ExporterPath  string
+	DcgmNamespace string
+	CacheTTL      int
+}
+
+type ACME struct {
+	Enable                bool
+	Email                 string
+	DirectoryUrl          string
+	InsecureSkipVerify    bool
+	RenewBeforeDays       int
+	SecretNamespace       string
+	SecretName            string
+	DnsProvider           string
+	DnsPropagationTimeout int
+	CloudflareApiToken    string `secret:"true"`
+	CloudflareZoneId      string
+	ExecPath              string
+	ChallTestSrvUrl       string
+}
+
+// restartFields are read once when the provider starts, a reload keeps their current value.
+var restartFields = [][]string{
+	{"API", "Port"},
+	{"API", "RedisUrl"},
+	{"API", "RedisPassword"},
+	{"LOG"},
+	{"MCS"},
+	{"ACME"},
+	{"Gateway", "Enable"},
+	{"ScaleToZero"},
+	{"Hardware"},
+	{"Proof", "Images"},
+	{"Inference", "Runtimes"},
+	{"Inference", "WarmInterval"},
 }
 
 func InitConfig(cpRepoPath string) error {
+	c, err := LoadConfig(cpRepoPath)
+	if err != nil {
+		return err
+	}
+	config.Store(c)
+	return nil
+}
+
+// LoadConfig reads the config.toml of the repo and validates it, without applying it.
+func LoadConfig(cpRepoPath string) (*ComputeNode, error) {
 	configFile := filepath.Join(cpRepoPath, "config.toml")
+	data, err := os.ReadFile(configFile)
+	// without the file, the whole config can come from the environment
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && len(EnvOverrides()) > 0) {
+		return nil, fmt.Errorf("failed load config file, path: %s, error: %w", configFile, err)
+	}
+	c, err := ParseConfig(data)
+	if err != nil {
+		if _, ok := err.(ValidationErrors); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed load config file, path: %s, error: %w", configFile, err)
+	}
+	return c, nil
+}
 
-	if metaData, err := toml.DecodeFile(configFile, &config); err != nil {
-		return fmt.Errorf("failed load config file, path: %s, error: %w", configFile, err)
-	} else {
-		if !requiredFieldsAreGiven(metaData) {
-			log.Fatal("Required fields not given")
+// ParseConfig decodes a config, applies the environment variables overriding it and validates the
+// result. Its problems are returned as ValidationErrors.
+func ParseConfig(data []byte) (*ComputeNode, error) {
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, err
+	}
+	var errs ValidationErrors
+	if checkTypes(reflect.TypeOf(ComputeNode{}), raw, "", &errs); len(errs) > 0 {
+		return nil, errs
+	}
+
+	c := new(ComputeNode)
+	metaData, err := toml.Decode(string(data), c)
+	if err != nil {
+		return nil, err
+	}
+	given, errs := applyEnv(c)
+	if errs = append(errs, Validate(c, metaData, given)...); len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// DecodeConfig reads a config with its environment overrides, without validating it. init checks the
+// services of a config that is not complete yet with it.
+func DecodeConfig(data []byte) (*ComputeNode, error) {
+	c := new(ComputeNode)
+	if _, err := toml.Decode(string(data), c); err != nil {
+		return nil, err
+	}
+	if _, errs := applyEnv(c); len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// Reload applies the config.toml of the repo again. An invalid config is not applied, and the
+// fields read at start keep their value, the changed ones are returned.
+func Reload(cpRepoPath string) ([]string, error) {
+	c, err := LoadConfig(cpRepoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	current := reflect.ValueOf(GetConfig()).Elem()
+	next := reflect.ValueOf(c).Elem()
+	for _, field := range restartFields {
+		currentValue, nextValue := fieldByPath(current, field), fieldByPath(next, field)
+		if !reflect.DeepEqual(currentValue.Interface(), nextValue.Interface()) {
+			nextValue.Set(currentValue)
+			kept = append(kept, strings.Join(field, "."))
 		}
 	}
-	return nil
+	config.Store(c)
+	return kept, nil
 }
 
+// GetConfig returns the config in effect. A reload replaces it as a whole, so a task keeping the
+// returned config sees consistent values.
 func GetConfig() *ComputeNode {
-	return config
+	c, _ := config.Load().(*ComputeNode)
+	return c
+}
+
+func fieldByPath(v reflect.Value, path []string) reflect.Value {
+	for _, name := range path {
+		v = v.FieldByName(name)
+	}
+	return v
 }
 
-func requiredFieldsAreGiven(metaData toml.MetaData) bool {
+func requiredFields(c *ComputeNode) [][]string {
 	requiredFields := [][]string{
 		{"API"},
-		{"LOG"},
 		{"LAG"},
 		{"MCS"},
 		{"Registry"},
@@ -81,9 +290,6 @@ func requiredFieldsAreGiven(metaData toml.MetaData) bool {
 		{"API", "Domain"},
 		{"API", "RedisUrl"},
 
-		{"LOG", "CrtFile"},
-		{"LOG", "KeyFile"},
-
 		{"LAG", "ServerUrl"},
 		{"LAG", "AccessToken"},
 
@@ -93,11 +299,26 @@ func requiredFieldsAreGiven(metaData toml.MetaData) bool {
 		{"MCS", "FileCachePath"},
 	}
 
-	for _, v := range requiredFields {
-		if !metaData.IsDefined(v...) {
-			log.Fatal("Required fields ", v)
-		}
+	// the certificate files are optional once ACME manages the certificate
+	if !c.ACME.Enable {
+		requiredFields = append(requiredFields, []string{"LOG"}, []string{"LOG", "CrtFile"}, []string{"LOG", "KeyFile"})
+	} else {
+		requiredFields = append(requiredFields, []string{"ACME", "Email"}, []string{"ACME", "DnsProvider"})
 	}
+	return requiredFields
+}
 
+// isGiven tells whether a required field is in the file or the environment, and not empty for a string.
+func isGiven(c *ComputeNode, metaData toml.MetaData, given map[string]bool, field []string) bool {
+	// the sections only matter in a file, a config from the environment alone has none
+	if len(field) == 1 && len(metaData.Keys()) == 0 && len(given) > 0 {
+		return true
+	}
+	if !metaData.IsDefined(field...) && !given[strings.Join(field, ".")] {
+		return false
+	}
+	if v := fieldByPath(reflect.ValueOf(c).Elem(), field); v.Kind() == reflect.String {
+		return v.String() != ""
+	}
 	return true
 }
@@ -0,0 +1,195 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const redacted = "********"
+
+// Redact returns a copy of the config whose secrets, the fields tagged `secret:"true"`, are hidden, and
+// whose urls tagged `secret:"url"` have no password.
+func Redact(c *ComputeNode) *ComputeNode {
+	r := *c
+	sections := reflect.ValueOf(&r).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			if field.Tag.Get("secret") == "true" && section.Field(j).String() != "" {
+				section.Field(j).SetString(redacted)
+			}
+			if field.Tag.Get("secret") == "url" {
+				section.Field(j).SetString(RedactUrl(section.Field(j).String()))
+			}
+		}
+	}
+	return &r
+}
+
+// RedactUrl hides the password of a url like "redis://:password@host:6379".
+func RedactUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	// url.UserPassword would escape the redaction mark
+	u.User = url.User(u.User.Username())
+	user := u.User.String()
+	return strings.Replace(u.String(), "//"+user+"@", "//"+user+":"+redacted+"@", 1)
+}
+
+// IsSecret tells whether a key like "LAG.AccessToken" holds a secret.
+func IsSecret(key string) bool {
+	field, err := lookupField(key)
+	return err == nil && field.Tag.Get("secret") == "true"
+}
+
+// lookupField finds the field of a key "Section.Field", ignoring case.
+func lookupField(key string) (reflect.StructField, error) {
+	sectionName, fieldName, ok := strings.Cut(key, ".")
+	if !ok || strings.Contains(fieldName, ".") {
+		return reflect.StructField{}, fmt.Errorf("%q is not a key like API.Domain", key)
+	}
+	equalFold := func(name string) func(string) bool {
+		return func(s string) bool { return strings.EqualFold(s, name) }
+	}
+	section, ok := reflect.TypeOf(ComputeNode{}).FieldByNameFunc(equalFold(sectionName))
+	if !ok {
+		return reflect.StructField{}, fmt.Errorf("section %q is unknown", sectionName)
+	}
+	field, ok := section.Type.FieldByNameFunc(equalFold(fieldName))
+	if !ok {
+		return reflect.StructField{}, fmt.Errorf("%q is not a field of %s", fieldName, section.Name)
+	}
+	field.Name = section.Name + "." + field.Name
+	return field, nil
+}
+
+// parseValue converts the text of a value to the type of the field, a list is comma separated.
+func parseValue(field reflect.StructField, value string) (interface{}, error) {
+	switch field.Type.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, field.Type.Bits())
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, field.Type.Bits())
+	case reflect.Slice:
+		if field.Type.Elem().Kind() == reflect.String {
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("%s can only be set in config.toml", field.Name)
+}
+
+// SetValue sets the key "Section.Field" of a config file to value. Only the line of the key changes,
+// its comment stays; a missing key or section is added.
+func SetValue(data []byte, key, value string) ([]byte, error) {
+	field, err := lookupField(key)
+	if err != nil {
+		return nil, err
+	}
+	typed, err := parseValue(field, value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = toml.NewEncoder(&buf).Encode(map[string]interface{}{"v": typed}); err != nil {
+		return nil, err
+	}
+	literal := strings.TrimSpace(strings.TrimPrefix(buf.String(), "v = "))
+	sectionName, fieldName, _ := strings.Cut(field.Name, ".")
+
+	lines := strings.Split(string(data), "\n")
+	inSection, sectionLine, lastKeyLine := false, -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			header := strings.Trim(strings.SplitN(trimmed, "#", 2)[0], "[] \t")
+			inSection = strings.EqualFold(header, sectionName) && !strings.HasPrefix(trimmed, "[[")
+			if inSection {
+				sectionLine, lastKeyLine = i, i
+			}
+			continue
+		}
+		if !inSection || trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lastKeyLine = i
+		name, rest, ok := strings.Cut(line, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), fieldName) {
+			continue
+		}
+		lines[i] = replaceValue(name, rest, literal)
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	entry := fieldName + " = " + literal
+	if sectionLine < 0 {
+		text := strings.TrimRight(string(data), "\n")
+		return []byte(text + "\n\n[" + sectionName + "]\n" + entry + "\n"), nil
+	}
+	lines = append(lines[:lastKeyLine+1], append([]string{entry}, lines[lastKeyLine+1:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// replaceValue swaps the value of a `key = value  # comment` line, the comment keeps its column
+// when the new value leaves room for it.
+func replaceValue(name, rest, literal string) string {
+	end := valueEnd(rest)
+	comment := strings.TrimLeft(rest[end:], " \t")
+	newLine := strings.TrimRight(name, " \t") + " = " + literal
+	if comment == "" {
+		return newLine
+	}
+	column := len(name) + 1 + len(rest) - len(comment)
+	padding := column - len(newLine)
+	if padding < 1 {
+		padding = 1
+	}
+	return newLine + strings.Repeat(" ", padding) + comment
+}
+
+// valueEnd is the end of the toml value at the start of s, before spaces and a comment.
+func valueEnd(s string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case ch == '#' && depth == 0:
+			return len(strings.TrimRight(s[:i], " \t"))
+		}
+	}
+	return len(strings.TrimRight(s, " \t"))
+}
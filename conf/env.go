@@ -0,0 +1,125 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	envPrefix = "CP_"
+	envFile   = "_FILE"
+)
+
+// EnvName is the environment variable overriding a field, e.g. CP_LAG_ACCESSTOKEN for LAG.AccessToken.
+// The variable with the suffix _FILE names a file holding the value, such as a mounted secret.
+func EnvName(section, field string) string {
+	return envPrefix + strings.ToUpper(section) + "_" + strings.ToUpper(field)
+}
+
+// applyEnv overrides the fields of c by the environment, which takes precedence over config.toml.
+// It returns the fields it set and their sections, e.g. "LAG" and "LAG.AccessToken".
+func applyEnv(c *ComputeNode) (map[string]bool, ValidationErrors) {
+	given := make(map[string]bool)
+	var errs ValidationErrors
+
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		sectionName := sections.Type().Field(i).Name
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			key := sectionName + "." + field.Name
+			name := EnvName(sectionName, field.Name)
+
+			value, ok, err := lookupEnv(name)
+			if err != nil {
+				errs.add(key, "%v", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			field.Name = key
+			typed, err := parseEnvValue(field, value)
+			if err != nil {
+				errs.add(key, "%s: %v", name, err)
+				continue
+			}
+			section.Field(j).Set(reflect.ValueOf(typed).Convert(field.Type))
+			given[sectionName], given[key] = true, true
+		}
+	}
+	return given, errs
+}
+
+// lookupEnv reads a variable, or the file named by its _FILE variable. Giving both is an error, as
+// it is not clear which one is meant.
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	file, fileOk := os.LookupEnv(name + envFile)
+	switch {
+	case ok && fileOk:
+		return "", false, fmt.Errorf("both %s and %s are set", name, name+envFile)
+	case fileOk:
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %v", name+envFile, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return value, ok, nil
+}
+
+// parseEnvValue is parseValue, with tables written as comma separated key=value pairs.
+func parseEnvValue(field reflect.StructField, value string) (interface{}, error) {
+	if field.Type.Kind() != reflect.Map || field.Type.Elem().Kind() != reflect.String {
+		return parseValue(field, value)
+	}
+	table := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		table[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return table, nil
+}
+
+// EnvOverrides returns the variables overriding a field that are set, with their _FILE variables.
+func EnvOverrides() map[string]string {
+	overrides := make(map[string]string)
+	sections := reflect.TypeOf(ComputeNode{})
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			key := section.Name + "." + section.Type.Field(j).Name
+			name := EnvName(section.Name, section.Type.Field(j).Name)
+			for _, variable := range []string{name, name + envFile} {
+				if _, ok := os.LookupEnv(variable); ok {
+					overrides[key] = variable
+				}
+			}
+		}
+	}
+	return overrides
+}
+
+// SecretFiles are the files named by the _FILE variables, a reload reads them again.
+func SecretFiles() []string {
+	var files []string
+	for _, variable := range EnvOverrides() {
+		if strings.HasSuffix(variable, envFile) {
+			files = append(files, os.Getenv(variable))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,252 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors holds every problem of a config, so they can be fixed in one go.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs *ValidationErrors) add(field, format string, args ...interface{}) {
+	*errs = append(*errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkTypes compares the decoded toml to the fields of t, so that every value of a wrong type is
+// reported, not only the first one the decoder meets. Unknown keys are left to the decoder.
+func checkTypes(t reflect.Type, value interface{}, path string, errs *ValidationErrors) {
+	switch t.Kind() {
+	case reflect.Struct:
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be a table")
+			return
+		}
+		for key, v := range table {
+			field, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) })
+			if !ok {
+				continue
+			}
+			checkTypes(field.Type, v, joinField(path, field.Name), errs)
+		}
+	case reflect.Map:
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be a table")
+			return
+		}
+		for key, v := range table {
+			checkTypes(t.Elem(), v, joinField(path, key), errs)
+		}
+	case reflect.Slice:
+		switch items := value.(type) {
+		case []interface{}:
+			for i, item := range items {
+				checkTypes(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		case []map[string]interface{}:
+			for i, item := range items {
+				checkTypes(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		default:
+			errs.add(path, "must be an array")
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(path, "must be a string, got %v", value)
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "must be true or false, got %v", value)
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok {
+			errs.add(path, "must be an integer, got %v", value)
+			return
+		}
+		if reflect.Zero(t).OverflowInt(n) {
+			errs.add(path, "%d is out of range", n)
+		}
+	case reflect.Float32, reflect.Float64:
+		switch value.(type) {
+		case float64, int64:
+		default:
+			errs.add(path, "must be a number, got %v", value)
+		}
+	}
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Validate reports every missing required field and every value of a wrong format. Given are the
+// fields set by the environment.
+func Validate(c *ComputeNode, metaData toml.MetaData, given map[string]bool) ValidationErrors {
+	var errs ValidationErrors
+
+	for _, field := range requiredFields(c) {
+		if isGiven(c, metaData, given, field) {
+			continue
+		}
+		if len(field) == 2 {
+			errs.add(strings.Join(field, "."), "is required, in config.toml or as %s", EnvName(field[0], field[1]))
+		} else {
+			errs.add(strings.Join(field, "."), "is required")
+		}
+	}
+
+	checkUrl(&errs, "API.RedisUrl", c.API.RedisUrl, "redis", "rediss")
+	checkUrl(&errs, "LAG.ServerUrl", c.LAG.ServerUrl, "http", "https")
+	checkUrl(&errs, "ACME.DirectoryUrl", c.ACME.DirectoryUrl, "http", "https")
+	checkUrl(&errs, "ACME.ChallTestSrvUrl", c.ACME.ChallTestSrvUrl, "http", "https")
+	checkUrl(&errs, "Inference.HubEndpoint", c.Inference.HubEndpoint, "http", "https")
+	checkUrl(&errs, "ScaleToZero.PrometheusUrl", c.ScaleToZero.PrometheusUrl, "http", "https")
+	checkUrl(&errs, "ScaleToZero.ActivatorUrl", c.ScaleToZero.ActivatorUrl, "http", "https")
+
+	if c.API.MultiAddress != "" {
+		if err := checkMultiAddress(c.API.MultiAddress); err != nil {
+			errs.add("API.MultiAddress", "%v", err)
+		}
+	}
+	if strings.Contains(c.API.Domain, "://") || strings.ContainsAny(c.API.Domain, " /") {
+		errs.add("API.Domain", "must be a domain name, got %q", c.API.Domain)
+	}
+
+	if metaData.IsDefined("API", "Port") || given["API.Port"] {
+		checkPort(&errs, "API.Port", int64(c.API.Port))
+	}
+	if c.API.PortRangeStart != 0 || c.API.PortRangeEnd != 0 {
+		checkPort(&errs, "API.PortRangeStart", int64(c.API.PortRangeStart))
+		checkPort(&errs, "API.PortRangeEnd", int64(c.API.PortRangeEnd))
+		if c.API.PortRangeStart > c.API.PortRangeEnd {
+			errs.add("API.PortRangeEnd", "%d is before PortRangeStart %d", c.API.PortRangeEnd, c.API.PortRangeStart)
+		}
+	}
+	if c.Hardware.ExporterPort != 0 {
+		checkPort(&errs, "Hardware.ExporterPort", int64(c.Hardware.ExporterPort))
+	}
+
+	checkOneOf(&errs, "API.ExposeServiceType", c.API.ExposeServiceType, "NodePort", "LoadBalancer")
+	if c.ACME.Enable {
+		checkOneOf(&errs, "ACME.DnsProvider", c.ACME.DnsProvider, "cloudflare", "exec", "challtestsrv")
+	}
+	for i, collector := range c.Hardware.Collectors {
+		checkOneOf(&errs, fmt.Sprintf("Hardware.Collectors[%d]", i), collector, "exporter", "dcgm", "labels")
+	}
+
+	for field, n := range map[string]int64{
+		"Inference.WarmInterval":        int64(c.Inference.WarmInterval),
+		"ScaleToZero.IdleMinutes":       int64(c.ScaleToZero.IdleMinutes),
+		"ScaleToZero.ActivationTimeout": int64(c.ScaleToZero.ActivationTimeout),
+		"Batch.MaxTimeout":              c.Batch.MaxTimeout,
+		"Hardware.CacheTTL":             int64(c.Hardware.CacheTTL),
+		"ACME.RenewBeforeDays":          int64(c.ACME.RenewBeforeDays),
+	} {
+		if n < 0 {
+			errs.add(field, "can't be negative")
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func checkUrl(errs *ValidationErrors, field, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		errs.add(field, "%q is not a url", value)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	errs.add(field, "the scheme of %q must be %s", value, strings.Join(schemes, " or "))
+}
+
+func checkPort(errs *ValidationErrors, field string, port int64) {
+	if port < 1 || port > 65535 {
+		errs.add(field, "%d is not a port, must be between 1 and 65535", port)
+	}
+}
+
+func checkOneOf(errs *ValidationErrors, field, value string, values ...string) {
+	if value == "" {
+		return
+	}
+	for _, v := range values {
+		if strings.EqualFold(value, v) {
+			return
+		}
+	}
+	errs.add(field, "%q must be one of %s", value, strings.Join(values, ", "))
+}
+
+// checkMultiAddress accepts the multiaddresses a provider is reached at, e.g. /ip4/1.2.3.4/tcp/8085.
+func checkMultiAddress(addr string) error {
+	parts := strings.Split(addr, "/")
+	if len(parts) < 5 || parts[0] != "" || len(parts)%2 == 0 {
+		return fmt.Errorf("%q is not a multiaddress like /ip4/<public_ip>/tcp/<port>", addr)
+	}
+	for i := 1; i+1 < len(parts); i += 2 {
+		protocol, value := parts[i], parts[i+1]
+		switch protocol {
+		case "ip4":
+			if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+				return fmt.Errorf("%q is not an ipv4 address", value)
+			}
+		case "ip6":
+			if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+				return fmt.Errorf("%q is not an ipv6 address", value)
+			}
+		case "dns", "dns4", "dns6":
+			if value == "" || strings.ContainsAny(value, " <>") {
+				return fmt.Errorf("%q is not a host name", value)
+			}
+		case "tcp", "udp":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("%q is not a port", value)
+			}
+		default:
+			return fmt.Errorf("protocol %q of %q is not supported", protocol, addr)
+		}
+	}
+	return nil
+}
@@ -17,5 +17,21 @@ const K8S_NAMESPACE_NAME_PREFIX = "ns-"
 const K8S_CONTAINER_NAME_PREFIX = "pod-"
 const K8S_INGRESS_NAME_PREFIX = "ing-"
 const K8S_SERVICE_NAME_PREFIX = "svc-"
+const K8S_EXPOSE_SERVICE_SUFFIX = "-ext"
 const K8S_DEPLOY_NAME_PREFIX = "deploy-"
+const K8S_PVC_NAME_PREFIX = "pvc-"
 const REDIS_FULL_PREFIX = "FULL:"
+const REDIS_DOMAIN_PREFIX = "DOMAIN:"
+const REDIS_HOST_PREFIX = "HOST:"
+const REDIS_NODE_PORT_PREFIX = "NODEPORT:"
+const REDIS_GATEWAY_SPACE_PREFIX = "GATEWAY:SPACE:"
+const REDIS_GATEWAY_KEY_PREFIX = "GATEWAY:KEY:"
+const REDIS_GATEWAY_USAGE_PREFIX = "GATEWAY:USAGE:"
+const REDIS_IDLE_PREFIX = "IDLE:"
+const REDIS_BATCH_PREFIX = "BATCH:"
+const REDIS_PROOF_PREFIX = "PROOF:"
+const REDIS_RESERVATION_PREFIX = "RESERVATION:"
+const REDIS_RESERVATIONS_KEY = "RESERVATIONS"
+const REDIS_HOSTS_KEY = "HOSTS"
+const REDIS_NONCE_PREFIX = "NONCE:"
+const K8S_TLS_SECRET_NAME_PREFIX = "tls-"
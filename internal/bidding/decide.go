@@ -0,0 +1,119 @@
+package bidding
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Job is what the policy knows of an incoming job. PricePerHour is the price of the whole job,
+// 0 when the order has none; the minimum price doesn't apply to such a job.
+type Job struct {
+	Uuid         string
+	Wallet       string
+	Tier         string
+	Gpus         int64
+	Duration     int
+	PricePerHour float64
+}
+
+// State is the provider at the time of the decision. WalletJobs are the running and deploying
+// jobs of the wallet of the job, Utilization the fraction of the cluster in use, 0 to 1.
+type State struct {
+	WalletJobs  int
+	Utilization float64
+}
+
+type Decision struct {
+	Accept  bool   `json:"accept"`
+	Reason  Reason `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func accept() Decision {
+	return Decision{Accept: true}
+}
+
+func reject(reason Reason, format string, args ...interface{}) Decision {
+	return Decision{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Decide applies the rules of the policy in order, the first rule a job breaks rejects it.
+func (p *Policy) Decide(job Job, state State) Decision {
+	if !p.AutoBid {
+		return reject(ReasonBiddingDisabled, "the provider doesn't bid")
+	}
+
+	if len(p.Tiers) > 0 && !containsTier(p.Tiers, job.Tier) {
+		return reject(ReasonTierNotOffered, "%s is not offered", job.Tier)
+	}
+
+	if p.MinDuration > 0 && job.Duration < p.MinDuration {
+		return reject(ReasonDurationTooShort, "duration %ds is shorter than %ds", job.Duration, p.MinDuration)
+	}
+	if p.MaxDuration > 0 && job.Duration > p.MaxDuration {
+		return reject(ReasonDurationTooLong, "duration %ds is longer than %ds", job.Duration, p.MaxDuration)
+	}
+
+	if containsWallet(p.DenyWallets, job.Wallet) {
+		return reject(ReasonWalletDenied, "wallet %s is denied", job.Wallet)
+	}
+	if len(p.AllowWallets) > 0 && !containsWallet(p.AllowWallets, job.Wallet) {
+		return reject(ReasonWalletNotAllowed, "wallet %s is not allowed", job.Wallet)
+	}
+
+	if p.MinPricePerGpuHour > 0 && job.Gpus > 0 && job.PricePerHour > 0 {
+		if perGpu := job.PricePerHour / float64(job.Gpus); perGpu < p.MinPricePerGpuHour {
+			return reject(ReasonPriceTooLow, "price %.4f per GPU-hour is below %.4f", perGpu, p.MinPricePerGpuHour)
+		}
+	}
+
+	if p.MaxJobsPerWallet > 0 && state.WalletJobs >= p.MaxJobsPerWallet {
+		return reject(ReasonTooManyJobs, "wallet %s already has %d jobs", job.Wallet, state.WalletJobs)
+	}
+
+	if p.MaxUtilization > 0 && state.Utilization >= p.MaxUtilization {
+		return reject(ReasonUtilizationTooHigh, "utilization %.0f%% reached the limit of %.0f%%", state.Utilization*100, p.MaxUtilization*100)
+	}
+	return accept()
+}
+
+// AcceptsGpus tells whether the policy offers any GPU tier.
+func (p *Policy) AcceptsGpus() bool {
+	if len(p.Tiers) == 0 {
+		return true
+	}
+	for _, tier := range p.Tiers {
+		if !strings.EqualFold(tier, TierCpu) {
+			return true
+		}
+	}
+	return false
+}
+
+// Offers tells whether the tier is offered.
+func (p *Policy) Offers(tier string) bool {
+	return len(p.Tiers) == 0 || containsTier(p.Tiers, tier)
+}
+
+// containsTier compares tiers ignoring case and the difference between spaces and dashes, as GPU
+// models are written both ways.
+func containsTier(tiers []string, tier string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), " ", "-"))
+	}
+	for _, t := range tiers {
+		if normalize(t) == normalize(tier) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsWallet(wallets []string, wallet string) bool {
+	for _, w := range wallets {
+		if strings.EqualFold(strings.TrimSpace(w), wallet) {
+			return true
+		}
+	}
+	return false
+}
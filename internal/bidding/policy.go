@@ -0,0 +1,104 @@
+package bidding
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Reason tells why a job was rejected.
+type Reason string
+
+const (
+	ReasonBiddingDisabled       Reason = "bidding_disabled"
+	ReasonTierNotOffered        Reason = "tier_not_offered"
+	ReasonDurationTooShort      Reason = "duration_too_short"
+	ReasonDurationTooLong       Reason = "duration_too_long"
+	ReasonWalletDenied          Reason = "wallet_denied"
+	ReasonWalletNotAllowed      Reason = "wallet_not_allowed"
+	ReasonPriceTooLow           Reason = "price_too_low"
+	ReasonTooManyJobs           Reason = "too_many_jobs"
+	ReasonUtilizationTooHigh    Reason = "utilization_too_high"
+	ReasonInsufficientResources Reason = "insufficient_resources"
+)
+
+// TierCpu is the tier of the jobs without GPU, the tier of the other jobs is their GPU model.
+const TierCpu = "CPU"
+
+// Policy decides which jobs the provider bids on. Empty lists and zero limits don't restrict.
+type Policy struct {
+	AutoBid bool `toml:"auto_bid"`
+
+	// Tiers are the hardware tiers offered, "CPU" or GPU models such as "NVIDIA A100".
+	Tiers       []string `toml:"tiers"`
+	MinDuration int      `toml:"min_duration"`
+	MaxDuration int      `toml:"max_duration"`
+
+	AllowWallets []string `toml:"allow_wallets"`
+	DenyWallets  []string `toml:"deny_wallets"`
+
+	MinPricePerGpuHour float64 `toml:"min_price_per_gpu_hour"`
+	MaxJobsPerWallet   int     `toml:"max_jobs_per_wallet"`
+	// MaxUtilization is the fraction of the cluster, 0 to 1, above which no more jobs are taken.
+	MaxUtilization float64 `toml:"max_utilization"`
+}
+
+// DefaultPolicy bids on every job.
+func DefaultPolicy() *Policy {
+	return &Policy{AutoBid: true}
+}
+
+// LoadPolicy reads a policy file. Without the file the default policy applies.
+func LoadPolicy(path string) (*Policy, error) {
+	policy := DefaultPolicy()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return policy, nil
+	}
+	if _, err := toml.DecodeFile(path, policy); err != nil {
+		return nil, fmt.Errorf("failed load bid policy %s, error: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bid policy %s, error: %w", path, err)
+	}
+	return policy, nil
+}
+
+func (p *Policy) Validate() error {
+	var problems []string
+	if p.MinDuration < 0 || p.MaxDuration < 0 {
+		problems = append(problems, "durations can't be negative")
+	}
+	if p.MaxDuration > 0 && p.MinDuration > p.MaxDuration {
+		problems = append(problems, "min_duration is longer than max_duration")
+	}
+	if p.MinPricePerGpuHour < 0 {
+		problems = append(problems, "min_price_per_gpu_hour can't be negative")
+	}
+	if p.MaxJobsPerWallet < 0 {
+		problems = append(problems, "max_jobs_per_wallet can't be negative")
+	}
+	if p.MaxUtilization < 0 || p.MaxUtilization > 1 {
+		problems = append(problems, "max_utilization must be between 0 and 1")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// SamplePolicy is a commented policy file that bids on every job, as the default policy does.
+const SamplePolicy = `# The bid policy of the provider, reloaded when this file changes.
+# Empty lists and zero limits don't restrict.
+auto_bid = true                       # false stops bidding on new jobs
+tiers = []                            # "CPU" or GPU models such as "NVIDIA A100", empty for all
+min_duration = 0                      # The shortest job in seconds
+max_duration = 0                      # The longest job in seconds
+allow_wallets = []                    # Only take the jobs of these wallets
+deny_wallets = []                     # Never take the jobs of these wallets
+min_price_per_gpu_hour = 0.0          # The lowest price per GPU per hour
+max_jobs_per_wallet = 0               # The running and deploying jobs a wallet may have
+max_utilization = 0.0                 # The fraction of the cluster, 0 to 1, above which no job is taken
+`
@@ -0,0 +1,408 @@
+package capacity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const gpuResourceName = "nvidia.com/gpu"
+
+// Resources is an amount of cpu in millicores, memory and ephemeral storage in bytes.
+type Resources struct {
+	CpuMilli int64
+	Memory   int64
+	Storage  int64
+}
+
+func (r Resources) Add(o Resources) Resources {
+	return Resources{CpuMilli: r.CpuMilli + o.CpuMilli, Memory: r.Memory + o.Memory, Storage: r.Storage + o.Storage}
+}
+
+// Sub returns r - o, never below zero.
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{CpuMilli: nonNegative(r.CpuMilli - o.CpuMilli), Memory: nonNegative(r.Memory - o.Memory), Storage: nonNegative(r.Storage - o.Storage)}
+}
+
+// Fits tells whether r is at least o.
+func (r Resources) Fits(o Resources) bool {
+	return r.CpuMilli >= o.CpuMilli && r.Memory >= o.Memory && r.Storage >= o.Storage
+}
+
+// GpuCount is the GPUs of one model. Used are requested by pods, Reserved by jobs still deploying.
+type GpuCount struct {
+	Total    int64
+	Used     int64
+	Reserved int64
+}
+
+func (g GpuCount) Free() int64 {
+	return nonNegative(g.Total - g.Used - g.Reserved)
+}
+
+// Reservation is what the jobs that are deploying will take, not yet requested by their pods.
+// Gpus is by GPU model, as in the node labels.
+type Reservation struct {
+	Resources
+	Gpus map[string]int64
+}
+
+// Node is the capacity of a node: what it can allocate, what its pods request and what is reserved
+// on it, with its GPUs by model.
+type Node struct {
+	Name         string
+	MachineId    string
+	Architecture string
+
+	Allocatable Resources
+	Requested   Resources
+	Reserved    Resources
+	Gpus        map[string]*GpuCount
+
+	gpu models.Gpu
+}
+
+func (n *Node) Free() Resources {
+	return n.Allocatable.Sub(n.Requested).Sub(n.Reserved)
+}
+
+// Cluster is the capacity of all the nodes, computed once from one listing of nodes and pods.
+type Cluster struct {
+	Nodes []*Node
+}
+
+// GpuModel is the model name of a GPU as used by node labels and node selectors.
+func GpuModel(productName string) string {
+	return strings.ReplaceAll(productName, " ", "-")
+}
+
+// Build computes the capacity from the nodes, their pods, the GPUs found by the hardware collectors
+// and the reservations of the deploying jobs. Pods that ended or are not scheduled are ignored.
+func Build(nodes []coreV1.Node, pods []coreV1.Pod, gpus map[string]models.Gpu, reservation Reservation) *Cluster {
+	podsByNode := make(map[string][]*coreV1.Pod)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName == "" || pod.Status.Phase == coreV1.PodSucceeded || pod.Status.Phase == coreV1.PodFailed {
+			continue
+		}
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+	}
+
+	cluster := &Cluster{}
+	for i := range nodes {
+		node := &nodes[i]
+		n := &Node{
+			Name:         node.Name,
+			MachineId:    node.Status.NodeInfo.MachineID,
+			Architecture: node.Status.NodeInfo.Architecture,
+			Allocatable: Resources{
+				CpuMilli: node.Status.Allocatable.Cpu().MilliValue(),
+				Memory:   node.Status.Allocatable.Memory().Value(),
+				Storage:  node.Status.Allocatable.StorageEphemeral().Value(),
+			},
+			Gpus: make(map[string]*GpuCount),
+			gpu:  gpus[node.Name],
+		}
+		for _, detail := range n.gpu.Details {
+			model := GpuModel(detail.ProductName)
+			if n.Gpus[model] == nil {
+				n.Gpus[model] = &GpuCount{}
+			}
+			n.Gpus[model].Total++
+		}
+
+		for _, pod := range podsByNode[node.Name] {
+			n.Requested = n.Requested.Add(PodRequests(pod))
+			if count := PodGpus(pod); count > 0 {
+				if gpu := n.Gpus[n.podGpuModel(pod)]; gpu != nil {
+					gpu.Used += count
+				}
+			}
+		}
+		cluster.Nodes = append(cluster.Nodes, n)
+	}
+	cluster.reserve(reservation)
+	return cluster
+}
+
+// reserve spreads the reservation over the nodes in order, as far as they have room.
+func (c *Cluster) reserve(reservation Reservation) {
+	left := reservation.Resources
+	for _, n := range c.Nodes {
+		free := n.Free()
+		take := Resources{
+			CpuMilli: min64(free.CpuMilli, left.CpuMilli),
+			Memory:   min64(free.Memory, left.Memory),
+			Storage:  min64(free.Storage, left.Storage),
+		}
+		n.Reserved = n.Reserved.Add(take)
+		left = left.Sub(take)
+	}
+
+	for model, count := range reservation.Gpus {
+		for _, n := range c.Nodes {
+			gpu := n.Gpus[model]
+			if gpu == nil || count == 0 {
+				continue
+			}
+			take := min64(gpu.Free(), count)
+			gpu.Reserved += take
+			count -= take
+		}
+	}
+}
+
+// podGpuModel is the GPU model a pod was scheduled for: the model of its node selector, or the only
+// model of the node.
+func (n *Node) podGpuModel(pod *coreV1.Pod) string {
+	for key := range pod.Spec.NodeSelector {
+		if _, ok := n.Gpus[key]; ok {
+			return key
+		}
+	}
+	if len(n.Gpus) == 1 {
+		for model := range n.Gpus {
+			return model
+		}
+	}
+	return ""
+}
+
+// PodRequests is what a pod requests: the sum of its containers, or its largest init container
+// when that is more, as the scheduler counts it.
+func PodRequests(pod *coreV1.Pod) Resources {
+	var requests Resources
+	for _, container := range pod.Spec.Containers {
+		requests = requests.Add(containerRequests(container))
+	}
+	for _, container := range pod.Spec.InitContainers {
+		init := containerRequests(container)
+		requests.CpuMilli = max64(requests.CpuMilli, init.CpuMilli)
+		requests.Memory = max64(requests.Memory, init.Memory)
+		requests.Storage = max64(requests.Storage, init.Storage)
+	}
+	return requests
+}
+
+func containerRequests(container coreV1.Container) Resources {
+	return Resources{
+		CpuMilli: container.Resources.Requests.Cpu().MilliValue(),
+		Memory:   container.Resources.Requests.Memory().Value(),
+		Storage:  container.Resources.Requests.StorageEphemeral().Value(),
+	}
+}
+
+// PodGpus is the number of GPUs a pod requests.
+func PodGpus(pod *coreV1.Pod) int64 {
+	var count, initCount int64
+	for _, container := range pod.Spec.Containers {
+		if val, ok := container.Resources.Requests[gpuResourceName]; ok {
+			count += val.Value()
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if val, ok := container.Resources.Requests[gpuResourceName]; ok {
+			initCount = max64(initCount, val.Value())
+		}
+	}
+	return max64(count, initCount)
+}
+
+// Add sums two reservations.
+func (r Reservation) Add(o Reservation) Reservation {
+	sum := Reservation{Resources: r.Resources.Add(o.Resources), Gpus: make(map[string]int64)}
+	for model, count := range r.Gpus {
+		sum.Gpus[model] += count
+	}
+	for model, count := range o.Gpus {
+		sum.Gpus[model] += count
+	}
+	return sum
+}
+
+// Fits tells whether a job needing r can be placed on one of the nodes, with what is left after the
+// reservations the cluster was built with.
+func (c *Cluster) Fits(r Reservation) error {
+	var gpuFits bool
+	for _, n := range c.Nodes {
+		gpusFree := true
+		for model, count := range r.Gpus {
+			if gpu := n.Gpus[model]; count > 0 && (gpu == nil || gpu.Free() < count) {
+				gpusFree = false
+			}
+		}
+		if !gpusFree {
+			continue
+		}
+		gpuFits = true
+		if n.Free().Fits(r.Resources) {
+			return nil
+		}
+	}
+	if !gpuFits {
+		for model, count := range r.Gpus {
+			if count > 0 {
+				return fmt.Errorf("no node has %d free %s", count, model)
+			}
+		}
+	}
+	return fmt.Errorf("no node has %d millicores of cpu, %s of memory and %s of storage free",
+		r.CpuMilli, formatGiB(r.Memory), formatGiB(r.Storage))
+}
+
+// Free is the sum of what the nodes have free.
+func (c *Cluster) Free() Resources {
+	var free Resources
+	for _, n := range c.Nodes {
+		free = free.Add(n.Free())
+	}
+	return free
+}
+
+// FreeGpus is the number of free GPUs by model.
+func (c *Cluster) FreeGpus() map[string]int64 {
+	free := make(map[string]int64)
+	for _, n := range c.Nodes {
+		for model, gpu := range n.Gpus {
+			free[model] += gpu.Free()
+		}
+	}
+	return free
+}
+
+// Utilization is the fraction, 0 to 1, of the cpu and the memory requested or reserved, the larger
+// of both. With a GPU model, the fraction of its GPUs counts too.
+func (c *Cluster) Utilization(gpuModel string) float64 {
+	var allocatable, free Resources
+	var gpuTotal, gpuFree int64
+	for _, n := range c.Nodes {
+		allocatable = allocatable.Add(n.Allocatable)
+		free = free.Add(n.Free())
+		if gpu := n.Gpus[gpuModel]; gpu != nil {
+			gpuTotal += gpu.Total
+			gpuFree += gpu.Free()
+		}
+	}
+
+	var utilization float64
+	fraction := func(total, free int64) {
+		if total > 0 {
+			if used := float64(total-free) / float64(total); used > utilization {
+				utilization = used
+			}
+		}
+	}
+	fraction(allocatable.CpuMilli, free.CpuMilli)
+	fraction(allocatable.Memory, free.Memory)
+	if gpuModel != "" {
+		if gpuTotal == 0 {
+			return 1
+		}
+		fraction(gpuTotal, gpuFree)
+	}
+	return utilization
+}
+
+// Satisfies tells whether the free resources are at least the quotas of the policy.
+func (c *Cluster) Satisfies(policy models.ResourcePolicy) (bool, error) {
+	memory, err := QuotaBytes(policy.Memory)
+	if err != nil {
+		return false, err
+	}
+	storage, err := QuotaBytes(policy.Storage)
+	if err != nil {
+		return false, err
+	}
+	return c.Free().Fits(Resources{CpuMilli: policy.Cpu.Quota * 1000, Memory: memory, Storage: storage}), nil
+}
+
+// QuotaBytes converts a memory or storage quota to bytes, its unit defaults to GiB.
+func QuotaBytes(quota models.Quota) (int64, error) {
+	units := map[string]int64{"": 1 << 30, "B": 1, "KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40,
+		"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12}
+	factor, ok := units[strings.ToUpper(strings.TrimSpace(quota.Unit))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", quota.Unit)
+	}
+	return quota.Quota * factor, nil
+}
+
+// NodeResources is the report of the nodes. The status of a GPU detail tells whether a GPU of its
+// model is free, as many of them are available as the model has free GPUs.
+func (c *Cluster) NodeResources() []*models.NodeResource {
+	var result []*models.NodeResource
+	for _, n := range c.Nodes {
+		free := n.Free()
+		used := n.Allocatable.Sub(free)
+		resource := &models.NodeResource{
+			MachineId: n.MachineId,
+			Model:     n.Architecture,
+			Cpu: models.Common{
+				Total: strconv.FormatInt(n.Allocatable.CpuMilli/1000, 10),
+				Used:  strconv.FormatInt((used.CpuMilli+999)/1000, 10),
+				Free:  strconv.FormatInt(free.CpuMilli/1000, 10),
+			},
+			Memory:  commonGiB(n.Allocatable.Memory, used.Memory, free.Memory),
+			Storage: commonGiB(n.Allocatable.Storage, used.Storage, free.Storage),
+		}
+		resource.Vcpu = resource.Cpu
+
+		if len(n.gpu.Details) > 0 {
+			available := make(map[string]int64)
+			for model, gpu := range n.Gpus {
+				available[model] = gpu.Free()
+			}
+			details := make([]models.GpuDetail, 0, len(n.gpu.Details))
+			for _, detail := range n.gpu.Details {
+				model := GpuModel(detail.ProductName)
+				if available[model] > 0 {
+					detail.Status = models.Available
+					available[model]--
+				} else {
+					detail.Status = models.Occupied
+				}
+				details = append(details, detail)
+			}
+			resource.Gpu = n.gpu
+			resource.Gpu.Details = details
+		}
+		result = append(result, resource)
+	}
+	return result
+}
+
+func commonGiB(total, used, free int64) models.Common {
+	return models.Common{
+		Total: formatGiB(total),
+		Used:  formatGiB(used),
+		Free:  formatGiB(free),
+	}
+}
+
+func formatGiB(bytes int64) string {
+	return fmt.Sprintf("%.2f GiB", float64(bytes)/(1<<30))
+}
+
+func nonNegative(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,384 @@
+package computing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/bidding"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/util"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	batchJobPrefix       = "batch-"
+	batchWorkloadName    = "workload"
+	batchCollectorName   = "output-collector"
+	batchOutputVolume    = "output"
+	batchOutputPath      = "/output"
+	batchMaxRetries      = 5
+	defaultBatchTimeout  = 3600
+	batchCollectTimeout  = 10 * time.Minute
+	batchStatusRetention = 7 * 24 * time.Hour
+)
+
+// batchCollectorScript keeps the pod alive once the workload is done, until the provider has fetched
+// /output or the collect timeout passes. The job only succeeds when the output was fetched.
+var batchCollectorScript = fmt.Sprintf(`i=0
+while [ ! -f /tmp/collected ] && [ "$i" -lt %d ]; do sleep 1; i=$((i+1)); done
+[ -f /tmp/collected ]`, int(batchCollectTimeout.Seconds()))
+
+// ReceiveBatchJob runs a container to completion as a Kubernetes Job. The files it writes to /output
+// are uploaded to the bucket and reported as the result of the job.
+func ReceiveBatchJob(c *gin.Context) {
+	var req models.BatchJobReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("batch job received: %+v", req)
+
+	if err := checkBatchJobReq(&req); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BatchParamError, err.Error()))
+		return
+	}
+
+	_, hardware := getHardwareDetail(req.Hardware)
+	job := bidding.Job{Uuid: req.UUID, Wallet: req.WalletAddress, Duration: int(req.Timeout)}
+	job.Tier, job.Gpus = jobTier(hardware)
+	if decision := decideJob(job, "lad_batch="+req.UUID); !decision.Accept {
+		response := util.CreateErrorResponse(util.BatchRejected, decision.Message)
+		response.Data = decision
+		c.JSON(http.StatusForbidden, response)
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	statusKey := constants.REDIS_BATCH_PREFIX + req.UUID
+	created, err := redis.Int(conn.Do("HSETNX", statusKey, "status", string(models.JobBatchPending)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.BatchError, err.Error()))
+		return
+	}
+	if created == 0 {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BatchParamError, "the job is already submitted"))
+		return
+	}
+	conn.Do("HSET", statusKey, "wallet_address", req.WalletAddress)
+
+	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(req.WalletAddress)
+	if err = reserveJob(req.UUID, k8sNameSpace, "lad_batch="+req.UUID, hardware); err != nil {
+		conn.Do("DEL", statusKey)
+		if errors.Is(err, ErrInsufficientResources) {
+			c.JSON(http.StatusConflict, util.CreateErrorResponse(util.BatchError, err.Error()))
+		} else {
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.BatchError, err.Error()))
+		}
+		return
+	}
+
+	go runBatchJob(req)
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(models.BatchJobStatus{
+		UUID:   req.UUID,
+		Status: models.JobBatchPending,
+	}))
+}
+
+// GetBatchJob returns the stage of a batch job and its result once it succeeded.
+func GetBatchJob(c *gin.Context) {
+	jobUuid := c.Query("job_uuid")
+	if strings.TrimSpace(jobUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.BatchParamError, "missing required field: job_uuid"))
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_BATCH_PREFIX+jobUuid))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.BatchError, err.Error()))
+		return
+	}
+	if len(values) == 0 {
+		c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.BatchParamError, "job not found"))
+		return
+	}
+	var attempts int32
+	fmt.Sscan(values["attempts"], &attempts)
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(models.BatchJobStatus{
+		UUID:         jobUuid,
+		Status:       models.JobStatus(values["status"]),
+		Attempts:     attempts,
+		Reason:       values["reason"],
+		ResultCid:    values["result_cid"],
+		JobResultURI: values["job_result_uri"],
+	}))
+}
+
+func checkBatchJobReq(req *models.BatchJobReq) error {
+	req.UUID = strings.TrimSpace(req.UUID)
+	switch {
+	case req.UUID == "":
+		return fmt.Errorf("missing required field: uuid")
+	case strings.TrimSpace(req.WalletAddress) == "":
+		return fmt.Errorf("missing required field: wallet_address")
+	case strings.TrimSpace(req.Image) == "":
+		return fmt.Errorf("missing required field: image")
+	case strings.TrimSpace(req.Hardware) == "":
+		return fmt.Errorf("missing required field: hardware")
+	case len(strings.Split(req.Hardware, "·")) < 3:
+		return fmt.Errorf("hardware must be like \"CPU only · 2 vCPU · 16 GiB\"")
+	case req.Retries < 0 || req.Retries > batchMaxRetries:
+		return fmt.Errorf("retries range is [0~%d]", batchMaxRetries)
+	}
+
+	maxTimeout := conf.GetConfig().Batch.MaxTimeout
+	if req.Timeout <= 0 {
+		req.Timeout = defaultBatchTimeout
+	}
+	if maxTimeout > 0 && req.Timeout > maxTimeout {
+		return fmt.Errorf("timeout is longer than the %d seconds allowed by the provider", maxTimeout)
+	}
+	return nil
+}
+
+// updateBatchStatus keeps the stage of a batch job for GetBatchJob and reports it.
+func updateBatchStatus(jobUuid string, status models.JobStatus, fields ...interface{}) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	statusKey := constants.REDIS_BATCH_PREFIX + jobUuid
+	args := append([]interface{}{statusKey, "status", string(status)}, fields...)
+	if _, err := conn.Do("HSET", args...); err != nil {
+		logs.GetLogger().Errorf("Failed save status of batch job %s, error: %+v", jobUuid, err)
+	}
+	if status == models.JobBatchSucceeded || status == models.JobBatchFailed {
+		conn.Do("EXPIRE", statusKey, int64(batchStatusRetention.Seconds()))
+	}
+
+	job := models.Job{Uuid: jobUuid, Status: status}
+	for i := 0; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "reason":
+			job.Progress = fmt.Sprint(fields[i+1])
+		case "attempts":
+			job.Progress = fmt.Sprintf("attempt %v", fields[i+1])
+		case "job_result_uri":
+			job.ResultUri = fmt.Sprint(fields[i+1])
+		}
+	}
+	go func() {
+		deployingChan <- job
+	}()
+}
+
+func runBatchJob(req models.BatchJobReq) {
+	defer releaseJob(req.UUID)
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("batch job panic, error: %+v", err)
+			updateBatchStatus(req.UUID, models.JobBatchFailed, "reason", "internal error")
+		}
+	}()
+
+	deploy := NewDeploy(req.UUID, "", req.WalletAddress, req.Hardware, req.Timeout)
+	if err := deploy.deployNamespace(); err != nil {
+		logs.GetLogger().Errorf("Failed create namespace of batch job %s, error: %+v", req.UUID, err)
+		updateBatchStatus(req.UUID, models.JobBatchFailed, "reason", err.Error())
+		return
+	}
+
+	k8sService := NewK8sService()
+	jobs := k8sService.k8sClient.BatchV1().Jobs(deploy.k8sNameSpace)
+	job, err := jobs.Create(context.TODO(), deploy.batchJob(req), metaV1.CreateOptions{})
+	if err != nil {
+		logs.GetLogger().Errorf("Failed create batch job %s, error: %+v", req.UUID, err)
+		updateBatchStatus(req.UUID, models.JobBatchFailed, "reason", err.Error())
+		return
+	}
+	defer func() {
+		propagation := metaV1.DeletePropagationBackground
+		if err := jobs.Delete(context.TODO(), job.Name, metaV1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			logs.GetLogger().Errorf("Failed delete batch job %s, error: %+v", job.Name, err)
+		}
+	}()
+	logs.GetLogger().Infof("Created batch job %s in %s", job.Name, deploy.k8sNameSpace)
+
+	resultCid, resultUri, err := deploy.waitBatchJob(job.Name, req)
+	if err != nil {
+		logs.GetLogger().Errorf("Batch job %s failed, error: %+v", req.UUID, err)
+		updateBatchStatus(req.UUID, models.JobBatchFailed, "reason", err.Error())
+		return
+	}
+	logs.GetLogger().Infof("Batch job %s succeeded, result: %s", req.UUID, resultUri)
+	updateBatchStatus(req.UUID, models.JobBatchSucceeded, "result_cid", resultCid, "job_result_uri", resultUri)
+}
+
+// batchJob runs the workload as init container, so the collector only starts once it succeeded.
+// Failed attempts are retried by the Job, the whole job is bounded by the timeout.
+func (d *Deploy) batchJob(req models.BatchJobReq) *batchV1.Job {
+	var env []coreV1.EnvVar
+	for name, value := range req.Env {
+		env = append(env, coreV1.EnvVar{Name: name, Value: value})
+	}
+	outputMount := []coreV1.VolumeMount{{Name: batchOutputVolume, MountPath: batchOutputPath}}
+
+	collectorImage := conf.GetConfig().Batch.CollectorImage
+	if collectorImage == "" {
+		collectorImage = "busybox:1.36"
+	}
+	collectorResources := coreV1.ResourceList{
+		coreV1.ResourceCPU:    resource.MustParse("100m"),
+		coreV1.ResourceMemory: resource.MustParse("64Mi"),
+	}
+
+	labels := map[string]string{"lad_batch": req.UUID}
+	deadline := req.Timeout + int64(batchCollectTimeout.Seconds())
+	ttl := int32(time.Hour.Seconds())
+	return &batchV1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      batchJobPrefix + req.UUID,
+			Namespace: d.k8sNameSpace,
+			Labels:    labels,
+		},
+		Spec: batchV1.JobSpec{
+			BackoffLimit:            &req.Retries,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{Labels: labels},
+				Spec: coreV1.PodSpec{
+					RestartPolicy: coreV1.RestartPolicyNever,
+					NodeSelector:  generateLabel(d.hardwareResource.Gpu.Unit),
+					InitContainers: []coreV1.Container{{
+						Name:            batchWorkloadName,
+						Image:           req.Image,
+						Command:         req.Command,
+						Args:            req.Args,
+						Env:             env,
+						Resources:       d.createResources(),
+						VolumeMounts:    outputMount,
+						ImagePullPolicy: coreV1.PullIfNotPresent,
+					}},
+					Containers: []coreV1.Container{{
+						Name:            batchCollectorName,
+						Image:           collectorImage,
+						Command:         []string{"/bin/sh", "-c", batchCollectorScript},
+						Resources:       coreV1.ResourceRequirements{Limits: collectorResources, Requests: collectorResources},
+						VolumeMounts:    outputMount,
+						ImagePullPolicy: coreV1.PullIfNotPresent,
+					}},
+					Volumes: []coreV1.Volume{{
+						Name:         batchOutputVolume,
+						VolumeSource: coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// waitBatchJob follows the job until its workload succeeded, then uploads its output.
+func (d *Deploy) waitBatchJob(jobName string, req models.BatchJobReq) (string, string, error) {
+	k8sService := NewK8sService()
+	deadline := time.Now().Add(time.Duration(req.Timeout)*time.Second + batchCollectTimeout)
+	var reported models.JobStatus = models.JobBatchPending
+	var attempts int32
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Now().After(deadline) {
+			return "", "", fmt.Errorf("timed out after %d seconds", req.Timeout)
+		}
+
+		job, err := k8sService.k8sClient.BatchV1().Jobs(d.k8sNameSpace).Get(context.TODO(), jobName, metaV1.GetOptions{})
+		if err != nil {
+			return "", "", err
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchV1.JobFailed && condition.Status == coreV1.ConditionTrue {
+				return "", "", fmt.Errorf("%s: %s", condition.Reason, condition.Message)
+			}
+		}
+
+		pods, err := k8sService.ListPods(context.TODO(), d.k8sNameSpace, "job-name="+jobName)
+		if err != nil {
+			continue
+		}
+		for _, pod := range pods {
+			if pod.Status.Phase != coreV1.PodPending && pod.Status.Phase != coreV1.PodRunning {
+				continue
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == batchCollectorName && status.State.Running != nil {
+					updateBatchStatus(req.UUID, models.JobBatchCollecting)
+					return d.collectBatchOutput(pod.Name, req.UUID)
+				}
+			}
+			for _, status := range pod.Status.InitContainerStatuses {
+				if status.Name == batchWorkloadName && status.State.Running != nil {
+					if reported != models.JobBatchRunning || attempts != job.Status.Failed+1 {
+						reported, attempts = models.JobBatchRunning, job.Status.Failed+1
+						updateBatchStatus(req.UUID, models.JobBatchRunning, "attempts", attempts)
+					}
+				}
+			}
+		}
+	}
+	return "", "", fmt.Errorf("stopped watching the job")
+}
+
+// collectBatchOutput fetches /output as tar.gz from the collector and uploads it to the bucket.
+func (d *Deploy) collectBatchOutput(podName, jobUuid string) (string, string, error) {
+	fileCachePath := conf.GetConfig().MCS.FileCachePath
+	objectName := filepath.Join("batch", jobUuid+".tar.gz")
+	outputPath := filepath.Join(fileCachePath, objectName)
+	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+		return "", "", err
+	}
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(outputPath)
+	defer outputFile.Close()
+
+	k8sService := NewK8sService()
+	if err = k8sService.ExecInPod(d.k8sNameSpace, podName, batchCollectorName, []string{"tar", "-czf", "-", "-C", batchOutputPath, "."}, outputFile); err != nil {
+		return "", "", fmt.Errorf("failed collect output, error: %w", err)
+	}
+	if err = outputFile.Close(); err != nil {
+		return "", "", err
+	}
+	if err = k8sService.ExecInPod(d.k8sNameSpace, podName, batchCollectorName, []string{"touch", "/tmp/collected"}, io.Discard); err != nil {
+		logs.GetLogger().Warnf("Failed release collector of batch job %s, error: %v", jobUuid, err)
+	}
+
+	storageService := NewStorageService()
+	mcsOssFile, err := storageService.UploadFileToBucket(objectName, outputPath, true)
+	if err != nil {
+		return "", "", fmt.Errorf("failed upload output, error: %w", err)
+	}
+	gatewayUrl, err := storageService.GetGatewayUrl()
+	if err != nil {
+		return "", "", fmt.Errorf("failed get mcs ipfs gatewayUrl, error: %w", err)
+	}
+	return mcsOssFile.PayloadCid, *gatewayUrl + "/ipfs/" + mcsOssFile.PayloadCid, nil
+}
@@ -0,0 +1,165 @@
+package computing
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/bidding"
+	"github.com/lagrangedao/go-computing-provider/internal/capacity"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/util"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const BidPolicyFile = "bid_policy.toml"
+
+var (
+	bidPolicyLock sync.RWMutex
+	bidPolicy     = bidding.DefaultPolicy()
+)
+
+// LoadBidPolicy reads the bid policy of the repo and reloads it whenever the file changes. A policy
+// that fails to reload is logged and the previous one stays.
+func LoadBidPolicy(cpRepoPath string) error {
+	path := filepath.Join(cpRepoPath, BidPolicyFile)
+	if err := reloadBidPolicy(path); err != nil {
+		return err
+	}
+	util.WatchFile(path, 5*time.Second, func() {
+		if err := reloadBidPolicy(path); err != nil {
+			logs.GetLogger().Errorf("The bid policy is not reloaded, error: %v", err)
+			return
+		}
+		logs.GetLogger().Infof("Reloaded the bid policy %s", path)
+	})
+	return nil
+}
+
+func reloadBidPolicy(path string) error {
+	policy, err := bidding.LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	bidPolicyLock.Lock()
+	defer bidPolicyLock.Unlock()
+	bidPolicy = policy
+	return nil
+}
+
+func currentBidPolicy() *bidding.Policy {
+	bidPolicyLock.RLock()
+	defer bidPolicyLock.RUnlock()
+	return bidPolicy
+}
+
+// jobTier is the tier of a job of the hardware and its number of GPUs.
+func jobTier(hardware models.Resource) (string, int64) {
+	if hardware.Gpu.Unit == "" || hardware.Gpu.Quantity == 0 {
+		return bidding.TierCpu, 0
+	}
+	return hardware.Gpu.Unit, hardware.Gpu.Quantity
+}
+
+// decideJob applies the bid policy to an incoming job, whose pods match selector.
+func decideJob(job bidding.Job, selector string) bidding.Decision {
+	policy := currentBidPolicy()
+
+	var state bidding.State
+	if policy.MaxJobsPerWallet > 0 && job.Wallet != "" {
+		walletJobs, err := countWalletJobs(job.Wallet, selector)
+		if err != nil {
+			logs.GetLogger().Errorf("Failed count the jobs of wallet %s, error: %v", job.Wallet, err)
+		}
+		state.WalletJobs = walletJobs
+	}
+	if policy.MaxUtilization > 0 {
+		if cluster, err := clusterCapacity(context.TODO()); err != nil {
+			logs.GetLogger().Errorf("Failed get the cluster capacity, error: %v", err)
+		} else {
+			var gpuModel string
+			if job.Gpus > 0 {
+				gpuModel = capacity.GpuModel(job.Tier)
+			}
+			state.Utilization = cluster.Utilization(gpuModel)
+		}
+	}
+
+	decision := policy.Decide(job, state)
+	if !decision.Accept {
+		logs.GetLogger().Infof("Job %s is rejected, reason: %s, %s", job.Uuid, decision.Reason, decision.Message)
+	}
+	return decision
+}
+
+// countWalletJobs counts the spaces and batch jobs of a wallet, with the ones still deploying. The job
+// matching selector is left out, a redeploy or renewal of a space doesn't count against its own wallet.
+func countWalletJobs(wallet, selector string) (int, error) {
+	k8sService := NewK8sService()
+	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(wallet)
+
+	jobs := make(map[string]bool)
+	deployments, err := k8sService.k8sClient.AppsV1().Deployments(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{LabelSelector: "lad_app"})
+	if err != nil {
+		return 0, err
+	}
+	for _, deployment := range deployments.Items {
+		jobs["lad_app="+deployment.Labels["lad_app"]] = true
+	}
+	batchJobs, err := k8sService.k8sClient.BatchV1().Jobs(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{LabelSelector: "lad_batch"})
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range batchJobs.Items {
+		if job.Status.Active > 0 {
+			jobs["lad_batch="+job.Labels["lad_batch"]] = true
+		}
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	reservations, err := ledgerReservations(conn)
+	if err != nil && err != redis.ErrNil {
+		return 0, err
+	}
+	for _, reservation := range reservations {
+		if reservation.Namespace == k8sNameSpace {
+			jobs[reservation.Selector] = true
+		}
+	}
+	delete(jobs, selector)
+	return len(jobs), nil
+}
+
+// providerBidStatus is the bid status reported with the provider: disabled by the policy, GPU jobs
+// disabled when no GPU of an offered tier is free, enabled otherwise.
+func providerBidStatus() models.BidStatus {
+	policy := currentBidPolicy()
+	if !policy.AutoBid {
+		return models.BidDisabledStatus
+	}
+	if !policy.AcceptsGpus() {
+		return models.BidGpuDisabledStatus
+	}
+
+	cluster, err := clusterCapacity(context.TODO())
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get the cluster capacity, error: %v", err)
+		return models.BidEnabledStatus
+	}
+	var freeGpus int64
+	for model, count := range cluster.FreeGpus() {
+		if policy.Offers(model) {
+			freeGpus += count
+		}
+	}
+	if freeGpus == 0 {
+		return models.BidGpuDisabledStatus
+	}
+	return models.BidEnabledStatus
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
 	"io"
 	"io/fs"
 	"log"
@@ -35,7 +36,7 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 
 		imagePath := filepath.Join(buildFolder, getDownloadPath(files[0].Name))
 		var containsYaml bool
-		var yamlPath string
+		var yamlPath, composePath string
 		var modelsSetting string
 
 		err = filepath.WalkDir(imagePath, func(path string, d fs.DirEntry, err error) error {
@@ -43,6 +44,9 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 				containsYaml = true
 				yamlPath = path
 			}
+			if yaml.IsComposeFile(path) {
+				composePath = path
+			}
 			if strings.EqualFold(d.Name(), "model-setting.json") {
 				modelsSetting = path
 			}
@@ -51,6 +55,11 @@ func BuildSpaceTaskImage(spaceUuid string, files []models.SpaceFile) (bool, stri
 		if err != nil {
 			return containsYaml, yamlPath, imagePath, modelsSetting, err
 		}
+		// a deploy.yaml wins over a compose file next to it
+		if !containsYaml && composePath != "" {
+			containsYaml = true
+			yamlPath = composePath
+		}
 		return containsYaml, yamlPath, imagePath, modelsSetting, nil
 	} else {
 		logs.GetLogger().Warnf("Space %s is not found.", spaceUuid)
@@ -65,13 +74,7 @@ func getDownloadPath(fileName string) string {
 
 func BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath string) (string, string) {
 	updateJobStatus(jobUuid, models.JobBuildImage)
-	spaceFlag := spaceName + spaceUuid[strings.LastIndex(spaceUuid, "-"):]
-	imageName := fmt.Sprintf("lagrange/%s:%d", spaceFlag, time.Now().Unix())
-	if conf.GetConfig().Registry.ServerAddress != "" {
-		imageName = fmt.Sprintf("%s/%s:%d",
-			strings.TrimSpace(conf.GetConfig().Registry.ServerAddress), spaceFlag, time.Now().Unix())
-	}
-	imageName = strings.ToLower(imageName)
+	imageName := spaceImageName(spaceUuid, spaceName, "")
 	dockerfilePath := filepath.Join(imagePath, "Dockerfile")
 	log.Printf("Image path: %s", imagePath)
 
@@ -91,6 +94,47 @@ func BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath string) (s
 	return imageName, dockerfilePath
 }
 
+// BuildComposeImage builds the image of a compose service that has a build context instead of an image.
+func BuildComposeImage(jobUuid, spaceUuid, spaceName, serviceName string, build *yaml.BuildSpec) (string, error) {
+	updateJobStatus(jobUuid, models.JobBuildImage)
+	imageName := spaceImageName(spaceUuid, spaceName, serviceName)
+	log.Printf("Image path: %s", build.Context)
+
+	var opts []BuildOption
+	if build.Dockerfile != "" {
+		opts = append(opts, WithDockerfile(build.Dockerfile))
+	}
+	if len(build.Args) > 0 {
+		opts = append(opts, WithBuildArgs(build.Args))
+	}
+
+	dockerService := NewDockerService()
+	if err := dockerService.BuildImage(build.Context, imageName, opts...); err != nil {
+		return "", fmt.Errorf("failed build image of service %s, %w", serviceName, err)
+	}
+
+	if conf.GetConfig().Registry.ServerAddress != "" {
+		updateJobStatus(jobUuid, models.JobPushImage)
+		if err := dockerService.PushImage(imageName); err != nil {
+			return "", fmt.Errorf("failed push image of service %s, %w", serviceName, err)
+		}
+	}
+	return imageName, nil
+}
+
+func spaceImageName(spaceUuid, spaceName, serviceName string) string {
+	spaceFlag := spaceName + spaceUuid[strings.LastIndex(spaceUuid, "-"):]
+	if serviceName != "" {
+		spaceFlag += "-" + serviceName
+	}
+	imageName := fmt.Sprintf("lagrange/%s:%d", spaceFlag, time.Now().Unix())
+	if conf.GetConfig().Registry.ServerAddress != "" {
+		imageName = fmt.Sprintf("%s/%s:%d",
+			strings.TrimSpace(conf.GetConfig().Registry.ServerAddress), spaceFlag, time.Now().Unix())
+	}
+	return strings.ToLower(imageName)
+}
+
 func downloadFile(filepath string, url string) error {
 	out, err := os.Create(filepath)
 	if err != nil {
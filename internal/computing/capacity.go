@@ -0,0 +1,81 @@
+package computing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/internal/capacity"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// capacityTTL is about a tick of the resource report, the provider status of the same tick reuses
+// its capacity.
+const capacityTTL = 10 * time.Second
+
+var (
+	capacityLock     sync.Mutex
+	capacityCluster  *capacity.Cluster
+	capacityComputed time.Time
+)
+
+// clusterCapacity returns the capacity of the cluster, computed at most once per capacityTTL.
+func clusterCapacity(ctx context.Context) (*capacity.Cluster, error) {
+	capacityLock.Lock()
+	defer capacityLock.Unlock()
+
+	if capacityCluster != nil && time.Since(capacityComputed) < capacityTTL {
+		return capacityCluster, nil
+	}
+	cluster, err := buildCapacity(ctx, reservedResources(), "", "")
+	if err != nil {
+		return nil, err
+	}
+	capacityCluster, capacityComputed = cluster, time.Now()
+	return cluster, nil
+}
+
+// buildCapacity computes the capacity of the cluster now, less the reservation. The pods matching
+// replacedSelector in replacedNamespace are left out, a deploy is about to replace them.
+func buildCapacity(ctx context.Context, reservation capacity.Reservation, replacedNamespace, replacedSelector string) (*capacity.Cluster, error) {
+	k8sService := NewK8sService()
+	nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8sService.k8sClient.CoreV1().Pods("").List(ctx, metaV1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	podItems := pods.Items
+	if replacedSelector != "" {
+		selector, err := labels.Parse(replacedSelector)
+		if err != nil {
+			return nil, err
+		}
+		podItems = nil
+		for _, pod := range pods.Items {
+			if pod.Namespace == replacedNamespace && selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			podItems = append(podItems, pod)
+		}
+	}
+	nodeGpus, err := collectNodeGpus(ctx)
+	if err != nil {
+		logs.GetLogger().Errorf("Collect cluster gpu info Failed, if have available gpu, please check the hardware collectors. error: %+v", err)
+	}
+
+	return capacity.Build(nodes.Items, podItems, nodeGpus, reservation), nil
+}
+
+// invalidateCapacity makes the next consumer compute the capacity again, after a reservation changed.
+func invalidateCapacity() {
+	capacityLock.Lock()
+	defer capacityLock.Unlock()
+	capacityCluster = nil
+}
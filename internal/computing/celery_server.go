@@ -84,3 +84,29 @@ func (s *CeleryService) Start() {
 func (s *CeleryService) Stop() {
 	s.cli.StopWorker()
 }
+
+// scanKeys returns the keys matching pattern, without blocking redis the way KEYS does.
+func scanKeys(pattern string) ([]string, error) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package computing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/util"
+	"golang.org/x/crypto/acme"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	acmeAccountKeyFile      = "acme_account_key"
+	defaultAcmeDirectoryUrl = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultTlsSecretName    = "cp-wildcard-tls"
+	acmeChallengePrefix     = "_acme-challenge."
+)
+
+var certService *CertService
+
+// CertService obtains the wildcard certificate of API.Domain through ACME DNS-01,
+// keeps it in a kubernetes TLS secret and hot-reloads it into util.ServeHttp.
+type CertService struct {
+	acmeConf    conf.ACME
+	cpRepoPath  string
+	domain      string
+	dnsProvider DNSProvider
+	client      *acme.Client
+}
+
+func NewCertService(cpRepoPath string) (*CertService, error) {
+	acmeConf := conf.GetConfig().ACME
+	if acmeConf.DirectoryUrl == "" {
+		acmeConf.DirectoryUrl = defaultAcmeDirectoryUrl
+	}
+	if acmeConf.RenewBeforeDays <= 0 {
+		acmeConf.RenewBeforeDays = 30
+	}
+	if acmeConf.SecretNamespace == "" {
+		acmeConf.SecretNamespace = metaV1.NamespaceDefault
+	}
+	if acmeConf.SecretName == "" {
+		acmeConf.SecretName = defaultTlsSecretName
+	}
+
+	dnsProvider, err := NewDNSProvider(acmeConf)
+	if err != nil {
+		return nil, err
+	}
+
+	certService = &CertService{
+		acmeConf:    acmeConf,
+		cpRepoPath:  cpRepoPath,
+		domain:      strings.TrimPrefix(conf.GetConfig().API.Domain, "."),
+		dnsProvider: dnsProvider,
+	}
+	return certService, nil
+}
+
+// Run loads the stored certificate and renews it when it is about to expire, checking twice a day.
+func (cs *CertService) Run() {
+	cs.ensureCertificate()
+
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		cs.ensureCertificate()
+		renewCustomDomainCertificates()
+	}
+}
+
+func (cs *CertService) ensureCertificate() {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("catch panic error: %+v", err)
+		}
+	}()
+
+	ctx := context.TODO()
+	k8sService := NewK8sService()
+	secret, err := k8sService.GetSecret(ctx, cs.acmeConf.SecretNamespace, cs.acmeConf.SecretName)
+	if err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed get tls secret %s/%s, error: %+v", cs.acmeConf.SecretNamespace, cs.acmeConf.SecretName, err)
+		return
+	}
+
+	if err == nil {
+		certPEM, keyPEM := secret.Data[coreV1.TLSCertKey], secret.Data[coreV1.TLSPrivateKeyKey]
+		if notAfter, err := certNotAfter(certPEM); err == nil && time.Until(notAfter) > cs.renewBefore() {
+			if util.CertificateExpiry().Equal(notAfter) {
+				return
+			}
+			if err = util.LoadCertificate(certPEM, keyPEM); err != nil {
+				logs.GetLogger().Errorf("Failed load certificate from secret, error: %+v", err)
+			} else {
+				logs.GetLogger().Infof("Loaded certificate of *.%s, expire time: %s", cs.domain, notAfter.Format(time.RFC3339))
+				return
+			}
+		}
+	}
+
+	logs.GetLogger().Infof("Start obtaining certificate of *.%s from %s", cs.domain, cs.acmeConf.DirectoryUrl)
+	certPEM, keyPEM, err := cs.Obtain(ctx, "*."+cs.domain, cs.domain)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed obtain certificate, error: %+v", err)
+		return
+	}
+
+	if err = cs.storeSecret(ctx, cs.acmeConf.SecretNamespace, cs.acmeConf.SecretName, certPEM, keyPEM); err != nil {
+		logs.GetLogger().Errorf("Failed store tls secret, error: %+v", err)
+	}
+	if err = util.LoadCertificate(certPEM, keyPEM); err != nil {
+		logs.GetLogger().Errorf("Failed load certificate, error: %+v", err)
+		return
+	}
+	logs.GetLogger().Infof("Obtained certificate of *.%s successfully", cs.domain)
+}
+
+func (cs *CertService) renewBefore() time.Duration {
+	return time.Duration(cs.acmeConf.RenewBeforeDays) * 24 * time.Hour
+}
+
+func (cs *CertService) storeSecret(ctx context.Context, namespace, name string, certPEM, keyPEM []byte) error {
+	secret := &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: coreV1.SecretTypeTLS,
+		Data: map[string][]byte{
+			coreV1.TLSCertKey:       certPEM,
+			coreV1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+	_, err := NewK8sService().CreateOrUpdateSecret(ctx, namespace, secret)
+	return err
+}
+
+// Obtain runs a full ACME order for the given DNS names and returns the PEM encoded chain and private key.
+func (cs *CertService) Obtain(ctx context.Context, domains ...string) ([]byte, []byte, error) {
+	client, err := cs.acmeClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed create order, error: %w", err)
+	}
+
+	for _, authzUrl := range order.AuthzURLs {
+		if err = cs.solveAuthorization(ctx, client, authzUrl); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, fmt.Errorf("failed wait order ready, error: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	derChain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed finalize order, error: %w", err)
+	}
+
+	var certPEM []byte
+	for _, der := range derChain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func (cs *CertService) solveAuthorization(ctx context.Context, client *acme.Client, authzUrl string) error {
+	authz, err := client.GetAuthorization(ctx, authzUrl)
+	if err != nil {
+		return fmt.Errorf("failed get authorization, error: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := dnsFqdn(acmeChallengePrefix + strings.TrimPrefix(authz.Identifier.Value, "*."))
+	// a custom domain delegates its challenge with a CNAME into API.Domain, where the provider can write
+	if cname, err := net.LookupCNAME(fqdn); err == nil && cname != "" {
+		fqdn = dnsFqdn(cname)
+	}
+	if err = cs.dnsProvider.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("failed present dns record %s, error: %w", fqdn, err)
+	}
+	defer func() {
+		if err := cs.dnsProvider.CleanUp(context.TODO(), fqdn, value); err != nil {
+			logs.GetLogger().Warnf("Failed clean up dns record %s, error: %+v", fqdn, err)
+		}
+	}()
+
+	if err = cs.waitForPropagation(ctx, fqdn, value); err != nil {
+		return err
+	}
+
+	if _, err = client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed accept challenge, error: %w", err)
+	}
+	if _, err = client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("failed authorize %s, error: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// waitForPropagation polls public DNS until the TXT record is visible, and fails when it is not
+// visible within the timeout. A zero timeout skips the check, which is what a Pebble setup needs
+// since challtestsrv is not the system resolver.
+func (cs *CertService) waitForPropagation(ctx context.Context, fqdn, value string) error {
+	timeout := time.Duration(cs.acmeConf.DnsPropagationTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		records, _ := net.LookupTXT(fqdn)
+		for _, record := range records {
+			if record == value {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	if timeout > 0 {
+		return fmt.Errorf("dns record %s is not visible after %s", fqdn, timeout)
+	}
+	return nil
+}
+
+func (cs *CertService) acmeClient(ctx context.Context) (*acme.Client, error) {
+	if cs.client != nil {
+		return cs.client, nil
+	}
+
+	accountKey, err := cs.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: cs.acmeConf.DirectoryUrl,
+	}
+	if cs.acmeConf.InsecureSkipVerify {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+
+	account := &acme.Account{Contact: []string{"mailto:" + cs.acmeConf.Email}}
+	if _, err = client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("failed register acme account, error: %w", err)
+	}
+	cs.client = client
+	return client, nil
+}
+
+func (cs *CertService) loadAccountKey() (crypto.Signer, error) {
+	keyPath := filepath.Join(cs.cpRepoPath, acmeAccountKeyFile)
+	if data, err := os.ReadFile(keyPath); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid acme account key: %s", keyPath)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(cs.cpRepoPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func certNotAfter(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("invalid certificate pem")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -13,14 +13,14 @@ import (
 	"github.com/lagrangedao/go-computing-provider/build"
 	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/bidding"
 	"github.com/lagrangedao/go-computing-provider/internal/models"
 	"github.com/lagrangedao/go-computing-provider/util"
 	"io"
-	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
 	"math/rand"
 	"net/http"
 	"os"
@@ -28,6 +28,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -49,20 +50,56 @@ func ReceiveJob(c *gin.Context) {
 	}
 	logs.GetLogger().Infof("Job received Data: %+v", jobData)
 
-	var hostName string
-	var logHost string
-	prefixStr := generateString(10)
-	if strings.HasPrefix(conf.GetConfig().API.Domain, ".") {
-		hostName = prefixStr + conf.GetConfig().API.Domain
-		logHost = "log" + conf.GetConfig().API.Domain
-	} else {
-		hostName = strings.Join([]string{prefixStr, conf.GetConfig().API.Domain}, ".")
-		logHost = "log." + conf.GetConfig().API.Domain
+	jobSourceUri := jobData.JobSourceURI
+	spaceUuid := jobSourceUri[strings.LastIndex(jobSourceUri, "/")+1:]
+	// the bid policy decides on the owner and the hardware of the space
+	spaceJson, err := getSpaceJson(jobSourceUri)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get space info, error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	spaceName := spaceJson.Data.Space.Name
+
+	job := bidding.Job{Uuid: jobData.UUID, Duration: jobData.Duration, PricePerHour: jobData.PricePerHour}
+	job.Wallet = spaceJson.Data.Owner.PublicAddress
+	var hardware *models.Resource
+	if description := spaceJson.Data.Space.ActiveOrder.Config.Description; description != "" {
+		_, spaceHardware := getHardwareDetail(description)
+		hardware = &spaceHardware
+		job.Tier, job.Gpus = jobTier(spaceHardware)
+	}
+	if decision := decideJob(job, "lad_app="+strings.ToLower(spaceJson.Data.Space.Uuid)); !decision.Accept {
+		c.JSON(http.StatusForbidden, gin.H{"error": decision.Message, "reason": decision.Reason})
+		return
+	}
+
+	hostName, err := AllocateHostName(spaceName, spaceUuid)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed allocate hostname, error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logHost := joinDomain("log")
+
+	// the hardware is reserved now, the deploy reserves it itself when the order has no hardware
+	if hardware != nil {
+		k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceJson.Data.Owner.PublicAddress)
+		if err = reserveJob(jobData.UUID, k8sNameSpace, "lad_app="+strings.ToLower(spaceJson.Data.Space.Uuid), *hardware); err != nil {
+			logs.GetLogger().Errorf("Failed reserve resources of job %s, error: %v", jobData.UUID, err)
+			if stErr.Is(err, ErrInsufficientResources) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "reason": bidding.ReasonInsufficientResources})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
 	}
 
 	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, jobData.JobSourceURI, hostName, jobData.Duration, jobData.UUID)
 	if err != nil {
 		logs.GetLogger().Errorf("Failed sync delpoy task, error: %v", err)
+		releaseJob(jobData.UUID)
 		return
 	}
 	go func() {
@@ -76,8 +113,6 @@ func ReceiveJob(c *gin.Context) {
 	jobData.JobResultURI = fmt.Sprintf("https://%s", hostName)
 
 	multiAddressSplit := strings.Split(conf.GetConfig().API.MultiAddress, "/")
-	jobSourceUri := jobData.JobSourceURI
-	spaceUuid := jobSourceUri[strings.LastIndex(jobSourceUri, "/")+1:]
 	wsUrl := fmt.Sprintf("wss://%s:%s/api/v1/computing/lagrange/spaces/log?space_id=%s", logHost, multiAddressSplit[4], spaceUuid)
 	jobData.BuildLog = wsUrl + "&type=build"
 	jobData.ContainerLog = wsUrl + "&type=container"
@@ -168,7 +203,18 @@ func RedeployJob(c *gin.Context) {
 		}
 		hostName = strings.ReplaceAll(hostInfo.JobResultUri, "https://", "")
 	} else {
-		hostName = generateString(10) + conf.GetConfig().API.Domain
+		spaceJson, err := getSpaceJson(jobData.JobSourceURI)
+		if err != nil {
+			logs.GetLogger().Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hostName, err = AllocateHostName(spaceJson.Data.Space.Name, spaceJson.Data.Space.Uuid)
+		if err != nil {
+			logs.GetLogger().Errorf("Failed allocate hostname, error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
 	delayTask, err := celeryService.DelayTask(constants.TASK_DEPLOY, jobData.JobResultURI, hostName, jobData.Duration, jobData.UUID)
@@ -241,6 +287,9 @@ func ReNewJob(c *gin.Context) {
 			"task_type":      spaceDetail.TaskType,
 			"deploy_name":    spaceDetail.DeployName,
 			"hardware":       spaceDetail.Hardware,
+			"url":            spaceDetail.Url,
+			"custom_domains": strings.Join(spaceDetail.CustomDomains, ","),
+			"endpoints":      marshalEndpoints(spaceDetail.Endpoints),
 		}
 
 		for key, val := range fields {
@@ -275,7 +324,10 @@ func DeleteJob(c *gin.Context) {
 		}
 	}
 	k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobDetail.WalletAddress)
-	deleteJob(k8sNameSpace, spaceUuid)
+	if err = deleteJob(k8sNameSpace, spaceUuid); err == nil {
+		deleteJobVolumes(k8sNameSpace, spaceUuid)
+		deleteGatewayRoute(spaceUuid)
+	}
 	c.JSON(http.StatusOK, util.CreateSuccessResponse("deleted success"))
 }
 
@@ -335,129 +387,6 @@ func GetSpaceLog(c *gin.Context) {
 	handleConnection(conn, spaceDetail, logType)
 }
 
-func DoProof(c *gin.Context) {
-	var proofTask struct {
-		Method    string `json:"method"`
-		BlockData string `json:"block_data"`
-		Exp       int64  `json:"exp"`
-	}
-	if err := c.ShouldBindJSON(&proofTask); err != nil {
-		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
-		return
-	}
-	logs.GetLogger().Infof("do proof task received: %+v", proofTask)
-
-	if strings.TrimSpace(proofTask.Method) == "" {
-		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, "missing required field: method"))
-		return
-	}
-	if proofTask.Method != "mine" {
-		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, "method must be mine"))
-		return
-	}
-	if proofTask.Exp < 0 || proofTask.Exp > 250 {
-		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, "exp range is [0~250]"))
-		return
-	}
-
-	k8sService := NewK8sService()
-	job := &batchv1.Job{
-		ObjectMeta: metaV1.ObjectMeta{
-			Name: "proof-job-" + generateString(5),
-		},
-		Spec: batchv1.JobSpec{
-			Template: v1.PodTemplateSpec{
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Name:  "worker-container-" + generateString(5),
-							Image: "filswan/worker-proof:v1.0",
-							Env: []v1.EnvVar{
-								{
-									Name:  "METHOD",
-									Value: proofTask.Method,
-								},
-								{
-									Name:  "BLOCK_DATA",
-									Value: proofTask.BlockData,
-								},
-								{
-									Name:  "EXP",
-									Value: strconv.Itoa(int(proofTask.Exp)),
-								},
-							},
-						},
-					},
-					RestartPolicy: "Never",
-				},
-			},
-			BackoffLimit:            new(int32),
-			TTLSecondsAfterFinished: new(int32),
-		},
-	}
-
-	*job.Spec.BackoffLimit = 1
-	*job.Spec.TTLSecondsAfterFinished = 30
-
-	createdJob, err := k8sService.k8sClient.BatchV1().Jobs(metaV1.NamespaceDefault).Create(context.TODO(), job, metaV1.CreateOptions{})
-	if err != nil {
-		logs.GetLogger().Errorf("Failed creating Pod: %v", err)
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
-		return
-	}
-
-	err = wait.PollImmediate(time.Second*3, time.Minute*5, func() (bool, error) {
-		job, err := k8sService.k8sClient.BatchV1().Jobs(metaV1.NamespaceDefault).Get(context.Background(), createdJob.Name, metaV1.GetOptions{})
-		if err != nil {
-			logs.GetLogger().Errorf("Failed getting Job status: %v\n", err)
-			return false, err
-		}
-
-		if job.Status.Succeeded > 0 {
-			return true, nil
-		}
-
-		return false, nil
-	})
-	if err != nil {
-		logs.GetLogger().Errorf("Failed waiting for Job completion: %v", err)
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
-		return
-	}
-
-	podList, err := k8sService.k8sClient.CoreV1().Pods(metaV1.NamespaceDefault).List(context.Background(), metaV1.ListOptions{
-		LabelSelector: fmt.Sprintf("job-name=%s", createdJob.Name),
-	})
-	if err != nil {
-		logs.GetLogger().Errorf("Error getting Pods for Job: %v\n", err)
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
-		return
-	}
-
-	if len(podList.Items) == 0 {
-		logs.GetLogger().Errorf("No Pods found for Job.")
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
-		return
-	}
-
-	podName := podList.Items[0].Name
-	podLog, err := k8sService.k8sClient.CoreV1().Pods(metaV1.NamespaceDefault).GetLogs(podName, &v1.PodLogOptions{}).Stream(context.Background())
-	if err != nil {
-		logs.GetLogger().Errorf("Failed gettingPod logs: %v", err)
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofReadLogError))
-		return
-	}
-	defer podLog.Close()
-
-	bytes, err := io.ReadAll(podLog)
-	if err != nil {
-		logs.GetLogger().Errorf("Failed gettingPod logs: %v", err)
-		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofReadLogError))
-		return
-	}
-	c.JSON(http.StatusOK, util.CreateSuccessResponse(string(bytes)))
-}
-
 func handleConnection(conn *websocket.Conn, spaceDetail models.CacheSpaceDetail, logType string) {
 	client := NewWsClient(conn)
 
@@ -476,8 +405,16 @@ func handleConnection(conn *websocket.Conn, spaceDetail models.CacheSpaceDetail,
 		k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceDetail.WalletAddress)
 
 		k8sService := NewK8sService()
+		// spaces with several services show the logs of the service that serves the space hostname
+		labelSelector := fmt.Sprintf("lad_app=%s", spaceDetail.SpaceUuid)
+		if spaceDetail.DeployName != "" {
+			deployment, err := k8sService.k8sClient.AppsV1().Deployments(k8sNameSpace).Get(context.TODO(), spaceDetail.DeployName, metaV1.GetOptions{})
+			if err == nil && deployment.Spec.Selector != nil {
+				labelSelector = labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String()
+			}
+		}
 		pods, err := k8sService.k8sClient.CoreV1().Pods(k8sNameSpace).List(context.TODO(), metaV1.ListOptions{
-			LabelSelector: fmt.Sprintf("lad_app=%s", spaceDetail.SpaceUuid),
+			LabelSelector: labelSelector,
 		})
 		if err != nil {
 			logs.GetLogger().Errorf("Error listing Pods: %v", err)
@@ -511,10 +448,16 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 	var success bool
 	var spaceUuid string
 	var walletAddress string
+	var deploy *Deploy
 	defer func() {
 		if !success {
-			k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress)
-			deleteJob(k8sNameSpace, spaceUuid)
+			// a failure before the deploy replaced the objects of the space leaves a running space alone
+			if deploy != nil && deploy.replaced {
+				k8sNameSpace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress)
+				deleteJob(k8sNameSpace, spaceUuid)
+			}
+			releaseJob(jobUuid)
+			updateJobStatus(jobUuid, models.JobDeployFailed)
 		}
 
 		if err := recover(); err != nil {
@@ -522,38 +465,10 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 			return
 		}
 	}()
-	var gpuName string
-	defer func() {
-		if gpuName != "" {
-			count, ok := runTaskGpuResource.Load(gpuName)
-			if ok && count.(int) > 0 {
-				runTaskGpuResource.Store(gpuName, count.(int)-1)
-			} else {
-				runTaskGpuResource.Delete(gpuName)
-			}
-		}
-	}()
 
-	resp, err := http.Get(jobSourceURI)
+	spaceJson, err := getSpaceJson(jobSourceURI)
 	if err != nil {
-		logs.GetLogger().Errorf("error making request to Space API: %+v", err)
-		return ""
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			logs.GetLogger().Errorf("error closed resp Space API: %+v", err)
-		}
-	}(resp.Body)
-	logs.GetLogger().Infof("Space API response received. Response: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusOK {
-		logs.GetLogger().Errorf("space API response not OK. Status Code: %d", resp.StatusCode)
-		return ""
-	}
-
-	var spaceJson models.SpaceJSON
-	if err := json.NewDecoder(resp.Body).Decode(&spaceJson); err != nil {
-		logs.GetLogger().Errorf("error decoding Space API response JSON: %v", err)
+		logs.GetLogger().Error(err)
 		return ""
 	}
 
@@ -562,6 +477,13 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 	spaceUuid = strings.ToLower(spaceJson.Data.Space.Uuid)
 	spaceHardware := spaceJson.Data.Space.ActiveOrder.Config
 
+	var customDomains []string
+	var endpoints []models.Endpoint
+	if spaceDetail, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid); err == nil {
+		customDomains = spaceDetail.CustomDomains
+		endpoints = spaceDetail.Endpoints
+	}
+
 	conn := redisPool.Get()
 	fullArgs := []interface{}{constants.REDIS_FULL_PREFIX + spaceUuid}
 	fields := map[string]string{
@@ -581,17 +503,13 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 		return ""
 	}
 
-	deploy := NewDeploy(jobUuid, hostName, walletAddress, spaceHardware.Description, int64(duration))
-	deploy.WithSpaceInfo(spaceUuid, spaceName)
+	deploy = NewDeploy(jobUuid, hostName, walletAddress, spaceHardware.Description, int64(duration))
+	deploy.WithSpaceInfo(spaceUuid, spaceName).WithCustomDomains(customDomains).WithEndpoints(endpoints)
 
-	if deploy.hardwareResource.Gpu.Unit != "" {
-		gpuName = strings.ReplaceAll(deploy.hardwareResource.Gpu.Unit, " ", "-")
-		count, ok := runTaskGpuResource.Load(gpuName)
-		if ok {
-			runTaskGpuResource.Store(gpuName, count.(int)+1)
-		} else {
-			runTaskGpuResource.Store(gpuName, 1)
-		}
+	// kept until the pod of the space is scheduled
+	if err = reserveJob(jobUuid, deploy.k8sNameSpace, "lad_app="+spaceUuid, deploy.hardwareResource); err != nil {
+		logs.GetLogger().Errorf("Failed reserve resources of space %s, error: %v", spaceUuid, err)
+		return ""
 	}
 
 	spacePath := filepath.Join("build", walletAddress, "spaces", spaceName)
@@ -610,20 +528,77 @@ func DeploySpaceTask(jobSourceURI, hostName string, duration int, jobUuid string
 			logs.GetLogger().Error(err)
 			return ""
 		}
+		success = true
 		return hostName
 	}
 
+	if chartPath, manifestFiles := spaceManifests(imagePath); chartPath != "" || len(manifestFiles) > 0 {
+		if manifestAllowed(walletAddress) {
+			if err := deploy.WithManifests(chartPath, manifestFiles).ManifestToK8s(); err != nil {
+				logs.GetLogger().Error(err)
+				return ""
+			}
+			success = true
+			return hostName
+		}
+		logs.GetLogger().Warnf("Wallet %s is not allowed to deploy manifests, space %s is deployed without them", walletAddress, spaceUuid)
+	}
+
 	if containsYaml {
-		deploy.WithYamlInfo(yamlPath).YamlToK8s()
+		err = deploy.WithYamlInfo(yamlPath).YamlToK8s()
 	} else {
 		imageName, dockerfilePath := BuildImagesByDockerfile(jobUuid, spaceUuid, spaceName, imagePath)
-		deploy.WithDockerfile(imageName, dockerfilePath).DockerfileToK8s()
+		err = deploy.WithDockerfile(imageName, dockerfilePath).DockerfileToK8s()
+	}
+	if err != nil {
+		logs.GetLogger().Errorf("Failed deploy space %s, error: %+v", spaceUuid, err)
+		return ""
 	}
 	success = true
 
 	return hostName
 }
 
+func getSpaceJson(jobSourceURI string) (*models.SpaceJSON, error) {
+	resp, err := http.Get(jobSourceURI)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to Space API: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logs.GetLogger().Errorf("error closed resp Space API: %+v", err)
+		}
+	}(resp.Body)
+	logs.GetLogger().Infof("Space API response received. Response: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("space API response not OK. Status Code: %d", resp.StatusCode)
+	}
+
+	var spaceJson models.SpaceJSON
+	if err := json.NewDecoder(resp.Body).Decode(&spaceJson); err != nil {
+		return nil, fmt.Errorf("error decoding Space API response JSON: %w", err)
+	}
+	return &spaceJson, nil
+}
+
+// deleteJobVolumes removes the persistent volumes of a space. Unlike deleteJob, which also runs on
+// redeploys, it is only called once the space ends.
+func deleteJobVolumes(namespace, spaceUuid string) {
+	if err := NewK8sService().DeletePersistentVolumeClaims(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete persistent volume claims, spaceUuid: %s, error: %+v", spaceUuid, err)
+	}
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, v := range list {
+		if v == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
 func deleteJob(namespace, spaceUuid string) error {
 	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceUuid
 	serviceName := constants.K8S_SERVICE_NAME_PREFIX + spaceUuid
@@ -636,28 +611,44 @@ func deleteJob(namespace, spaceUuid string) error {
 	}
 	logs.GetLogger().Infof("Deleted ingress %s finished", ingressName)
 
-	if err := k8sService.DeleteService(context.TODO(), namespace, serviceName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName, err)
+	serviceNames, err := k8sService.ListServiceNames(context.TODO(), namespace, spaceUuid)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list services, spaceUuid: %s, error: %+v", spaceUuid, err)
 		return err
 	}
-	logs.GetLogger().Infof("Deleted service %s finished", serviceName)
+	for _, serviceName := range appendUnique(serviceNames, serviceName) {
+		if err := k8sService.DeleteService(context.TODO(), namespace, serviceName); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete service, serviceName: %s, error: %+v", serviceName, err)
+			return err
+		}
+		logs.GetLogger().Infof("Deleted service %s finished", serviceName)
+	}
 
-	dockerService := NewDockerService()
-	deployImageIds, err := k8sService.GetDeploymentImages(context.TODO(), namespace, deployName)
-	if err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed get deploy imageIds, deployName: %s, error: %+v", deployName, err)
+	deployNames, err := k8sService.ListDeploymentNames(context.TODO(), namespace, spaceUuid)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list deployments, spaceUuid: %s, error: %+v", spaceUuid, err)
 		return err
 	}
-	for _, imageId := range deployImageIds {
-		dockerService.RemoveImage(imageId)
-	}
+	dockerService := NewDockerService()
+	for _, deployName := range appendUnique(deployNames, deployName) {
+		deployImageIds, err := k8sService.GetDeploymentImages(context.TODO(), namespace, deployName)
+		if err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed get deploy imageIds, deployName: %s, error: %+v", deployName, err)
+			return err
+		}
+		for _, imageId := range deployImageIds {
+			dockerService.RemoveImage(imageId)
+		}
 
-	if err := k8sService.DeleteDeployment(context.TODO(), namespace, deployName); err != nil && !errors.IsNotFound(err) {
-		logs.GetLogger().Errorf("Failed delete deployment, deployName: %s, error: %+v", deployName, err)
-		return err
+		if err := k8sService.DeleteDeployment(context.TODO(), namespace, deployName); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete deployment, deployName: %s, error: %+v", deployName, err)
+			return err
+		}
+		logs.GetLogger().Infof("Deleted deployment %s finished", deployName)
 	}
+	deleteManifestObjects(namespace, spaceUuid)
+	deleteIdleState(spaceUuid)
 	time.Sleep(6 * time.Second)
-	logs.GetLogger().Infof("Deleted deployment %s finished", deployName)
 
 	if err := k8sService.DeleteDeployRs(context.TODO(), namespace, spaceUuid); err != nil && !errors.IsNotFound(err) {
 		logs.GetLogger().Errorf("Failed delete ReplicaSetsController, spaceUuid: %s, error: %+v", spaceUuid, err)
@@ -691,18 +682,28 @@ func deleteJob(namespace, spaceUuid string) error {
 	return nil
 }
 
-func downloadModelUrl(namespace, spaceUuid, serviceIp string, podCmd []string) {
-	k8sService := NewK8sService()
-	podName, err := k8sService.WaitForPodRunning(namespace, spaceUuid, serviceIp)
-	if err != nil {
-		logs.GetLogger().Error(err)
-		return
-	}
+// updateJobEndpoints reports the job status together with the public endpoints of its TCP/UDP ports.
+func updateJobEndpoints(jobUuid string, jobStatus models.JobStatus, url string, endpoints []models.Endpoint) {
+	go func() {
+		deployingChan <- models.Job{
+			Uuid:      jobUuid,
+			Status:    jobStatus,
+			Count:     0,
+			Url:       url,
+			Endpoints: endpoints,
+		}
+	}()
+}
 
-	if err = k8sService.PodDoCommand(namespace, podName, "", podCmd); err != nil {
-		logs.GetLogger().Error(err)
-		return
-	}
+// updateJobProgress reports a job stage together with how far it got.
+func updateJobProgress(jobUuid string, jobStatus models.JobStatus, progress string) {
+	go func() {
+		deployingChan <- models.Job{
+			Uuid:     jobUuid,
+			Status:   jobStatus,
+			Progress: progress,
+		}
+	}()
 }
 
 func updateJobStatus(jobUuid string, jobStatus models.JobStatus, url ...string) {
@@ -725,14 +726,20 @@ func updateJobStatus(jobUuid string, jobStatus models.JobStatus, url ...string)
 	}()
 }
 
+var (
+	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randMutex  sync.Mutex
+)
+
 func generateString(length int) string {
 	characters := "abcdefghijklmnopqrstuvwxyz"
 	numbers := "0123456789"
 	source := characters + numbers
 	result := make([]byte, length)
-	rand.Seed(time.Now().UnixNano())
+	randMutex.Lock()
+	defer randMutex.Unlock()
 	for i := 0; i < length; i++ {
-		result[i] = source[rand.Intn(len(source))]
+		result[i] = source[randSource.Intn(len(source))]
 	}
 	return string(result)
 }
@@ -805,7 +812,7 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 	}
 
 	args := append([]interface{}{key}, "wallet_address", "space_name", "expire_time", "space_uuid", "job_uuid",
-		"task_type", "deploy_name", "hardware", "url")
+		"task_type", "deploy_name", "hardware", "url", "custom_domains", "endpoints")
 	valuesStr, err := redis.Strings(redisConn.Do("HMGET", args...))
 	if err != nil {
 		logs.GetLogger().Errorf("Failed get redis key data, key: %s, error: %+v", key, err)
@@ -822,6 +829,8 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		deployName    string
 		hardware      string
 		url           string
+		customDomains []string
+		endpoints     []models.Endpoint
 	)
 
 	if len(valuesStr) >= 3 {
@@ -834,6 +843,14 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		deployName = valuesStr[6]
 		hardware = valuesStr[7]
 		url = valuesStr[8]
+		if valuesStr[9] != "" {
+			customDomains = strings.Split(valuesStr[9], ",")
+		}
+		if valuesStr[10] != "" {
+			if err = json.Unmarshal([]byte(valuesStr[10]), &endpoints); err != nil {
+				logs.GetLogger().Warnf("Failed parse endpoints of key: %s, error: %+v", key, err)
+			}
+		}
 		expireTime, err = strconv.ParseInt(strings.TrimSpace(expireTimeStr), 10, 64)
 		if err != nil {
 			logs.GetLogger().Errorf("Failed convert time str: [%s], error: %+v", expireTimeStr, err)
@@ -851,5 +868,7 @@ func RetrieveJobMetadata(key string) (models.CacheSpaceDetail, error) {
 		DeployName:    deployName,
 		Hardware:      hardware,
 		Url:           url,
+		CustomDomains: customDomains,
+		Endpoints:     endpoints,
 	}, nil
 }
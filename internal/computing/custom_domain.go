@@ -0,0 +1,472 @@
+package computing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stErr "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/util"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	DomainVerifyDns  = "dns"
+	DomainVerifyHttp = "http"
+
+	// a domain is pending until its ownership is verified, then issuing until it is served with its
+	// certificate (active) or the attach failed (failed), which can be verified again
+	domainStatusPending = "pending"
+	domainStatusIssuing = "issuing"
+	domainStatusActive  = "active"
+	domainStatusFailed  = "failed"
+
+	domainVerifyTxtPrefix = "_lagrange-verification."
+	domainVerifyHttpPath  = "/.well-known/lagrange-verification.txt"
+)
+
+var domainRegexp = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+type customDomainReq struct {
+	SpaceUuid string `json:"space_uuid"`
+	Domain    string `json:"domain"`
+	Method    string `json:"method"`
+}
+
+type customDomainResp struct {
+	SpaceUuid   string `json:"space_uuid"`
+	Domain      string `json:"domain"`
+	Status      string `json:"status"`
+	Token       string `json:"token"`
+	TxtRecord   string `json:"txt_record"`
+	HttpUrl     string `json:"http_url"`
+	CnameTarget string `json:"cname_target"`
+	AcmeCname   string `json:"acme_cname"`
+	AcmeTarget  string `json:"acme_target"`
+	Error       string `json:"error,omitempty"`
+}
+
+// AddCustomDomain creates an ownership token for a custom hostname of a running space and returns the
+// DNS records the owner has to create before calling VerifyCustomDomain.
+func AddCustomDomain(c *gin.Context) {
+	var req customDomainReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("add custom domain received: %+v", req)
+
+	domain, spaceDetail, err := checkCustomDomainReq(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, err.Error()))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, spaceDetail.WalletAddress); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.CustomDomainUnauthorized, err.Error()))
+		return
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	domainKey := constants.REDIS_DOMAIN_PREFIX + domain
+	values, err := redis.StringMap(redisConn.Do("HGETALL", domainKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	if owner := values["space_uuid"]; owner != "" && owner != req.SpaceUuid {
+		if _, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + owner); err == nil {
+			c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "the domain is used by another space"))
+			return
+		}
+	}
+
+	token := values["token"]
+	status := values["status"]
+	if values["space_uuid"] != req.SpaceUuid || token == "" {
+		if token, err = generateDomainToken(); err != nil {
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+			return
+		}
+		status = domainStatusPending
+		redisConn.Send("MULTI")
+		redisConn.Send("DEL", domainKey)
+		redisConn.Send("HSET", domainKey, "space_uuid", req.SpaceUuid, "token", token, "status", status)
+		if _, err = redisConn.Do("EXEC"); err != nil {
+			c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+			return
+		}
+	}
+
+	spaceHost := strings.TrimPrefix(spaceDetail.Url, "https://")
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(customDomainResp{
+		SpaceUuid:   req.SpaceUuid,
+		Domain:      domain,
+		Status:      status,
+		Token:       token,
+		TxtRecord:   domainVerifyTxtPrefix + domain,
+		HttpUrl:     "http://" + domain + domainVerifyHttpPath,
+		CnameTarget: spaceHost,
+		AcmeCname:   acmeChallengePrefix + domain,
+		AcmeTarget:  acmeChallengePrefix + spaceHost,
+	}))
+}
+
+// VerifyCustomDomain checks the ownership token through DNS TXT or HTTP and attaches the domain to the
+// space in the background. It answers 202 with the issuing status, GetCustomDomain tells when the domain
+// is active, a failed attach can be verified again.
+func VerifyCustomDomain(c *gin.Context) {
+	var req customDomainReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("verify custom domain received: %+v", req)
+
+	domain, spaceDetail, err := checkCustomDomainReq(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, err.Error()))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, spaceDetail.WalletAddress); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.CustomDomainUnauthorized, err.Error()))
+		return
+	}
+	if req.Method != DomainVerifyDns && req.Method != DomainVerifyHttp {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "method must be dns or http"))
+		return
+	}
+	if certService == nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "custom domains require ACME to be enabled on the provider"))
+		return
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	domainKey := constants.REDIS_DOMAIN_PREFIX + domain
+	values, err := redis.StringMap(redisConn.Do("HGETALL", domainKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	if values["space_uuid"] != req.SpaceUuid || values["token"] == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "no pending token for the domain, please add it first"))
+		return
+	}
+	if values["status"] == domainStatusIssuing {
+		c.JSON(http.StatusAccepted, util.CreateSuccessResponse(customDomainResp{
+			SpaceUuid: req.SpaceUuid,
+			Domain:    domain,
+			Status:    domainStatusIssuing,
+		}))
+		return
+	}
+
+	if err = verifyDomainOwnership(req.Method, domain, values["token"]); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainVerifyError, err.Error()))
+		return
+	}
+
+	spaceKey := constants.REDIS_FULL_PREFIX + req.SpaceUuid
+	customDomains := appendDomain(spaceDetail.CustomDomains, domain)
+	redisConn.Send("MULTI")
+	redisConn.Send("HSET", domainKey, "status", domainStatusIssuing, "error", "")
+	redisConn.Send("HSET", spaceKey, "custom_domains", strings.Join(customDomains, ","))
+	if _, err = redisConn.Do("EXEC"); err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+
+	namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceDetail.WalletAddress)
+	go issueCustomDomain(namespace, req.SpaceUuid, domain)
+
+	c.JSON(http.StatusAccepted, util.CreateSuccessResponse(customDomainResp{
+		SpaceUuid: req.SpaceUuid,
+		Domain:    domain,
+		Status:    domainStatusIssuing,
+	}))
+}
+
+// GetCustomDomain returns the status of a custom domain, and the error of a failed attach.
+func GetCustomDomain(c *gin.Context) {
+	req := customDomainReq{SpaceUuid: strings.ToLower(c.Query("space_uuid")), Domain: c.Query("domain")}
+	domain, spaceDetail, err := checkCustomDomainReq(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, err.Error()))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, spaceDetail.WalletAddress); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.CustomDomainUnauthorized, err.Error()))
+		return
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+	values, err := redis.StringMap(redisConn.Do("HGETALL", constants.REDIS_DOMAIN_PREFIX+domain))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	if values["space_uuid"] != req.SpaceUuid {
+		c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.CustomDomainParamError, "the domain is not added to the space"))
+		return
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(customDomainResp{
+		SpaceUuid: req.SpaceUuid,
+		Domain:    domain,
+		Status:    values["status"],
+		Error:     values["error"],
+	}))
+}
+
+// issueCustomDomain attaches a verified domain and records whether it is active or failed.
+func issueCustomDomain(namespace, spaceUuid, domain string) {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("catch panic error: %+v", err)
+			setDomainStatus(spaceUuid, domain, domainStatusFailed, fmt.Sprint(err))
+		}
+	}()
+
+	if err := attachCustomDomain(namespace, spaceUuid, domain); err != nil {
+		logs.GetLogger().Errorf("Failed attach custom domain %s to space %s, error: %+v", domain, spaceUuid, err)
+		setDomainStatus(spaceUuid, domain, domainStatusFailed, err.Error())
+		return
+	}
+	setDomainStatus(spaceUuid, domain, domainStatusActive, "")
+}
+
+// setDomainStatus records the status of a domain, unless it was deleted or added to another space meanwhile.
+func setDomainStatus(spaceUuid, domain, status, message string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	domainKey := constants.REDIS_DOMAIN_PREFIX + domain
+	if owner, err := redis.String(conn.Do("HGET", domainKey, "space_uuid")); err != nil || owner != spaceUuid {
+		return
+	}
+	if _, err := conn.Do("HSET", domainKey, "status", status, "error", message); err != nil {
+		logs.GetLogger().Errorf("Failed set status of custom domain %s, error: %+v", domain, err)
+	}
+}
+
+func DeleteCustomDomain(c *gin.Context) {
+	spaceUuid := strings.ToLower(c.Query("space_uuid"))
+	domain := strings.ToLower(strings.TrimSpace(c.Query("domain")))
+	if spaceUuid == "" || domain == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "space_uuid and domain are required"))
+		return
+	}
+
+	spaceKey := constants.REDIS_FULL_PREFIX + spaceUuid
+	spaceDetail, err := RetrieveJobMetadata(spaceKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.CustomDomainParamError, "not found space"))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, spaceDetail.WalletAddress); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.CustomDomainUnauthorized, err.Error()))
+		return
+	}
+
+	var customDomains []string
+	for _, d := range spaceDetail.CustomDomains {
+		if d != domain {
+			customDomains = append(customDomains, d)
+		}
+	}
+
+	redisConn := redisPool.Get()
+	defer redisConn.Close()
+
+	// the token of the domain is removed only when it belongs to this space
+	domainKey := constants.REDIS_DOMAIN_PREFIX + domain
+	owner, err := redis.String(redisConn.Do("HGET", domainKey, "space_uuid"))
+	if err != nil && err != redis.ErrNil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	redisConn.Send("MULTI")
+	redisConn.Send("HSET", spaceKey, "custom_domains", strings.Join(customDomains, ","))
+	if owner == spaceUuid {
+		redisConn.Send("DEL", domainKey)
+	}
+	if _, err = redisConn.Do("EXEC"); err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+
+	namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceDetail.WalletAddress)
+	k8sService := NewK8sService()
+	if err = k8sService.RemoveIngressHost(context.TODO(), namespace, constants.K8S_INGRESS_NAME_PREFIX+spaceUuid, domain); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed remove ingress host %s, error: %+v", domain, err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	if err = k8sService.DeleteSecret(context.TODO(), namespace, customDomainSecretName(domain)); err != nil && !errors.IsNotFound(err) {
+		logs.GetLogger().Errorf("Failed delete tls secret of %s, error: %+v", domain, err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.CustomDomainError, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse("deleted success"))
+}
+
+// checkCustomDomainReq returns the normalized domain and the running space of the request.
+func checkCustomDomainReq(req customDomainReq) (string, models.CacheSpaceDetail, error) {
+	if strings.TrimSpace(req.SpaceUuid) == "" {
+		return "", models.CacheSpaceDetail{}, stErr.New("missing required field: space_uuid")
+	}
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), "."))
+	if !domainRegexp.MatchString(domain) {
+		return "", models.CacheSpaceDetail{}, fmt.Errorf("invalid domain: %s", req.Domain)
+	}
+	if strings.HasSuffix(domain, "."+strings.TrimPrefix(conf.GetConfig().API.Domain, ".")) {
+		return "", models.CacheSpaceDetail{}, stErr.New("the domain belongs to the provider")
+	}
+
+	spaceDetail, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + req.SpaceUuid)
+	if err != nil {
+		return "", models.CacheSpaceDetail{}, fmt.Errorf("not found space: %s", req.SpaceUuid)
+	}
+	return domain, spaceDetail, nil
+}
+
+func verifyDomainOwnership(method, domain, token string) error {
+	switch method {
+	case DomainVerifyDns:
+		records, err := net.LookupTXT(domainVerifyTxtPrefix + domain)
+		if err != nil {
+			return fmt.Errorf("failed lookup TXT record %s, error: %w", domainVerifyTxtPrefix+domain, err)
+		}
+		for _, record := range records {
+			if strings.TrimSpace(record) == token {
+				return nil
+			}
+		}
+		return fmt.Errorf("TXT record %s does not contain the token", domainVerifyTxtPrefix+domain)
+	case DomainVerifyHttp:
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get("http://" + domain + domainVerifyHttpPath)
+		if err != nil {
+			return fmt.Errorf("failed request %s, error: %w", domainVerifyHttpPath, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != token {
+			return fmt.Errorf("http://%s%s does not return the token", domain, domainVerifyHttpPath)
+		}
+		return nil
+	default:
+		return fmt.Errorf("not support verify method: %s", method)
+	}
+}
+
+// attachCustomDomain routes domain to the space right away and adds TLS once the certificate is ready.
+func attachCustomDomain(namespace, spaceUuid, domain string) error {
+	k8sService := NewK8sService()
+	ingressName := constants.K8S_INGRESS_NAME_PREFIX + spaceUuid
+	if err := k8sService.AddIngressHost(context.TODO(), namespace, ingressName, domain, ""); err != nil {
+		return err
+	}
+
+	secretName, err := ensureCustomDomainCertificate(namespace, domain)
+	if err != nil {
+		return err
+	}
+	if err = k8sService.AddIngressHost(context.TODO(), namespace, ingressName, domain, secretName); err != nil {
+		return err
+	}
+	logs.GetLogger().Infof("Attached custom domain %s to space %s", domain, spaceUuid)
+	return nil
+}
+
+func ensureCustomDomainCertificate(namespace, domain string) (string, error) {
+	if certService == nil {
+		return "", stErr.New("ACME is not enabled")
+	}
+	ctx := context.TODO()
+	secretName := customDomainSecretName(domain)
+
+	k8sService := NewK8sService()
+	secret, err := k8sService.GetSecret(ctx, namespace, secretName)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if notAfter, err := certNotAfter(secret.Data[coreV1.TLSCertKey]); err == nil && time.Until(notAfter) > certService.renewBefore() {
+			return secretName, nil
+		}
+	}
+
+	certPEM, keyPEM, err := certService.Obtain(ctx, domain)
+	if err != nil {
+		return "", fmt.Errorf("failed obtain certificate of %s, error: %w", domain, err)
+	}
+	if err = certService.storeSecret(ctx, namespace, secretName, certPEM, keyPEM); err != nil {
+		return "", err
+	}
+	return secretName, nil
+}
+
+func renewCustomDomainCertificates() {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	prefix := constants.REDIS_FULL_PREFIX + "*"
+	keys, err := redis.Strings(conn.Do("KEYS", prefix))
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get redis %s prefix, error: %+v", prefix, err)
+		return
+	}
+	for _, key := range keys {
+		spaceDetail, err := RetrieveJobMetadata(key)
+		if err != nil {
+			continue
+		}
+		namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(spaceDetail.WalletAddress)
+		for _, domain := range spaceDetail.CustomDomains {
+			if _, err = ensureCustomDomainCertificate(namespace, domain); err != nil {
+				logs.GetLogger().Errorf("Failed renew certificate of custom domain %s, error: %+v", domain, err)
+			}
+		}
+	}
+}
+
+func customDomainSecretName(domain string) string {
+	return constants.K8S_TLS_SECRET_NAME_PREFIX + strings.ReplaceAll(domain, ".", "-")
+}
+
+func appendDomain(domains []string, domain string) []string {
+	for _, d := range domains {
+		if d == domain {
+			return domains
+		}
+	}
+	return append(domains, domain)
+}
+
+func generateDomainToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -9,20 +9,26 @@ import (
 	"github.com/lagrangedao/go-computing-provider/constants"
 	"github.com/lagrangedao/go-computing-provider/internal/models"
 	"github.com/lagrangedao/go-computing-provider/internal/yaml"
-	"github.com/lagrangedao/go-computing-provider/util"
 	appV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	serviceSlugLength     = 14
+	dependsOnReadyTimeout = 10 * time.Minute
+)
+
 type Deploy struct {
 	jobUuid           string
 	hostName          string
@@ -32,6 +38,8 @@ type Deploy struct {
 	image             string
 	dockerfilePath    string
 	yamlPath          string
+	chartPath         string
+	manifestFiles     []string
 	duration          int64
 	hardwareResource  models.Resource
 	modelsSettingFile string
@@ -40,6 +48,12 @@ type Deploy struct {
 	TaskType          string
 	DeployName        string
 	hardwareDesc      string
+	customDomains     []string
+	endpoints         []models.Endpoint
+	previousEndpoints []models.Endpoint
+	// replaced is set once the objects of a previous deploy of the space are deleted
+	replaced  bool
+	deleteJob func(namespace, spaceUuid string) error
 }
 
 func NewDeploy(jobUuid, hostName, walletAddress, hardwareDesc string, duration int64) *Deploy {
@@ -53,9 +67,31 @@ func NewDeploy(jobUuid, hostName, walletAddress, hardwareDesc string, duration i
 		TaskType:         taskType,
 		k8sNameSpace:     constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(walletAddress),
 		hardwareDesc:     hardwareDesc,
+		deleteJob:        deleteJob,
 	}
 }
 
+// replaceJob deletes the objects of a previous deploy of the space, before the new ones are created.
+func (d *Deploy) replaceJob() error {
+	err := d.deleteJob(d.k8sNameSpace, d.spaceUuid)
+	d.replaced = true
+	if err != nil {
+		return fmt.Errorf("failed delete the previous deploy of space %s, error: %w", d.spaceUuid, err)
+	}
+	return nil
+}
+
+// WithJobDeleter replaces how the objects of a previous deploy are deleted, deleteJob by default.
+func (d *Deploy) WithJobDeleter(deleteJob func(namespace, spaceUuid string) error) *Deploy {
+	d.deleteJob = deleteJob
+	return d
+}
+
+// Replaced tells whether the deploy deleted the objects of a previous deploy of the space.
+func (d *Deploy) Replaced() bool {
+	return d.replaced
+}
+
 func (d *Deploy) WithSpaceInfo(spaceUuid, spaceName string) *Deploy {
 	d.spaceUuid = spaceUuid
 	d.spaceName = spaceName
@@ -78,28 +114,37 @@ func (d *Deploy) WithSpacePath(spacePath string) *Deploy {
 	return d
 }
 
+func (d *Deploy) WithCustomDomains(customDomains []string) *Deploy {
+	d.customDomains = customDomains
+	return d
+}
+
+// WithEndpoints passes the endpoints of the previous deployment, so the TCP/UDP ports keep their public ports.
+func (d *Deploy) WithEndpoints(endpoints []models.Endpoint) *Deploy {
+	d.previousEndpoints = endpoints
+	return d
+}
+
 func (d *Deploy) WithModelSettingFile(modelsSettingFile string) *Deploy {
 	d.modelsSettingFile = modelsSettingFile
 	return d
 }
 
-func (d *Deploy) DockerfileToK8s() {
+func (d *Deploy) DockerfileToK8s() error {
 	exposedPort, err := ExtractExposedPort(d.dockerfilePath)
 	if err != nil {
-		logs.GetLogger().Infof("Failed to extract exposed port: %v", err)
-		return
+		return fmt.Errorf("failed to extract exposed port, error: %w", err)
 	}
 	containerPort, err := strconv.ParseInt(exposedPort, 10, 64)
 	if err != nil {
-		logs.GetLogger().Errorf("Failed to convert exposed port: %v", err)
-		return
+		return fmt.Errorf("failed to convert exposed port, error: %w", err)
 	}
 
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
-
-	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if err = d.replaceJob(); err != nil {
+		return err
+	}
+	if err = d.deployNamespace(); err != nil {
+		return err
 	}
 
 	k8sService := NewK8sService()
@@ -140,54 +185,103 @@ func (d *Deploy) DockerfileToK8s() {
 		}}
 	createDeployment, err := k8sService.CreateDeployment(context.TODO(), d.k8sNameSpace, deployment)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return err
 	}
 	d.DeployName = createDeployment.GetName()
 	updateJobStatus(d.jobUuid, models.JobPullImage)
 	logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetName())
 
-	if _, err := d.deployK8sResource(int32(containerPort)); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if _, err = d.deployK8sResource(int32(containerPort)); err != nil {
+		return err
 	}
 	updateJobStatus(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName)
 
 	d.watchContainerRunningTime()
-	return
+	return nil
 }
 
-func (d *Deploy) YamlToK8s() {
-	containerResources, err := yaml.HandlerYaml(d.yamlPath)
+func (d *Deploy) YamlToK8s() error {
+	containerResources, warnings, err := yaml.LoadYaml(d.yamlPath)
 	if err != nil {
-		logs.GetLogger().Error(err)
-		return
+		return err
+	}
+	for _, warning := range warnings {
+		logs.GetLogger().Warnf("Space %s, %s: %s", d.spaceUuid, filepath.Base(d.yamlPath), warning.Error())
 	}
 
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
+	if err = d.buildServiceImages(containerResources); err != nil {
+		return fmt.Errorf("failed build images of space %s, error: %w", d.spaceUuid, err)
+	}
 
-	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
-		return
+	if err = d.replaceJob(); err != nil {
+		return err
+	}
+	if err = d.deployNamespace(); err != nil {
+		return err
 	}
 
+	primary := primaryService(containerResources)
+	serviceNames := make(map[string]k8sServiceNames)
+	usedSuffixes := make(map[string]string)
+	for i, cr := range containerResources {
+		names := d.serviceNames(cr.Name, i == primary)
+		if other, ok := usedSuffixes[names.deployment]; ok {
+			return fmt.Errorf("services %s and %s of space %s map to the same resource name %s", other, cr.Name, d.spaceUuid, names.deployment)
+		}
+		usedSuffixes[names.deployment] = cr.Name
+		serviceNames[cr.Name] = names
+	}
+	if primary >= 0 {
+		narrowReservation(d.jobUuid, labels.SelectorFromSet(serviceNames[containerResources[primary].Name].labels).String())
+	}
+
+	serviceResources, err := d.serviceResources(containerResources, primary)
+	if err != nil {
+		return fmt.Errorf("failed assign resources to the services of space %s, error: %w", d.spaceUuid, err)
+	}
+
+	// the services are created first, every pod reaches the other services by the DNS name of their
+	// Service, which stays valid when their pods restart
 	k8sService := NewK8sService()
+	templateContext := d.templateContext()
+	templateContext.Compose = yaml.IsComposeFile(d.yamlPath)
+	var modelFetchers sync.WaitGroup
+	var modelFetchFailed atomic.Bool
 	for _, cr := range containerResources {
-		for i, envVar := range cr.Env {
-			if strings.Contains(envVar.Name, "NEXTAUTH_URL") {
-				cr.Env[i].Value = "https://" + d.hostName
-				break
+		ports := clusterServicePorts(cr)
+		if len(ports) == 0 {
+			continue
+		}
+		names := serviceNames[cr.Name]
+		createService, err := k8sService.CreateService(context.TODO(), d.k8sNameSpace, names.service, names.labels, ports)
+		if err != nil {
+			return fmt.Errorf("failed create service %s, error: %w", names.service, err)
+		}
+		logs.GetLogger().Infof("Created service successfully: %s", createService.GetName())
+		templateContext.ServiceHosts[cr.Name] = fmt.Sprintf("%s.%s.svc", createService.GetName(), d.k8sNameSpace)
+	}
+
+	for i, cr := range containerResources {
+		names := serviceNames[cr.Name]
+		for _, depend := range cr.DependsOn {
+			dependName := serviceNames[depend].deployment
+			logs.GetLogger().Infof("Service %s of space %s waits for %s to be ready", cr.Name, d.spaceUuid, dependName)
+			if err := k8sService.WaitDeploymentReady(context.TODO(), d.k8sNameSpace, dependName, dependsOnReadyTimeout); err != nil {
+				return fmt.Errorf("service %s of space %s: dependency %s is not ready, error: %w", cr.Name, d.spaceUuid, depend, err)
 			}
 		}
 
+		if err := templateContext.ResolveEnv(&cr); err != nil {
+			return fmt.Errorf("failed resolve template variables of space %s, error: %w", d.spaceUuid, err)
+		}
+
 		var volumeMount []coreV1.VolumeMount
 		var volumes []coreV1.Volume
 		if cr.VolumeMounts.Path != "" {
 			fileNameWithoutExt := filepath.Base(cr.VolumeMounts.Name[:len(cr.VolumeMounts.Name)-len(filepath.Ext(cr.VolumeMounts.Name))])
 			configMap, err := k8sService.CreateConfigMap(context.TODO(), d.k8sNameSpace, d.spaceUuid, filepath.Dir(d.yamlPath), cr.VolumeMounts.Name)
 			if err != nil {
-				logs.GetLogger().Error(err)
-				return
+				return err
 			}
 			configName := configMap.GetName()
 			volumes = []coreV1.Volume{
@@ -211,25 +305,17 @@ func (d *Deploy) YamlToK8s() {
 		}
 
 		var containers []coreV1.Container
-		for _, depend := range cr.Depends {
-			var handler = new(coreV1.ExecAction)
-			handler.Command = depend.ReadyCmd
+		for _, sidecar := range cr.Sidecars {
 			containers = append(containers, coreV1.Container{
-				Name:            d.spaceUuid + "-" + depend.Name,
-				Image:           depend.ImageName,
-				Command:         depend.Command,
-				Args:            depend.Args,
-				Env:             depend.Env,
-				Ports:           depend.Ports,
+				Name:            d.spaceUuid + "-" + sidecar.Name,
+				Image:           sidecar.ImageName,
+				Command:         sidecar.Command,
+				Args:            sidecar.Args,
+				Env:             sidecar.Env,
+				Ports:           sidecar.Ports,
 				ImagePullPolicy: coreV1.PullIfNotPresent,
 				Resources:       coreV1.ResourceRequirements{},
-				ReadinessProbe: &coreV1.Probe{
-					ProbeHandler: coreV1.ProbeHandler{
-						Exec: handler,
-					},
-					InitialDelaySeconds: 5,
-					PeriodSeconds:       5,
-				},
+				ReadinessProbe:  readyCmdProbe(sidecar.ReadyCmd),
 			})
 		}
 
@@ -252,76 +338,401 @@ func (d *Deploy) YamlToK8s() {
 			},
 		}...)
 
+		serviceVolumes, serviceMounts, err := d.serviceVolumes(cr, names)
+		if err != nil {
+			return fmt.Errorf("failed create volumes of service %s, error: %w", cr.Name, err)
+		}
+		volumes = append(volumes, serviceVolumes...)
+		volumeMount = append(volumeMount, serviceMounts...)
+
+		var initContainers []coreV1.Container
+		if len(cr.Models) > 0 {
+			fetcher, cacheVolume, modelMounts, err := d.modelFetcher(cr.Models)
+			if err != nil {
+				return fmt.Errorf("failed prepare models of service %s, error: %w", cr.Name, err)
+			}
+			initContainers = append(initContainers, fetcher)
+			volumes = append(volumes, cacheVolume)
+			volumeMount = append(volumeMount, modelMounts...)
+		}
+
+		readinessProbe := cr.ReadinessProbe
+		if readinessProbe == nil {
+			readinessProbe = readyCmdProbe(cr.ReadyCmd)
+		}
+
 		containers = append(containers, coreV1.Container{
 			Name:            d.spaceUuid + "-" + cr.Name,
 			Image:           cr.ImageName,
 			Command:         cr.Command,
 			Args:            cr.Args,
-			Env:             cr.Env,
+			Env:             append(cr.Env, secretEnvVars(cr.Secrets)...),
 			Ports:           cr.Ports,
 			ImagePullPolicy: coreV1.PullIfNotPresent,
-			Resources:       d.createResources(),
+			Resources:       serviceResources[i],
 			VolumeMounts:    volumeMount,
+			ReadinessProbe:  readinessProbe,
+			LivenessProbe:   cr.LivenessProbe,
+			StartupProbe:    cr.StartupProbe,
 		})
 
+		var replicas *int32
+		if cr.Count > 0 {
+			count := int32(cr.Count)
+			replicas = &count
+		}
+
 		deployment := &appV1.Deployment{
 			TypeMeta: metaV1.TypeMeta{
 				Kind:       "Deployment",
 				APIVersion: "apps/v1",
 			},
 			ObjectMeta: metaV1.ObjectMeta{
-				Name:      constants.K8S_DEPLOY_NAME_PREFIX + d.spaceUuid,
+				Name:      names.deployment,
 				Namespace: d.k8sNameSpace,
+				Labels:    names.labels,
 			},
 
 			Spec: appV1.DeploymentSpec{
+				Replicas: replicas,
 				Selector: &metaV1.LabelSelector{
-					MatchLabels: map[string]string{"lad_app": d.spaceUuid},
+					MatchLabels: names.labels,
 				},
 				Template: coreV1.PodTemplateSpec{
 					ObjectMeta: metaV1.ObjectMeta{
-						Labels:    map[string]string{"lad_app": d.spaceUuid},
+						Labels:    names.labels,
 						Namespace: d.k8sNameSpace,
 					},
 					Spec: coreV1.PodSpec{
-						NodeSelector: generateLabel(d.hardwareResource.Gpu.Unit),
-						Containers:   containers,
-						Volumes:      volumes,
+						NodeSelector:   generateLabel(d.hardwareResource.Gpu.Unit),
+						InitContainers: initContainers,
+						Containers:     containers,
+						Volumes:        volumes,
 					},
 				},
 			}}
 
 		createDeployment, err := k8sService.CreateDeployment(context.TODO(), d.k8sNameSpace, deployment)
 		if err != nil {
-			logs.GetLogger().Error(err)
-			return
+			return err
+		}
+		if i == primary {
+			d.DeployName = createDeployment.GetName()
 		}
-		d.DeployName = createDeployment.GetName()
 		updateJobStatus(d.jobUuid, models.JobPullImage)
 		logs.GetLogger().Infof("Created deployment: %s", createDeployment.GetObjectMeta().GetName())
 
-		serviceHost, err := d.deployK8sResource(cr.Ports[0].ContainerPort)
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return
+		if httpPorts := cr.HttpPorts(); i == primary && len(httpPorts) > 0 {
+			var extraPorts []int32
+			for _, port := range httpPorts[1:] {
+				extraPorts = append(extraPorts, port.Port)
+			}
+			if err = d.deployIngress(names.service, httpPorts[0].Port, extraPorts...); err != nil {
+				return err
+			}
 		}
 
-		updateJobStatus(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName)
+		endpoints, err := d.deployExposeService(cr.Name, names, cr.L4Ports())
+		if err != nil {
+			return err
+		}
+		d.endpoints = append(d.endpoints, endpoints...)
 
 		if len(cr.Models) > 0 {
-			for _, res := range cr.Models {
-				go func(res yaml.ModelResource) {
-					downloadModelUrl(d.k8sNameSpace, d.spaceUuid, serviceHost, []string{"wget", res.Url, "-O", filepath.Join(res.Dir, res.Name)})
-				}(res)
+			modelFetchers.Add(1)
+			go func(selector string) {
+				defer modelFetchers.Done()
+				if !d.watchModelFetcher(selector) {
+					modelFetchFailed.Store(true)
+				}
+			}(labels.SelectorFromSet(names.labels).String())
+		}
+	}
+
+	d.watchContainerRunningTime()
+	// with models, the space is reported as deployed once they are fetched
+	go func() {
+		modelFetchers.Wait()
+		if !modelFetchFailed.Load() {
+			updateJobEndpoints(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName, d.endpoints)
+		}
+	}()
+	return nil
+}
+
+// serviceResources assigns the ordered hardware to the services. Services with `resources` get them,
+// the primary service without gets what is left, split among its replicas, the other services without
+// run best-effort and without GPU. The persistent volumes take their size from the ordered storage.
+func (d *Deploy) serviceResources(containerResources []yaml.ContainerResource, primary int) ([]coreV1.ResourceRequirements, error) {
+	ordered := d.createResources().Limits
+	left := ordered.DeepCopy()
+
+	result := make([]coreV1.ResourceRequirements, len(containerResources))
+	for i, cr := range containerResources {
+		for _, volume := range cr.Volumes {
+			if volume.Size == "" {
+				continue
+			}
+			size, err := resource.ParseQuantity(volume.Size)
+			if err != nil {
+				return nil, fmt.Errorf("service %s, volume %s: invalid size %q", cr.Name, volume.Name, volume.Size)
+			}
+			storage := left[coreV1.ResourceEphemeralStorage]
+			storage.Sub(size)
+			if storage.Sign() < 0 {
+				total := ordered[coreV1.ResourceEphemeralStorage]
+				return nil, fmt.Errorf("the volumes and services request more storage than the ordered %s", total.String())
+			}
+			left[coreV1.ResourceEphemeralStorage] = storage
+		}
+
+		if cr.Resources == nil {
+			continue
+		}
+		result[i] = *cr.Resources
+		replicas := serviceReplicas(cr)
+		for name, quantity := range cr.Resources.Limits {
+			orderedQuantity, ok := left[name]
+			if !ok {
+				return nil, fmt.Errorf("service %s requests %s, which is not part of the order", cr.Name, name)
+			}
+			for j := int64(0); j < replicas; j++ {
+				orderedQuantity.Sub(quantity)
 			}
+			if orderedQuantity.Sign() < 0 {
+				total := ordered[name]
+				return nil, fmt.Errorf("the services request more %s than the ordered %s", name, total.String())
+			}
+			left[name] = orderedQuantity
 		}
-		d.watchContainerRunningTime()
+	}
+
+	if primary >= 0 && containerResources[primary].Resources == nil {
+		perReplica, err := splitResources(left, serviceReplicas(containerResources[primary]))
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", containerResources[primary].Name, err)
+		}
+		result[primary] = coreV1.ResourceRequirements{
+			Limits:   perReplica,
+			Requests: perReplica.DeepCopy(),
+		}
+	}
+	return result, nil
+}
+
+func serviceReplicas(cr yaml.ContainerResource) int64 {
+	if cr.Count < 1 {
+		return 1
+	}
+	return int64(cr.Count)
+}
+
+// splitResources divides the resources among replicas, GPUs can't be shared between them.
+func splitResources(resources coreV1.ResourceList, replicas int64) (coreV1.ResourceList, error) {
+	if replicas == 1 {
+		return resources, nil
+	}
+	split := make(coreV1.ResourceList, len(resources))
+	for name, quantity := range resources {
+		switch name {
+		case "nvidia.com/gpu":
+			if quantity.Value()%replicas != 0 {
+				return nil, fmt.Errorf("%s GPUs can't be split among %d replicas", quantity.String(), replicas)
+			}
+			split[name] = *resource.NewQuantity(quantity.Value()/replicas, resource.DecimalSI)
+		case coreV1.ResourceCPU:
+			split[name] = *resource.NewMilliQuantity(quantity.MilliValue()/replicas, resource.DecimalSI)
+		default:
+			split[name] = *resource.NewQuantity(quantity.Value()/replicas, resource.BinarySI)
+		}
+	}
+	return split, nil
+}
+
+// serviceVolumes returns the volumes of a service: a persistent volume claim, kept across redeploys,
+// for volumes with a size, an emptyDir for the others, and the secrets mounted as files.
+func (d *Deploy) serviceVolumes(cr yaml.ContainerResource, names k8sServiceNames) ([]coreV1.Volume, []coreV1.VolumeMount, error) {
+	k8sService := NewK8sService()
+
+	var volumes []coreV1.Volume
+	var mounts []coreV1.VolumeMount
+	for _, volume := range cr.Volumes {
+		volumeName := "vol-" + volume.Name
+		source := coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}}
+		if volume.Size != "" {
+			claimName := fmt.Sprintf("%s%s-%s-%s", constants.K8S_PVC_NAME_PREFIX, d.spaceUuid, names.host, volume.Name)
+			if err := k8sService.EnsurePersistentVolumeClaim(context.TODO(), d.k8sNameSpace, claimName, volume.Size, names.labels); err != nil {
+				return nil, nil, err
+			}
+			source = coreV1.VolumeSource{
+				PersistentVolumeClaim: &coreV1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+			}
+		}
+		volumes = append(volumes, coreV1.Volume{Name: volumeName, VolumeSource: source})
+		mounts = append(mounts, coreV1.VolumeMount{Name: volumeName, MountPath: volume.Mount, ReadOnly: volume.ReadOnly})
+	}
+
+	for i, secret := range cr.Secrets {
+		if secret.Mount == "" {
+			continue
+		}
+		volumeName := fmt.Sprintf("secret-%d", i)
+		secretSource := &coreV1.SecretVolumeSource{SecretName: secret.Name}
+		if secret.Key != "" {
+			secretSource.Items = []coreV1.KeyToPath{{Key: secret.Key, Path: secret.Key}}
+		}
+		volumes = append(volumes, coreV1.Volume{
+			Name:         volumeName,
+			VolumeSource: coreV1.VolumeSource{Secret: secretSource},
+		})
+		mounts = append(mounts, coreV1.VolumeMount{Name: volumeName, MountPath: secret.Mount, ReadOnly: true})
+	}
+	return volumes, mounts, nil
+}
+
+func secretEnvVars(secrets []yaml.SecretRef) []coreV1.EnvVar {
+	var envVars []coreV1.EnvVar
+	for _, secret := range secrets {
+		if secret.Env == "" {
+			continue
+		}
+		key := secret.Key
+		if key == "" {
+			key = secret.Name
+		}
+		envVars = append(envVars, coreV1.EnvVar{
+			Name: secret.Env,
+			ValueFrom: &coreV1.EnvVarSource{
+				SecretKeyRef: &coreV1.SecretKeySelector{
+					LocalObjectReference: coreV1.LocalObjectReference{Name: secret.Name},
+					Key:                  key,
+				},
+			},
+		})
+	}
+	return envVars
+}
+
+// k8sServiceNames are the names of the kubernetes resources of one deploy.yaml service.
+type k8sServiceNames struct {
+	deployment string
+	service    string
+	host       string
+	labels     map[string]string
+}
+
+// serviceNames keeps the names of single-service spaces for the primary service, the other services
+// get their name as suffix.
+func (d *Deploy) serviceNames(service string, primary bool) k8sServiceNames {
+	host := serviceNameSlug(service)
+	var suffix string
+	if !primary {
+		suffix = "-" + host
+	}
+	return k8sServiceNames{
+		deployment: constants.K8S_DEPLOY_NAME_PREFIX + d.spaceUuid + suffix,
+		service:    constants.K8S_SERVICE_NAME_PREFIX + d.spaceUuid + suffix,
+		host:       host,
+		labels: map[string]string{
+			"lad_app":     d.spaceUuid,
+			"lad_service": host,
+		},
+	}
+}
+
+// serviceNameSlug turns a service name into a DNS label that still fits into the resource names,
+// "svc-<uuid>-<slug>-ext" must not be longer than 63 characters.
+func serviceNameSlug(service string) string {
+	slug := strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(service), "-"), "-")
+	if len(slug) > serviceSlugLength {
+		slug = strings.Trim(slug[:serviceSlugLength], "-")
+	}
+	return slug
+}
+
+func (d *Deploy) templateContext() yaml.TemplateContext {
+	return yaml.TemplateContext{
+		SpaceUrl:     "https://" + d.hostName,
+		SpaceUuid:    d.spaceUuid,
+		Wallet:       d.walletAddress,
+		JobUuid:      d.jobUuid,
+		ServiceHosts: make(map[string]string),
+	}
+}
+
+// buildServiceImages builds the images of compose services that come with a build context.
+func (d *Deploy) buildServiceImages(containerResources []yaml.ContainerResource) error {
+	build := func(cr *yaml.ContainerResource) error {
+		if cr.Build == nil {
+			return nil
+		}
+		imageName, err := BuildComposeImage(d.jobUuid, d.spaceUuid, d.spaceName, cr.Name, cr.Build)
+		if err != nil {
+			return err
+		}
+		cr.ImageName = imageName
+		return nil
+	}
+
+	for i := range containerResources {
+		if err := build(&containerResources[i]); err != nil {
+			return err
+		}
+		for j := range containerResources[i].Sidecars {
+			if err := build(&containerResources[i].Sidecars[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// primaryService is the service that serves the space hostname: the last service in startup order
+// that has an HTTP port, or the last one when no service has.
+func primaryService(containerResources []yaml.ContainerResource) int {
+	for i := len(containerResources) - 1; i >= 0; i-- {
+		if len(containerResources[i].HttpPorts()) > 0 {
+			return i
+		}
+	}
+	return len(containerResources) - 1
+}
+
+func clusterServicePorts(cr yaml.ContainerResource) []coreV1.ServicePort {
+	var ports []coreV1.ServicePort
+	for i, expose := range cr.Expose {
+		name := fmt.Sprintf("%s-%d", expose.Protocol, expose.Port)
+		if i == 0 && expose.IsHttp() {
+			name = "http"
+		}
+		ports = append(ports, coreV1.ServicePort{
+			Name:     name,
+			Protocol: exposeProtocol(expose.Protocol),
+			Port:     expose.Port,
+		})
+	}
+	return ports
+}
+
+func readyCmdProbe(readyCmd []string) *coreV1.Probe {
+	if len(readyCmd) == 0 {
+		return nil
+	}
+	return &coreV1.Probe{
+		ProbeHandler: coreV1.ProbeHandler{
+			Exec: &coreV1.ExecAction{
+				Command: readyCmd,
+			},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       5,
 	}
 }
 
 func (d *Deploy) ModelInferenceToK8s() error {
 	var modelSetting struct {
-		ModelId string `json:"model_id"`
+		ModelId     string `json:"model_id"`
+		ScaleToZero bool   `json:"scale_to_zero"`
 	}
 	modelData, _ := os.ReadFile(d.modelsSettingFile)
 	err := json.Unmarshal(modelData, &modelSetting)
@@ -330,39 +741,17 @@ func (d *Deploy) ModelInferenceToK8s() error {
 		return err
 	}
 
-	cpPath, _ := os.LookupEnv("CP_PATH")
-	basePath := filepath.Join(cpPath, "inference-model")
-
-	modelInfoOut, err := util.RunPythonScript(filepath.Join(basePath, "/scripts/hf_client.py"), "model_info", modelSetting.ModelId)
+	modelInfo, err := resolveModel(modelSetting.ModelId)
 	if err != nil {
-		logs.GetLogger().Errorf("exec model_info cmd failed, error: %+v", err)
-		return err
+		return fmt.Errorf("failed resolve model %s, error: %w", modelSetting.ModelId, err)
 	}
 
-	var modelInfo struct {
-		ModelId   string `json:"model_id"`
-		Task      string `json:"task"`
-		Framework string `json:"framework"`
-	}
-	err = json.Unmarshal([]byte(modelInfoOut), &modelInfo)
+	// the image is built before the previous deploy goes, a failed build leaves the running space alone
+	imageName, openAI, err := d.inferenceImage(modelInfo)
 	if err != nil {
-		logs.GetLogger().Errorf("convert model_info out to json failed, error: %+v", err)
-		return err
-	}
-
-	deleteJob(d.k8sNameSpace, d.spaceUuid)
-	imageName := "lagrange/" + modelInfo.Framework + ":v1.0"
-
-	logFile := filepath.Join(d.SpacePath, BuildFileName)
-	if _, err = os.Create(logFile); err != nil {
-		return err
+		return fmt.Errorf("failed build image of model %s, error: %w", modelInfo.ModelId, err)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	util.StreamPythonScriptOutput(&wg, filepath.Join(basePath, "build_docker.py"), basePath, modelInfo.Framework, imageName, logFile)
-	wg.Wait()
-
 	modelEnvs := []coreV1.EnvVar{
 		{
 			Name:  "TASK",
@@ -376,8 +765,10 @@ func (d *Deploy) ModelInferenceToK8s() error {
 
 	d.image = imageName
 
-	if err := d.deployNamespace(); err != nil {
-		logs.GetLogger().Error(err)
+	if err = d.replaceJob(); err != nil {
+		return err
+	}
+	if err = d.deployNamespace(); err != nil {
 		return err
 	}
 
@@ -404,6 +795,7 @@ func (d *Deploy) ModelInferenceToK8s() error {
 
 				Spec: coreV1.PodSpec{
 					NodeSelector: generateLabel(d.hardwareResource.Gpu.Unit),
+					Affinity:     warmNodeAffinity(imageName),
 					Containers: []coreV1.Container{{
 						Name:            constants.K8S_CONTAINER_NAME_PREFIX + d.spaceUuid,
 						Image:           d.image,
@@ -411,8 +803,8 @@ func (d *Deploy) ModelInferenceToK8s() error {
 						Ports: []coreV1.ContainerPort{{
 							ContainerPort: int32(80),
 						}},
-						Env: d.createEnv(modelEnvs...),
-						//Resources: d.createResources(),
+						Env:       d.createEnv(modelEnvs...),
+						Resources: d.createResources(),
 					}},
 				},
 			},
@@ -431,6 +823,7 @@ func (d *Deploy) ModelInferenceToK8s() error {
 		return err
 	}
 	updateJobStatus(d.jobUuid, models.JobDeployToK8s)
+	registerGatewayRoute(d.spaceUuid, d.walletAddress, modelInfo.ModelId, openAI, modelSetting.ScaleToZero)
 	d.watchContainerRunningTime()
 	return nil
 }
@@ -494,13 +887,13 @@ func (d *Deploy) createResources() coreV1.ResourceRequirements {
 
 	memQuantity, err := resource.ParseQuantity(fmt.Sprintf("%d%s", d.hardwareResource.Memory.Quantity, d.hardwareResource.Memory.Unit))
 	if err != nil {
-		logs.GetLogger().Error("get memory failed, error: %+v", err)
+		logs.GetLogger().Errorf("get memory failed, error: %+v", err)
 		return coreV1.ResourceRequirements{}
 	}
 
 	storageQuantity, err := resource.ParseQuantity(fmt.Sprintf("%d%s", d.hardwareResource.Storage.Quantity, d.hardwareResource.Storage.Unit))
 	if err != nil {
-		logs.GetLogger().Error("get storage failed, error: %+v", err)
+		logs.GetLogger().Errorf("get storage failed, error: %+v", err)
 		return coreV1.ResourceRequirements{}
 	}
 
@@ -520,10 +913,23 @@ func (d *Deploy) createResources() coreV1.ResourceRequirements {
 	}
 }
 
-func (d *Deploy) deployK8sResource(containerPort int32) (string, error) {
+func (d *Deploy) deployK8sResource(containerPort int32, extraPorts ...int32) (string, error) {
 	k8sService := NewK8sService()
 
-	createService, err := k8sService.CreateService(context.TODO(), d.k8sNameSpace, d.spaceUuid, containerPort)
+	ports := []coreV1.ServicePort{
+		{
+			Name: "http",
+			Port: containerPort,
+		},
+	}
+	for _, port := range extraPorts {
+		ports = append(ports, coreV1.ServicePort{
+			Name: fmt.Sprintf("http-%d", port),
+			Port: port,
+		})
+	}
+	createService, err := k8sService.CreateService(context.TODO(), d.k8sNameSpace, constants.K8S_SERVICE_NAME_PREFIX+d.spaceUuid,
+		map[string]string{"lad_app": d.spaceUuid}, ports)
 	if err != nil {
 		return "", fmt.Errorf("failed creata service, error: %w", err)
 	}
@@ -531,12 +937,43 @@ func (d *Deploy) deployK8sResource(containerPort int32) (string, error) {
 
 	serviceHost := fmt.Sprintf("http://%s:%d", createService.Spec.ClusterIP, createService.Spec.Ports[0].Port)
 
-	createIngress, err := k8sService.CreateIngress(context.TODO(), d.k8sNameSpace, d.spaceUuid, d.hostName, containerPort)
+	if err = d.deployIngress(createService.GetName(), containerPort, extraPorts...); err != nil {
+		return "", err
+	}
+	return serviceHost, nil
+}
+
+// deployIngress serves containerPort of serviceName at the space hostname, every extra port at a hostname of its own.
+func (d *Deploy) deployIngress(serviceName string, containerPort int32, extraPorts ...int32) error {
+	k8sService := NewK8sService()
+
+	createIngress, err := k8sService.CreateIngress(context.TODO(), d.k8sNameSpace, d.spaceUuid, d.hostName, serviceName, containerPort)
 	if err != nil {
-		return "", fmt.Errorf("failed creata ingress, error: %w", err)
+		return fmt.Errorf("failed creata ingress, error: %w", err)
 	}
 	logs.GetLogger().Infof("Created Ingress successfully: %s", createIngress.GetObjectMeta().GetName())
-	return serviceHost, nil
+
+	for _, port := range extraPorts {
+		host := httpPortHost(d.hostName, port)
+		if err = k8sService.AddIngressRule(context.TODO(), d.k8sNameSpace, createIngress.GetName(), host, serviceName, port); err != nil {
+			return fmt.Errorf("failed add ingress rule for port %d, error: %w", port, err)
+		}
+		d.endpoints = append(d.endpoints, models.Endpoint{
+			Protocol:      yaml.ExposeProtocolHttp,
+			ContainerPort: port,
+			PublicPort:    443,
+			Address:       "https://" + host,
+		})
+	}
+
+	for _, domain := range d.customDomains {
+		go func(domain string) {
+			if err := attachCustomDomain(d.k8sNameSpace, d.spaceUuid, domain); err != nil {
+				logs.GetLogger().Errorf("Failed attach custom domain %s to space %s, error: %+v", domain, d.spaceUuid, err)
+			}
+		}(domain)
+	}
+	return nil
 }
 
 func (d *Deploy) watchContainerRunningTime() {
@@ -561,13 +998,15 @@ func (d *Deploy) watchContainerRunningTime() {
 		"deploy_name":    d.DeployName,
 		"hardware":       d.hardwareDesc,
 		"url":            fmt.Sprintf("https://%s", d.hostName),
+		"custom_domains": strings.Join(d.customDomains, ","),
+		"endpoints":      marshalEndpoints(d.endpoints),
 	}
 
 	for key, val := range fields {
 		fullArgs = append(fullArgs, key, val)
 	}
 	_, _ = conn.Do("HSET", fullArgs...)
-
+	indexHostNames(d.spaceUuid, spaceHosts(fmt.Sprintf("https://%s", d.hostName), d.endpoints))
 }
 
 func getHardwareDetail(description string) (string, models.Resource) {
@@ -0,0 +1,234 @@
+package computing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+const (
+	DnsProviderCloudflare   = "cloudflare"
+	DnsProviderExec         = "exec"
+	DnsProviderChallTestSrv = "challtestsrv"
+)
+
+// DNSProvider publishes the TXT records of an ACME DNS-01 challenge.
+// fqdn is the full record name, e.g. "_acme-challenge.example.com.".
+// Present must add the value without removing other values of the same name,
+// since a wildcard and an apex authorization share one record name.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+func NewDNSProvider(acmeConf conf.ACME) (DNSProvider, error) {
+	switch acmeConf.DnsProvider {
+	case DnsProviderCloudflare:
+		if acmeConf.CloudflareApiToken == "" || acmeConf.CloudflareZoneId == "" {
+			return nil, fmt.Errorf("dns provider %s requires CloudflareApiToken and CloudflareZoneId", acmeConf.DnsProvider)
+		}
+		return &cloudflareProvider{apiToken: acmeConf.CloudflareApiToken, zoneId: acmeConf.CloudflareZoneId}, nil
+	case DnsProviderExec:
+		if acmeConf.ExecPath == "" {
+			return nil, fmt.Errorf("dns provider %s requires ExecPath", acmeConf.DnsProvider)
+		}
+		return &execProvider{path: acmeConf.ExecPath}, nil
+	case DnsProviderChallTestSrv:
+		if acmeConf.ChallTestSrvUrl == "" {
+			return nil, fmt.Errorf("dns provider %s requires ChallTestSrvUrl", acmeConf.DnsProvider)
+		}
+		return &challTestSrvProvider{url: strings.TrimSuffix(acmeConf.ChallTestSrvUrl, "/")}, nil
+	default:
+		return nil, fmt.Errorf("not support dns provider: %s", acmeConf.DnsProvider)
+	}
+}
+
+// execProvider runs `<path> present|cleanup <fqdn> <value>`, so any DNS API can be scripted.
+type execProvider struct {
+	path string
+}
+
+func (p *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	out, err := exec.CommandContext(ctx, p.path, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns exec %s failed, output: %s, error: %w", action, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// challTestSrvProvider talks to the management API of pebble-challtestsrv,
+// the mock DNS server that ships with the Pebble ACME test server. Its /clear-txt removes every
+// value of a name, so the provider keeps the values it presented and sets the others again.
+type challTestSrvProvider struct {
+	url string
+
+	lock   sync.Mutex
+	values map[string][]string
+}
+
+func (p *challTestSrvProvider) Present(ctx context.Context, fqdn, value string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	host := dnsFqdn(fqdn)
+	if err := p.post(ctx, "/set-txt", map[string]string{"host": host, "value": value}); err != nil {
+		return err
+	}
+	if p.values == nil {
+		p.values = make(map[string][]string)
+	}
+	p.values[host] = append(p.values[host], value)
+	return nil
+}
+
+func (p *challTestSrvProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	host := dnsFqdn(fqdn)
+	var others []string
+	for _, v := range p.values[host] {
+		if v != value {
+			others = append(others, v)
+		}
+	}
+	if err := p.post(ctx, "/clear-txt", map[string]string{"host": host}); err != nil {
+		return err
+	}
+	if len(others) == 0 {
+		delete(p.values, host)
+	} else {
+		p.values[host] = others
+	}
+	for _, v := range others {
+		if err := p.post(ctx, "/set-txt", map[string]string{"host": host, "value": v}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *challTestSrvProvider) post(ctx context.Context, path string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+path, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challtestsrv %s, unexpected status code: %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+const cloudflareApiUrl = "https://api.cloudflare.com/client/v4"
+
+type cloudflareProvider struct {
+	apiToken string
+	zoneId   string
+}
+
+type cloudflareRecord struct {
+	Id      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Ttl     int    `json:"ttl"`
+}
+
+func (p *cloudflareProvider) Present(ctx context.Context, fqdn, value string) error {
+	record := cloudflareRecord{
+		Type:    "TXT",
+		Name:    strings.TrimSuffix(fqdn, "."),
+		Content: value,
+		Ttl:     120,
+	}
+	return p.do(ctx, http.MethodPost, "/zones/"+p.zoneId+"/dns_records", record, nil)
+}
+
+func (p *cloudflareProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	var records []cloudflareRecord
+	query := fmt.Sprintf("/zones/%s/dns_records?type=TXT&name=%s", p.zoneId, strings.TrimSuffix(fqdn, "."))
+	if err := p.do(ctx, http.MethodGet, query, nil, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.Content != value {
+			continue
+		}
+		if err := p.do(ctx, http.MethodDelete, "/zones/"+p.zoneId+"/dns_records/"+record.Id, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareApiUrl+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var cfResp struct {
+		Success bool            `json:"success"`
+		Errors  json.RawMessage `json:"errors"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
+		return fmt.Errorf("failed decode cloudflare response, status code: %d, error: %w", resp.StatusCode, err)
+	}
+	if !cfResp.Success {
+		return fmt.Errorf("cloudflare %s %s failed: %s", method, path, string(cfResp.Errors))
+	}
+	if result != nil {
+		return json.Unmarshal(cfResp.Result, result)
+	}
+	return nil
+}
+
+func dnsFqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
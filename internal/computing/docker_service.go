@@ -33,7 +33,7 @@ type DockerService struct {
 }
 
 func NewDockerService() *DockerService {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := newDockerClient()
 	if err != nil {
 		panic(err.Error())
 	}
@@ -42,6 +42,10 @@ func NewDockerService() *DockerService {
 	}
 }
 
+func newDockerClient() (*client.Client, error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
+
 func ExtractExposedPort(dockerfilePath string) (string, error) {
 	file, err := os.Open(dockerfilePath)
 	if err != nil {
@@ -165,13 +169,42 @@ func RemoveContainerIfExists(imageName string) error {
 	return nil
 }
 
-func (ds *DockerService) BuildImage(buildPath, imageName string) error {
+type buildSettings struct {
+	options types.ImageBuildOptions
+	logPath string
+}
+
+// BuildOption customizes an image build, e.g. with the dockerfile and build args of a compose service.
+type BuildOption func(*buildSettings)
+
+// WithDockerfile builds from a dockerfile other than the Dockerfile in the root of the build path.
+func WithDockerfile(dockerfile string) BuildOption {
+	return func(settings *buildSettings) {
+		settings.options.Dockerfile = dockerfile
+	}
+}
+
+func WithBuildArgs(args map[string]*string) BuildOption {
+	return func(settings *buildSettings) {
+		settings.options.BuildArgs = args
+	}
+}
+
+// WithBuildLog writes the build output to logPath instead of build.log in the build path.
+func WithBuildLog(logPath string) BuildOption {
+	return func(settings *buildSettings) {
+		settings.logPath = logPath
+	}
+}
+
+// BuildImage builds the image imageName from buildPath. The output goes to build.log, a failed step
+// is returned as error.
+func (ds *DockerService) BuildImage(buildPath, imageName string, opts ...BuildOption) error {
 	// Create a buffer
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
-	defer tw.Close()
 
-	filepath.Walk(buildPath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(buildPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -199,18 +232,31 @@ func (ds *DockerService) BuildImage(buildPath, imageName string) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("failed read build context %s, error: %w", buildPath, err)
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
 
 	dockerFileTarReader := bytes.NewReader(buf.Bytes())
-	buildResponse, err := ds.c.ImageBuild(context.Background(), dockerFileTarReader, types.ImageBuildOptions{
-		Context: dockerFileTarReader,
-		Tags:    []string{imageName},
-	})
+	settings := buildSettings{
+		options: types.ImageBuildOptions{
+			Context: dockerFileTarReader,
+			Tags:    []string{imageName},
+		},
+		logPath: filepath.Join(buildPath, BuildFileName),
+	}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	buildResponse, err := ds.c.ImageBuild(context.Background(), dockerFileTarReader, settings.options)
 	if err != nil {
 		return err
 	}
 	defer buildResponse.Body.Close()
 
-	logFile, err := os.Create(filepath.Join(buildPath, BuildFileName))
+	logFile, err := os.Create(settings.logPath)
 	if err != nil {
 		return err
 	}
@@ -219,10 +265,25 @@ func (ds *DockerService) BuildImage(buildPath, imageName string) error {
 	logWriters := []io.Writer{logFile, os.Stdout}
 	multiWriter := io.MultiWriter(logWriters...)
 
-	_, err = io.Copy(multiWriter, buildResponse.Body)
-	if err != nil {
+	var buildErr string
+	scanner := bufio.NewScanner(buildResponse.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if _, err = multiWriter.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		errLine := &ErrorLine{}
+		if json.Unmarshal(line, errLine) == nil && errLine.Error != "" {
+			buildErr = errLine.Error
+		}
+	}
+	if err = scanner.Err(); err != nil {
 		return err
 	}
+	if buildErr != "" {
+		return fmt.Errorf("failed build image %s, error: %s", imageName, buildErr)
+	}
 	return nil
 }
 
@@ -233,6 +294,21 @@ type ErrorLine struct {
 	} `json:"errorDetail"`
 }
 
+// Ping asks the version of the docker daemon.
+func (ds *DockerService) Ping(ctx context.Context) (types.Ping, error) {
+	return ds.c.Ping(ctx)
+}
+
+// LoginRegistry checks the credentials of the registry of the config.
+func (ds *DockerService) LoginRegistry(ctx context.Context) error {
+	_, err := ds.c.RegistryLogin(ctx, types.AuthConfig{
+		ServerAddress: conf.GetConfig().Registry.ServerAddress,
+		Username:      conf.GetConfig().Registry.UserName,
+		Password:      conf.GetConfig().Registry.Password,
+	})
+	return err
+}
+
 func (ds *DockerService) PushImage(imagesName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*6000)
 	defer cancel()
@@ -300,7 +376,7 @@ func (ds *DockerService) CleanResource() {
 	}
 
 	for _, image := range images {
-		if image.Containers == 0 {
+		if image.Containers == 0 && !isRuntimeImage(image.RepoTags) {
 			logs.GetLogger().Infof("start clean unused image, imageId: %s", image.ID)
 			ds.RemoveImage(image.ID)
 		}
@@ -0,0 +1,320 @@
+package computing
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/util"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// doctorCheck checks a dependency of the provider, the prerequisites of the README.
+type doctorCheck struct {
+	name  string
+	check func(ctx context.Context) (models.CheckStatus, string, string)
+}
+
+var doctorChecks = []doctorCheck{
+	{"redis", checkRedisPool},
+	{"kubernetes", checkKubernetesVersion},
+	{"ingress-nginx", checkIngressNginx},
+	{"nvidia-device-plugin", checkDevicePlugin},
+	{"resource-exporter", checkResourceExporter},
+	{"gpu-discovery", checkGpuDiscovery},
+	{"docker", checkDockerDaemon},
+	{"registry", checkRegistry},
+	{"tls", checkTls},
+	{"mcs-storage", checkStorage},
+	{"inference-runtimes", checkInferenceRuntimes},
+}
+
+// RunDoctorChecks runs all checks at once, each within a timeout, and returns them in order. A check
+// still running at its timeout fails, as some clients don't stop on the context.
+func RunDoctorChecks(ctx context.Context) []models.CheckResult {
+	results := make([]models.CheckResult, len(doctorChecks))
+	var wg sync.WaitGroup
+	for i, c := range doctorChecks {
+		wg.Add(1)
+		go func(i int, c doctorCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+			defer cancel()
+
+			done := make(chan models.CheckResult, 1)
+			go func() {
+				result := models.CheckResult{Name: c.name}
+				defer func() {
+					// a client that panics fails its check, not the doctor
+					if r := recover(); r != nil {
+						result.Status, result.Message = models.CheckFail, fmt.Sprint(r)
+					}
+					done <- result
+				}()
+				result.Status, result.Message, result.Hint = c.check(checkCtx)
+			}()
+
+			select {
+			case results[i] = <-done:
+			case <-checkCtx.Done():
+				results[i] = models.CheckResult{Name: c.name, Status: models.CheckFail,
+					Message: fmt.Sprintf("no answer within %s", preflightTimeout)}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// doctorCacheTTL is how long GetDoctor serves the same results, so the endpoint doesn't run the
+// checks against the cluster on every request.
+const doctorCacheTTL = time.Minute
+
+var doctorCache struct {
+	sync.Mutex
+	checkedAt time.Time
+	results   []models.CheckResult
+}
+
+// GetDoctor runs the checks of `computing-provider doctor` on the provider, for a remote diagnosis. It
+// is reserved to the provider, the results describe its setup.
+func GetDoctor(c *gin.Context) {
+	if status, err := authorizeProvider(c); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.DoctorUnauthorized, err.Error()))
+		return
+	}
+
+	doctorCache.Lock()
+	defer doctorCache.Unlock()
+	if time.Since(doctorCache.checkedAt) > doctorCacheTTL {
+		doctorCache.results = RunDoctorChecks(context.Background())
+		doctorCache.checkedAt = time.Now()
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(doctorCache.results))
+}
+
+func checkRedisPool(ctx context.Context) (models.CheckStatus, string, string) {
+	if redisPool == nil {
+		newRedisPool(conf.GetConfig().API.RedisUrl, conf.GetConfig().API.RedisPassword)
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return models.CheckFail, err.Error(), "start redis and check API.RedisUrl and API.RedisPassword"
+	}
+	defer conn.Close()
+	if _, err = conn.Do("PING"); err != nil {
+		return models.CheckFail, err.Error(), "check API.RedisPassword"
+	}
+	return models.CheckPass, "PONG from " + conf.Redact(conf.GetConfig()).API.RedisUrl, ""
+}
+
+func checkKubernetesVersion(ctx context.Context) (models.CheckStatus, string, string) {
+	result := CheckKubernetes()
+	return result.Status, result.Message, result.Hint
+}
+
+func checkIngressNginx(ctx context.Context) (models.CheckStatus, string, string) {
+	k8sService := NewK8sService()
+	if k8sService.k8sClient == nil {
+		return models.CheckFail, "no kubernetes client", "see the kubernetes check"
+	}
+	pods, err := k8sService.k8sClient.CoreV1().Pods("").List(ctx, metaV1.ListOptions{
+		LabelSelector: "app.kubernetes.io/name=ingress-nginx,app.kubernetes.io/component=controller",
+	})
+	if err != nil {
+		return models.CheckFail, err.Error(), ""
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == coreV1.PodRunning {
+			return models.CheckPass, fmt.Sprintf("controller %s/%s is running", pod.Namespace, pod.Name), ""
+		}
+	}
+	if len(pods.Items) > 0 {
+		return models.CheckFail, fmt.Sprintf("%d controller pod(s), none is running", len(pods.Items)), "kubectl describe po -n ingress-nginx"
+	}
+	return models.CheckFail, "no ingress-nginx controller", "install it as in the README, Install the Ingress-nginx Controller"
+}
+
+func checkDevicePlugin(ctx context.Context) (models.CheckStatus, string, string) {
+	k8sService := NewK8sService()
+	if k8sService.k8sClient == nil {
+		return models.CheckFail, "no kubernetes client", "see the kubernetes check"
+	}
+	nodes, err := k8sService.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return models.CheckFail, err.Error(), ""
+	}
+	var gpus int64
+	var gpuNodes int
+	for _, node := range nodes.Items {
+		if quantity, ok := node.Status.Allocatable["nvidia.com/gpu"]; ok && quantity.Value() > 0 {
+			gpus += quantity.Value()
+			gpuNodes++
+		}
+	}
+	if gpus == 0 {
+		return models.CheckWarn, "no node has an allocatable nvidia.com/gpu", "for GPU jobs, install the NVIDIA plugin as in the README"
+	}
+	return models.CheckPass, fmt.Sprintf("%d GPU(s) on %d node(s)", gpus, gpuNodes), ""
+}
+
+func checkResourceExporter(ctx context.Context) (models.CheckStatus, string, string) {
+	collectors := conf.GetConfig().Hardware.Collectors
+	if len(collectors) > 0 && !containsString(collectors, "exporter") {
+		return models.CheckPass, "not used, Hardware.Collectors is " + strings.Join(collectors, ", "), ""
+	}
+	k8sService := NewK8sService()
+	if k8sService.k8sClient == nil {
+		return models.CheckFail, "no kubernetes client", "see the kubernetes check"
+	}
+	daemonSets, err := k8sService.k8sClient.AppsV1().DaemonSets("kube-system").List(ctx, metaV1.ListOptions{LabelSelector: "app=resource-exporter"})
+	if err != nil {
+		return models.CheckFail, err.Error(), ""
+	}
+	if len(daemonSets.Items) == 0 {
+		return models.CheckFail, "no resource-exporter DaemonSet in kube-system", "install it as in the README, Install Hardware resource-exporter"
+	}
+	status := daemonSets.Items[0].Status
+	if status.NumberReady < status.DesiredNumberScheduled {
+		return models.CheckWarn, fmt.Sprintf("%d of %d pods are ready", status.NumberReady, status.DesiredNumberScheduled), "kubectl get po -n kube-system -l app=resource-exporter"
+	}
+	return models.CheckPass, fmt.Sprintf("%d pods are ready", status.NumberReady), ""
+}
+
+func checkGpuDiscovery(ctx context.Context) (models.CheckStatus, string, string) {
+	if NewK8sService().k8sClient == nil {
+		return models.CheckFail, "no kubernetes client", "see the kubernetes check"
+	}
+	nodeGpus, err := collectNodeGpus(ctx)
+	if err != nil {
+		return models.CheckFail, err.Error(), "check the sources of Hardware.Collectors"
+	}
+	var gpus int
+	for _, gpu := range nodeGpus {
+		gpus += len(gpu.Details)
+	}
+	if gpus == 0 {
+		return models.CheckWarn, "no GPU found", "for GPU jobs, check the sources of Hardware.Collectors"
+	}
+	return models.CheckPass, fmt.Sprintf("%d GPU(s) on %d node(s)", gpus, len(nodeGpus)), ""
+}
+
+func checkDockerDaemon(ctx context.Context) (models.CheckStatus, string, string) {
+	result := CheckDocker()
+	return result.Status, result.Message, result.Hint
+}
+
+func checkRegistry(ctx context.Context) (models.CheckStatus, string, string) {
+	registry := conf.GetConfig().Registry
+	if registry.ServerAddress == "" {
+		return models.CheckWarn, "no registry, the images built here can only run on this node", "set Registry.ServerAddress for a cluster of several nodes"
+	}
+	cli, err := newDockerClient()
+	if err != nil {
+		return models.CheckFail, err.Error(), "see the docker check"
+	}
+	defer cli.Close()
+	if err = (&DockerService{c: cli}).LoginRegistry(ctx); err != nil {
+		return models.CheckFail, err.Error(), "check Registry.ServerAddress, Registry.UserName and Registry.Password"
+	}
+	return models.CheckPass, "logged in to " + registry.ServerAddress, ""
+}
+
+func checkTls(ctx context.Context) (models.CheckStatus, string, string) {
+	cpConfig := conf.GetConfig()
+	domain := strings.TrimPrefix(cpConfig.API.Domain, ".")
+	if cpConfig.ACME.Enable {
+		k8sService := NewK8sService()
+		if k8sService.k8sClient == nil {
+			return models.CheckFail, "no kubernetes client", "see the kubernetes check"
+		}
+		secret, err := k8sService.k8sClient.CoreV1().Secrets(cpConfig.ACME.SecretNamespace).Get(ctx, cpConfig.ACME.SecretName, metaV1.GetOptions{})
+		if err != nil {
+			return models.CheckWarn, err.Error(), "the certificate is obtained by ACME when the provider starts"
+		}
+		result := CheckCertificate(secret.Data[coreV1.TLSCertKey], secret.Data[coreV1.TLSPrivateKeyKey], domain)
+		return result.Status, result.Message, result.Hint
+	}
+
+	certPEM, err := os.ReadFile(cpConfig.LOG.CrtFile)
+	if err != nil {
+		return models.CheckFail, err.Error(), "set LOG.CrtFile and LOG.KeyFile, or enable ACME"
+	}
+	keyPEM, err := os.ReadFile(cpConfig.LOG.KeyFile)
+	if err != nil {
+		return models.CheckFail, err.Error(), "set LOG.CrtFile and LOG.KeyFile, or enable ACME"
+	}
+	result := CheckCertificate(certPEM, keyPEM, domain)
+	return result.Status, result.Message, result.Hint
+}
+
+// CheckCertificate checks that a certificate matches its key, covers the hosts of the domain and is
+// not about to expire.
+func CheckCertificate(certPEM, keyPEM []byte, domain string) models.CheckResult {
+	result := models.CheckResult{Name: "tls"}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		result.Status, result.Message, result.Hint = models.CheckFail, err.Error(), "the certificate and the key don't match"
+		return result
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		return result
+	}
+	if domain != "" {
+		if err = cert.VerifyHostname("space." + domain); err != nil {
+			result.Status, result.Message, result.Hint = models.CheckFail, err.Error(), "the certificate must be the wildcard certificate of API.Domain"
+			return result
+		}
+	}
+
+	switch left := time.Until(cert.NotAfter); {
+	case left <= 0:
+		result.Status, result.Message, result.Hint = models.CheckFail, "expired on "+cert.NotAfter.Format(time.RFC3339), "renew the certificate, or enable ACME"
+	case left < certExpiryWarning:
+		result.Status, result.Message, result.Hint = models.CheckWarn, "expires on "+cert.NotAfter.Format(time.RFC3339), "renew the certificate, or enable ACME"
+	default:
+		result.Status, result.Message = models.CheckPass, "valid until "+cert.NotAfter.Format(time.RFC3339)
+	}
+	return result
+}
+
+func checkStorage(ctx context.Context) (models.CheckStatus, string, string) {
+	if err := NewStorageService().CheckBucket(); err != nil {
+		return models.CheckFail, err.Error(), "check MCS.ApiKey, MCS.Network and that MCS.BucketName exists"
+	}
+	return models.CheckPass, "bucket " + conf.GetConfig().MCS.BucketName, ""
+}
+
+func checkInferenceRuntimes(ctx context.Context) (models.CheckStatus, string, string) {
+	cpPath, _ := os.LookupEnv("CP_PATH")
+	dir := filepath.Join(cpPath, "inference-model", "docker_images")
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return models.CheckWarn, "no runtime images to build in " + dir, "for model spaces without a runtime image, run ./install.sh as in the README"
+	}
+	return models.CheckPass, fmt.Sprintf("%d framework(s) in %s", len(entries), dir), ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
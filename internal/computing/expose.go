@@ -0,0 +1,210 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultPortRangeStart = 30000
+	defaultPortRangeEnd   = 32767
+
+	loadBalancerWaitTimeout = 2 * time.Minute
+
+	// nodePortReserveSeconds covers the time from picking a port until its Service exists, from then
+	// on the Service holds it
+	nodePortReserveSeconds = 600
+)
+
+// deployExposeService publishes the global TCP/UDP ports of a space and returns their public endpoints.
+// A port keeps the public port it had before when that one is still free.
+func (d *Deploy) deployExposeService(serviceName string, names k8sServiceNames, exposePorts []yaml.ExposePort) ([]models.Endpoint, error) {
+	if len(exposePorts) == 0 {
+		return nil, nil
+	}
+
+	serviceType := coreV1.ServiceTypeNodePort
+	if strings.EqualFold(conf.GetConfig().API.ExposeServiceType, string(coreV1.ServiceTypeLoadBalancer)) {
+		serviceType = coreV1.ServiceTypeLoadBalancer
+	}
+
+	k8sService := NewK8sService()
+	usedPorts, err := k8sService.ListNodePorts(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed list node ports, error: %w", err)
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	start, end := portRange()
+	var servicePorts []coreV1.ServicePort
+	for _, expose := range exposePorts {
+		nodePort, err := allocateNodePort(conn, d.spaceUuid, usedPorts, previousPublicPort(d.previousEndpoints, serviceName, expose), start, end)
+		if err != nil {
+			return nil, err
+		}
+		servicePorts = append(servicePorts, coreV1.ServicePort{
+			Name:     fmt.Sprintf("%s-%d", expose.Protocol, expose.Port),
+			Protocol: exposeProtocol(expose.Protocol),
+			Port:     expose.As,
+			NodePort: nodePort,
+		})
+	}
+
+	service, err := k8sService.CreateExposeService(context.TODO(), d.k8sNameSpace, names.service+constants.K8S_EXPOSE_SERVICE_SUFFIX, names.labels, serviceType, servicePorts)
+	if err != nil {
+		return nil, fmt.Errorf("failed create expose service, error: %w", err)
+	}
+	logs.GetLogger().Infof("Created expose service successfully: %s", service.GetName())
+
+	host := publicIpAddress()
+	if serviceType == coreV1.ServiceTypeLoadBalancer {
+		if lbHost := d.waitLoadBalancer(service.GetName()); lbHost != "" {
+			host = lbHost
+		}
+	}
+
+	var endpoints []models.Endpoint
+	for i, expose := range exposePorts {
+		publicPort := service.Spec.Ports[i].NodePort
+		if serviceType == coreV1.ServiceTypeLoadBalancer {
+			publicPort = service.Spec.Ports[i].Port
+		}
+		endpoints = append(endpoints, models.Endpoint{
+			Service:       serviceName,
+			Protocol:      expose.Protocol,
+			ContainerPort: expose.Port,
+			PublicPort:    publicPort,
+			Address:       fmt.Sprintf("%s://%s:%d", expose.Protocol, host, publicPort),
+		})
+	}
+	return endpoints, nil
+}
+
+func (d *Deploy) waitLoadBalancer(serviceName string) string {
+	k8sService := NewK8sService()
+	deadline := time.Now().Add(loadBalancerWaitTimeout)
+	for time.Now().Before(deadline) {
+		service, err := k8sService.GetService(context.TODO(), d.k8sNameSpace, serviceName)
+		if err == nil {
+			for _, ingress := range service.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					return ingress.IP
+				}
+				if ingress.Hostname != "" {
+					return ingress.Hostname
+				}
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+	logs.GetLogger().Warnf("Load balancer of service %s has no address after %s", serviceName, loadBalancerWaitTimeout)
+	return ""
+}
+
+// allocateNodePort picks a port that no Service uses and reserves it in redis, so the providers
+// sharing the cluster never pick the same port.
+func allocateNodePort(conn redis.Conn, spaceUuid string, usedPorts map[int32]bool, preferred, start, end int32) (int32, error) {
+	if preferred >= start && preferred <= end && !usedPorts[preferred] {
+		reserved, err := reserveNodePort(conn, spaceUuid, preferred)
+		if err != nil {
+			return 0, err
+		}
+		usedPorts[preferred] = true
+		if reserved {
+			return preferred, nil
+		}
+	}
+	for port := start; port <= end; port++ {
+		if usedPorts[port] {
+			continue
+		}
+		reserved, err := reserveNodePort(conn, spaceUuid, port)
+		if err != nil {
+			return 0, err
+		}
+		usedPorts[port] = true
+		if reserved {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port left in range %d-%d", start, end)
+}
+
+func reserveNodePort(conn redis.Conn, spaceUuid string, port int32) (bool, error) {
+	reserveKey := fmt.Sprintf("%s%d", constants.REDIS_NODE_PORT_PREFIX, port)
+	reply, err := redis.String(conn.Do("SET", reserveKey, spaceUuid, "NX", "EX", nodePortReserveSeconds))
+	if err != nil && err != redis.ErrNil {
+		return false, err
+	}
+	if reply == "OK" {
+		return true, nil
+	}
+	owner, _ := redis.String(conn.Do("GET", reserveKey))
+	return owner == spaceUuid, nil
+}
+
+func previousPublicPort(endpoints []models.Endpoint, serviceName string, expose yaml.ExposePort) int32 {
+	for _, endpoint := range endpoints {
+		if endpoint.Service == serviceName && endpoint.Protocol == expose.Protocol && endpoint.ContainerPort == expose.Port {
+			return endpoint.PublicPort
+		}
+	}
+	return 0
+}
+
+func portRange() (int32, int32) {
+	start, end := conf.GetConfig().API.PortRangeStart, conf.GetConfig().API.PortRangeEnd
+	if start == 0 {
+		start = defaultPortRangeStart
+	}
+	if end == 0 {
+		end = defaultPortRangeEnd
+	}
+	return start, end
+}
+
+func exposeProtocol(protocol string) coreV1.Protocol {
+	if protocol == yaml.ExposeProtocolUdp {
+		return coreV1.ProtocolUDP
+	}
+	return coreV1.ProtocolTCP
+}
+
+// publicIpAddress takes the ip of the node from API.MultiAddress, e.g. "/ip4/<public_ip>/tcp/<port>".
+func publicIpAddress() string {
+	multiAddressSplit := strings.Split(conf.GetConfig().API.MultiAddress, "/")
+	if len(multiAddressSplit) > 2 {
+		return multiAddressSplit[2]
+	}
+	return ""
+}
+
+// httpPortHost is the hostname of an extra HTTP port: "<space label>-<port>.<domain>".
+func httpPortHost(hostName string, port int32) string {
+	label := strings.SplitN(hostName, ".", 2)[0]
+	return joinDomain(fmt.Sprintf("%s-%d", label, port))
+}
+
+func marshalEndpoints(endpoints []models.Endpoint) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(endpoints)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,209 @@
+package computing
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/gateway"
+	"github.com/lagrangedao/go-computing-provider/util"
+)
+
+// gatewayUsageRetention keeps the usage of ended spaces until it is billed.
+const gatewayUsageRetention = 30 * 24 * time.Hour
+
+type spaceApiKeyReq struct {
+	SpaceUuid string `json:"space_uuid"`
+}
+
+type spaceApiKeyResp struct {
+	SpaceUuid string `json:"space_uuid"`
+	Model     string `json:"model"`
+	ApiKey    string `json:"api_key"`
+}
+
+// redisGatewayStore keeps the model, the owner and the hash of the api key of a space in
+// GATEWAY:SPACE:<uuid>, the space of a key in GATEWAY:KEY:<hash> and the usage of a space and its
+// owner in GATEWAY:USAGE:<uuid>.
+type redisGatewayStore struct{}
+
+func NewGateway() *gateway.Gateway {
+	return gateway.New(redisGatewayStore{})
+}
+
+func (redisGatewayStore) RouteByKey(keyHash string) (*gateway.Route, error) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	spaceUuid, err := redis.String(conn.Do("GET", constants.REDIS_GATEWAY_KEY_PREFIX+keyHash))
+	if err == redis.ErrNil {
+		return nil, gateway.ErrUnknownKey
+	} else if err != nil {
+		return nil, err
+	}
+	values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_GATEWAY_SPACE_PREFIX+spaceUuid))
+	if err != nil {
+		return nil, err
+	}
+	if values["key_hash"] != keyHash || values["openai"] != "1" {
+		return nil, gateway.ErrUnknownKey
+	}
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid)
+	if err != nil {
+		return nil, gateway.ErrUnknownKey
+	}
+	return &gateway.Route{
+		SpaceUuid: spaceUuid,
+		Model:     values["model"],
+		Upstream:  jobMetadata.Url,
+	}, nil
+}
+
+func (redisGatewayStore) AddUsage(spaceUuid string, usage gateway.Usage) error {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	usageKey := constants.REDIS_GATEWAY_USAGE_PREFIX + spaceUuid
+	conn.Send("MULTI")
+	conn.Send("HINCRBY", usageKey, "requests", usage.Requests)
+	conn.Send("HINCRBY", usageKey, "prompt_tokens", usage.PromptTokens)
+	conn.Send("HINCRBY", usageKey, "completion_tokens", usage.CompletionTokens)
+	conn.Send("HINCRBY", usageKey, "total_tokens", usage.TotalTokens)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// registerGatewayRoute registers a model space, its api key survives redeploys. Only spaces whose
+// runtime serves the OpenAI api are reachable through the gateway, a space with scaleToZero is scaled
+// down when idle.
+func registerGatewayRoute(spaceUuid, wallet, modelId string, openAI, scaleToZero bool) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("HSET", constants.REDIS_GATEWAY_SPACE_PREFIX+spaceUuid, "model", modelId, "wallet_address", wallet,
+		"openai", openAI, "scale_to_zero", scaleToZero)
+	conn.Send("HSET", constants.REDIS_GATEWAY_USAGE_PREFIX+spaceUuid, "wallet_address", wallet)
+	if _, err := conn.Do("EXEC"); err != nil {
+		logs.GetLogger().Errorf("Failed register gateway route of space %s, error: %+v", spaceUuid, err)
+	}
+}
+
+// deleteGatewayRoute revokes the api key of an ended space, its usage is kept for billing.
+func deleteGatewayRoute(spaceUuid string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	spaceKey := constants.REDIS_GATEWAY_SPACE_PREFIX + spaceUuid
+	if keyHash, err := redis.String(conn.Do("HGET", spaceKey, "key_hash")); err == nil {
+		conn.Do("DEL", constants.REDIS_GATEWAY_KEY_PREFIX+keyHash)
+	}
+	conn.Do("DEL", spaceKey)
+	conn.Do("EXPIRE", constants.REDIS_GATEWAY_USAGE_PREFIX+spaceUuid, int64(gatewayUsageRetention.Seconds()))
+}
+
+// CreateSpaceApiKey issues a new api key of a model space for the gateway, the previous key stops working.
+// The request must be signed by the owner of the space. The key is only returned here, the provider
+// keeps its hash.
+func CreateSpaceApiKey(c *gin.Context) {
+	var req spaceApiKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	if strings.TrimSpace(req.SpaceUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.GatewayParamError, "missing required field: space_uuid"))
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	// the key is swapped in one transaction, concurrent calls can't leave two keys or none
+	spaceKey := constants.REDIS_GATEWAY_SPACE_PREFIX + req.SpaceUuid
+	if _, err := conn.Do("WATCH", spaceKey); err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.GatewayError, err.Error()))
+		return
+	}
+	defer conn.Do("UNWATCH")
+	values, err := redis.StringMap(conn.Do("HGETALL", spaceKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.GatewayError, err.Error()))
+		return
+	}
+	if values["model"] == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.GatewayParamError, "the space does not serve a model"))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, values["wallet_address"]); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.GatewayUnauthorized, err.Error()))
+		return
+	}
+	if values["openai"] != "1" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.GatewayParamError, "the runtime of the space does not serve the OpenAI api"))
+		return
+	}
+
+	apiKey, err := gateway.NewKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.GatewayError, err.Error()))
+		return
+	}
+	keyHash := gateway.HashKey(apiKey)
+	conn.Send("MULTI")
+	if oldHash := values["key_hash"]; oldHash != "" {
+		conn.Send("DEL", constants.REDIS_GATEWAY_KEY_PREFIX+oldHash)
+	}
+	conn.Send("SET", constants.REDIS_GATEWAY_KEY_PREFIX+keyHash, req.SpaceUuid)
+	conn.Send("HSET", spaceKey, "key_hash", keyHash)
+	if _, err = redis.Values(conn.Do("EXEC")); err == redis.ErrNil {
+		c.JSON(http.StatusConflict, util.CreateErrorResponse(util.GatewayError, "the api key was changed meanwhile, retry"))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.GatewayError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(spaceApiKeyResp{
+		SpaceUuid: req.SpaceUuid,
+		Model:     values["model"],
+		ApiKey:    apiKey,
+	}))
+}
+
+// GetSpaceUsage returns the requests and tokens a model space served through the gateway, to its owner.
+func GetSpaceUsage(c *gin.Context) {
+	spaceUuid := c.Query("space_uuid")
+	if strings.TrimSpace(spaceUuid) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.GatewayParamError, "missing required field: space_uuid"))
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_GATEWAY_USAGE_PREFIX+spaceUuid))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.GatewayError, err.Error()))
+		return
+	}
+	if status, err := authorizeSpaceOwner(c, values["wallet_address"]); err != nil {
+		c.JSON(status, util.CreateErrorResponse(util.GatewayUnauthorized, err.Error()))
+		return
+	}
+	counter := func(field string) int64 {
+		value, _ := strconv.ParseInt(values[field], 10, 64)
+		return value
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(gateway.Usage{
+		Requests:         counter("requests"),
+		PromptTokens:     counter("prompt_tokens"),
+		CompletionTokens: counter("completion_tokens"),
+		TotalTokens:      counter("total_tokens"),
+	}))
+}
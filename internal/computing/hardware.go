@@ -0,0 +1,69 @@
+package computing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/hardware"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+)
+
+const (
+	defaultExporterPort     = 8080
+	defaultExporterPath     = "/resources"
+	defaultHardwareCacheTTL = 30 * time.Second
+)
+
+var (
+	nodeHardware     *hardware.Cache
+	nodeHardwareOnce sync.Once
+)
+
+// hardwareCollector returns the collector of the Hardware section. It is cached, so the resource
+// report, the provider status and the node labels of a tick share one collection.
+func hardwareCollector() *hardware.Cache {
+	nodeHardwareOnce.Do(func() {
+		hardwareConf := conf.GetConfig().Hardware
+		client := NewK8sService().k8sClient
+
+		sources := hardwareConf.Collectors
+		if len(sources) == 0 {
+			sources = []string{"exporter", "dcgm", "labels"}
+		}
+		var collectors []hardware.HardwareCollector
+		for _, source := range sources {
+			switch source {
+			case "exporter":
+				port, path := hardwareConf.ExporterPort, hardwareConf.ExporterPath
+				if port == 0 {
+					port = defaultExporterPort
+				}
+				if path == "" {
+					path = defaultExporterPath
+				}
+				collectors = append(collectors, hardware.NewExporterCollector(client, port, path))
+			case "dcgm":
+				collectors = append(collectors, hardware.NewDcgmCollector(client, hardwareConf.DcgmNamespace))
+			case "labels":
+				collectors = append(collectors, hardware.NewLabelCollector(client))
+			default:
+				logs.GetLogger().Warnf("Unknown hardware collector %s is skipped", source)
+			}
+		}
+
+		ttl := defaultHardwareCacheTTL
+		if hardwareConf.CacheTTL > 0 {
+			ttl = time.Duration(hardwareConf.CacheTTL) * time.Second
+		}
+		nodeHardware = hardware.NewCache(hardware.FirstOf(collectors...), ttl)
+	})
+	return nodeHardware
+}
+
+// collectNodeGpus returns the GPUs of the nodes by node name.
+func collectNodeGpus(ctx context.Context) (map[string]models.Gpu, error) {
+	return hardwareCollector().Collect(ctx)
+}
@@ -0,0 +1,195 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	hostSlugNameLength = 20
+	hostSlugUuidLength = 6
+	hostReserveSeconds = 24 * 60 * 60
+	hostMaxAttempts    = 20
+)
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// AllocateHostName returns the hostname of a space. A space that already has a hostname keeps it,
+// otherwise a readable "<space-name>-<uuid prefix>" slug is derived and checked against the index
+// of the hostnames of deployed spaces and the hosts of the Ingresses of the spaces, which also have
+// the custom domains and the hosts of manifests and charts. The result is reserved in redis, so
+// parallel jobs never receive the same hostname.
+func AllocateHostName(spaceName, spaceUuid string) (string, error) {
+	spaceUuid = strings.ToLower(spaceUuid)
+	if spaceDetail, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid); err == nil && spaceDetail.Url != "" {
+		return strings.TrimPrefix(spaceDetail.Url, "https://"), nil
+	}
+
+	ingressHosts, err := spaceIngressHosts()
+	if err != nil {
+		return "", err
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	slug := HostSlug(spaceName, spaceUuid)
+	for i := 0; i < hostMaxAttempts; i++ {
+		label := slug
+		if i > 0 {
+			label = fmt.Sprintf("%s-%d", slug, i+1)
+		}
+		hostName := joinDomain(label)
+
+		owner, err := redis.String(conn.Do("HGET", constants.REDIS_HOSTS_KEY, hostName))
+		if err != nil && err != redis.ErrNil {
+			return "", err
+		}
+		if owner != "" && owner != spaceUuid {
+			continue
+		}
+		if owner, ok := ingressHosts[hostName]; ok && owner != spaceUuid {
+			continue
+		}
+
+		reserveKey := constants.REDIS_HOST_PREFIX + hostName
+		reply, err := redis.String(conn.Do("SET", reserveKey, spaceUuid, "NX", "EX", hostReserveSeconds))
+		if err != nil && err != redis.ErrNil {
+			return "", err
+		}
+		if reply == "OK" {
+			return hostName, nil
+		}
+		if owner, _ := redis.String(conn.Do("GET", reserveKey)); owner == spaceUuid {
+			return hostName, nil
+		}
+	}
+	return "", fmt.Errorf("failed allocate hostname for space %s after %d attempts", spaceUuid, hostMaxAttempts)
+}
+
+// spaceIngressHosts maps the hosts of the Ingresses labelled with a space, in every namespace, to the space.
+func spaceIngressHosts() (map[string]string, error) {
+	k8sService := NewK8sService()
+	ingresses, err := k8sService.k8sClient.NetworkingV1().Ingresses("").List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: "lad_app",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed list ingresses, error: %w", err)
+	}
+	hosts := make(map[string]string)
+	for _, ingress := range ingresses.Items {
+		owner := strings.ToLower(ingress.Labels["lad_app"])
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != "" {
+				hosts[rule.Host] = owner
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// HostSlug builds a DNS label from the space name and the first characters of its uuid.
+func HostSlug(spaceName, spaceUuid string) string {
+	name := slugInvalidChars.ReplaceAllString(strings.ToLower(spaceName), "-")
+	if len(name) > hostSlugNameLength {
+		name = name[:hostSlugNameLength]
+	}
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "space"
+	}
+
+	uuidPart := strings.ReplaceAll(strings.ToLower(spaceUuid), "-", "")
+	if len(uuidPart) > hostSlugUuidLength {
+		uuidPart = uuidPart[:hostSlugUuidLength]
+	}
+	if uuidPart == "" {
+		return name
+	}
+	return name + "-" + uuidPart
+}
+
+func joinDomain(label string) string {
+	domain := conf.GetConfig().API.Domain
+	if strings.HasPrefix(domain, ".") {
+		return label + domain
+	}
+	return label + "." + domain
+}
+
+// IndexHostNames fills the hostname index from the metadata of the running spaces, the spaces
+// deployed before the index existed. It runs once at start.
+func IndexHostNames() {
+	if redisPool == nil {
+		newRedisPool(conf.GetConfig().API.RedisUrl, conf.GetConfig().API.RedisPassword)
+	}
+	keys, err := scanKeys(constants.REDIS_FULL_PREFIX + "*")
+	if err != nil {
+		logs.GetLogger().Errorf("Failed index the hostnames of the spaces, error: %+v", err)
+		return
+	}
+	for _, key := range keys {
+		spaceDetail, err := RetrieveJobMetadata(key)
+		if err != nil {
+			logs.GetLogger().Warnf("Failed get job metadata, key: %s, error: %+v", key, err)
+			continue
+		}
+		indexHostNames(spaceDetail.SpaceUuid, spaceHosts(spaceDetail.Url, spaceDetail.Endpoints))
+	}
+}
+
+// spaceHosts returns the hostnames a space is served at, its own and those of its extra HTTP ports.
+func spaceHosts(url string, endpoints []models.Endpoint) []string {
+	var hosts []string
+	if host := strings.TrimPrefix(url, "https://"); host != "" {
+		hosts = append(hosts, host)
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Protocol == yaml.ExposeProtocolHttp {
+			hosts = append(hosts, strings.TrimPrefix(endpoint.Address, "https://"))
+		}
+	}
+	return hosts
+}
+
+// indexHostNames records the hostnames of a deployed space in the hash hostname → space.
+func indexHostNames(spaceUuid string, hosts []string) {
+	if len(hosts) == 0 {
+		return
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	args := redis.Args{}.Add(constants.REDIS_HOSTS_KEY)
+	for _, host := range hosts {
+		args = args.Add(host, strings.ToLower(spaceUuid))
+	}
+	if _, err := conn.Do("HSET", args...); err != nil {
+		logs.GetLogger().Errorf("Failed index the hostnames of space %s, error: %+v", spaceUuid, err)
+	}
+}
+
+// releaseHostNames removes the hostnames of an ended space from the index, those still owned by it.
+func releaseHostNames(spaceUuid string, hosts []string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	for _, host := range hosts {
+		if owner, _ := redis.String(conn.Do("HGET", constants.REDIS_HOSTS_KEY, host)); owner != strings.ToLower(spaceUuid) {
+			continue
+		}
+		if _, err := conn.Do("HDEL", constants.REDIS_HOSTS_KEY, host); err != nil {
+			logs.GetLogger().Errorf("Failed release hostname %s, error: %+v", host, err)
+		}
+	}
+}
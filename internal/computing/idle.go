@@ -0,0 +1,337 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	activatorAnnotation = "nginx.ingress.kubernetes.io/temporal-redirect"
+	// a 302 turns the held POST into a GET without its body, 307 keeps both
+	activatorCodeAnnotation     = "nginx.ingress.kubernetes.io/temporal-redirect-code"
+	activationReservationPrefix = "activate:"
+	activatorPath               = "/api/v1/computing/lagrange/spaces/activate/"
+	defaultIdleMinutes          = 30
+	defaultActivationTimeout    = 300
+	// ingressReloadDelay lets the ingress controller drop the redirect before the request comes back.
+	ingressReloadDelay = 3 * time.Second
+)
+
+// activations holds the scale up in progress of each space, the requests arriving meanwhile wait for it.
+var activations sync.Map
+
+type activation struct {
+	done chan struct{}
+	err  error
+}
+
+// IdleScaler scales the model spaces that opted in and have no ingress requests for IdleMinutes down to
+// zero. The ingress of a scaled down space redirects to the activator, which scales it up again. The
+// hardware of a scaled down space is free for other jobs, the activator reserves it again and gives up
+// when it is taken. The expire time of the job is not touched, the space still ends when its order does.
+type IdleScaler struct {
+	idleMinutes   int
+	prometheusUrl string
+	activatorUrl  string
+	client        *http.Client
+}
+
+func NewIdleScaler() (*IdleScaler, error) {
+	idleConf := conf.GetConfig().ScaleToZero
+	if idleConf.PrometheusUrl == "" || idleConf.ActivatorUrl == "" {
+		return nil, errors.New("ScaleToZero needs PrometheusUrl and ActivatorUrl")
+	}
+	idleMinutes := idleConf.IdleMinutes
+	if idleMinutes <= 0 {
+		idleMinutes = defaultIdleMinutes
+	}
+	return &IdleScaler{
+		idleMinutes:   idleMinutes,
+		prometheusUrl: strings.TrimRight(idleConf.PrometheusUrl, "/"),
+		activatorUrl:  strings.TrimRight(idleConf.ActivatorUrl, "/"),
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *IdleScaler) Run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.scaleIdleSpaces()
+	}
+}
+
+func (s *IdleScaler) scaleIdleSpaces() {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("catch panic error: %+v", err)
+		}
+	}()
+
+	keys, err := scanKeys(constants.REDIS_GATEWAY_SPACE_PREFIX + "*")
+	if err != nil {
+		logs.GetLogger().Errorf("Failed get model spaces, error: %+v", err)
+		return
+	}
+
+	for _, key := range keys {
+		spaceUuid := strings.TrimPrefix(key, constants.REDIS_GATEWAY_SPACE_PREFIX)
+		if !scaleToZeroEnabled(spaceUuid) || scaledDown(spaceUuid) {
+			continue
+		}
+		if _, ok := activations.Load(spaceUuid); ok {
+			continue
+		}
+		if err := s.scaleDownIfIdle(spaceUuid); err != nil {
+			logs.GetLogger().Errorf("Failed scale down idle space %s, error: %+v", spaceUuid, err)
+		}
+	}
+}
+
+func (s *IdleScaler) scaleDownIfIdle(spaceUuid string) error {
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid)
+	if err != nil {
+		return nil
+	}
+	namespace := constants.K8S_NAMESPACE_NAME_PREFIX + strings.ToLower(jobMetadata.WalletAddress)
+	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceUuid
+	ingressName := constants.K8S_INGRESS_NAME_PREFIX + spaceUuid
+
+	k8sService := NewK8sService()
+	deployment, err := k8sService.k8sClient.AppsV1().Deployments(namespace).Get(context.TODO(), deployName, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	// a space is only idle once it served, or could have served, for the whole window
+	readySince := deployment.CreationTimestamp.Time
+	if activatedAt, err := lastActivation(spaceUuid); err == nil && activatedAt.After(readySince) {
+		readySince = activatedAt
+	}
+	if time.Since(readySince) < time.Duration(s.idleMinutes)*time.Minute || deployment.Status.ReadyReplicas == 0 {
+		return nil
+	}
+
+	requests, err := s.ingressRequests(namespace, ingressName)
+	if err != nil {
+		return err
+	}
+	if requests >= 1 {
+		return nil
+	}
+
+	if time.Until(time.Unix(jobMetadata.ExpireTime, 0)) <= 0 {
+		return nil
+	}
+
+	// the ingress redirects before the pods go, no request hits an empty service
+	redirect := s.activatorUrl + activatorPath + spaceUuid + "$request_uri"
+	if err = k8sService.SetIngressAnnotations(context.TODO(), namespace, ingressName, activatorAnnotations(redirect)); err != nil {
+		return err
+	}
+	replicas, err := k8sService.ScaleDeployment(context.TODO(), namespace, deployName, 0)
+	if err != nil {
+		k8sService.SetIngressAnnotations(context.TODO(), namespace, ingressName, activatorAnnotations(""))
+		return err
+	}
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	if _, err = conn.Do("HSET", constants.REDIS_IDLE_PREFIX+spaceUuid, "namespace", namespace, "replicas", replicas, "scaled_at", time.Now().Unix()); err != nil {
+		return err
+	}
+	logs.GetLogger().Infof("Space %s has been idle for %d minutes, scaled down to zero", spaceUuid, s.idleMinutes)
+	return nil
+}
+
+// ingressRequests returns the requests of an ingress during the idle window.
+func (s *IdleScaler) ingressRequests(namespace, ingressName string) (float64, error) {
+	query := fmt.Sprintf(`sum(increase(nginx_ingress_controller_requests{exported_namespace=%q,ingress=%q}[%dm])) or sum(increase(nginx_ingress_controller_requests{namespace=%q,ingress=%q}[%dm])) or vector(0)`,
+		namespace, ingressName, s.idleMinutes, namespace, ingressName, s.idleMinutes)
+	resp, err := s.client.Get(s.prometheusUrl + "/api/v1/query?query=" + url.QueryEscape(query))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed decode prometheus response, error: %w", err)
+	}
+	if result.Status != "success" {
+		return 0, fmt.Errorf("prometheus query failed: %s", result.Error)
+	}
+	if len(result.Data.Result) == 0 || len(result.Data.Result[0].Value) != 2 {
+		return 0, nil
+	}
+	value, _ := result.Data.Result[0].Value[1].(string)
+	return strconv.ParseFloat(value, 64)
+}
+
+// activatorAnnotations redirect the ingress to the activator, or remove the redirect when it is empty.
+func activatorAnnotations(redirect string) map[string]string {
+	code := "307"
+	if redirect == "" {
+		code = ""
+	}
+	return map[string]string{activatorAnnotation: redirect, activatorCodeAnnotation: code}
+}
+
+// scaleToZeroEnabled tells whether a model space opted in to be scaled down when idle.
+func scaleToZeroEnabled(spaceUuid string) bool {
+	conn := redisPool.Get()
+	defer conn.Close()
+	enabled, err := redis.Bool(conn.Do("HGET", constants.REDIS_GATEWAY_SPACE_PREFIX+spaceUuid, "scale_to_zero"))
+	return err == nil && enabled
+}
+
+func scaledDown(spaceUuid string) bool {
+	conn := redisPool.Get()
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", constants.REDIS_IDLE_PREFIX+spaceUuid))
+	return err == nil && exists
+}
+
+func lastActivation(spaceUuid string) (time.Time, error) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	activatedAt, err := redis.Int64(conn.Do("HGET", constants.REDIS_GATEWAY_SPACE_PREFIX+spaceUuid, "activated_at"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(activatedAt, 0), nil
+}
+
+// deleteIdleState forgets that a space was scaled down, its deployment and ingress are gone or new.
+func deleteIdleState(spaceUuid string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+	conn.Do("DEL", constants.REDIS_IDLE_PREFIX+spaceUuid)
+	releaseJob(activationReservationPrefix + spaceUuid)
+}
+
+// activateSpace scales a space back up and waits until it serves again. Concurrent calls of the same
+// space wait for the first one.
+func activateSpace(spaceUuid string) error {
+	pending := &activation{done: make(chan struct{})}
+	if existing, loaded := activations.LoadOrStore(spaceUuid, pending); loaded {
+		<-existing.(*activation).done
+		return existing.(*activation).err
+	}
+	defer func() {
+		close(pending.done)
+		activations.Delete(spaceUuid)
+	}()
+	pending.err = scaleUp(spaceUuid)
+	return pending.err
+}
+
+func scaleUp(spaceUuid string) error {
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid)
+	if err != nil {
+		return err
+	}
+
+	conn := redisPool.Get()
+	values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_IDLE_PREFIX+spaceUuid))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	namespace := values["namespace"]
+	replicas, _ := strconv.Atoi(values["replicas"])
+	if replicas <= 0 {
+		replicas = 1
+	}
+	deployName := constants.K8S_DEPLOY_NAME_PREFIX + spaceUuid
+	ingressName := constants.K8S_INGRESS_NAME_PREFIX + spaceUuid
+
+	timeout := conf.GetConfig().ScaleToZero.ActivationTimeout
+	if timeout <= 0 {
+		timeout = defaultActivationTimeout
+	}
+
+	// the hardware was free while the space was scaled down, another job may have taken it
+	_, hardware := getHardwareDetail(jobMetadata.Hardware)
+	reservation := activationReservationPrefix + spaceUuid
+	if err = reserveJob(reservation, namespace, "lad_app="+spaceUuid, hardware); err != nil {
+		return err
+	}
+	defer releaseJob(reservation)
+
+	logs.GetLogger().Infof("Space %s received a request, scaling up to %d replicas", spaceUuid, replicas)
+	k8sService := NewK8sService()
+	if _, err = k8sService.ScaleDeployment(context.TODO(), namespace, deployName, int32(replicas)); err != nil {
+		return err
+	}
+	if err = k8sService.WaitDeploymentReady(context.TODO(), namespace, deployName, time.Duration(timeout)*time.Second); err != nil {
+		return fmt.Errorf("space %s is not ready after %d seconds, error: %w", spaceUuid, timeout, err)
+	}
+	if err = k8sService.SetIngressAnnotations(context.TODO(), namespace, ingressName, activatorAnnotations("")); err != nil {
+		return err
+	}
+
+	conn = redisPool.Get()
+	defer conn.Close()
+	conn.Do("HSET", constants.REDIS_GATEWAY_SPACE_PREFIX+spaceUuid, "activated_at", time.Now().Unix())
+	conn.Do("DEL", constants.REDIS_IDLE_PREFIX+spaceUuid)
+	time.Sleep(ingressReloadDelay)
+	return nil
+}
+
+// ActivateSpace holds a request to a scaled down space until the space is back, then sends the client
+// to the space again with the same method and body.
+func ActivateSpace(c *gin.Context) {
+	spaceUuid := c.Param("space_uuid")
+	jobMetadata, err := RetrieveJobMetadata(constants.REDIS_FULL_PREFIX + spaceUuid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "space not found"})
+		return
+	}
+
+	if err = activateSpace(spaceUuid); errors.Is(err, ErrInsufficientResources) {
+		logs.GetLogger().Warnf("Space %s can't be activated, its hardware is taken: %v", spaceUuid, err)
+		c.Header("Retry-After", "300")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the hardware of the space is in use, retry later"})
+		return
+	} else if err != nil {
+		logs.GetLogger().Errorf("Failed activate space %s, error: %+v", spaceUuid, err)
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the space is starting, retry later"})
+		return
+	}
+
+	target := strings.TrimRight(jobMetadata.Url, "/") + c.Param("path")
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+	c.Redirect(http.StatusTemporaryRedirect, target)
+}
@@ -0,0 +1,125 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/huggingface"
+	"github.com/lagrangedao/go-computing-provider/internal/inference"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+)
+
+const inferenceImageTag = "v1.0"
+
+// frameworkImages maps the library of a Hugging Face model to the directory of its image in the
+// docker_images of api-inference-community.
+var frameworkImages = map[string]string{
+	"adapter-transformers":  "adapter_transformers",
+	"allennlp":              "allennlp",
+	"asteroid":              "asteroid",
+	"diffusers":             "diffusers",
+	"espnet":                "espnet",
+	"fairseq":               "fairseq",
+	"fastai":                "fastai",
+	"fasttext":              "fasttext",
+	"flair":                 "flair",
+	"k2":                    "k2",
+	"mindspore":             "mindspore",
+	"nemo":                  "nemo",
+	"open_clip":             "open_clip",
+	"paddlenlp":             "paddlenlp",
+	"peft":                  "peft",
+	"pyannote-audio":        "pyannote_audio",
+	"sentence-transformers": "sentence_transformers",
+	"setfit":                "setfit",
+	"sklearn":               "sklearn",
+	"spacy":                 "spacy",
+	"span-marker":           "span_marker",
+	"speechbrain":           "speechbrain",
+	"stanza":                "stanza",
+	"timm":                  "timm",
+	"transformers":          "transformers",
+}
+
+func resolveModel(modelId string) (*huggingface.ModelInfo, error) {
+	inferenceConf := conf.GetConfig().Inference
+	return huggingface.NewClient(inferenceConf.HubEndpoint, inferenceConf.HubToken).ModelInfo(context.TODO(), modelId)
+}
+
+// inferenceImage returns the image of the runtime of a model, building it when the catalog has
+// no image for it, and whether it serves the OpenAI api. The built framework images don't.
+func (d *Deploy) inferenceImage(model *huggingface.ModelInfo) (string, bool, error) {
+	accelerator := inference.Accelerator(d.hardwareResource.Gpu.Quantity, d.hardwareResource.Gpu.Unit)
+	if runtime, ok := RuntimeCatalog().Lookup(model.Framework, model.Task, accelerator); ok && runtime.Image != "" {
+		logs.GetLogger().Infof("Space %s runs %s with runtime %s", d.spaceUuid, model.ModelId, runtime.Image)
+		return runtime.Image, runtime.OpenAI, nil
+	}
+	imageName, err := d.buildInferenceImage(model.Framework)
+	return imageName, false, err
+}
+
+// frameworkImage returns the name and the build context of the image of framework.
+func frameworkImage(framework string) (string, string, error) {
+	dir, ok := frameworkImages[framework]
+	if !ok {
+		return "", "", fmt.Errorf("framework %s is not supported", framework)
+	}
+
+	imageName := fmt.Sprintf("lagrange/%s:%s", dir, inferenceImageTag)
+	if registry := strings.TrimSpace(conf.GetConfig().Registry.ServerAddress); registry != "" {
+		imageName = fmt.Sprintf("%s/%s:%s", registry, dir, inferenceImageTag)
+	}
+	cpPath, _ := os.LookupEnv("CP_PATH")
+	return imageName, filepath.Join(cpPath, "inference-model", "docker_images", dir), nil
+}
+
+// builtImages holds the framework images built by this provider, they are not built again.
+var builtImages sync.Map
+
+// buildFrameworkImage builds the image of framework and pushes it when a registry is configured.
+func buildFrameworkImage(framework, logPath string, onPush func()) (string, error) {
+	imageName, buildPath, err := frameworkImage(framework)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(filepath.Join(buildPath, "Dockerfile")); err != nil {
+		return "", fmt.Errorf("no image for framework %s at %s, run install.sh, error: %w", framework, buildPath, err)
+	}
+
+	dockerService := NewDockerService()
+	if err = dockerService.BuildImage(buildPath, imageName, WithBuildLog(logPath)); err != nil {
+		return "", err
+	}
+	if conf.GetConfig().Registry.ServerAddress != "" {
+		if onPush != nil {
+			onPush()
+		}
+		if err = dockerService.PushImage(imageName); err != nil {
+			return "", fmt.Errorf("failed push image %s, error: %w", imageName, err)
+		}
+	}
+	builtImages.Store(imageName, true)
+	return imageName, nil
+}
+
+// buildInferenceImage builds the image of framework for a space, the build output goes to the
+// build.log of the space.
+func (d *Deploy) buildInferenceImage(framework string) (string, error) {
+	logPath := filepath.Join(d.SpacePath, BuildFileName)
+	if imageName, _, err := frameworkImage(framework); err == nil {
+		if _, ok := builtImages.Load(imageName); ok {
+			return imageName, os.WriteFile(logPath, []byte(fmt.Sprintf("Using the prebuilt image %s\n", imageName)), 0644)
+		}
+	}
+
+	updateJobStatus(d.jobUuid, models.JobBuildImage)
+	return buildFrameworkImage(framework, logPath, func() {
+		updateJobStatus(d.jobUuid, models.JobPushImage)
+	})
+}
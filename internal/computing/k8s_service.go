@@ -3,17 +3,18 @@ package computing
 import (
 	"context"
 	"encoding/json"
-	"errors"
-	"flag"
 	"fmt"
 	"github.com/lagrangedao/go-computing-provider/constants"
 	"github.com/lagrangedao/go-computing-provider/internal/models"
 	"io"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/util/retry"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -25,7 +26,9 @@ import (
 
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -37,6 +40,9 @@ var k8sOnce sync.Once
 var config *rest.Config
 var version string
 
+// k8sErr is why the clientset could not be created, NewK8sService then has no client.
+var k8sErr error
+
 type K8sService struct {
 	k8sClient *kubernetes.Clientset
 	Version   string
@@ -48,22 +54,22 @@ func NewK8sService() *K8sService {
 	k8sOnce.Do(func() {
 		config, err = rest.InClusterConfig()
 		if err != nil {
-			var kubeConfig *string
-			if home := homedir.HomeDir(); home != "" {
-				kubeConfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-			} else {
-				kubeConfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+			// the command line belongs to the cli, a kubeconfig of its own is given by KUBECONFIG
+			kubeConfig := os.Getenv("KUBECONFIG")
+			if home := homedir.HomeDir(); kubeConfig == "" && home != "" {
+				kubeConfig = filepath.Join(home, ".kube", "config")
 			}
-			flag.Parse()
-			config, err = clientcmd.BuildConfigFromFlags("", *kubeConfig)
+			config, err = clientcmd.BuildConfigFromFlags("", kubeConfig)
 			if err != nil {
 				logs.GetLogger().Errorf("Failed create k8s config, error: %v", err)
+				k8sErr = err
 				return
 			}
 		}
 		clientSet, err = kubernetes.NewForConfig(config)
 		if err != nil {
 			logs.GetLogger().Errorf("Failed create k8s clientset, error: %v", err)
+			k8sErr = err
 			return
 		}
 
@@ -135,40 +141,293 @@ func (s *K8sService) GetServiceByName(ctx context.Context, namespace, serviceNam
 	return s.k8sClient.CoreV1().Services(namespace).Get(ctx, serviceName, opts)
 }
 
-func (s *K8sService) CreateService(ctx context.Context, nameSpace, spaceUuid string, containerPort int32) (result *coreV1.Service, err error) {
+func (s *K8sService) CreateService(ctx context.Context, nameSpace, serviceName string, selector map[string]string, ports []coreV1.ServicePort) (result *coreV1.Service, err error) {
 	service := &coreV1.Service{
 		TypeMeta: metaV1.TypeMeta{
 			Kind:       "Service",
 			APIVersion: "v1",
 		},
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      constants.K8S_SERVICE_NAME_PREFIX + spaceUuid,
+			Name:      serviceName,
 			Namespace: nameSpace,
+			Labels:    selector,
 		},
 		Spec: coreV1.ServiceSpec{
-			Ports: []coreV1.ServicePort{
-				{
-					Name: "http",
-					Port: containerPort,
-				},
+			Ports:    ports,
+			Selector: selector,
+		},
+	}
+	return s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+}
+
+// CreateExposeService creates the NodePort or LoadBalancer service that publishes the TCP/UDP ports of a space.
+func (s *K8sService) CreateExposeService(ctx context.Context, nameSpace, serviceName string, selector map[string]string, serviceType coreV1.ServiceType, ports []coreV1.ServicePort) (*coreV1.Service, error) {
+	service := &coreV1.Service{
+		TypeMeta: metaV1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      serviceName,
+			Namespace: nameSpace,
+			Labels:    selector,
+		},
+		Spec: coreV1.ServiceSpec{
+			Type:     serviceType,
+			Ports:    ports,
+			Selector: selector,
+		},
+	}
+	return s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+}
+
+// EnsurePersistentVolumeClaim creates the claim unless it exists already, so the data survives redeploys.
+func (s *K8sService) EnsurePersistentVolumeClaim(ctx context.Context, namespace, name, size string, labels map[string]string) error {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid volume size %q, error: %w", size, err)
+	}
+	claim := &coreV1.PersistentVolumeClaim{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes: []coreV1.PersistentVolumeAccessMode{coreV1.ReadWriteOnce},
+			Resources: coreV1.ResourceRequirements{
+				Requests: coreV1.ResourceList{coreV1.ResourceStorage: quantity},
 			},
-			Selector: map[string]string{
-				"lad_app": spaceUuid,
+		},
+	}
+	_, err = s.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, claim, metaV1.CreateOptions{})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// EnsureSharedVolumeClaim creates a ReadWriteMany claim used by the pods of several spaces, if missing.
+func (s *K8sService) EnsureSharedVolumeClaim(ctx context.Context, namespace, name, size, storageClass string) error {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid volume size %q, error: %w", size, err)
+	}
+	claim := &coreV1.PersistentVolumeClaim{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes: []coreV1.PersistentVolumeAccessMode{coreV1.ReadWriteMany},
+			Resources: coreV1.ResourceRequirements{
+				Requests: coreV1.ResourceList{coreV1.ResourceStorage: quantity},
 			},
 		},
 	}
-	return s.k8sClient.CoreV1().Services(nameSpace).Create(ctx, service, metaV1.CreateOptions{})
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	_, err = s.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, claim, metaV1.CreateOptions{})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// EnsureNamespace creates a namespace of the provider itself, if missing.
+func (s *K8sService) EnsureNamespace(ctx context.Context, namespace string) error {
+	_, err := s.k8sClient.CoreV1().Namespaces().Create(ctx, &coreV1.Namespace{
+		ObjectMeta: metaV1.ObjectMeta{Name: namespace},
+	}, metaV1.CreateOptions{})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// ApplyDaemonSet creates the DaemonSet or replaces the spec of the existing one.
+func (s *K8sService) ApplyDaemonSet(ctx context.Context, daemonSet *appV1.DaemonSet) error {
+	daemonSets := s.k8sClient.AppsV1().DaemonSets(daemonSet.Namespace)
+	existing, err := daemonSets.Get(ctx, daemonSet.Name, metaV1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		_, err = daemonSets.Create(ctx, daemonSet, metaV1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	existing.Labels = daemonSet.Labels
+	existing.Spec.Template = daemonSet.Spec.Template
+	_, err = daemonSets.Update(ctx, existing, metaV1.UpdateOptions{})
+	return err
+}
+
+func (s *K8sService) ListNodes(ctx context.Context) ([]coreV1.Node, error) {
+	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// DeletePersistentVolumeClaims deletes the volumes of a space, only when the space ends.
+func (s *K8sService) DeletePersistentVolumeClaims(ctx context.Context, namespace, spaceUuid string) error {
+	return s.k8sClient.CoreV1().PersistentVolumeClaims(namespace).DeleteCollection(ctx, *metaV1.NewDeleteOptions(0), metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+}
+
+// ApplyObject creates or updates obj in namespace by server-side apply.
+func (s *K8sService) ApplyObject(ctx context.Context, namespace string, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	dynamicClient, err := dynamic.NewForConfig(s.config)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = dynamicClient.Resource(gvr).Namespace(namespace).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metaV1.PatchOptions{
+		FieldManager: "computing-provider",
+		Force:        &force,
+	})
+	return err
+}
+
+// DeleteLabelledObjects removes the objects of resource gvr labelled with the space uuid.
+func (s *K8sService) DeleteLabelledObjects(ctx context.Context, namespace string, gvr schema.GroupVersionResource, spaceUuid string) error {
+	dynamicClient, err := dynamic.NewForConfig(s.config)
+	if err != nil {
+		return err
+	}
+	propagation := metaV1.DeletePropagationBackground
+	return dynamicClient.Resource(gvr).Namespace(namespace).DeleteCollection(ctx, metaV1.DeleteOptions{PropagationPolicy: &propagation}, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+}
+
+// ListServiceNames returns the names of the services labelled with the space uuid.
+func (s *K8sService) ListServiceNames(ctx context.Context, namespace, spaceUuid string) ([]string, error) {
+	services, err := s.k8sClient.CoreV1().Services(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, service := range services.Items {
+		names = append(names, service.Name)
+	}
+	return names, nil
+}
+
+// ListDeploymentNames returns the names of the deployments labelled with the space uuid.
+func (s *K8sService) ListDeploymentNames(ctx context.Context, namespace, spaceUuid string) ([]string, error) {
+	deployments, err := s.k8sClient.AppsV1().Deployments(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("lad_app=%s", spaceUuid),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, deployment := range deployments.Items {
+		names = append(names, deployment.Name)
+	}
+	return names, nil
+}
+
+// WaitDeploymentReady waits until at least one pod of the deployment is ready.
+func (s *K8sService) WaitDeploymentReady(ctx context.Context, namespace, deploymentName string, timeout time.Duration) error {
+	return wait.PollImmediate(5*time.Second, timeout, func() (bool, error) {
+		deployment, err := s.k8sClient.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metaV1.GetOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return deployment.Status.ReadyReplicas > 0, nil
+	})
+}
+
+// ScaleDeployment sets the replicas of a deployment and returns the previous count.
+func (s *K8sService) ScaleDeployment(ctx context.Context, namespace, deploymentName string, replicas int32) (int32, error) {
+	scale, err := s.k8sClient.AppsV1().Deployments(namespace).GetScale(ctx, deploymentName, metaV1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	previous := scale.Spec.Replicas
+	scale.Spec.Replicas = replicas
+	_, err = s.k8sClient.AppsV1().Deployments(namespace).UpdateScale(ctx, deploymentName, scale, metaV1.UpdateOptions{})
+	return previous, err
+}
+
+// HasScaledDownDeployments reports whether a namespace keeps deployments with zero replicas.
+func (s *K8sService) HasScaledDownDeployments(ctx context.Context, namespace string) (bool, error) {
+	deployments, err := s.k8sClient.AppsV1().Deployments(namespace).List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, deployment := range deployments.Items {
+		if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetIngressAnnotations sets annotations of an ingress, an empty value removes the annotation.
+func (s *K8sService) SetIngressAnnotations(ctx context.Context, namespace, ingressName string, annotations map[string]string) error {
+	ingress, err := s.k8sClient.NetworkingV1().Ingresses(namespace).Get(ctx, ingressName, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for key, value := range annotations {
+		if value == "" {
+			delete(ingress.Annotations, key)
+			continue
+		}
+		if ingress.Annotations == nil {
+			ingress.Annotations = make(map[string]string)
+		}
+		ingress.Annotations[key] = value
+	}
+	_, err = s.k8sClient.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metaV1.UpdateOptions{})
+	return err
+}
+
+func (s *K8sService) GetService(ctx context.Context, namespace, serviceName string) (*coreV1.Service, error) {
+	return s.k8sClient.CoreV1().Services(namespace).Get(ctx, serviceName, metaV1.GetOptions{})
+}
+
+// ListNodePorts returns the node ports used by any service of the cluster.
+func (s *K8sService) ListNodePorts(ctx context.Context) (map[int32]bool, error) {
+	services, err := s.k8sClient.CoreV1().Services("").List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int32]bool)
+	for _, service := range services.Items {
+		for _, port := range service.Spec.Ports {
+			if port.NodePort != 0 {
+				used[port.NodePort] = true
+			}
+		}
+	}
+	return used, nil
 }
 
 func (s *K8sService) DeleteService(ctx context.Context, namespace, serviceName string) error {
 	return s.k8sClient.CoreV1().Services(namespace).Delete(ctx, serviceName, metaV1.DeleteOptions{})
 }
 
-func (s *K8sService) CreateIngress(ctx context.Context, k8sNameSpace, spaceUuid, hostName string, port int32) (*networkingv1.Ingress, error) {
+func (s *K8sService) CreateIngress(ctx context.Context, k8sNameSpace, spaceUuid, hostName, serviceName string, port int32) (*networkingv1.Ingress, error) {
 	var ingressClassName = "nginx"
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: constants.K8S_INGRESS_NAME_PREFIX + spaceUuid,
+			Name:   constants.K8S_INGRESS_NAME_PREFIX + spaceUuid,
+			Labels: map[string]string{"lad_app": spaceUuid},
 			Annotations: map[string]string{
 				"nginx.ingress.kubernetes.io/use-regex": "true",
 			},
@@ -186,7 +445,7 @@ func (s *K8sService) CreateIngress(ctx context.Context, k8sNameSpace, spaceUuid,
 									PathType: func() *networkingv1.PathType { t := networkingv1.PathTypePrefix; return &t }(),
 									Backend: networkingv1.IngressBackend{
 										Service: &networkingv1.IngressServiceBackend{
-											Name: constants.K8S_SERVICE_NAME_PREFIX + spaceUuid,
+											Name: serviceName,
 											Port: networkingv1.ServiceBackendPort{
 												Number: port,
 											},
@@ -204,10 +463,128 @@ func (s *K8sService) CreateIngress(ctx context.Context, k8sNameSpace, spaceUuid,
 	return s.k8sClient.NetworkingV1().Ingresses(k8sNameSpace).Create(ctx, ingress, metaV1.CreateOptions{})
 }
 
+// AddIngressRule routes host to the given port of serviceName. It is a no-op when the host already has a rule.
+func (s *K8sService) AddIngressRule(ctx context.Context, nameSpace, ingressName, host, serviceName string, port int32) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ingress, err := s.k8sClient.NetworkingV1().Ingresses(nameSpace).Get(ctx, ingressName, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == host {
+				return nil
+			}
+		}
+
+		pathType := networkingv1.PathTypePrefix
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path:     "/*",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: serviceName,
+									Port: networkingv1.ServiceBackendPort{
+										Number: port,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		_, err = s.k8sClient.NetworkingV1().Ingresses(nameSpace).Update(ctx, ingress, metaV1.UpdateOptions{})
+		return err
+	})
+}
+
 func (s *K8sService) DeleteIngress(ctx context.Context, nameSpace, ingressName string) error {
 	return s.k8sClient.NetworkingV1().Ingresses(nameSpace).Delete(ctx, ingressName, metaV1.DeleteOptions{})
 }
 
+// AddIngressHost adds a rule for host with the same backend as the space's own host,
+// and a TLS entry when tlsSecretName is given. It is a no-op for parts that already exist.
+func (s *K8sService) AddIngressHost(ctx context.Context, nameSpace, ingressName, host, tlsSecretName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ingress, err := s.k8sClient.NetworkingV1().Ingresses(nameSpace).Get(ctx, ingressName, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(ingress.Spec.Rules) == 0 {
+			return fmt.Errorf("ingress %s has no rules", ingressName)
+		}
+
+		var changed bool
+		var hasRule bool
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == host {
+				hasRule = true
+				break
+			}
+		}
+		if !hasRule {
+			newRule := *ingress.Spec.Rules[0].DeepCopy()
+			newRule.Host = host
+			ingress.Spec.Rules = append(ingress.Spec.Rules, newRule)
+			changed = true
+		}
+
+		if tlsSecretName != "" {
+			var hasTls bool
+			for _, tls := range ingress.Spec.TLS {
+				if tls.SecretName == tlsSecretName {
+					hasTls = true
+					break
+				}
+			}
+			if !hasTls {
+				ingress.Spec.TLS = append(ingress.Spec.TLS, networkingv1.IngressTLS{
+					Hosts:      []string{host},
+					SecretName: tlsSecretName,
+				})
+				changed = true
+			}
+		}
+
+		if !changed {
+			return nil
+		}
+		_, err = s.k8sClient.NetworkingV1().Ingresses(nameSpace).Update(ctx, ingress, metaV1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *K8sService) RemoveIngressHost(ctx context.Context, nameSpace, ingressName, host string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ingress, err := s.k8sClient.NetworkingV1().Ingresses(nameSpace).Get(ctx, ingressName, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		var rules []networkingv1.IngressRule
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != host {
+				rules = append(rules, rule)
+			}
+		}
+		var tlsList []networkingv1.IngressTLS
+		for _, tls := range ingress.Spec.TLS {
+			if len(tls.Hosts) != 1 || tls.Hosts[0] != host {
+				tlsList = append(tlsList, tls)
+			}
+		}
+		ingress.Spec.Rules = rules
+		ingress.Spec.TLS = tlsList
+		_, err = s.k8sClient.NetworkingV1().Ingresses(nameSpace).Update(ctx, ingress, metaV1.UpdateOptions{})
+		return err
+	})
+}
+
 func (s *K8sService) CreateConfigMap(ctx context.Context, k8sNameSpace, spaceUuid, basePath, configName string) (*coreV1.ConfigMap, error) {
 	configFilePath := filepath.Join(basePath, configName)
 
@@ -229,6 +606,26 @@ func (s *K8sService) CreateConfigMap(ctx context.Context, k8sNameSpace, spaceUui
 	return s.k8sClient.CoreV1().ConfigMaps(k8sNameSpace).Create(ctx, configMap, metaV1.CreateOptions{})
 }
 
+func (s *K8sService) GetSecret(ctx context.Context, namespace, name string) (*coreV1.Secret, error) {
+	return s.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metaV1.GetOptions{})
+}
+
+func (s *K8sService) CreateOrUpdateSecret(ctx context.Context, namespace string, secret *coreV1.Secret) (*coreV1.Secret, error) {
+	oldSecret, err := s.k8sClient.CoreV1().Secrets(namespace).Get(ctx, secret.Name, metaV1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return s.k8sClient.CoreV1().Secrets(namespace).Create(ctx, secret, metaV1.CreateOptions{})
+		}
+		return nil, err
+	}
+	secret.ResourceVersion = oldSecret.ResourceVersion
+	return s.k8sClient.CoreV1().Secrets(namespace).Update(ctx, secret, metaV1.UpdateOptions{})
+}
+
+func (s *K8sService) DeleteSecret(ctx context.Context, namespace, name string) error {
+	return s.k8sClient.CoreV1().Secrets(namespace).Delete(ctx, name, metaV1.DeleteOptions{})
+}
+
 func (s *K8sService) GetPods(namespace, spaceUuid string) (bool, error) {
 	listOption := metaV1.ListOptions{}
 	if spaceUuid != "" {
@@ -315,117 +712,11 @@ func (s *K8sService) ListNamespace(ctx context.Context) ([]string, error) {
 }
 
 func (s *K8sService) StatisticalSources(ctx context.Context) ([]*models.NodeResource, error) {
-	activePods, err := allActivePods(s.k8sClient)
-	if err != nil {
-		return nil, err
-	}
-	var nodeList []*models.NodeResource
-
-	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	cluster, err := clusterCapacity(ctx)
 	if err != nil {
-		logs.GetLogger().Error(err)
 		return nil, err
 	}
-
-	nodeGpuInfoMap, err := s.GetPodLog(ctx)
-	if err != nil {
-		logs.GetLogger().Errorf("Collect cluster gpu info Failed, if have available gpu, please check resource-exporter. error: %+v", err)
-	}
-
-	for _, node := range nodes.Items {
-		nodeGpu, _, nodeResource := getNodeResource(activePods, &node)
-
-		collectGpu := make(map[string]collectGpuInfo)
-		if gpu, ok := nodeGpuInfoMap[node.Name]; ok {
-			var gpuInfo struct {
-				Gpu models.Gpu `json:"gpu"`
-			}
-			if err := json.Unmarshal([]byte(gpu.String()), &gpuInfo); err != nil {
-				logs.GetLogger().Error("nodeName: %s, error: %+v", node.Name, err)
-				continue
-			}
-
-			for index, gpuDetail := range gpuInfo.Gpu.Details {
-				gpuName := strings.ReplaceAll(gpuDetail.ProductName, " ", "-")
-				if v, ok := collectGpu[gpuName]; ok {
-					v.count += 1
-					collectGpu[gpuName] = v
-				} else {
-					collectGpu[gpuName] = collectGpuInfo{
-						index,
-						1,
-						0,
-					}
-				}
-			}
-
-			for name, info := range collectGpu {
-				runCount := int(nodeGpu[name])
-				if num, ok := runTaskGpuResource.Load(name); ok {
-					runCount += num.(int)
-				}
-
-				if runCount < info.count {
-					info.remainNum = info.count - runCount
-				} else {
-					info.remainNum = 0
-				}
-				collectGpu[name] = info
-			}
-
-			var counter = make(map[string]int)
-			newGpu := make([]models.GpuDetail, 0)
-			for _, gpuDetail := range gpuInfo.Gpu.Details {
-				gpuName := strings.ReplaceAll(gpuDetail.ProductName, " ", "-")
-				newDetail := gpuDetail
-				g := collectGpu[gpuName]
-				if g.remainNum > 0 && counter[gpuName] < g.remainNum {
-					newDetail.Status = models.Available
-					counter[gpuName] += 1
-				} else {
-					newDetail.Status = models.Occupied
-				}
-				newGpu = append(newGpu, newDetail)
-			}
-			nodeResource.Gpu = models.Gpu{
-				DriverVersion: gpuInfo.Gpu.DriverVersion,
-				CudaVersion:   gpuInfo.Gpu.CudaVersion,
-				AttachedGpus:  gpuInfo.Gpu.AttachedGpus,
-				Details:       newGpu,
-			}
-		}
-		nodeList = append(nodeList, nodeResource)
-	}
-	return nodeList, nil
-}
-
-func (s *K8sService) GetPodLog(ctx context.Context) (map[string]*strings.Builder, error) {
-	var num int64 = 1
-	podLogOptions := coreV1.PodLogOptions{
-		Container:  "",
-		TailLines:  &num,
-		Timestamps: false,
-	}
-
-	podList, err := s.k8sClient.CoreV1().Pods("kube-system").List(ctx, metaV1.ListOptions{
-		LabelSelector: "app=resource-exporter",
-	})
-	if err != nil {
-		logs.GetLogger().Error(err)
-		return nil, err
-	}
-
-	result := make(map[string]*strings.Builder)
-	for _, pod := range podList.Items {
-		req := s.k8sClient.CoreV1().Pods("kube-system").GetLogs(pod.Name, &podLogOptions)
-		buf, err := readLog(req)
-		if err != nil {
-			logs.GetLogger().Errorf("collect gpu log, nodeName: %s, please check resource-exporter pod status. error: %+v", pod.Spec.NodeName, err)
-			continue
-		}
-		result[pod.Spec.NodeName] = buf
-	}
-	return result, nil
+	return cluster.NodeResources(), nil
 }
 
 func (s *K8sService) AddNodeLabel(nodeName, key string) error {
@@ -446,35 +737,27 @@ func (s *K8sService) AddNodeLabel(nodeName, key string) error {
 	return nil
 }
 
-func (s *K8sService) WaitForPodRunning(namespace, spaceUuid, serviceIp string) (string, error) {
-	var podName string
-	var podErr = errors.New("get pod status failed")
-
-	retryErr := retry.OnError(wait.Backoff{
-		Steps:    120,
-		Duration: 10 * time.Second,
-	}, func(err error) bool {
-		return err != nil && err.Error() == podErr.Error()
-	}, func() error {
-		if _, err := http.Get(serviceIp); err != nil {
-			return podErr
-		}
-		podList, err := s.k8sClient.CoreV1().Pods(namespace).List(context.TODO(), metaV1.ListOptions{
-			LabelSelector: fmt.Sprintf("lad_app==%s", spaceUuid),
-		})
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return podErr
-		}
-		podName = podList.Items[0].Name
-
-		return nil
+func (s *K8sService) ListPods(ctx context.Context, namespace, labelSelector string) ([]coreV1.Pod, error) {
+	podList, err := s.k8sClient.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: labelSelector,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
 
-	if retryErr != nil {
-		return podName, fmt.Errorf("failed waiting for pods to be running: %v", retryErr)
+// GetContainerLogTail returns the last lines of the log of a container.
+func (s *K8sService) GetContainerLogTail(ctx context.Context, namespace, podName, containerName string, lines int64) (string, error) {
+	req := s.k8sClient.CoreV1().Pods(namespace).GetLogs(podName, &coreV1.PodLogOptions{
+		Container: containerName,
+		TailLines: &lines,
+	})
+	buf, err := readLog(req)
+	if err != nil {
+		return "", err
 	}
-	return podName, nil
+	return buf.String(), nil
 }
 
 func (s *K8sService) PodDoCommand(namespace, podName, containerName string, podCmd []string) error {
@@ -512,6 +795,32 @@ func (s *K8sService) PodDoCommand(namespace, podName, containerName string, podC
 	return nil
 }
 
+// ExecInPod runs a command in a container and streams its output to stdout.
+func (s *K8sService) ExecInPod(namespace, podName, containerName string, command []string, stdout io.Writer) error {
+	req := s.k8sClient.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&coreV1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(s.config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create spdy client: %w", err)
+	}
+	var stderr strings.Builder
+	if err = executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("command %v failed: %w, %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
 func readLog(req *rest.Request) (*strings.Builder, error) {
 	podLogs, err := req.Stream(context.TODO())
 	if err != nil {
@@ -536,9 +845,3 @@ func generateLabel(name string) map[string]string {
 		return map[string]string{}
 	}
 }
-
-type collectGpuInfo struct {
-	index     int
-	count     int
-	remainNum int
-}
@@ -0,0 +1,162 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/manifest"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// spaceManifests finds a Helm chart or raw manifests (k8s/*.yaml) in the files of a space.
+// The chart closest to the root wins over raw manifests.
+func spaceManifests(spacePath string) (string, []string) {
+	var chartPath string
+	var manifestFiles []string
+	filepath.WalkDir(spacePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if d.Name() == "Chart.yaml" {
+			dir := filepath.Dir(path)
+			if chartPath == "" || len(dir) < len(chartPath) {
+				chartPath = dir
+			}
+		}
+		ext := filepath.Ext(path)
+		if filepath.Base(filepath.Dir(path)) == "k8s" && (ext == ".yaml" || ext == ".yml") {
+			manifestFiles = append(manifestFiles, path)
+		}
+		return nil
+	})
+	if chartPath != "" {
+		return chartPath, nil
+	}
+	return "", manifestFiles
+}
+
+// manifestAllowed reports whether the wallet may deploy its own manifests.
+func manifestAllowed(walletAddress string) bool {
+	manifestConf := conf.GetConfig().Manifest
+	if !manifestConf.Enable {
+		return false
+	}
+	for _, wallet := range manifestConf.Wallets {
+		if strings.EqualFold(strings.TrimSpace(wallet), walletAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Deploy) WithManifests(chartPath string, manifestFiles []string) *Deploy {
+	d.chartPath = chartPath
+	d.manifestFiles = manifestFiles
+	return d
+}
+
+// ManifestToK8s applies the manifests or the rendered chart of a space once they pass the policy.
+func (d *Deploy) ManifestToK8s() error {
+	var objects []manifest.Object
+	var err error
+	if d.chartPath != "" {
+		objects, err = manifest.RenderChart(d.chartPath, "space-"+d.spaceUuid, d.k8sNameSpace, d.manifestValues())
+	} else {
+		objects, err = manifest.LoadFiles(d.manifestFiles)
+	}
+	if err != nil {
+		return fmt.Errorf("failed load manifests of space %s, error: %w", d.spaceUuid, err)
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("space %s has no manifests", d.spaceUuid)
+	}
+
+	limits := d.createResources().Limits
+	if d.hardwareResource.Gpu.Quantity == 0 {
+		delete(limits, "nvidia.com/gpu")
+	}
+	policy := manifest.Policy{
+		Namespace:    d.k8sNameSpace,
+		Labels:       map[string]string{"lad_app": d.spaceUuid},
+		NodeSelector: generateLabel(d.hardwareResource.Gpu.Unit),
+		Kinds:        conf.GetConfig().Manifest.Kinds,
+		Limits:       limits,
+	}
+	if err = policy.Enforce(objects); err != nil {
+		return fmt.Errorf("manifests of space %s are rejected:\n%w", d.spaceUuid, err)
+	}
+	manifest.Sort(objects)
+
+	if err = d.replaceJob(); err != nil {
+		return err
+	}
+	if err = d.deployNamespace(); err != nil {
+		return err
+	}
+
+	k8sService := NewK8sService()
+	for _, o := range objects {
+		gvr, _ := manifest.GroupVersionResource(o.Kind)
+		obj, err := manifest.ToUnstructured(o)
+		if err != nil {
+			return fmt.Errorf("failed convert %s %s, error: %w", o.Kind, o.Name(), err)
+		}
+		if err = k8sService.ApplyObject(context.TODO(), d.k8sNameSpace, gvr, obj); err != nil {
+			return fmt.Errorf("failed apply %s %s, error: %w", o.Kind, o.Name(), err)
+		}
+		logs.GetLogger().Infof("Applied %s %s of space %s", o.Kind, o.Name(), d.spaceUuid)
+	}
+	d.DeployName = manifest.FirstDeployment(objects)
+	updateJobStatus(d.jobUuid, models.JobPullImage)
+
+	if serviceName, port, ok := manifest.FirstService(objects); ok {
+		if err = d.deployIngress(serviceName, port); err != nil {
+			return err
+		}
+	} else {
+		logs.GetLogger().Warnf("Space %s has no Service, it is not reachable at %s", d.spaceUuid, d.hostName)
+	}
+	updateJobStatus(d.jobUuid, models.JobDeployToK8s, "https://"+d.hostName)
+
+	d.watchContainerRunningTime()
+	return nil
+}
+
+// manifestValues are merged over the values.yaml of a chart.
+func (d *Deploy) manifestValues() map[string]interface{} {
+	return map[string]interface{}{
+		"lagrange": map[string]interface{}{
+			"spaceUuid":     d.spaceUuid,
+			"spaceName":     d.spaceName,
+			"jobUuid":       d.jobUuid,
+			"walletAddress": d.walletAddress,
+			"hostName":      d.hostName,
+			"namespace":     d.k8sNameSpace,
+			"resources": map[string]interface{}{
+				"cpu":    d.hardwareResource.Cpu.Quantity,
+				"memory": fmt.Sprintf("%d%s", d.hardwareResource.Memory.Quantity, d.hardwareResource.Memory.Unit),
+				"gpu":    d.hardwareResource.Gpu.Quantity,
+			},
+		},
+	}
+}
+
+// deleteManifestObjects removes the objects of a space that deleteJob does not know about. It runs
+// whether manifests are enabled or not, spaces deployed before they were disabled still have them.
+// Their persistent volume claims carry the label of the space too, deleteJobVolumes removes them once
+// the space ends.
+func deleteManifestObjects(namespace, spaceUuid string) {
+	k8sService := NewK8sService()
+	for _, kind := range []string{"StatefulSet", "Job", "CronJob", "ConfigMap", "Secret"} {
+		gvr, _ := manifest.GroupVersionResource(kind)
+		if err := k8sService.DeleteLabelledObjects(context.TODO(), namespace, gvr, spaceUuid); err != nil && !errors.IsNotFound(err) {
+			logs.GetLogger().Errorf("Failed delete %s objects, spaceUuid: %s, error: %+v", kind, spaceUuid, err)
+		}
+	}
+}
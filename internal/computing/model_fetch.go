@@ -0,0 +1,206 @@
+package computing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	modelFetcherName      = "model-fetcher"
+	modelCacheVolumeName  = "model-cache"
+	modelCacheMountPath   = "/lad-model-cache"
+	modelCacheClaimName   = "model-cache"
+	modelFetchTimeout     = 6 * time.Hour
+	modelFetchMaxRestarts = 3
+)
+
+// modelFetchScript downloads every model into the cache unless another space of the node already did.
+// Parallel fetches of the same file wait on its lock, interrupted downloads resume from the .part file.
+const modelFetchScript = `set -e
+i=0
+while [ "$i" -lt "$MODEL_COUNT" ]; do
+  eval "url=\$MODEL_${i}_URL; key=\$MODEL_${i}_KEY; sum=\$MODEL_${i}_SHA256; name=\$MODEL_${i}_NAME"
+  n=$((i+1))
+  file="` + modelCacheMountPath + `/$key"
+  (
+    flock 9
+    if [ ! -f "$file" ]; then
+      wget -q -c -O "$file.part" "$url" &
+      pid=$!
+      while kill -0 $pid 2>/dev/null; do
+        echo "progress $n/$MODEL_COUNT $name $(stat -c %s "$file.part" 2>/dev/null || echo 0)"
+        sleep 10
+      done
+      wait $pid
+      if [ -n "$sum" ] && ! echo "$sum  $file.part" | sha256sum -c -s -; then
+        rm -f "$file.part"
+        echo "failed $n/$MODEL_COUNT $name: sha256 mismatch"
+        exit 1
+      fi
+      mv "$file.part" "$file"
+    fi
+  ) 9>"$file.lock"
+  echo "progress $n/$MODEL_COUNT $name done"
+  i=$n
+done
+`
+
+// modelCacheKey names the cache file of a model: by its checksum when known, by its url otherwise.
+func modelCacheKey(model yaml.ModelResource) string {
+	if model.Sha256 != "" {
+		return "sha256-" + strings.ToLower(model.Sha256)
+	}
+	sum := sha256.Sum256([]byte(model.Url))
+	return "url-" + hex.EncodeToString(sum[:])
+}
+
+// modelFetcher returns the init container fetching models into the cache, the cache volume and
+// the mounts of the cached files at Dir/Name of the service container.
+func (d *Deploy) modelFetcher(modelResources []yaml.ModelResource) (coreV1.Container, coreV1.Volume, []coreV1.VolumeMount, error) {
+	cacheConf := conf.GetConfig().ModelCache
+
+	volume := coreV1.Volume{Name: modelCacheVolumeName}
+	if cacheConf.PvcSize != "" {
+		if err := NewK8sService().EnsureSharedVolumeClaim(context.TODO(), d.k8sNameSpace, modelCacheClaimName, cacheConf.PvcSize, cacheConf.StorageClass); err != nil {
+			return coreV1.Container{}, coreV1.Volume{}, nil, fmt.Errorf("failed create model cache claim, error: %w", err)
+		}
+		volume.PersistentVolumeClaim = &coreV1.PersistentVolumeClaimVolumeSource{ClaimName: modelCacheClaimName}
+	} else {
+		hostPath := cacheConf.HostPath
+		if hostPath == "" {
+			hostPath = "/var/lib/lagrange/models"
+		}
+		hostPathType := coreV1.HostPathDirectoryOrCreate
+		volume.HostPath = &coreV1.HostPathVolumeSource{Path: hostPath, Type: &hostPathType}
+	}
+
+	env := []coreV1.EnvVar{{Name: "MODEL_COUNT", Value: strconv.Itoa(len(modelResources))}}
+	var mounts []coreV1.VolumeMount
+	for i, model := range modelResources {
+		key := modelCacheKey(model)
+		prefix := fmt.Sprintf("MODEL_%d_", i)
+		env = append(env,
+			coreV1.EnvVar{Name: prefix + "URL", Value: model.Url},
+			coreV1.EnvVar{Name: prefix + "KEY", Value: key},
+			coreV1.EnvVar{Name: prefix + "SHA256", Value: strings.ToLower(model.Sha256)},
+			coreV1.EnvVar{Name: prefix + "NAME", Value: model.Name},
+		)
+		mounts = append(mounts, coreV1.VolumeMount{
+			Name:      modelCacheVolumeName,
+			MountPath: filepath.Join(model.Dir, model.Name),
+			SubPath:   key,
+			ReadOnly:  true,
+		})
+	}
+
+	image := cacheConf.FetcherImage
+	if image == "" {
+		image = "busybox:1.36"
+	}
+	fetcher := coreV1.Container{
+		Name:            modelFetcherName,
+		Image:           image,
+		Command:         []string{"/bin/sh", "-c", modelFetchScript},
+		Env:             env,
+		ImagePullPolicy: coreV1.PullIfNotPresent,
+		VolumeMounts: []coreV1.VolumeMount{
+			{Name: modelCacheVolumeName, MountPath: modelCacheMountPath},
+		},
+	}
+	return fetcher, volume, mounts, nil
+}
+
+// watchModelFetcher reports the progress of the model fetcher of the pods matching labelSelector
+// as the downloadModel stage. It returns false once the models failed to download.
+func (d *Deploy) watchModelFetcher(labelSelector string) bool {
+	k8sService := NewK8sService()
+	deadline := time.Now().Add(modelFetchTimeout)
+	var lastProgress string
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Now().After(deadline) {
+			d.modelFetchFailed(lastProgress, "timed out")
+			return false
+		}
+
+		pods, err := k8sService.ListPods(context.TODO(), d.k8sNameSpace, labelSelector)
+		if err != nil || len(pods) == 0 {
+			continue
+		}
+		pod := pods[0]
+
+		var status *coreV1.ContainerStatus
+		for i := range pod.Status.InitContainerStatuses {
+			if pod.Status.InitContainerStatuses[i].Name == modelFetcherName {
+				status = &pod.Status.InitContainerStatuses[i]
+			}
+		}
+		if status == nil {
+			continue
+		}
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode == 0 {
+			logs.GetLogger().Infof("Models of space %s are ready", d.spaceUuid)
+			return true
+		}
+
+		logTail, err := k8sService.GetContainerLogTail(context.TODO(), d.k8sNameSpace, pod.Name, modelFetcherName, 5)
+		if err == nil {
+			if progress, failed := modelFetchProgress(logTail); failed {
+				d.modelFetchFailed(lastProgress, progress)
+				return false
+			} else if progress != "" && progress != lastProgress {
+				lastProgress = progress
+				updateJobProgress(d.jobUuid, models.JobDownloadModel, progress)
+			}
+		}
+
+		if status.RestartCount >= modelFetchMaxRestarts {
+			reason := "fetcher keeps failing"
+			if last := status.LastTerminationState.Terminated; last != nil {
+				reason = fmt.Sprintf("fetcher exited with code %d", last.ExitCode)
+			}
+			d.modelFetchFailed(lastProgress, reason)
+			return false
+		}
+	}
+	return false
+}
+
+func (d *Deploy) modelFetchFailed(lastProgress, reason string) {
+	progress := strings.TrimSpace(lastProgress + " " + reason)
+	logs.GetLogger().Errorf("Failed fetch models of space %s: %s", d.spaceUuid, progress)
+	updateJobProgress(d.jobUuid, models.JobDownloadModelFailed, progress)
+}
+
+// modelFetchProgress turns the last line of the fetcher log into a readable progress.
+func modelFetchProgress(logTail string) (string, bool) {
+	lines := strings.Split(strings.TrimSpace(logTail), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if strings.HasPrefix(last, "failed ") {
+		return strings.TrimPrefix(last, "failed "), true
+	}
+
+	fields := strings.Fields(last)
+	if len(fields) != 4 || fields[0] != "progress" {
+		return "", false
+	}
+	if fields[3] == "done" {
+		return fmt.Sprintf("%s (%s) done", fields[2], fields[1]), false
+	}
+	size, _ := strconv.ParseInt(fields[3], 10, 64)
+	return fmt.Sprintf("%s (%s) %d MiB", fields[2], fields[1], size>>20), false
+}
@@ -0,0 +1,78 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+)
+
+const preflightTimeout = 10 * time.Second
+
+// CheckRedis pings the redis server of the config, with a connection of its own so that the pool
+// of a running provider is left alone.
+func CheckRedis(url, password string) models.CheckResult {
+	result := models.CheckResult{Name: "redis"}
+	conn, err := redis.DialURL(url, redis.DialPassword(password),
+		redis.DialConnectTimeout(preflightTimeout), redis.DialReadTimeout(preflightTimeout))
+	if err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		result.Hint = "start redis and check API.RedisUrl and API.RedisPassword"
+		return result
+	}
+	defer conn.Close()
+	if _, err = conn.Do("PING"); err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		result.Hint = "check API.RedisPassword"
+		return result
+	}
+	result.Status, result.Message = models.CheckPass, "PONG from "+url
+	return result
+}
+
+// CheckKubernetes asks the version of the cluster of the kubeconfig, or of the cluster the provider runs in.
+func CheckKubernetes() models.CheckResult {
+	result := models.CheckResult{Name: "kubernetes"}
+	k8sService := NewK8sService()
+	if k8sService.k8sClient == nil {
+		result.Status, result.Message = models.CheckFail, "no kubernetes client"
+		if k8sErr != nil {
+			result.Message = k8sErr.Error()
+		}
+		result.Hint = "copy the kubeconfig of the cluster to ~/.kube/config"
+		return result
+	}
+	version, err := k8sService.k8sClient.Discovery().ServerVersion()
+	if err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		result.Hint = "check that the api server in ~/.kube/config is reachable"
+		return result
+	}
+	result.Status, result.Message = models.CheckPass, "kubernetes "+version.GitVersion
+	return result
+}
+
+// CheckDocker pings the docker daemon the images of spaces are built with.
+func CheckDocker() models.CheckResult {
+	result := models.CheckResult{Name: "docker"}
+	cli, err := newDockerClient()
+	if err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		result.Hint = "check DOCKER_HOST"
+		return result
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	ping, err := (&DockerService{c: cli}).Ping(ctx)
+	if err != nil {
+		result.Status, result.Message = models.CheckFail, err.Error()
+		result.Hint = "start docker, and add the user to the docker group"
+		return result
+	}
+	result.Status, result.Message = models.CheckPass, fmt.Sprintf("docker api %s", ping.APIVersion)
+	return result
+}
@@ -0,0 +1,324 @@
+package computing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/internal/proof"
+	"github.com/lagrangedao/go-computing-provider/util"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultProofNamespace = "lagrange-proof"
+	proofContainerName    = "proof"
+	proofPullTimeout      = 5 * time.Minute
+	proofResultRetention  = 24 * time.Hour
+)
+
+var (
+	proofRegistry     *proof.Registry
+	proofRegistryOnce sync.Once
+)
+
+// ProofRegistry returns the proof methods of the provider, with the images of the Proof section.
+func ProofRegistry() *proof.Registry {
+	proofRegistryOnce.Do(func() {
+		proofRegistry = proof.NewRegistry()
+		methods := []proof.Method{proof.Mine()}
+		cpPath, _ := os.LookupEnv("CP_PATH")
+		if nodeKey, err := LoadNodeKey(cpPath); err != nil {
+			logs.GetLogger().Errorf("Failed load node key, the gpu proof is disabled, error: %v", err)
+		} else {
+			methods = append(methods, proof.Gpu(proof.NewSigner(nodeKey)))
+		}
+		for _, method := range methods {
+			if image := conf.GetConfig().Proof.Images[method.Name]; image != "" {
+				method.Image = image
+			}
+			if err := proofRegistry.Register(method); err != nil {
+				logs.GetLogger().Errorf("Failed register proof method, error: %v", err)
+			}
+		}
+	})
+	return proofRegistry
+}
+
+func proofNamespace() string {
+	if namespace := conf.GetConfig().Proof.Namespace; namespace != "" {
+		return namespace
+	}
+	return defaultProofNamespace
+}
+
+// DoProof starts a proof task and returns its id, the result is polled with GetProof.
+func DoProof(c *gin.Context) {
+	var proofReq models.ProofReq
+	if err := c.ShouldBindJSON(&proofReq); err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.JsonError))
+		return
+	}
+	logs.GetLogger().Infof("do proof task received: %+v", proofReq)
+
+	if strings.TrimSpace(proofReq.Method) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, "missing required field: method"))
+		return
+	}
+	registry := ProofRegistry()
+	method, err := registry.Lookup(proofReq.Method)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError,
+			fmt.Sprintf("method must be one of %s", strings.Join(registry.Names(), ", "))))
+		return
+	}
+
+	params := proofReq.Params
+	if len(params) == 0 {
+		// the params of the mine method used to be fields of the request
+		params, _ = json.Marshal(map[string]interface{}{"block_data": proofReq.BlockData, "exp": proofReq.Exp})
+	}
+	env, err := method.Env(params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, err.Error()))
+		return
+	}
+
+	task := models.ProofTask{
+		TaskId: uuid.NewString(),
+		Method: method.Name,
+		Status: models.ProofPending,
+	}
+	if err = saveProofTask(task, false); err != nil {
+		logs.GetLogger().Errorf("Failed save proof task, error: %v", err)
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
+		return
+	}
+
+	go runProof(task, method, params, env)
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(task))
+}
+
+// GetProof returns the status of a proof task, and its verified result once it succeeded.
+func GetProof(c *gin.Context) {
+	taskId := c.Query("task_id")
+	if strings.TrimSpace(taskId) == "" {
+		c.JSON(http.StatusBadRequest, util.CreateErrorResponse(util.ProofParamError, "missing required field: task_id"))
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_PROOF_PREFIX+taskId))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, util.CreateErrorResponse(util.ProofError))
+		return
+	}
+	if len(values) == 0 {
+		c.JSON(http.StatusNotFound, util.CreateErrorResponse(util.ProofParamError, "proof task not found"))
+		return
+	}
+
+	task := models.ProofTask{
+		TaskId: taskId,
+		Method: values["method"],
+		Status: models.ProofStatus(values["status"]),
+		Reason: values["reason"],
+	}
+	if result := values["result"]; result != "" {
+		task.Result = json.RawMessage(result)
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(task))
+}
+
+func saveProofTask(task models.ProofTask, finished bool) error {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	key := constants.REDIS_PROOF_PREFIX + task.TaskId
+	args := []interface{}{key, "method", task.Method, "status", string(task.Status), "reason", task.Reason}
+	if task.Result != nil {
+		args = append(args, "result", string(task.Result))
+	}
+	if _, err := conn.Do("HSET", args...); err != nil {
+		return err
+	}
+	ttl := proofResultRetention
+	if !finished {
+		ttl += proofPullTimeout
+	}
+	_, err := conn.Do("EXPIRE", key, int64(ttl.Seconds()))
+	return err
+}
+
+func runProof(task models.ProofTask, method proof.Method, params json.RawMessage, env map[string]string) {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("proof task panic, error: %+v", err)
+			task.Status, task.Reason = models.ProofFailed, "internal error"
+			saveProofTask(task, true)
+		}
+	}()
+
+	result, err := executeProof(task, method, params, env)
+	if err != nil {
+		logs.GetLogger().Errorf("Proof task %s failed, error: %v", task.TaskId, err)
+		task.Status, task.Reason = models.ProofFailed, err.Error()
+	} else {
+		logs.GetLogger().Infof("Proof task %s succeeded", task.TaskId)
+		task.Status, task.Result = models.ProofSucceeded, result
+	}
+	if err = saveProofTask(task, true); err != nil {
+		logs.GetLogger().Errorf("Failed save proof task %s, error: %v", task.TaskId, err)
+	}
+}
+
+// executeProof runs the method as Job in the proof namespace and verifies its output. The Job is
+// deleted whatever its outcome.
+func executeProof(task models.ProofTask, method proof.Method, params json.RawMessage, env map[string]string) (json.RawMessage, error) {
+	k8sService := NewK8sService()
+	namespace := proofNamespace()
+	if err := k8sService.EnsureNamespace(context.TODO(), namespace); err != nil {
+		return nil, fmt.Errorf("failed create namespace %s, error: %w", namespace, err)
+	}
+
+	jobs := k8sService.k8sClient.BatchV1().Jobs(namespace)
+	job, err := jobs.Create(context.TODO(), proofJob(task.TaskId, method, params, env), metaV1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed create job, error: %w", err)
+	}
+	defer func() {
+		propagation := metaV1.DeletePropagationBackground
+		if err := jobs.Delete(context.TODO(), job.Name, metaV1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			logs.GetLogger().Errorf("Failed delete proof job %s, error: %v", job.Name, err)
+		}
+	}()
+
+	task.Status = models.ProofRunning
+	saveProofTask(task, false)
+
+	podName, err := waitProofJob(namespace, job.Name, method.Timeout+proofPullTimeout)
+	if err != nil {
+		return nil, err
+	}
+	output, err := readLog(k8sService.k8sClient.CoreV1().Pods(namespace).GetLogs(podName, &coreV1.PodLogOptions{Container: proofContainerName}))
+	if err != nil {
+		return nil, fmt.Errorf("failed read the log of the proof, error: %w", err)
+	}
+
+	result, err := method.Parse(params, output.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof: %w", err)
+	}
+	return json.Marshal(result)
+}
+
+func proofJob(taskId string, method proof.Method, params json.RawMessage, env map[string]string) *batchV1.Job {
+	var envVars []coreV1.EnvVar
+	for name, value := range env {
+		envVars = append(envVars, coreV1.EnvVar{Name: name, Value: value})
+	}
+
+	resources := coreV1.ResourceList{}
+	if method.Resources.Cpu != "" {
+		resources[coreV1.ResourceCPU] = resource.MustParse(method.Resources.Cpu)
+	}
+	if method.Resources.Memory != "" {
+		resources[coreV1.ResourceMemory] = resource.MustParse(method.Resources.Memory)
+	}
+	if method.Resources.Gpu > 0 {
+		resources["nvidia.com/gpu"] = *resource.NewQuantity(method.Resources.Gpu, resource.DecimalSI)
+	}
+
+	var nodeSelector map[string]string
+	if method.NodeSelector != nil {
+		nodeSelector = method.NodeSelector(params)
+	}
+
+	labels := map[string]string{"lad_proof": taskId, "lad_proof_method": method.Name}
+	deadline := int64((method.Timeout + proofPullTimeout).Seconds())
+	backoffLimit := int32(0)
+	ttl := int32(30)
+	return &batchV1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:   "proof-" + taskId,
+			Labels: labels,
+		},
+		Spec: batchV1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{Labels: labels},
+				Spec: coreV1.PodSpec{
+					RestartPolicy: coreV1.RestartPolicyNever,
+					NodeSelector:  nodeSelector,
+					Containers: []coreV1.Container{{
+						Name:            proofContainerName,
+						Image:           method.Image,
+						Command:         method.Command,
+						Args:            method.Args,
+						Env:             envVars,
+						Resources:       coreV1.ResourceRequirements{Limits: resources, Requests: resources},
+						ImagePullPolicy: coreV1.PullIfNotPresent,
+					}},
+				},
+			},
+		},
+	}
+}
+
+// waitProofJob waits for the Job to succeed and returns its pod.
+func waitProofJob(namespace, jobName string, timeout time.Duration) (string, error) {
+	k8sService := NewK8sService()
+	deadline := time.Now().Add(timeout)
+
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timed out after %s", timeout)
+		}
+
+		job, err := k8sService.k8sClient.BatchV1().Jobs(namespace).Get(context.TODO(), jobName, metaV1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchV1.JobFailed && condition.Status == coreV1.ConditionTrue {
+				return "", fmt.Errorf("%s: %s", condition.Reason, condition.Message)
+			}
+		}
+		if job.Status.Succeeded == 0 {
+			continue
+		}
+
+		pods, err := k8sService.ListPods(context.TODO(), namespace, "job-name="+jobName)
+		if err != nil {
+			return "", err
+		}
+		for _, pod := range pods {
+			if pod.Status.Phase == coreV1.PodSucceeded {
+				return pod.Name, nil
+			}
+		}
+		return "", errors.New("no succeeded pod found for the job")
+	}
+	return "", errors.New("stopped watching the job")
+}
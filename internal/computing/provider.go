@@ -33,11 +33,17 @@ func updateProviderInfo(nodeID, peerID, address string, status string) {
 		cpName, _ = os.Hostname()
 	}
 
+	bidStatus := providerBidStatus()
+	autobid := 1
+	if bidStatus == models.BidDisabledStatus {
+		autobid = 0
+	}
 	provider := models.ComputingProvider{
 		Name:         cpName,
 		NodeId:       nodeID,
 		MultiAddress: conf.GetConfig().API.MultiAddress,
-		Autobid:      1,
+		Autobid:      autobid,
+		BidStatus:    bidStatus,
 		Status:       status,
 	}
 
@@ -87,11 +93,17 @@ func GenerateNodeID(cpRepoPath string) (string, string, string) {
 	privateKeyPath := filepath.Join(cpRepoPath, "private_key")
 	var privateKeyBytes []byte
 
-	if _, err := os.Stat(privateKeyPath); err == nil {
+	if info, err := os.Stat(privateKeyPath); err == nil {
 		privateKeyBytes, err = os.ReadFile(privateKeyPath)
 		if err != nil {
 			log.Fatalf("Error reading private key: %v", err)
 		}
+		// keys of older versions were readable by everyone
+		if info.Mode().Perm()&0077 != 0 {
+			if err = os.Chmod(privateKeyPath, 0600); err != nil {
+				log.Printf("Failed restrict the permissions of %s, error: %v", privateKeyPath, err)
+			}
+		}
 	} else {
 		privateKeyBytes = make([]byte, 32)
 		_, err := rand.Read(privateKeyBytes)
@@ -104,7 +116,7 @@ func GenerateNodeID(cpRepoPath string) (string, string, string) {
 			log.Fatalf("Error creating directory for private key: %v", err)
 		}
 
-		err = os.WriteFile(privateKeyPath, privateKeyBytes, 0644)
+		err = os.WriteFile(privateKeyPath, privateKeyBytes, 0600)
 		if err != nil {
 			log.Fatalf("Error writing private key: %v", err)
 		}
@@ -120,6 +132,15 @@ func GenerateNodeID(cpRepoPath string) (string, string, string) {
 	return nodeID, peerID, address
 }
 
+// LoadNodeKey reads the node key created by GenerateNodeID.
+func LoadNodeKey(cpRepoPath string) (*ecdsa.PrivateKey, error) {
+	privateKeyBytes, err := os.ReadFile(filepath.Join(cpRepoPath, "private_key"))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToECDSA(privateKeyBytes)
+}
+
 func hashPublicKey(publicKey *ecdsa.PublicKey) string {
 	publicKeyBytes := crypto.FromECDSAPub(publicKey)
 	hash := sha256.Sum256(publicKeyBytes)
@@ -0,0 +1,278 @@
+package computing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/internal/capacity"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// reservationTTL bounds a reservation whose pod never shows up, e.g. after a crash of the deploy.
+	reservationTTL     = 3 * time.Hour
+	reservationRetries = 10
+)
+
+// ErrInsufficientResources is returned when a job doesn't fit in what is free and not reserved.
+var ErrInsufficientResources = errors.New("insufficient resources")
+
+// jobReservation is what a job reserved in the ledger, until a pod matching Selector in Namespace
+// is scheduled and requests it.
+type jobReservation struct {
+	capacity.Reservation
+	Namespace string
+	Selector  string
+	CreatedAt int64
+}
+
+// hardwareReservation is what a job of the hardware needs.
+func hardwareReservation(hardware models.Resource) (capacity.Reservation, error) {
+	reservation := capacity.Reservation{Gpus: make(map[string]int64)}
+	reservation.CpuMilli = hardware.Cpu.Quantity * 1000
+
+	memory, err := resource.ParseQuantity(fmt.Sprintf("%d%s", hardware.Memory.Quantity, hardware.Memory.Unit))
+	if err != nil {
+		return reservation, fmt.Errorf("invalid memory, error: %w", err)
+	}
+	reservation.Memory = memory.Value()
+	storage, err := resource.ParseQuantity(fmt.Sprintf("%d%s", hardware.Storage.Quantity, hardware.Storage.Unit))
+	if err != nil {
+		return reservation, fmt.Errorf("invalid storage, error: %w", err)
+	}
+	reservation.Storage = storage.Value()
+
+	if hardware.Gpu.Unit != "" && hardware.Gpu.Quantity > 0 {
+		reservation.Gpus[capacity.GpuModel(hardware.Gpu.Unit)] = hardware.Gpu.Quantity
+	}
+	return reservation, nil
+}
+
+// reserveJob reserves the hardware of a job in the ledger, if it fits in the capacity left by the other
+// reservations. The check and the reservation are one transaction, parallel jobs can't both take the
+// last GPU of a model. Reserving a job again keeps its reservation. The running pods matching selector
+// are not counted as used, a redeploy of a space replaces them.
+func reserveJob(jobUuid, namespace, selector string, hardware models.Resource) error {
+	return reserve(jobUuid, namespace, selector, hardware, reservationTTL)
+}
+
+func reserve(jobUuid, namespace, selector string, hardware models.Resource, ttl time.Duration) error {
+	reservation, err := hardwareReservation(hardware)
+	if err != nil {
+		return err
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	key := constants.REDIS_RESERVATION_PREFIX + jobUuid
+
+	for i := 0; i < reservationRetries; i++ {
+		if _, err = conn.Do("WATCH", constants.REDIS_RESERVATIONS_KEY); err != nil {
+			return err
+		}
+		if exists, _ := redis.Bool(conn.Do("EXISTS", key)); exists {
+			conn.Do("UNWATCH")
+			return nil
+		}
+
+		reserved, err := ledgerReservations(conn)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+		cluster, err := buildCapacity(context.TODO(), totalReservation(reserved), namespace, selector)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+		if err = cluster.Fits(reservation); err != nil {
+			conn.Do("UNWATCH")
+			return fmt.Errorf("%w: %v", ErrInsufficientResources, err)
+		}
+
+		var gpuModel string
+		var gpuCount int64
+		for model, count := range reservation.Gpus {
+			gpuModel, gpuCount = model, count
+		}
+		conn.Send("MULTI")
+		conn.Send("HSET", key,
+			"cpu_milli", reservation.CpuMilli,
+			"memory", reservation.Memory,
+			"storage", reservation.Storage,
+			"gpu_model", gpuModel,
+			"gpu", gpuCount,
+			"namespace", namespace,
+			"selector", selector,
+			"created_at", time.Now().Unix())
+		conn.Send("EXPIRE", key, int64(ttl.Seconds()))
+		conn.Send("SADD", constants.REDIS_RESERVATIONS_KEY, jobUuid)
+		if _, err = redis.Values(conn.Do("EXEC")); err == redis.ErrNil {
+			// another job reserved or released meanwhile, check again
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		invalidateCapacity()
+		logs.GetLogger().Infof("Reserved %+v for job %s", reservation, jobUuid)
+		return nil
+	}
+	return fmt.Errorf("failed reserve resources of job %s, too many concurrent reservations", jobUuid)
+}
+
+// narrowReservation changes the pods that release the reservation of a job. A space with several
+// services is released once the pod of its primary service is scheduled, its dependencies come first.
+func narrowReservation(jobUuid, selector string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	// a released reservation must not come back without its TTL
+	key := constants.REDIS_RESERVATION_PREFIX + jobUuid
+	if _, err := conn.Do("WATCH", key); err != nil {
+		logs.GetLogger().Errorf("Failed narrow the reservation of job %s, error: %v", jobUuid, err)
+		return
+	}
+	if exists, _ := redis.Bool(conn.Do("EXISTS", key)); !exists {
+		conn.Do("UNWATCH")
+		return
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", key, "selector", selector)
+	if _, err := conn.Do("EXEC"); err != nil {
+		logs.GetLogger().Errorf("Failed narrow the reservation of job %s, error: %v", jobUuid, err)
+	}
+}
+
+// releaseJob removes the reservation of a job, once its pod requests the resources or it failed.
+func releaseJob(jobUuid string) {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", constants.REDIS_RESERVATION_PREFIX+jobUuid)
+	conn.Send("SREM", constants.REDIS_RESERVATIONS_KEY, jobUuid)
+	if _, err := conn.Do("EXEC"); err != nil {
+		logs.GetLogger().Errorf("Failed release the reservation of job %s, error: %v", jobUuid, err)
+		return
+	}
+	invalidateCapacity()
+}
+
+// ledgerReservations reads the reservations by job uuid. Members whose reservation expired are skipped.
+func ledgerReservations(conn redis.Conn) (map[string]jobReservation, error) {
+	jobUuids, err := redis.Strings(conn.Do("SMEMBERS", constants.REDIS_RESERVATIONS_KEY))
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := make(map[string]jobReservation)
+	for _, jobUuid := range jobUuids {
+		values, err := redis.StringMap(conn.Do("HGETALL", constants.REDIS_RESERVATION_PREFIX+jobUuid))
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		reservation := jobReservation{
+			Reservation: capacity.Reservation{Gpus: make(map[string]int64)},
+			Namespace:   values["namespace"],
+			Selector:    values["selector"],
+		}
+		reservation.CpuMilli, _ = strconv.ParseInt(values["cpu_milli"], 10, 64)
+		reservation.Memory, _ = strconv.ParseInt(values["memory"], 10, 64)
+		reservation.Storage, _ = strconv.ParseInt(values["storage"], 10, 64)
+		reservation.CreatedAt, _ = strconv.ParseInt(values["created_at"], 10, 64)
+		if gpu, _ := strconv.ParseInt(values["gpu"], 10, 64); gpu > 0 && values["gpu_model"] != "" {
+			reservation.Gpus[values["gpu_model"]] = gpu
+		}
+		reservations[jobUuid] = reservation
+	}
+	return reservations, nil
+}
+
+func totalReservation(reservations map[string]jobReservation) capacity.Reservation {
+	total := capacity.Reservation{Gpus: make(map[string]int64)}
+	for _, reservation := range reservations {
+		total = total.Add(reservation.Reservation)
+	}
+	return total
+}
+
+// reservedResources is what the jobs of the ledger reserved, their pods don't request it yet.
+func reservedResources() capacity.Reservation {
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	reservations, err := ledgerReservations(conn)
+	if err != nil {
+		logs.GetLogger().Errorf("Failed read the reservations, error: %v", err)
+	}
+	return totalReservation(reservations)
+}
+
+// watchReservations releases the reservations whose pod is scheduled, and forgets the expired ones.
+func watchReservations() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.GetLogger().Errorf("Failed watch reservations, error: %+v", err)
+			}
+		}()
+
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			releaseScheduledReservations()
+		}
+	}()
+}
+
+func releaseScheduledReservations() {
+	conn := redisPool.Get()
+	jobUuids, err := redis.Strings(conn.Do("SMEMBERS", constants.REDIS_RESERVATIONS_KEY))
+	if err != nil {
+		conn.Close()
+		logs.GetLogger().Errorf("Failed read the reservations, error: %v", err)
+		return
+	}
+	reservations, err := ledgerReservations(conn)
+	conn.Close()
+	if err != nil {
+		logs.GetLogger().Errorf("Failed read the reservations, error: %v", err)
+		return
+	}
+
+	k8sService := NewK8sService()
+	for _, jobUuid := range jobUuids {
+		reservation, ok := reservations[jobUuid]
+		if !ok {
+			releaseJob(jobUuid)
+			continue
+		}
+		if reservation.Selector == "" {
+			continue
+		}
+
+		pods, err := k8sService.ListPods(context.TODO(), reservation.Namespace, reservation.Selector)
+		if err != nil {
+			continue
+		}
+		for _, pod := range pods {
+			// pods of a previous deploy of the space may still be terminating
+			if pod.Spec.NodeName != "" && pod.DeletionTimestamp == nil && pod.CreationTimestamp.Unix() >= reservation.CreatedAt {
+				logs.GetLogger().Infof("Pod %s of job %s is scheduled on %s, release its reservation", pod.Name, jobUuid, pod.Spec.NodeName)
+				releaseJob(jobUuid)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package computing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/inference"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"github.com/lagrangedao/go-computing-provider/util"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	runtimeNamespace    = "lagrange-runtimes"
+	runtimeWarmerName   = "runtime-warmer"
+	runtimePauseImage   = "registry.k8s.io/pause:3.9"
+	defaultWarmInterval = 60
+	warmNodesRefresh    = time.Minute
+
+	// runtimeTrueImage has a static busybox, its `true` runs in any runtime image, with or without shell
+	runtimeTrueImage = "busybox:1.36-musl"
+	runtimeTrueDir   = "/.runtime-warmer"
+)
+
+// the catalog is built once from the config at start, Inference.Runtimes is one of the fields a
+// reload keeps until a restart
+var (
+	runtimeCatalog     *inference.Catalog
+	runtimeCatalogErr  error
+	runtimeCatalogOnce sync.Once
+)
+
+// RuntimeCatalog returns the runtime catalog of the config, it is empty when the config is invalid.
+func RuntimeCatalog() *inference.Catalog {
+	runtimeCatalogOnce.Do(func() {
+		runtimeCatalog, runtimeCatalogErr = inference.NewCatalog(conf.GetConfig().Inference.Runtimes)
+		if runtimeCatalogErr != nil {
+			runtimeCatalog, _ = inference.NewCatalog(nil)
+		}
+	})
+	return runtimeCatalog
+}
+
+// runtimeImage returns the image of a runtime, the framework image for runtimes built by the provider.
+func runtimeImage(runtime conf.Runtime) string {
+	if runtime.Image != "" {
+		return runtime.Image
+	}
+	imageName, _, _ := frameworkImage(runtime.Framework)
+	return imageName
+}
+
+// RuntimeWarmer keeps the images of the runtime catalog on every node. It builds the images the
+// catalog does not name, then a DaemonSet pulls all of them, at startup and on a schedule.
+type RuntimeWarmer struct {
+	catalog  *inference.Catalog
+	interval time.Duration
+	images   []string
+}
+
+func NewRuntimeWarmer() (*RuntimeWarmer, error) {
+	catalog := RuntimeCatalog()
+	if runtimeCatalogErr != nil {
+		return nil, fmt.Errorf("invalid runtime catalog, error: %w", runtimeCatalogErr)
+	}
+	interval := conf.GetConfig().Inference.WarmInterval
+	if interval <= 0 {
+		interval = defaultWarmInterval
+	}
+	return &RuntimeWarmer{
+		catalog:  catalog,
+		interval: time.Duration(interval) * time.Minute,
+	}, nil
+}
+
+// Run warms the runtimes every interval and refreshes the warm nodes every minute in between.
+func (w *RuntimeWarmer) Run() {
+	if len(w.catalog.Runtimes()) == 0 {
+		return
+	}
+	w.warm()
+
+	warmTicker := time.NewTicker(w.interval)
+	defer warmTicker.Stop()
+	refreshTicker := time.NewTicker(warmNodesRefresh)
+	defer refreshTicker.Stop()
+	for {
+		select {
+		case <-warmTicker.C:
+			w.warm()
+		case <-refreshTicker.C:
+			w.refreshWarmNodes()
+		}
+	}
+}
+
+func (w *RuntimeWarmer) warm() {
+	defer func() {
+		if err := recover(); err != nil {
+			logs.GetLogger().Errorf("catch panic error: %+v", err)
+		}
+	}()
+
+	w.images = w.prepareImages()
+	if len(w.images) == 0 {
+		return
+	}
+	if err := w.applyWarmer(); err != nil {
+		logs.GetLogger().Errorf("Failed pull runtime images on the nodes, error: %+v", err)
+		return
+	}
+	logs.GetLogger().Infof("Pulling %d runtime images on all nodes", len(w.images))
+	w.refreshWarmNodes()
+}
+
+// prepareImages builds the runtimes without image. Built images can only be pulled by the nodes
+// through a registry, without one they stay on the provider host.
+func (w *RuntimeWarmer) prepareImages() []string {
+	cpPath, _ := os.LookupEnv("CP_PATH")
+	pullable := conf.GetConfig().Registry.ServerAddress != ""
+
+	var images []string
+	seen := make(map[string]bool)
+	for _, runtime := range w.catalog.Runtimes() {
+		image := runtimeImage(runtime)
+		if image == "" || seen[image] {
+			continue
+		}
+		seen[image] = true
+
+		if runtime.Image == "" {
+			if _, ok := builtImages.Load(image); !ok {
+				logPath := filepath.Join(cpPath, "inference-model", fmt.Sprintf("build-%s.log", runtime.Framework))
+				if _, err := buildFrameworkImage(runtime.Framework, logPath, nil); err != nil {
+					logs.GetLogger().Errorf("Failed build runtime of %s, see %s, error: %+v", runtime.Framework, logPath, err)
+					continue
+				}
+				logs.GetLogger().Infof("Built runtime image %s", image)
+			}
+			if !pullable {
+				continue
+			}
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// applyWarmer pulls the images with the init containers of a DaemonSet on every node. The first one
+// copies a static busybox to a shared volume, the others run its `true` in each image, so an image
+// without shell is pulled too. The pods are restarted on every run, which pulls again the images
+// the nodes removed meanwhile.
+func (w *RuntimeWarmer) applyWarmer() error {
+	k8sService := NewK8sService()
+	if err := k8sService.EnsureNamespace(context.TODO(), runtimeNamespace); err != nil {
+		return err
+	}
+
+	resources := coreV1.ResourceRequirements{
+		Requests: coreV1.ResourceList{
+			coreV1.ResourceCPU:    resource.MustParse("10m"),
+			coreV1.ResourceMemory: resource.MustParse("16Mi"),
+		},
+		Limits: coreV1.ResourceList{
+			coreV1.ResourceCPU:    resource.MustParse("100m"),
+			coreV1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+	}
+	trueMount := []coreV1.VolumeMount{{Name: "true", MountPath: runtimeTrueDir}}
+	initContainers := []coreV1.Container{{
+		Name:         "true",
+		Image:        runtimeTrueImage,
+		Command:      []string{"cp", "/bin/busybox", runtimeTrueDir + "/busybox"},
+		Resources:    resources,
+		VolumeMounts: trueMount,
+	}}
+	for i, image := range w.images {
+		initContainers = append(initContainers, coreV1.Container{
+			Name:            fmt.Sprintf("runtime-%d", i),
+			Image:           image,
+			Command:         []string{runtimeTrueDir + "/busybox", "true"},
+			ImagePullPolicy: coreV1.PullIfNotPresent,
+			Resources:       resources,
+			VolumeMounts:    trueMount,
+		})
+	}
+
+	labels := map[string]string{"app": runtimeWarmerName}
+	daemonSet := &appV1.DaemonSet{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      runtimeWarmerName,
+			Namespace: runtimeNamespace,
+			Labels:    labels,
+		},
+		Spec: appV1.DaemonSetSpec{
+			Selector: &metaV1.LabelSelector{MatchLabels: labels},
+			Template: coreV1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{"lagrange/warmed-at": time.Now().Format(time.RFC3339)},
+				},
+				Spec: coreV1.PodSpec{
+					InitContainers: initContainers,
+					Containers: []coreV1.Container{{
+						Name:      "pause",
+						Image:     runtimePauseImage,
+						Resources: resources,
+					}},
+					Volumes: []coreV1.Volume{{
+						Name:         "true",
+						VolumeSource: coreV1.VolumeSource{EmptyDir: &coreV1.EmptyDirVolumeSource{}},
+					}},
+					Tolerations: []coreV1.Toleration{{Operator: coreV1.TolerationOpExists}},
+				},
+			},
+		},
+	}
+	return k8sService.ApplyDaemonSet(context.TODO(), daemonSet)
+}
+
+// refreshWarmNodes reads the images the nodes report to have.
+func (w *RuntimeWarmer) refreshWarmNodes() {
+	nodes, err := NewK8sService().ListNodes(context.TODO())
+	if err != nil {
+		logs.GetLogger().Errorf("Failed list nodes, error: %+v", err)
+		return
+	}
+	nodeImages := make(map[string][]string)
+	for _, node := range nodes {
+		for _, image := range node.Status.Images {
+			nodeImages[node.Name] = append(nodeImages[node.Name], image.Names...)
+		}
+	}
+	w.catalog.SetWarm(w.images, nodeImages)
+}
+
+// isRuntimeImage reports whether one of the tags of an image is a runtime, which is kept on cleanup.
+func isRuntimeImage(tags []string) bool {
+	for _, tag := range tags {
+		if _, ok := builtImages.Load(tag); ok {
+			return true
+		}
+		for _, runtime := range RuntimeCatalog().Runtimes() {
+			if runtime.Image == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// warmNodeAffinity prefers the nodes that have image, the space starts there without pulling it.
+func warmNodeAffinity(image string) *coreV1.Affinity {
+	nodes := RuntimeCatalog().WarmNodes(image)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return &coreV1.Affinity{
+		NodeAffinity: &coreV1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []coreV1.PreferredSchedulingTerm{{
+				Weight: 100,
+				Preference: coreV1.NodeSelectorTerm{
+					MatchExpressions: []coreV1.NodeSelectorRequirement{{
+						Key:      coreV1.LabelHostname,
+						Operator: coreV1.NodeSelectorOpIn,
+						Values:   nodes,
+					}},
+				},
+			}},
+		},
+	}
+}
+
+// GetRuntimes lists the runtime catalog and the nodes having each runtime warm.
+func GetRuntimes(c *gin.Context) {
+	var runtimes []models.RuntimeStatus
+	for _, runtime := range RuntimeCatalog().Runtimes() {
+		image := runtimeImage(runtime)
+		runtimes = append(runtimes, models.RuntimeStatus{
+			Framework:   runtime.Framework,
+			Task:        runtime.Task,
+			Accelerator: runtime.Accelerator,
+			Image:       image,
+			WarmNodes:   RuntimeCatalog().WarmNodes(image),
+		})
+	}
+	c.JSON(http.StatusOK, util.CreateSuccessResponse(runtimes))
+}
@@ -1,6 +1,7 @@
 package computing
 
 import (
+	"errors"
 	"strings"
 	"sync"
 
@@ -48,6 +49,15 @@ func NewStorageService() *StorageService {
 	return storage
 }
 
+// CheckBucket tells whether the login to MCS succeeded and the bucket of the config exists.
+func (storage *StorageService) CheckBucket() error {
+	if storage.mcsClient == nil {
+		return errors.New("the login to MCS failed")
+	}
+	_, err := bucket.GetBucketClient(*storage.mcsClient).GetBucketUid(storage.BucketName)
+	return err
+}
+
 func (storage *StorageService) UploadFileToBucket(objectName, filePath string, replace bool) (*bucket.OssFile, error) {
 	logs.GetLogger().Infof("uploading file to bucket, objectName: %s, filePath: %s", objectName, filePath)
 	buketClient := bucket.GetBucketClient(*storage.mcsClient)
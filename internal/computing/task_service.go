@@ -17,7 +17,6 @@ import (
 	"time"
 )
 
-var runTaskGpuResource sync.Map
 var deployingChan = make(chan models2.Job)
 
 type ScheduleTask struct {
@@ -71,18 +70,27 @@ func (s *ScheduleTask) Run() {
 			s.TaskMap.Range(func(key, value any) bool {
 				jobUuid := key.(string)
 				job := value.(*models2.Job)
-				reportJobStatus(jobUuid, job.Status)
+				reportJobStatus(jobUuid, job.Status, job.Endpoints, job.Progress, job.ResultUri)
 				return true
 			})
 		}
 	}
 }
 
-func reportJobStatus(jobUuid string, jobStatus models2.JobStatus) {
+func reportJobStatus(jobUuid string, jobStatus models2.JobStatus, endpoints []models2.Endpoint, progress, resultUri string) {
 	reqParam := map[string]interface{}{
 		"job_uuid": jobUuid,
 		"status":   jobStatus,
 	}
+	if len(endpoints) > 0 {
+		reqParam["endpoints"] = endpoints
+	}
+	if progress != "" {
+		reqParam["progress"] = progress
+	}
+	if resultUri != "" {
+		reqParam["job_result_uri"] = resultUri
+	}
 
 	payload, err := json.Marshal(reqParam)
 	if err != nil {
@@ -124,7 +132,7 @@ func RunSyncTask(nodeId string) {
 			return
 		}
 
-		nodeGpuInfoMap, err := k8sService.GetPodLog(context.TODO())
+		nodeGpus, err := collectNodeGpus(context.TODO())
 		if err != nil {
 			logs.GetLogger().Error(err)
 			return
@@ -133,15 +141,8 @@ func RunSyncTask(nodeId string) {
 		logs.GetLogger().Infof("collect all node: %d", len(nodes.Items))
 		for _, node := range nodes.Items {
 			cpNode := node
-			if gpu, ok := nodeGpuInfoMap[cpNode.Name]; ok {
-				var gpuInfo struct {
-					Gpu models2.Gpu `json:"gpu"`
-				}
-				if err = json.Unmarshal([]byte(gpu.String()), &gpuInfo); err != nil {
-					logs.GetLogger().Errorf("convert to json, nodeName %s, error: %+v", cpNode.Name, err)
-					continue
-				}
-				for _, detail := range gpuInfo.Gpu.Details {
+			if gpu, ok := nodeGpus[cpNode.Name]; ok {
+				for _, detail := range gpu.Details {
 					if err = k8sService.AddNodeLabel(cpNode.Name, detail.ProductName); err != nil {
 						logs.GetLogger().Errorf("add node label, nodeName %s, gpuName: %s, error: %+v", cpNode.Name, detail.ProductName, err)
 						continue
@@ -199,6 +200,7 @@ func RunSyncTask(nodeId string) {
 
 	watchExpiredTask()
 	watchNameSpaceForDeleted()
+	watchReservations()
 }
 
 func reportClusterResource(location, nodeId string) {
@@ -273,6 +275,9 @@ func watchExpiredTask() {
 						expireTimeStr := time.Unix(jobMetadata.ExpireTime, 0).Format("2006-01-02 15:04:05")
 						logs.GetLogger().Infof("<timer-task> redis-key: %s, namespace: %s,expireTime: %s. the job starting terminated", key, namespace, expireTimeStr)
 						if err = deleteJob(namespace, jobMetadata.SpaceUuid); err == nil {
+							deleteJobVolumes(namespace, jobMetadata.SpaceUuid)
+							deleteGatewayRoute(jobMetadata.SpaceUuid)
+							releaseHostNames(jobMetadata.SpaceUuid, spaceHosts(jobMetadata.Url, jobMetadata.Endpoints))
 							deleteKey = append(deleteKey, key)
 							continue
 						}
@@ -282,6 +287,7 @@ func watchExpiredTask() {
 					deployName := constants.K8S_DEPLOY_NAME_PREFIX + jobMetadata.SpaceUuid
 					service := NewK8sService()
 					if _, err = service.k8sClient.AppsV1().Deployments(k8sNameSpace).Get(context.TODO(), deployName, metaV1.GetOptions{}); err != nil && errors.IsNotFound(err) {
+						releaseHostNames(jobMetadata.SpaceUuid, spaceHosts(jobMetadata.Url, jobMetadata.Endpoints))
 						deleteKey = append(deleteKey, key)
 						continue
 					}
@@ -320,6 +326,10 @@ func watchNameSpaceForDeleted() {
 						continue
 					}
 					if !getPods && strings.HasPrefix(namespace, constants.K8S_NAMESPACE_NAME_PREFIX) {
+						// spaces scaled to zero have no pods until their next request
+						if scaledDown, err := service.HasScaledDownDeployments(context.TODO(), namespace); err != nil || scaledDown {
+							continue
+						}
 						if err = service.DeleteNameSpace(context.TODO(), namespace); err != nil {
 							logs.GetLogger().Errorf("Failed delete namespace, namepace: %s, error: %+v", namespace, err)
 						}
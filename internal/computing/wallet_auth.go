@@ -0,0 +1,160 @@
+package computing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/constants"
+)
+
+// Requests on behalf of the owner of a space are signed with the owner's wallet: X-Wallet-Address,
+// X-Timestamp (unix seconds), X-Nonce and X-Signature, the personal_sign signature of
+// "<METHOD> <path>[?<query>] <timestamp> <nonce> <hex sha256 of the body>", e.g.
+// "POST /api/v1/computing/lagrange/spaces/apikey 1700000000 f3a1c9 e3b0c442...". A nonce is accepted
+// once per wallet while the signature is valid.
+const (
+	walletHeader    = "X-Wallet-Address"
+	timestampHeader = "X-Timestamp"
+	nonceHeader     = "X-Nonce"
+	signatureHeader = "X-Signature"
+	signatureMaxAge = 5 * time.Minute
+	maxNonceLength  = 64
+)
+
+var (
+	errUnsignedRequest = errors.New("the request is not signed by a wallet")
+	errNotSpaceOwner   = errors.New("the wallet does not own the space")
+	errNotAdmin        = errors.New("the request has neither the provider token nor the signature of an admin wallet")
+)
+
+// signedWallet returns the wallet that signed the request.
+func signedWallet(c *gin.Context) (string, error) {
+	wallet := strings.TrimSpace(c.GetHeader(walletHeader))
+	timestamp := strings.TrimSpace(c.GetHeader(timestampHeader))
+	nonce := strings.TrimSpace(c.GetHeader(nonceHeader))
+	signature := strings.TrimSpace(c.GetHeader(signatureHeader))
+	if wallet == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", errUnsignedRequest
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid %s", errUnsignedRequest, timestampHeader)
+	}
+	if age := time.Since(time.Unix(signedAt, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+		return "", fmt.Errorf("%w: the signature expired", errUnsignedRequest)
+	}
+
+	if len(nonce) > maxNonceLength {
+		return "", fmt.Errorf("%w: %s is longer than %d characters", errUnsignedRequest, nonceHeader, maxNonceLength)
+	}
+
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return "", fmt.Errorf("%w: invalid %s", errUnsignedRequest, signatureHeader)
+	}
+	// wallets return v as 27/28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	bodyHash, err := requestBodyHash(c.Request)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsignedRequest, err)
+	}
+	target := c.Request.URL.Path
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+	message := fmt.Sprintf("%s %s %s %s %s", c.Request.Method, target, timestamp, nonce, bodyHash)
+	publicKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsignedRequest, err)
+	}
+	if signer := crypto.PubkeyToAddress(*publicKey).Hex(); !strings.EqualFold(signer, wallet) {
+		return "", fmt.Errorf("%w: the signature is not from %s", errUnsignedRequest, wallet)
+	}
+
+	// the nonce is kept until the signature expires, a replayed request is older than that or reuses it
+	expiresIn := time.Until(time.Unix(signedAt, 0).Add(signatureMaxAge))
+	conn := redisPool.Get()
+	defer conn.Close()
+	_, err = redis.String(conn.Do("SET", constants.REDIS_NONCE_PREFIX+strings.ToLower(wallet)+":"+nonce, signedAt,
+		"NX", "EX", int64(expiresIn/time.Second)+1))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("%w: the nonce was already used", errUnsignedRequest)
+	} else if err != nil {
+		return "", err
+	}
+	return wallet, nil
+}
+
+// requestBodyHash returns the hex sha256 of the body, which is kept for the handler.
+func requestBodyHash(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body.Close()
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// authorizeProvider checks that the request is made by the provider, with LAG.AccessToken as bearer
+// token or signed by one of API.AdminWallets. It returns the http status of the failure.
+func authorizeProvider(c *gin.Context) (int, error) {
+	token := conf.GetConfig().LAG.AccessToken
+	if authorization := c.GetHeader("Authorization"); strings.HasPrefix(authorization, "Bearer ") && token != "" &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))), []byte(token)) == 1 {
+		return http.StatusOK, nil
+	}
+
+	if c.GetHeader(signatureHeader) == "" {
+		return http.StatusUnauthorized, errNotAdmin
+	}
+	wallet, err := signedWallet(c)
+	if errors.Is(err, errUnsignedRequest) {
+		return http.StatusUnauthorized, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, admin := range conf.GetConfig().API.AdminWallets {
+		if strings.EqualFold(admin, wallet) {
+			return http.StatusOK, nil
+		}
+	}
+	return http.StatusForbidden, errNotAdmin
+}
+
+// authorizeSpaceOwner checks that the request is signed by owner, the wallet of a space. It returns
+// the http status of the failure.
+func authorizeSpaceOwner(c *gin.Context, owner string) (int, error) {
+	wallet, err := signedWallet(c)
+	if errors.Is(err, errUnsignedRequest) {
+		return http.StatusUnauthorized, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if owner == "" || !strings.EqualFold(wallet, owner) {
+		return http.StatusForbidden, errNotSpaceOwner
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/gin-gonic/gin"
+)
+
+// Paths are the OpenAI endpoints served by the gateway, relative to /v1.
+var Paths = []string{"/chat/completions", "/completions", "/embeddings"}
+
+const (
+	keyPrefix      = "sk-lag-"
+	maxRequestSize = 16 << 20
+)
+
+// ErrUnknownKey is returned by a Store for keys that belong to no space.
+var ErrUnknownKey = errors.New("unknown api key")
+
+// Route is the model space an api key gives access to.
+type Route struct {
+	SpaceUuid string
+	Model     string
+	Upstream  string
+}
+
+// Usage is what a space served, as reported by the usage of the responses.
+type Usage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// Store keeps the routes and the usage of the model spaces.
+type Store interface {
+	RouteByKey(keyHash string) (*Route, error)
+	AddUsage(spaceUuid string, usage Usage) error
+}
+
+// Gateway serves the OpenAI api of the model spaces: it authenticates the key of a space, checks
+// the model of the request, forwards it to the space and meters the usage.
+type Gateway struct {
+	store  Store
+	client *http.Client
+}
+
+func New(store Store) *Gateway {
+	return &Gateway{
+		store:  store,
+		client: &http.Client{},
+	}
+}
+
+// Register serves the gateway on router, which is mounted at /v1 like the OpenAI api.
+func (g *Gateway) Register(router *gin.RouterGroup) {
+	for _, path := range Paths {
+		router.POST(path, g.proxy)
+	}
+}
+
+// NewKey returns a new api key, only its hash is stored.
+func NewKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (g *Gateway) proxy(c *gin.Context) {
+	apiKey := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if !strings.HasPrefix(apiKey, keyPrefix) {
+		apiError(c, http.StatusUnauthorized, "invalid_api_key", "missing or malformed api key")
+		return
+	}
+	route, err := g.store.RouteByKey(HashKey(apiKey))
+	if errors.Is(err, ErrUnknownKey) {
+		apiError(c, http.StatusUnauthorized, "invalid_api_key", "incorrect api key provided")
+		return
+	} else if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRequestSize+1))
+	if err != nil || len(body) > maxRequestSize {
+		apiError(c, http.StatusBadRequest, "invalid_request", "the request body is unreadable or too large")
+		return
+	}
+	var request struct {
+		Model string `json:"model"`
+	}
+	if err = json.Unmarshal(body, &request); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_request", "the request body is not valid json")
+		return
+	}
+	if request.Model != route.Model {
+		apiError(c, http.StatusNotFound, "model_not_found", fmt.Sprintf("the model %s does not exist or you do not have access to it", request.Model))
+		return
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, strings.TrimRight(route.Upstream, "/")+c.FullPath(), bytes.NewReader(body))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	upstreamReq.Header.Set("Content-Type", "application/json")
+	if accept := c.GetHeader("Accept"); accept != "" {
+		upstreamReq.Header.Set("Accept", accept)
+	}
+
+	resp, err := g.client.Do(upstreamReq)
+	if err != nil {
+		apiError(c, http.StatusBadGateway, "upstream_error", "the model space is not reachable")
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Cache-Control"} {
+		if value := resp.Header.Get(header); value != "" {
+			c.Header(header, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+
+	var usage Usage
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		usage, err = relayEvents(c.Writer, resp.Body)
+	} else {
+		usage, err = relayBody(c.Writer, resp.Body)
+	}
+	if err != nil {
+		logs.GetLogger().Warnf("Gateway response of space %s is incomplete, error: %v", route.SpaceUuid, err)
+	}
+
+	if resp.StatusCode < http.StatusBadRequest {
+		usage.Requests = 1
+		if err = g.store.AddUsage(route.SpaceUuid, usage); err != nil {
+			logs.GetLogger().Errorf("Failed meter usage of space %s, error: %v", route.SpaceUuid, err)
+		}
+	}
+}
+
+type usageChunk struct {
+	Usage *struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+		TotalTokens      int64 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (u *Usage) read(data []byte) {
+	var chunk usageChunk
+	if json.Unmarshal(data, &chunk) != nil || chunk.Usage == nil {
+		return
+	}
+	u.PromptTokens = chunk.Usage.PromptTokens
+	u.CompletionTokens = chunk.Usage.CompletionTokens
+	u.TotalTokens = chunk.Usage.TotalTokens
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+}
+
+func relayBody(w http.ResponseWriter, body io.Reader) (Usage, error) {
+	var usage Usage
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return usage, err
+	}
+	usage.read(data)
+	_, err = w.Write(data)
+	return usage, err
+}
+
+// relayEvents passes the server-sent events through as they come. The usage is the one of the last
+// chunk reporting it, which streams only have when they are asked for with stream_options.
+func relayEvents(w http.ResponseWriter, body io.Reader) (Usage, error) {
+	var usage Usage
+	flusher, _ := w.(http.Flusher)
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, writeErr := w.Write(line); writeErr != nil {
+				return usage, writeErr
+			}
+			if data := bytes.TrimSpace(line); bytes.HasPrefix(data, []byte("data:")) {
+				usage.read(bytes.TrimSpace(data[len("data:"):]))
+			}
+			if flusher != nil && len(bytes.TrimSpace(line)) == 0 {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return usage, nil
+		} else if err != nil {
+			return usage, err
+		}
+	}
+}
+
+// apiError writes an error the way the OpenAI api does, which clients know how to show.
+func apiError(c *gin.Context, status int, code, message string) {
+	errorType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errorType = "api_error"
+	}
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errorType,
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,129 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// HardwareCollector finds the GPUs of the nodes, by node name. A node without GPU or unknown to
+// the collector is missing from the result.
+type HardwareCollector interface {
+	Name() string
+	Collect(ctx context.Context) (map[string]models.Gpu, error)
+}
+
+// FirstOf asks every collector in order, a node takes the GPUs of the first collector knowing it.
+// It fails only when all collectors fail.
+func FirstOf(collectors ...HardwareCollector) HardwareCollector {
+	return firstOf(collectors)
+}
+
+type firstOf []HardwareCollector
+
+func (f firstOf) Name() string {
+	var names []string
+	for _, collector := range f {
+		names = append(names, collector.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (f firstOf) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	result := make(map[string]models.Gpu)
+	var errs []string
+	for _, collector := range f {
+		gpus, err := collector.Collect(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", collector.Name(), err))
+			continue
+		}
+		for node, gpu := range gpus {
+			if _, ok := result[node]; !ok {
+				result[node] = gpu
+			}
+		}
+	}
+	if len(errs) == len(f) && len(f) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return result, nil
+}
+
+// Cache keeps the result of a collector for a TTL, so the consumers of a tick share one collection.
+// When a collection fails, the last result is served with the error.
+type Cache struct {
+	collector HardwareCollector
+	ttl       time.Duration
+
+	lock        sync.Mutex
+	gpus        map[string]models.Gpu
+	collectedAt time.Time
+}
+
+func NewCache(collector HardwareCollector, ttl time.Duration) *Cache {
+	return &Cache{collector: collector, ttl: ttl}
+}
+
+func (c *Cache) Name() string {
+	return c.collector.Name()
+}
+
+func (c *Cache) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.gpus != nil && time.Since(c.collectedAt) < c.ttl {
+		return c.gpus, nil
+	}
+	gpus, err := c.collector.Collect(ctx)
+	if err != nil {
+		return c.gpus, err
+	}
+	c.gpus, c.collectedAt = gpus, time.Now()
+	return gpus, nil
+}
+
+// podsByNode returns the running pods matching labelSelector by node name.
+func podsByNode(ctx context.Context, client kubernetes.Interface, namespace, labelSelector string) (map[string]coreV1.Pod, error) {
+	podList, err := client.CoreV1().Pods(namespace).List(ctx, metaV1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return nil, err
+	}
+	pods := make(map[string]coreV1.Pod)
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != "" && pod.Status.PodIP != "" {
+			pods[pod.Spec.NodeName] = pod
+		}
+	}
+	return pods, nil
+}
+
+func httpGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+}
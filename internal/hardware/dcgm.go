@@ -0,0 +1,188 @@
+package hardware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DcgmCollector reads the GPUs from the Prometheus metrics of the NVIDIA DCGM exporter pod of each node.
+type DcgmCollector struct {
+	Client        kubernetes.Interface
+	Namespace     string
+	LabelSelector string
+	Port          int
+	HTTPClient    *http.Client
+}
+
+func NewDcgmCollector(client kubernetes.Interface, namespace string) *DcgmCollector {
+	if namespace == "" {
+		namespace = "gpu-operator"
+	}
+	return &DcgmCollector{
+		Client:        client,
+		Namespace:     namespace,
+		LabelSelector: "app=nvidia-dcgm-exporter",
+		Port:          9400,
+		HTTPClient:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (d *DcgmCollector) Name() string {
+	return "dcgm"
+}
+
+func (d *DcgmCollector) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	pods, err := podsByNode(ctx, d.Client, d.Namespace, d.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]models.Gpu)
+	for node, pod := range pods {
+		body, err := httpGet(ctx, d.HTTPClient, fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, d.Port))
+		if err != nil {
+			logs.GetLogger().Errorf("collect gpu, nodeName: %s, please check dcgm-exporter pod status. error: %v", node, err)
+			continue
+		}
+		if gpu := ParseDcgm(body); len(gpu.Details) > 0 {
+			result[node] = gpu
+		}
+	}
+	return result, nil
+}
+
+type dcgmDevice struct {
+	index   int
+	model   string
+	fbUsed  int64
+	fbFree  int64
+	driver  string
+	hasUsed bool
+}
+
+// ParseDcgm reads the GPUs from the framebuffer metrics of dcgm-exporter, in the order of their index.
+// Lines it does not know are skipped.
+func ParseDcgm(body []byte) models.Gpu {
+	devices := make(map[string]*dcgmDevice)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		name, labels, value, ok := parseMetricLine(scanner.Text())
+		if !ok || (name != "DCGM_FI_DEV_FB_USED" && name != "DCGM_FI_DEV_FB_FREE") {
+			continue
+		}
+		id := labels["UUID"]
+		if id == "" {
+			id = labels["gpu"]
+		}
+		device, ok := devices[id]
+		if !ok {
+			index, _ := strconv.Atoi(labels["gpu"])
+			device = &dcgmDevice{index: index, model: labels["modelName"], driver: labels["DCGM_FI_DRIVER_VERSION"]}
+			devices[id] = device
+		}
+		if name == "DCGM_FI_DEV_FB_USED" {
+			device.fbUsed, device.hasUsed = int64(value), true
+		} else {
+			device.fbFree = int64(value)
+		}
+	}
+
+	var sorted []*dcgmDevice
+	for _, device := range devices {
+		if device.model != "" {
+			sorted = append(sorted, device)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].index < sorted[j].index })
+
+	var gpu models.Gpu
+	for _, device := range sorted {
+		if gpu.DriverVersion == "" {
+			gpu.DriverVersion = device.driver
+		}
+		gpu.Details = append(gpu.Details, models.GpuDetail{
+			ProductName: device.model,
+			FbMemoryUsage: models.Common{
+				Total: fmt.Sprintf("%d MiB", device.fbUsed+device.fbFree),
+				Used:  fmt.Sprintf("%d MiB", device.fbUsed),
+				Free:  fmt.Sprintf("%d MiB", device.fbFree),
+			},
+		})
+	}
+	gpu.AttachedGpus = len(gpu.Details)
+	return gpu
+}
+
+// parseMetricLine splits a line of the Prometheus text format, name{label="value",...} value.
+func parseMetricLine(line string) (string, map[string]string, float64, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, 0, false
+	}
+
+	labels := make(map[string]string)
+	name, rest := line, ""
+	if open := strings.IndexByte(line, '{'); open >= 0 {
+		end := strings.LastIndexByte(line, '}')
+		if end < open {
+			return "", nil, 0, false
+		}
+		name, rest = line[:open], line[end+1:]
+		for _, pair := range splitLabels(line[open+1 : end]) {
+			key, value, found := strings.Cut(pair, "=")
+			if !found {
+				continue
+			}
+			if unquoted, err := strconv.Unquote(strings.TrimSpace(value)); err == nil {
+				labels[strings.TrimSpace(key)] = unquoted
+			}
+		}
+	} else if space := strings.IndexByte(line, ' '); space >= 0 {
+		name, rest = line[:space], line[space:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	return name, labels, value, true
+}
+
+// splitLabels splits label pairs on the commas outside of quoted values.
+func splitLabels(s string) []string {
+	var pairs []string
+	var quoted, escaped bool
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			pairs = append(pairs, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		pairs = append(pairs, s[start:])
+	}
+	return pairs
+}
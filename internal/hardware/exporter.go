@@ -0,0 +1,74 @@
+package hardware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExporterCollector reads the GPUs from the HTTP endpoint of the resource-exporter pod of each node.
+type ExporterCollector struct {
+	Client        kubernetes.Interface
+	Namespace     string
+	LabelSelector string
+	Port          int
+	Path          string
+	HTTPClient    *http.Client
+}
+
+func NewExporterCollector(client kubernetes.Interface, port int, path string) *ExporterCollector {
+	return &ExporterCollector{
+		Client:        client,
+		Namespace:     "kube-system",
+		LabelSelector: "app=resource-exporter",
+		Port:          port,
+		Path:          path,
+		HTTPClient:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (e *ExporterCollector) Name() string {
+	return "exporter"
+}
+
+func (e *ExporterCollector) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	pods, err := podsByNode(ctx, e.Client, e.Namespace, e.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]models.Gpu)
+	for node, pod := range pods {
+		body, err := httpGet(ctx, e.HTTPClient, fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, e.Port, e.Path))
+		if err != nil {
+			logs.GetLogger().Errorf("collect gpu, nodeName: %s, please check resource-exporter pod status. error: %v", node, err)
+			continue
+		}
+		gpu, err := ParseExporter(body)
+		if err != nil {
+			logs.GetLogger().Errorf("collect gpu, nodeName: %s, error: %v", node, err)
+			continue
+		}
+		if len(gpu.Details) > 0 {
+			result[node] = gpu
+		}
+	}
+	return result, nil
+}
+
+// ParseExporter reads the node resource reported by resource-exporter, {"gpu": {...}, ...}.
+func ParseExporter(body []byte) (models.Gpu, error) {
+	var resource struct {
+		Gpu models.Gpu `json:"gpu"`
+	}
+	if err := json.Unmarshal(body, &resource); err != nil {
+		return models.Gpu{}, fmt.Errorf("invalid resource-exporter response: %w", err)
+	}
+	return resource.Gpu, nil
+}
@@ -0,0 +1,92 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	gpuResourceName    = "nvidia.com/gpu"
+	gpuProductLabel    = "nvidia.com/gpu.product"
+	gpuMemoryLabel     = "nvidia.com/gpu.memory"
+	cudaDriverLabel    = "nvidia.com/cuda.driver"
+	cudaRuntimeLabel   = "nvidia.com/cuda.runtime"
+	gpuProductFallback = "NVIDIA-GPU"
+)
+
+// LabelCollector reads the GPUs from the nodes: their count from the extended resource of the device
+// plugin, their model and memory from the labels of GPU feature discovery. It knows no memory usage.
+type LabelCollector struct {
+	Client kubernetes.Interface
+}
+
+func NewLabelCollector(client kubernetes.Interface) *LabelCollector {
+	return &LabelCollector{Client: client}
+}
+
+func (l *LabelCollector) Name() string {
+	return "labels"
+}
+
+func (l *LabelCollector) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	nodes, err := l.Client.CoreV1().Nodes().List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]models.Gpu)
+	for i := range nodes.Items {
+		if gpu := NodeGpu(&nodes.Items[i]); len(gpu.Details) > 0 {
+			result[nodes.Items[i].Name] = gpu
+		}
+	}
+	return result, nil
+}
+
+// NodeGpu reads the GPUs of a node from its capacity and labels.
+func NodeGpu(node *coreV1.Node) models.Gpu {
+	var gpu models.Gpu
+	quantity, ok := node.Status.Capacity[gpuResourceName]
+	if !ok || quantity.Value() <= 0 {
+		return gpu
+	}
+
+	product := node.Labels[gpuProductLabel]
+	if product == "" {
+		product = gpuProductFallback
+	}
+	// the labels of feature discovery have dashes for the spaces of the model name
+	product = strings.ReplaceAll(product, "-", " ")
+
+	var memory models.Common
+	if total := node.Labels[gpuMemoryLabel]; total != "" {
+		memory.Total = total + " MiB"
+	}
+	gpu.DriverVersion = labelVersion(node.Labels, cudaDriverLabel)
+	gpu.CudaVersion = labelVersion(node.Labels, cudaRuntimeLabel)
+	for i := int64(0); i < quantity.Value(); i++ {
+		gpu.Details = append(gpu.Details, models.GpuDetail{ProductName: product, FbMemoryUsage: memory})
+	}
+	gpu.AttachedGpus = len(gpu.Details)
+	return gpu
+}
+
+func labelVersion(labels map[string]string, prefix string) string {
+	major, minor := labels[prefix+".major"], labels[prefix+".minor"]
+	if major == "" {
+		return ""
+	}
+	version := major
+	if minor != "" {
+		version = fmt.Sprintf("%s.%s", major, minor)
+	}
+	if rev := labels[prefix+".rev"]; rev != "" {
+		version += "." + rev
+	}
+	return version
+}
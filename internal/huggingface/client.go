@@ -0,0 +1,120 @@
+package huggingface
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultEndpoint = "https://huggingface.co"
+
+// ErrModelNotFound is returned for models that do not exist or are not visible with the token.
+var ErrModelNotFound = errors.New("model not found")
+
+// Client reads model metadata from the Hugging Face Hub API, or any server serving the same API.
+type Client struct {
+	Endpoint   string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func NewClient(endpoint, token string) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &Client{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ModelInfo is what an inference space needs to know about a model.
+type ModelInfo struct {
+	ModelId   string
+	Task      string
+	Framework string
+	Tags      []string
+}
+
+type modelResponse struct {
+	Id          string   `json:"id"`
+	ModelId     string   `json:"modelId"`
+	PipelineTag string   `json:"pipeline_tag"`
+	LibraryName string   `json:"library_name"`
+	Tags        []string `json:"tags"`
+}
+
+// ModelInfo returns the task and the framework of modelId. The framework is the library of the
+// model, models without one are taken for transformers models when tagged so.
+func (c *Client) ModelInfo(ctx context.Context, modelId string) (*ModelInfo, error) {
+	modelId = strings.Trim(modelId, "/ ")
+	if modelId == "" {
+		return nil, fmt.Errorf("empty model id")
+	}
+	var parts []string
+	for _, part := range strings.Split(modelId, "/") {
+		parts = append(parts, url.PathEscape(part))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Endpoint+"/api/models/"+strings.Join(parts, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed get model %s, error: %w", modelId, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelId)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed get model %s, status: %s", modelId, resp.Status)
+	}
+
+	var model modelResponse
+	if err = json.NewDecoder(resp.Body).Decode(&model); err != nil {
+		return nil, fmt.Errorf("failed decode model %s, error: %w", modelId, err)
+	}
+
+	info := &ModelInfo{
+		ModelId:   model.Id,
+		Task:      model.PipelineTag,
+		Framework: model.LibraryName,
+		Tags:      model.Tags,
+	}
+	if info.ModelId == "" {
+		info.ModelId = model.ModelId
+	}
+	if info.ModelId == "" {
+		info.ModelId = modelId
+	}
+	if info.Framework == "" {
+		for _, tag := range model.Tags {
+			if tag == "transformers" {
+				info.Framework = tag
+			}
+		}
+	}
+	if info.Task == "" {
+		return nil, fmt.Errorf("model %s has no pipeline tag, the inference task is unknown", modelId)
+	}
+	if info.Framework == "" {
+		return nil, fmt.Errorf("model %s has no library, the inference framework is unknown", modelId)
+	}
+	return info, nil
+}
@@ -0,0 +1,135 @@
+package inference
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+)
+
+const (
+	AcceleratorCpu = "cpu"
+	AcceleratorGpu = "gpu"
+)
+
+// Accelerator names the accelerator of an order: its GPU model, or cpu without GPU.
+func Accelerator(gpuQuantity int64, gpuModel string) string {
+	if gpuQuantity <= 0 {
+		return AcceleratorCpu
+	}
+	if gpuModel == "" {
+		return AcceleratorGpu
+	}
+	return gpuModel
+}
+
+// Catalog is the runtime catalog of the inference spaces and the nodes having its images warm.
+type Catalog struct {
+	runtimes []conf.Runtime
+
+	mu        sync.RWMutex
+	warmNodes map[string][]string
+}
+
+// NewCatalog checks that every runtime names a framework and that its image is pinned to a version.
+func NewCatalog(runtimes []conf.Runtime) (*Catalog, error) {
+	for i, runtime := range runtimes {
+		if runtime.Framework == "" {
+			return nil, fmt.Errorf("runtime %d has no framework", i)
+		}
+		if runtime.Image != "" && !versionedImage(runtime.Image) {
+			return nil, fmt.Errorf("runtime %d: image %s has no version, pin a tag other than latest or a digest", i, runtime.Image)
+		}
+	}
+	return &Catalog{runtimes: runtimes, warmNodes: make(map[string][]string)}, nil
+}
+
+func versionedImage(image string) bool {
+	if strings.Contains(image, "@sha256:") {
+		return true
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	_, tag, found := strings.Cut(name, ":")
+	return found && tag != "" && tag != "latest"
+}
+
+func (c *Catalog) Runtimes() []conf.Runtime {
+	return c.runtimes
+}
+
+// Lookup returns the most specific runtime of framework for task on accelerator. A runtime of the
+// task wins over one for any task, a runtime of the GPU model over one for any GPU.
+func (c *Catalog) Lookup(framework, task, accelerator string) (conf.Runtime, bool) {
+	best, bestScore := conf.Runtime{}, -1
+	for _, runtime := range c.runtimes {
+		if !strings.EqualFold(runtime.Framework, framework) {
+			continue
+		}
+		score := 0
+		switch {
+		case runtime.Task == "":
+		case strings.EqualFold(runtime.Task, task):
+			score += 4
+		default:
+			continue
+		}
+		switch {
+		case runtime.Accelerator == "":
+		case strings.EqualFold(runtime.Accelerator, accelerator):
+			score += 2
+		case strings.EqualFold(runtime.Accelerator, AcceleratorGpu) && !strings.EqualFold(accelerator, AcceleratorCpu):
+			score += 1
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = runtime, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// SetWarm records which nodes have the runtime images, nodeImages holds the images of each node.
+func (c *Catalog) SetWarm(images []string, nodeImages map[string][]string) {
+	warmNodes := make(map[string][]string)
+	for _, image := range images {
+		var nodes []string
+		for node, present := range nodeImages {
+			for _, nodeImage := range present {
+				if sameImage(nodeImage, image) {
+					nodes = append(nodes, node)
+					break
+				}
+			}
+		}
+		sort.Strings(nodes)
+		warmNodes[image] = nodes
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warmNodes = warmNodes
+}
+
+// WarmNodes returns the nodes that have image, a space on them starts without pulling it.
+func (c *Catalog) WarmNodes(image string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.warmNodes[image]
+}
+
+// sameImage compares the image names of the container runtime, which are fully qualified, with
+// the possibly short image names of the config.
+func sameImage(nodeImage, image string) bool {
+	if nodeImage == image {
+		return true
+	}
+	for _, prefix := range []string{"docker.io/", "docker.io/library/"} {
+		if nodeImage == prefix+image {
+			return true
+		}
+	}
+	return false
+}
@@ -5,12 +5,17 @@ import (
 	"github.com/lagrangedao/go-computing-provider/internal/computing"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/filswan/go-swan-lib/logs"
 	"github.com/lagrangedao/go-computing-provider/conf"
 	"github.com/lagrangedao/go-computing-provider/constants"
+	"github.com/lagrangedao/go-computing-provider/util"
 )
 
 func sendHeartbeat(nodeId string) {
@@ -53,16 +58,77 @@ func sendHeartbeats(nodeId string) {
 		sendHeartbeat(nodeId)
 	}
 }
+
+// watchConfig reloads the config on SIGHUP and when config.toml changes.
+func watchConfig(cpRepoPath string) {
+	reload := func(trigger string) {
+		kept, err := conf.Reload(cpRepoPath)
+		if err != nil {
+			logs.GetLogger().Errorf("The config is not reloaded on %s, error: %v", trigger, err)
+			return
+		}
+		logs.GetLogger().Infof("Reloaded the config on %s", trigger)
+		if len(kept) > 0 {
+			logs.GetLogger().Warnf("%s changed, it takes effect after a restart", strings.Join(kept, ", "))
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			reload("SIGHUP")
+		}
+	}()
+	util.WatchFile(filepath.Join(cpRepoPath, "config.toml"), 5*time.Second, func() {
+		reload("file change")
+	})
+	// a rotated secret of a *_FILE variable is applied too
+	for _, secretFile := range conf.SecretFiles() {
+		util.WatchFile(secretFile, 5*time.Second, func() {
+			reload("secret change")
+		})
+	}
+}
+
 func ProjectInit(cpRepoPath string) {
 	if err := conf.InitConfig(cpRepoPath); err != nil {
 		logs.GetLogger().Fatal(err)
 	}
+	watchConfig(cpRepoPath)
+	if conf.GetConfig().ACME.Enable {
+		certService, err := computing.NewCertService(cpRepoPath)
+		if err != nil {
+			logs.GetLogger().Fatal(err)
+		}
+		go certService.Run()
+	}
+
+	if err := computing.LoadBidPolicy(cpRepoPath); err != nil {
+		logs.GetLogger().Fatal(err)
+	}
+
 	nodeID := computing.InitComputingProvider(cpRepoPath)
 	// Start sending heartbeats
 	go sendHeartbeats(nodeID)
 
 	go computing.NewScheduleTask().Run()
 
+	if runtimeWarmer, err := computing.NewRuntimeWarmer(); err != nil {
+		logs.GetLogger().Errorf("Inference runtimes are not warmed, error: %v", err)
+	} else {
+		go runtimeWarmer.Run()
+	}
+
+	if conf.GetConfig().ScaleToZero.Enable {
+		idleScaler, err := computing.NewIdleScaler()
+		if err != nil {
+			logs.GetLogger().Fatal(err)
+		}
+		go idleScaler.Run()
+	}
+
+	computing.IndexHostNames()
 	computing.RunSyncTask(nodeID)
 	celeryService := computing.NewCeleryService()
 	celeryService.RegisterTask(constants.TASK_DEPLOY, computing.DeploySpaceTask)
@@ -0,0 +1,209 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sYaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Object is a decoded Kubernetes object of a space manifest.
+type Object struct {
+	Kind   string
+	Source string
+	Object runtime.Object
+}
+
+// Name returns the metadata name of the object.
+func (o Object) Name() string {
+	if accessor, ok := o.Object.(interface{ GetName() string }); ok {
+		return accessor.GetName()
+	}
+	return ""
+}
+
+type kindInfo struct {
+	gvr   schema.GroupVersionResource
+	order int
+}
+
+// supportedKinds are the kinds that can be applied, in the order they are applied.
+var supportedKinds = map[string]kindInfo{
+	"ConfigMap":             {schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, 0},
+	"Secret":                {schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, 1},
+	"PersistentVolumeClaim": {schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, 2},
+	"Service":               {schema.GroupVersionResource{Version: "v1", Resource: "services"}, 3},
+	"Deployment":            {schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, 4},
+	"StatefulSet":           {schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, 5},
+	"Job":                   {schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, 6},
+	"CronJob":               {schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, 7},
+}
+
+// SupportedKinds returns every kind a manifest may contain, further limited by Policy.Kinds.
+func SupportedKinds() []string {
+	var kinds []string
+	for kind := range supportedKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return supportedKinds[kinds[i]].order < supportedKinds[kinds[j]].order })
+	return kinds
+}
+
+// GroupVersionResource returns the API resource of a supported kind.
+func GroupVersionResource(kind string) (schema.GroupVersionResource, bool) {
+	info, ok := supportedKinds[kind]
+	return info.gvr, ok
+}
+
+// LoadFiles decodes the objects of multi-document YAML files.
+func LoadFiles(paths []string) ([]Object, error) {
+	var objects []Object
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed read manifest %s, %w", path, err)
+		}
+		decoded, err := Decode(data, filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// Decode reads every document of data, source names it in errors.
+func Decode(data []byte, source string) ([]Object, error) {
+	var objects []Object
+	decoder := k8sYaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+
+		obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			if gvk != nil && runtime.IsNotRegisteredError(err) {
+				return nil, fmt.Errorf("%s: kind %s is not supported", source, gvk.Kind)
+			}
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if info, ok := supportedKinds[gvk.Kind]; ok && gvk.GroupVersion() != info.gvr.GroupVersion() {
+			return nil, fmt.Errorf("%s: %s of %s is not supported, use %s", source, gvk.Kind, gvk.GroupVersion(), info.gvr.GroupVersion())
+		}
+		objects = append(objects, Object{Kind: gvk.Kind, Source: source, Object: obj})
+	}
+	return objects, nil
+}
+
+// RenderChart renders the Helm chart in chartDir as a fresh install into namespace. values are merged
+// over the values.yaml of the chart.
+func RenderChart(chartDir, releaseName, namespace string, values map[string]interface{}) ([]Object, error) {
+	chart, err := loader.Load(chartDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed load helm chart, %w", err)
+	}
+	if err = chartutil.ProcessDependencies(chart, values); err != nil {
+		return nil, fmt.Errorf("failed process helm chart dependencies, %w", err)
+	}
+
+	renderValues, err := chartutil.ToRenderValues(chart, values, chartutil.ReleaseOptions{
+		Name:      releaseName,
+		Namespace: namespace,
+		Revision:  1,
+		IsInstall: true,
+	}, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed build helm values, %w", err)
+	}
+	rendered, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed render helm chart, %w", err)
+	}
+
+	var names []string
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objects []Object
+	for _, name := range names {
+		base := filepath.Base(name)
+		if strings.HasPrefix(base, "_") || base == "NOTES.txt" || strings.Contains(name, "/templates/tests/") {
+			continue
+		}
+		decoded, err := Decode([]byte(rendered[name]), name)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// Sort orders objects so that configuration and services exist before the workloads using them.
+func Sort(objects []Object) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return supportedKinds[objects[i].Kind].order < supportedKinds[objects[j].Kind].order
+	})
+}
+
+// ToUnstructured converts a supported object for the dynamic client.
+func ToUnstructured(o Object) (*unstructured.Unstructured, error) {
+	info, ok := supportedKinds[o.Kind]
+	if !ok {
+		return nil, fmt.Errorf("kind %s is not supported", o.Kind)
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o.Object)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetAPIVersion(info.gvr.GroupVersion().String())
+	obj.SetKind(o.Kind)
+	delete(obj.Object, "status")
+	return obj, nil
+}
+
+// FirstService returns the name and first port of the first Service, which is served at the space hostname.
+func FirstService(objects []Object) (string, int32, bool) {
+	for _, o := range objects {
+		if service, ok := o.Object.(*coreV1.Service); ok && len(service.Spec.Ports) > 0 {
+			return service.Name, service.Spec.Ports[0].Port, true
+		}
+	}
+	return "", 0, false
+}
+
+// FirstDeployment returns the name of the first Deployment, used to find the logs of the space.
+func FirstDeployment(objects []Object) string {
+	for _, o := range objects {
+		if deployment, ok := o.Object.(*appV1.Deployment); ok {
+			return deployment.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,250 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	appV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const gpuResourceName coreV1.ResourceName = "nvidia.com/gpu"
+
+// Policy is what a manifest must obey before it is applied. Namespace and Labels are forced onto
+// every object, NodeSelector onto every pod template, Limits is the hardware of the order shared by
+// all containers.
+type Policy struct {
+	Namespace    string
+	Labels       map[string]string
+	NodeSelector map[string]string
+	Kinds        []string
+	Limits       coreV1.ResourceList
+}
+
+// Violation is an object that breaks the policy.
+type Violation struct {
+	Kind    string
+	Name    string
+	Message string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s %s: %s", v.Kind, v.Name, v.Message)
+}
+
+// Violations holds every violation of a manifest.
+type Violations []Violation
+
+func (vs Violations) Error() string {
+	var lines []string
+	for _, v := range vs {
+		lines = append(lines, v.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type podTemplate struct {
+	object   Object
+	meta     *metaV1.ObjectMeta
+	spec     *coreV1.PodSpec
+	replicas int64
+}
+
+// Enforce checks objects against the policy and rewrites them in place: the namespace, the labels and
+// the resource limits of every container. Containers without a limit share what the others leave.
+func (p Policy) Enforce(objects []Object) error {
+	var violations Violations
+	violate := func(o Object, format string, args ...interface{}) {
+		violations = append(violations, Violation{Kind: o.Kind, Name: o.Name(), Message: fmt.Sprintf(format, args...)})
+	}
+
+	allowed := make(map[string]bool)
+	for _, kind := range p.Kinds {
+		allowed[kind] = true
+	}
+	if len(allowed) == 0 {
+		for _, kind := range SupportedKinds() {
+			allowed[kind] = true
+		}
+	}
+
+	var templates []podTemplate
+	for _, o := range objects {
+		if _, ok := supportedKinds[o.Kind]; !ok || !allowed[o.Kind] {
+			violate(o, "kind is not allowed")
+			continue
+		}
+
+		meta := objectMeta(o)
+		meta.Namespace = p.Namespace
+		meta.Labels = withLabels(meta.Labels, p.Labels)
+
+		switch obj := o.Object.(type) {
+		case *appV1.Deployment:
+			templates = append(templates, podTemplate{o, &obj.Spec.Template.ObjectMeta, &obj.Spec.Template.Spec, replicas(obj.Spec.Replicas)})
+		case *appV1.StatefulSet:
+			templates = append(templates, podTemplate{o, &obj.Spec.Template.ObjectMeta, &obj.Spec.Template.Spec, replicas(obj.Spec.Replicas)})
+			for i := range obj.Spec.VolumeClaimTemplates {
+				claim := &obj.Spec.VolumeClaimTemplates[i]
+				claim.Labels = withLabels(claim.Labels, p.Labels)
+			}
+		case *batchV1.Job:
+			templates = append(templates, podTemplate{o, &obj.Spec.Template.ObjectMeta, &obj.Spec.Template.Spec, replicas(obj.Spec.Parallelism)})
+		case *batchV1.CronJob:
+			jobTemplate := &obj.Spec.JobTemplate
+			jobTemplate.Labels = withLabels(jobTemplate.Labels, p.Labels)
+			templates = append(templates, podTemplate{o, &jobTemplate.Spec.Template.ObjectMeta, &jobTemplate.Spec.Template.Spec, replicas(jobTemplate.Spec.Parallelism)})
+		case *coreV1.Service:
+			if obj.Spec.Type != "" && obj.Spec.Type != coreV1.ServiceTypeClusterIP {
+				violate(o, "service type %s is not allowed, ports are published through expose", obj.Spec.Type)
+			}
+			if len(obj.Spec.ExternalIPs) > 0 {
+				violate(o, "externalIPs are not allowed")
+			}
+		}
+	}
+
+	for _, t := range templates {
+		t.meta.Labels = withLabels(t.meta.Labels, p.Labels)
+		if len(p.NodeSelector) > 0 {
+			t.spec.NodeSelector = withLabels(t.spec.NodeSelector, p.NodeSelector)
+		}
+		if t.spec.HostNetwork || t.spec.HostPID || t.spec.HostIPC {
+			violate(t.object, "host namespaces are not allowed")
+		}
+		for _, volume := range t.spec.Volumes {
+			if volume.HostPath != nil {
+				violate(t.object, "hostPath volume %s is not allowed", volume.Name)
+			}
+		}
+		for _, container := range allContainers(t.spec) {
+			if sc := container.SecurityContext; sc != nil && sc.Privileged != nil && *sc.Privileged {
+				violate(t.object, "privileged container %s is not allowed", container.Name)
+			}
+			for _, port := range container.Ports {
+				if port.HostPort != 0 {
+					violate(t.object, "hostPort %d of container %s is not allowed, ports are published through expose", port.HostPort, container.Name)
+				}
+			}
+		}
+	}
+
+	for name, ordered := range p.Limits {
+		if err := p.enforceLimit(templates, name, ordered); err != nil {
+			violations = append(violations, *err)
+		}
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// enforceLimit gives every container a limit of the resource name, requests are set to the limits.
+func (p Policy) enforceLimit(templates []podTemplate, name coreV1.ResourceName, ordered resource.Quantity) *Violation {
+	value := func(q resource.Quantity) int64 {
+		if name == coreV1.ResourceCPU {
+			return q.MilliValue()
+		}
+		return q.Value()
+	}
+
+	remaining := value(ordered)
+	var unlimited int64
+	for _, t := range templates {
+		for _, container := range allContainers(t.spec) {
+			if limit, ok := container.Resources.Limits[name]; ok {
+				remaining -= value(limit) * t.replicas
+			} else {
+				unlimited += t.replicas
+			}
+		}
+	}
+	if remaining < 0 {
+		return &Violation{Kind: "manifest", Message: fmt.Sprintf("containers ask for more %s than the ordered %s", name, ordered.String())}
+	}
+
+	// GPUs are only given to the containers asking for them
+	var share *resource.Quantity
+	if unlimited > 0 && name != gpuResourceName {
+		if remaining/unlimited == 0 {
+			return &Violation{Kind: "manifest", Message: fmt.Sprintf("no %s left for the containers without a limit", name)}
+		}
+		if name == coreV1.ResourceCPU {
+			share = resource.NewMilliQuantity(remaining/unlimited, resource.DecimalSI)
+		} else {
+			share = resource.NewQuantity(remaining/unlimited, resource.BinarySI)
+		}
+	}
+
+	for _, t := range templates {
+		for _, containers := range [][]coreV1.Container{t.spec.InitContainers, t.spec.Containers} {
+			for i := range containers {
+				resources := &containers[i].Resources
+				if resources.Limits == nil {
+					resources.Limits = make(coreV1.ResourceList)
+				}
+				if resources.Requests == nil {
+					resources.Requests = make(coreV1.ResourceList)
+				}
+				if _, ok := resources.Limits[name]; !ok {
+					if share == nil {
+						continue
+					}
+					resources.Limits[name] = share.DeepCopy()
+				}
+				resources.Requests[name] = resources.Limits[name].DeepCopy()
+			}
+		}
+	}
+	return nil
+}
+
+func objectMeta(o Object) *metaV1.ObjectMeta {
+	switch obj := o.Object.(type) {
+	case *appV1.Deployment:
+		return &obj.ObjectMeta
+	case *appV1.StatefulSet:
+		return &obj.ObjectMeta
+	case *batchV1.Job:
+		return &obj.ObjectMeta
+	case *batchV1.CronJob:
+		return &obj.ObjectMeta
+	case *coreV1.Service:
+		return &obj.ObjectMeta
+	case *coreV1.ConfigMap:
+		return &obj.ObjectMeta
+	case *coreV1.Secret:
+		return &obj.ObjectMeta
+	case *coreV1.PersistentVolumeClaim:
+		return &obj.ObjectMeta
+	}
+	return &metaV1.ObjectMeta{}
+}
+
+func withLabels(labels, forced map[string]string) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for k, v := range forced {
+		labels[k] = v
+	}
+	return labels
+}
+
+func allContainers(spec *coreV1.PodSpec) []coreV1.Container {
+	containers := make([]coreV1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	return append(containers, spec.Containers...)
+}
+
+func replicas(count *int32) int64 {
+	if count == nil {
+		return 1
+	}
+	return int64(*count)
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type BidStatus string
 
 const (
@@ -12,12 +14,13 @@ const (
 )
 
 type ComputingProvider struct {
-	Name          string `json:"name"`
-	NodeId        string `json:"node_id"`
-	MultiAddress  string `json:"multi_address"`
-	Autobid       int    `json:"autobid"`
-	WalletAddress int    `json:"wallet_address"`
-	Status        string `json:"status"`
+	Name          string    `json:"name"`
+	NodeId        string    `json:"node_id"`
+	MultiAddress  string    `json:"multi_address"`
+	Autobid       int       `json:"autobid"`
+	BidStatus     BidStatus `json:"bid_status"`
+	WalletAddress int       `json:"wallet_address"`
+	Status        string    `json:"status"`
 }
 
 type JobData struct {
@@ -34,13 +37,28 @@ type JobData struct {
 	UpdatedAt     string `json:"updated_at"`
 	BuildLog      string `json:"build_log"`
 	ContainerLog  string `json:"container_log"`
+	// PricePerHour is the price of the order per hour, for the minimum price of the bid policy. The
+	// platform doesn't send it yet, without it the minimum price is not checked.
+	PricePerHour float64 `json:"price_per_hour,omitempty"`
+}
+
+// Endpoint is a public address of a space port that is not served by the Ingress.
+type Endpoint struct {
+	Service       string `json:"service,omitempty"`
+	Protocol      string `json:"protocol"`
+	ContainerPort int32  `json:"container_port"`
+	PublicPort    int32  `json:"public_port"`
+	Address       string `json:"address"`
 }
 
 type Job struct {
-	Uuid   string
-	Status JobStatus
-	Url    string
-	Count  int
+	Uuid      string
+	Status    JobStatus
+	Url       string
+	Count     int
+	Endpoints []Endpoint
+	Progress  string
+	ResultUri string
 }
 
 type JobStatus string
@@ -52,8 +70,66 @@ const (
 	JobPushImage      JobStatus = "pushImage"      // push image to registry
 	JobPullImage      JobStatus = "pullImage"      // download file form job_resource_uri
 	JobDeployToK8s    JobStatus = "deployToK8s"    // deploy image to k8s
+	JobDeployFailed   JobStatus = "deployFailed"   // the space could not be deployed, its resources are released
+
+	JobDownloadModel       JobStatus = "downloadModel"       // fetch the models of deploy.yaml, with progress
+	JobDownloadModelFailed JobStatus = "downloadModelFailed" // a model could not be fetched or verified
+
+	JobBatchPending    JobStatus = "batchPending"    // the batch job is created, waiting for its pod
+	JobBatchRunning    JobStatus = "batchRunning"    // the container of the batch job runs, with the attempt as progress
+	JobBatchCollecting JobStatus = "batchCollecting" // the output of the batch job is uploaded
+	JobBatchSucceeded  JobStatus = "batchSucceeded"  // the output is stored, its uri is the job result
+	JobBatchFailed     JobStatus = "batchFailed"     // the batch job failed or timed out, the reason is the progress
 )
 
+// BatchJobReq runs a container to completion, the files it writes to /output are the result of the job.
+type BatchJobReq struct {
+	UUID          string            `json:"uuid"`
+	WalletAddress string            `json:"wallet_address"`
+	Image         string            `json:"image"`
+	Command       []string          `json:"command"`
+	Args          []string          `json:"args"`
+	Env           map[string]string `json:"env"`
+	Hardware      string            `json:"hardware"`
+	Timeout       int64             `json:"timeout"`
+	Retries       int32             `json:"retries"`
+}
+
+type BatchJobStatus struct {
+	UUID         string    `json:"uuid"`
+	Status       JobStatus `json:"status"`
+	Attempts     int32     `json:"attempts"`
+	Reason       string    `json:"reason,omitempty"`
+	ResultCid    string    `json:"result_cid,omitempty"`
+	JobResultURI string    `json:"job_result_uri,omitempty"`
+}
+
+// ProofReq asks for a proof of the provider. Params are the parameters of the method, the mine
+// method also takes them from BlockData and Exp.
+type ProofReq struct {
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	BlockData string          `json:"block_data"`
+	Exp       int64           `json:"exp"`
+}
+
+type ProofStatus string
+
+const (
+	ProofPending   ProofStatus = "pending"
+	ProofRunning   ProofStatus = "running"
+	ProofSucceeded ProofStatus = "succeeded"
+	ProofFailed    ProofStatus = "failed"
+)
+
+type ProofTask struct {
+	TaskId string          `json:"task_id"`
+	Method string          `json:"method"`
+	Status ProofStatus     `json:"status"`
+	Reason string          `json:"reason,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
 type DeleteJobReq struct {
 	CreatorWallet string `json:"creator_wallet"`
 	SpaceName     string `json:"space_name"`
@@ -112,4 +188,6 @@ type CacheSpaceDetail struct {
 	DeployName    string
 	Hardware      string
 	Url           string
+	CustomDomains []string
+	Endpoints     []Endpoint
 }
@@ -6,3 +6,19 @@ type HostInfo struct {
 	Architecture        string `json:"architecture"`
 	CPUCores            int    `json:"cpu_cores"`
 }
+
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// CheckResult is the outcome of a check of a dependency of the provider, Hint tells how to fix it.
+type CheckResult struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+	Hint    string      `json:"hint,omitempty"`
+}
@@ -0,0 +1,9 @@
+package models
+
+type RuntimeStatus struct {
+	Framework   string   `json:"framework"`
+	Task        string   `json:"task"`
+	Accelerator string   `json:"accelerator"`
+	Image       string   `json:"image"`
+	WarmNodes   []string `json:"warm_nodes"`
+}
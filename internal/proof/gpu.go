@@ -0,0 +1,191 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minNonceLength = 16
+
+// gpuBenchmarkScript lists the GPUs of the container and measures their fp16 matmul throughput.
+// The nonce of the task is echoed, so the result belongs to this run.
+const gpuBenchmarkScript = `import json, os, subprocess, time, torch
+out = subprocess.check_output(["nvidia-smi", "--query-gpu=uuid,name", "--format=csv,noheader"]).decode()
+devices = [{"uuid": u.strip(), "name": n.strip()} for u, n in (line.split(",", 1) for line in out.strip().splitlines())]
+n, iterations = int(os.environ["MATRIX_SIZE"]), int(os.environ["ITERATIONS"])
+a = torch.randn(n, n, device="cuda", dtype=torch.float16)
+b = torch.randn(n, n, device="cuda", dtype=torch.float16)
+for _ in range(3):
+    a @ b
+torch.cuda.synchronize()
+start = time.time()
+for _ in range(iterations):
+    a @ b
+torch.cuda.synchronize()
+elapsed = time.time() - start
+print(json.dumps({"devices": devices, "tflops": 2 * n ** 3 * iterations / elapsed / 1e12, "nonce": os.environ["NONCE"]}))
+`
+
+// GpuParams asks for an attestation of a GPU model. Nonce is chosen by the platform, so an old
+// attestation can't be replayed.
+type GpuParams struct {
+	GpuModel string `json:"gpu_model"`
+	Nonce    string `json:"nonce"`
+}
+
+type GpuDevice struct {
+	Uuid string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// GpuBenchmark is the last line of the benchmark output.
+type GpuBenchmark struct {
+	Devices []GpuDevice `json:"devices"`
+	Tflops  float64     `json:"tflops"`
+	Nonce   string      `json:"nonce"`
+}
+
+// GpuAttestation is the signed result of the gpu method. Hash binds the nonce to the node, the GPU
+// model, the device uuids and the throughput, Signature is the signature of Hash by the node key.
+type GpuAttestation struct {
+	NodeId    string      `json:"node_id"`
+	GpuModel  string      `json:"gpu_model"`
+	Devices   []GpuDevice `json:"devices"`
+	Tflops    float64     `json:"tflops"`
+	Nonce     string      `json:"nonce"`
+	Hash      string      `json:"hash"`
+	Signature string      `json:"signature"`
+}
+
+// Gpu runs a short benchmark on a node labelled with the claimed GPU model and signs what it found.
+func Gpu(signer *Signer) Method {
+	return Method{
+		Name:      "gpu",
+		Image:     "pytorch/pytorch:2.1.0-cuda12.1-cudnn8-runtime",
+		Command:   []string{"python", "-c", gpuBenchmarkScript},
+		Resources: Resources{Cpu: "1", Memory: "4Gi", Gpu: 1},
+		Timeout:   5 * time.Minute,
+		Env: func(raw json.RawMessage) (map[string]string, error) {
+			params, err := gpuParams(raw)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{
+				"NONCE":       params.Nonce,
+				"MATRIX_SIZE": "8192",
+				"ITERATIONS":  "50",
+			}, nil
+		},
+		NodeSelector: func(raw json.RawMessage) map[string]string {
+			params, _ := gpuParams(raw)
+			return map[string]string{GpuLabel(params.GpuModel): "true"}
+		},
+		Parse: func(raw json.RawMessage, output string) (interface{}, error) {
+			params, err := gpuParams(raw)
+			if err != nil {
+				return nil, err
+			}
+			var benchmark GpuBenchmark
+			if err = lastJSONLine(output, &benchmark); err != nil {
+				return nil, err
+			}
+			return AttestGpu(signer, params, benchmark)
+		},
+	}
+}
+
+// GpuLabel is the node label of a GPU model, as the provider labels its nodes.
+func GpuLabel(gpuModel string) string {
+	return strings.ReplaceAll(gpuModel, " ", "-")
+}
+
+func gpuParams(raw json.RawMessage) (GpuParams, error) {
+	var params GpuParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return params, fmt.Errorf("invalid params: %w", err)
+	}
+	if strings.TrimSpace(params.GpuModel) == "" {
+		return params, fmt.Errorf("missing required field: gpu_model")
+	}
+	if len(params.Nonce) < minNonceLength {
+		return params, fmt.Errorf("nonce must have at least %d characters", minNonceLength)
+	}
+	return params, nil
+}
+
+// AttestGpu checks the benchmark against the claim and signs it.
+func AttestGpu(signer *Signer, params GpuParams, benchmark GpuBenchmark) (*GpuAttestation, error) {
+	if benchmark.Nonce != params.Nonce {
+		return nil, fmt.Errorf("the benchmark is not bound to the nonce")
+	}
+	if len(benchmark.Devices) == 0 {
+		return nil, fmt.Errorf("the benchmark found no GPU")
+	}
+	for _, device := range benchmark.Devices {
+		if device.Uuid == "" {
+			return nil, fmt.Errorf("GPU %s without uuid", device.Name)
+		}
+		if !sameGpuModel(device.Name, params.GpuModel) {
+			return nil, fmt.Errorf("found GPU %s, not %s", device.Name, params.GpuModel)
+		}
+	}
+	if benchmark.Tflops <= 0 {
+		return nil, fmt.Errorf("the benchmark measured no throughput")
+	}
+
+	attestation := &GpuAttestation{
+		NodeId:   signer.NodeId(),
+		GpuModel: params.GpuModel,
+		Devices:  benchmark.Devices,
+		Tflops:   benchmark.Tflops,
+		Nonce:    params.Nonce,
+	}
+	hash := attestation.digest()
+	signature, err := signer.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	attestation.Hash = hex.EncodeToString(hash)
+	attestation.Signature = signature
+	return attestation, nil
+}
+
+// Verify checks the hash and the signature of the attestation against its node id.
+func (a *GpuAttestation) Verify() error {
+	hash := a.digest()
+	if hex.EncodeToString(hash) != a.Hash {
+		return fmt.Errorf("hash does not match the attestation")
+	}
+	return VerifySignature(a.NodeId, hash, a.Signature)
+}
+
+// digest is the sha256 of nonce|node id|gpu model|uuid,uuid|tflops with 3 decimals.
+func (a *GpuAttestation) digest() []byte {
+	var uuids []string
+	for _, device := range a.Devices {
+		uuids = append(uuids, device.Uuid)
+	}
+	message := strings.Join([]string{
+		a.Nonce,
+		a.NodeId,
+		a.GpuModel,
+		strings.Join(uuids, ","),
+		strconv.FormatFloat(a.Tflops, 'f', 3, 64),
+	}, "|")
+	sum := sha256.Sum256([]byte(message))
+	return sum[:]
+}
+
+// sameGpuModel matches the name reported by nvidia-smi, e.g. "NVIDIA A100-PCIE-40GB", with the
+// model of the node label, ignoring case, spaces and dashes.
+func sameGpuModel(name, model string) bool {
+	normalize := func(s string) string {
+		return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(s))
+	}
+	return strings.Contains(normalize(name), normalize(model))
+}
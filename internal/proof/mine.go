@@ -0,0 +1,123 @@
+package proof
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxMineExp = 250
+
+// the worker logs its result as text, e.g. "nonce: 1234" and "hash: 00ab...", or as a JSON line
+var (
+	mineNoncePattern = regexp.MustCompile(`(?i)\bnonce\b["']?\s*[:=]\s*["']?([0-9a-z]+)`)
+	mineHashPattern  = regexp.MustCompile(`(?i)\bhash\b["']?\s*[:=]\s*["']?([0-9a-f]{64})\b`)
+)
+
+// MineParams asks for a nonce whose sha256 with the block data has at least Exp leading zero bits.
+type MineParams struct {
+	BlockData string `json:"block_data"`
+	Exp       int64  `json:"exp"`
+}
+
+// MineResult is the nonce and hash found by the worker. When its log doesn't name them, the result
+// is the raw log, as the provider returned it before, and it is not verified.
+type MineResult struct {
+	Nonce    string `json:"nonce,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Log      string `json:"log,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+// Mine is the proof of work of filswan/worker-proof.
+func Mine() Method {
+	return Method{
+		Name:      "mine",
+		Image:     "filswan/worker-proof:v1.0",
+		Resources: Resources{Cpu: "1", Memory: "512Mi"},
+		Timeout:   5 * time.Minute,
+		Env: func(raw json.RawMessage) (map[string]string, error) {
+			params, err := mineParams(raw)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{
+				"METHOD":     "mine",
+				"BLOCK_DATA": params.BlockData,
+				"EXP":        strconv.FormatInt(params.Exp, 10),
+			}, nil
+		},
+		Parse: func(raw json.RawMessage, output string) (interface{}, error) {
+			params, err := mineParams(raw)
+			if err != nil {
+				return nil, err
+			}
+			result, ok := parseMineOutput(output)
+			if !ok {
+				return MineResult{Log: output}, nil
+			}
+			if err = VerifyMine(params, result); err != nil {
+				return nil, err
+			}
+			result.Verified = true
+			return result, nil
+		},
+	}
+}
+
+func mineParams(raw json.RawMessage) (MineParams, error) {
+	var params MineParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return params, fmt.Errorf("invalid params: %w", err)
+	}
+	if strings.TrimSpace(params.BlockData) == "" {
+		return params, fmt.Errorf("missing required field: block_data")
+	}
+	if params.Exp < 0 || params.Exp > maxMineExp {
+		return params, fmt.Errorf("exp range is [0~%d]", maxMineExp)
+	}
+	return params, nil
+}
+
+// parseMineOutput finds the nonce and hash in the worker log, a JSON line or text.
+func parseMineOutput(output string) (MineResult, bool) {
+	var result MineResult
+	if err := lastJSONLine(output, &result); err == nil && result.Nonce != "" && result.Hash != "" {
+		return result, true
+	}
+	nonces, hashes := mineNoncePattern.FindAllStringSubmatch(output, -1), mineHashPattern.FindAllStringSubmatch(output, -1)
+	if len(nonces) == 0 || len(hashes) == 0 {
+		return MineResult{}, false
+	}
+	// the last values are the result, a log may print progress before
+	return MineResult{Nonce: nonces[len(nonces)-1][1], Hash: hashes[len(hashes)-1][1]}, true
+}
+
+// VerifyMine recomputes the hash of the nonce and checks its difficulty.
+func VerifyMine(params MineParams, result MineResult) error {
+	sum := sha256.Sum256([]byte(params.BlockData + result.Nonce))
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), result.Hash) {
+		return fmt.Errorf("hash does not match the nonce")
+	}
+	if zeros := leadingZeroBits(sum[:]); int64(zeros) < params.Exp {
+		return fmt.Errorf("hash has %d leading zero bits, %d required", zeros, params.Exp)
+	}
+	return nil
+}
+
+func leadingZeroBits(hash []byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
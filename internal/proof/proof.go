@@ -0,0 +1,105 @@
+package proof
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownMethod is returned for a method no one registered.
+var ErrUnknownMethod = errors.New("unknown proof method")
+
+// Resources is what the container of a proof needs. Cpu and Memory are Kubernetes quantities,
+// Gpu is the number of GPUs.
+type Resources struct {
+	Cpu    string
+	Memory string
+	Gpu    int64
+}
+
+// Method is a kind of proof: the container computing it and how its output is verified.
+type Method struct {
+	Name      string
+	Image     string
+	Command   []string
+	Args      []string
+	Resources Resources
+	Timeout   time.Duration
+
+	// Env checks the parameters of a task and turns them into the environment of the container.
+	Env func(params json.RawMessage) (map[string]string, error)
+	// NodeSelector places the container on the nodes a task is about, optional.
+	NodeSelector func(params json.RawMessage) map[string]string
+	// Parse reads the result from the log of the container and verifies it against the parameters,
+	// a result that does not verify is an error.
+	Parse func(params json.RawMessage, output string) (interface{}, error)
+}
+
+// Registry is the set of proof methods a provider offers.
+type Registry struct {
+	lock    sync.RWMutex
+	methods map[string]Method
+}
+
+func NewRegistry() *Registry {
+	return &Registry{methods: make(map[string]Method)}
+}
+
+// Register adds a method, replacing the one of the same name.
+func (r *Registry) Register(method Method) error {
+	switch {
+	case strings.TrimSpace(method.Name) == "":
+		return fmt.Errorf("proof method without name")
+	case method.Image == "":
+		return fmt.Errorf("proof method %s without image", method.Name)
+	case method.Env == nil || method.Parse == nil:
+		return fmt.Errorf("proof method %s without Env or Parse", method.Name)
+	}
+	if method.Timeout <= 0 {
+		method.Timeout = 5 * time.Minute
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.methods[method.Name] = method
+	return nil
+}
+
+func (r *Registry) Lookup(name string) (Method, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	method, ok := r.methods[name]
+	if !ok {
+		return Method{}, fmt.Errorf("%w: %s", ErrUnknownMethod, name)
+	}
+	return method, nil
+}
+
+// Names returns the registered methods in order.
+func (r *Registry) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var names []string
+	for name := range r.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lastJSONLine decodes the last line of output that is a JSON object into v, the log of a proof
+// may have any output before its result.
+func lastJSONLine(output string, v interface{}) error {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), v) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no result in the output")
+}
@@ -0,0 +1,48 @@
+package proof
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs proofs with the node key, the one behind the node id of the provider.
+type Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewSigner(key *ecdsa.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// NodeId is the node id of the key, the hex of its uncompressed public key.
+func (s *Signer) NodeId() string {
+	return hex.EncodeToString(crypto.FromECDSAPub(&s.key.PublicKey))
+}
+
+// Sign returns the hex of the recoverable signature of a 32 bytes hash.
+func (s *Signer) Sign(hash []byte) (string, error) {
+	signature, err := crypto.Sign(hash, s.key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+// VerifySignature checks that signature of hash was made by the key of nodeId.
+func VerifySignature(nodeId string, hash []byte, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	publicKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if hex.EncodeToString(crypto.FromECDSAPub(publicKey)) != nodeId {
+		return fmt.Errorf("signature is not from node %s", nodeId)
+	}
+	return nil
+}
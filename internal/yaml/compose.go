@@ -0,0 +1,692 @@
+package yaml
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ComposeFileNames are the names of Docker Compose files that are deployed like a deploy.yaml.
+var ComposeFileNames = []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"}
+
+// IsComposeFile reports whether path is named like a Docker Compose file.
+func IsComposeFile(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, composeName := range ComposeFileNames {
+		if name == composeName {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildSpec is the build context of a service that has no prebuilt image.
+type BuildSpec struct {
+	Context    string
+	Dockerfile string
+	Args       map[string]*string
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Volumes  map[string]composeVolume  `yaml:"volumes"`
+}
+
+type composeVolume struct {
+	// Size turns a named volume into a persistent volume claim, Compose itself has no size.
+	Size string `yaml:"x-size"`
+}
+
+type composeService struct {
+	Image       string              `yaml:"image"`
+	Build       yaml.Node           `yaml:"build"`
+	Command     yaml.Node           `yaml:"command"`
+	Entrypoint  yaml.Node           `yaml:"entrypoint"`
+	Environment yaml.Node           `yaml:"environment"`
+	Ports       []yaml.Node         `yaml:"ports"`
+	Expose      []yaml.Node         `yaml:"expose"`
+	Volumes     []yaml.Node         `yaml:"volumes"`
+	DependsOn   yaml.Node           `yaml:"depends_on"`
+	Healthcheck *composeHealthcheck `yaml:"healthcheck"`
+	Deploy      *composeDeploy      `yaml:"deploy"`
+}
+
+type composeHealthcheck struct {
+	Test        yaml.Node `yaml:"test"`
+	Interval    string    `yaml:"interval"`
+	Timeout     string    `yaml:"timeout"`
+	Retries     int32     `yaml:"retries"`
+	StartPeriod string    `yaml:"start_period"`
+	Disable     bool      `yaml:"disable"`
+}
+
+type composeDeploy struct {
+	Replicas  int `yaml:"replicas"`
+	Resources struct {
+		Limits struct {
+			Cpus   string `yaml:"cpus"`
+			Memory string `yaml:"memory"`
+		} `yaml:"limits"`
+		Reservations struct {
+			Cpus    string `yaml:"cpus"`
+			Memory  string `yaml:"memory"`
+			Devices []struct {
+				Capabilities []string `yaml:"capabilities"`
+				Count        string   `yaml:"count"`
+			} `yaml:"devices"`
+		} `yaml:"reservations"`
+	} `yaml:"resources"`
+}
+
+// composeKeySpec lists the supported keys, a nil entry accepts any content and "*" matches every key.
+type composeKeySpec map[string]composeKeySpec
+
+var composeServiceKeys = composeKeySpec{
+	"image":       nil,
+	"build":       composeKeySpec{"context": nil, "dockerfile": nil, "args": nil},
+	"command":     nil,
+	"entrypoint":  nil,
+	"environment": nil,
+	"ports":       nil,
+	"expose":      nil,
+	"volumes":     nil,
+	"depends_on":  nil,
+	"healthcheck": composeKeySpec{"test": nil, "interval": nil, "timeout": nil, "retries": nil, "start_period": nil, "disable": nil},
+	"deploy": composeKeySpec{
+		"replicas": nil,
+		"resources": composeKeySpec{
+			"limits":       composeKeySpec{"cpus": nil, "memory": nil},
+			"reservations": composeKeySpec{"cpus": nil, "memory": nil, "devices": nil},
+		},
+	},
+}
+
+var composeKeys = composeKeySpec{
+	"version":  nil,
+	"name":     nil,
+	"services": composeKeySpec{"*": composeServiceKeys},
+	"volumes":  composeKeySpec{"*": composeKeySpec{"x-size": nil}},
+}
+
+// LoadCompose translates the common subset of a Docker Compose file into container resources.
+// Keys outside of that subset do not fail the deployment, they are returned as warnings.
+func LoadCompose(composeFilePath string) ([]ContainerResource, []ValidationError, error) {
+	data, err := os.ReadFile(composeFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed unable to read file, %w", err)
+	}
+
+	var document yaml.Node
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		return nil, nil, fmt.Errorf("failed unable to parse compose file, %w", err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("a compose file must be a mapping")
+	}
+	root := document.Content[0]
+
+	var warnings []ValidationError
+	checkComposeKeys(root, composeKeys, "", &warnings)
+
+	var compose composeFile
+	if err = root.Decode(&compose); err != nil {
+		return nil, warnings, fmt.Errorf("failed unable to parse compose file, %w", err)
+	}
+	if len(compose.Services) == 0 {
+		return nil, warnings, fmt.Errorf("at least one service must be defined")
+	}
+
+	converter := &composeConverter{
+		baseDir:   filepath.Dir(composeFilePath),
+		volumes:   compose.Volumes,
+		warnings:  warnings,
+		published: make(map[string]int),
+	}
+	services := make(map[string]serviceSpec)
+	deployments := make(map[string]Deployment)
+	for name, service := range compose.Services {
+		services[name] = composeServiceSpec{service: service, converter: converter}
+		deployments[name] = Deployment{}
+	}
+
+	containerResources, err := orderResources(services, deployments, converter.primary)
+	if err != nil {
+		return nil, converter.warnings, err
+	}
+	// the ports of the service serving the space hostname are published, the ports of the others,
+	// e.g. a database, stay inside the space unless they opt in
+	primary := &containerResources[converter.primary(containerResources)]
+	for i := 0; i < converter.published[primary.Name]; i++ {
+		primary.Expose[i].Global = true
+	}
+	return containerResources, converter.warnings, checkTemplates(containerResources, true)
+}
+
+func checkComposeKeys(node *yaml.Node, spec composeKeySpec, path string, warnings *[]ValidationError) {
+	if node.Kind != yaml.MappingNode || spec == nil {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+		if strings.HasPrefix(key, "x-") {
+			continue
+		}
+		childPath := key
+		if path != "" {
+			childPath = path + "." + key
+		}
+
+		childSpec, ok := spec[key]
+		if !ok {
+			childSpec, ok = spec["*"]
+		}
+		if !ok {
+			*warnings = append(*warnings, ValidationError{
+				Line:    keyNode.Line,
+				Column:  keyNode.Column,
+				Path:    path,
+				Message: fmt.Sprintf("%q is not supported and ignored", key),
+			})
+			continue
+		}
+		checkComposeKeys(valueNode, childSpec, childPath, warnings)
+	}
+}
+
+type composeConverter struct {
+	baseDir  string
+	volumes  map[string]composeVolume
+	warnings []ValidationError
+	// published counts the `ports` of each service, the first entries of its Expose
+	published map[string]int
+}
+
+// primary is the last service, in startup order, that publishes `ports`, a worker started after the web
+// service doesn't take its hostname. Without any, it is the last service.
+func (c *composeConverter) primary(containerResources []ContainerResource) int {
+	for i := len(containerResources) - 1; i >= 0; i-- {
+		if c.published[containerResources[i].Name] > 0 {
+			return i
+		}
+	}
+	return len(containerResources) - 1
+}
+
+func (c *composeConverter) warn(node *yaml.Node, path, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, ValidationError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+type composeServiceSpec struct {
+	service   composeService
+	converter *composeConverter
+}
+
+func (s composeServiceSpec) dependsOn() []string {
+	switch s.service.DependsOn.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		for _, item := range s.service.DependsOn.Content {
+			names = append(names, item.Value)
+		}
+		return names
+	case yaml.MappingNode:
+		var names []string
+		for i := 0; i+1 < len(s.service.DependsOn.Content); i += 2 {
+			names = append(names, s.service.DependsOn.Content[i].Value)
+		}
+		return names
+	}
+	return nil
+}
+
+func (s composeServiceSpec) sidecars() []string {
+	return nil
+}
+
+func (s composeServiceSpec) toContainerResource(name string, _ *Deployment) (ContainerResource, error) {
+	service := s.service
+	c := s.converter
+	path := "services." + name
+
+	container := ContainerResource{
+		Name:          name,
+		ImageName:     service.Image,
+		DependsOn:     s.dependsOn(),
+		ResourceLimit: make(corev1.ResourceList),
+	}
+
+	if service.Build.Kind != 0 {
+		build, err := c.buildSpec(&service.Build)
+		if err != nil {
+			return container, fmt.Errorf("%s.build: %w", path, err)
+		}
+		container.Build = build
+	} else if service.Image == "" {
+		return container, fmt.Errorf("%s: image or build is required", path)
+	}
+
+	var err error
+	if container.Command, err = commandLine(&service.Entrypoint); err != nil {
+		return container, fmt.Errorf("%s.entrypoint: %w", path, err)
+	}
+	if container.Args, err = commandLine(&service.Command); err != nil {
+		return container, fmt.Errorf("%s.command: %w", path, err)
+	}
+	container.Env = c.environment(&service.Environment, path+".environment")
+
+	for i := range service.Ports {
+		expose, err := parseComposePort(&service.Ports[i])
+		if err != nil {
+			return container, fmt.Errorf("%s.ports[%d]: %w", path, i, err)
+		}
+		container.Expose = append(container.Expose, expose)
+	}
+	c.published[name] = len(service.Ports)
+	for i := range service.Expose {
+		port, err := strconv.Atoi(strings.Split(service.Expose[i].Value, "/")[0])
+		if err != nil {
+			return container, fmt.Errorf("%s.expose[%d]: invalid port %q", path, i, service.Expose[i].Value)
+		}
+		container.Expose = append(container.Expose, ExposePort{Port: int32(port), As: int32(port), Protocol: ExposeProtocolTcp})
+	}
+	for _, expose := range container.Expose {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: expose.Port,
+			Protocol:      getProtocol(expose.Protocol),
+		})
+	}
+
+	for i := range service.Volumes {
+		if volume, ok := c.volume(&service.Volumes[i], fmt.Sprintf("%s.volumes[%d]", path, i)); ok {
+			container.Volumes = append(container.Volumes, volume)
+		}
+	}
+
+	if service.Healthcheck != nil && !service.Healthcheck.Disable {
+		probe, err := service.Healthcheck.toProbe()
+		if err != nil {
+			return container, fmt.Errorf("%s.healthcheck: %w", path, err)
+		}
+		container.ReadinessProbe = probe
+	}
+
+	if service.Deploy != nil {
+		container.Count = service.Deploy.Replicas
+		requirements, err := service.Deploy.toRequirements()
+		if err != nil {
+			return container, fmt.Errorf("%s.deploy.resources: %w", path, err)
+		}
+		container.Resources = requirements
+	}
+	return container, nil
+}
+
+func (c *composeConverter) buildSpec(node *yaml.Node) (*BuildSpec, error) {
+	build := &BuildSpec{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		build.Context = node.Value
+	case yaml.MappingNode:
+		var long struct {
+			Context    string    `yaml:"context"`
+			Dockerfile string    `yaml:"dockerfile"`
+			Args       yaml.Node `yaml:"args"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return nil, err
+		}
+		build.Context = long.Context
+		build.Dockerfile = long.Dockerfile
+		if long.Args.Kind != 0 {
+			build.Args = make(map[string]*string)
+			for _, env := range c.environment(&long.Args, "build.args") {
+				value := env.Value
+				build.Args[env.Name] = &value
+			}
+		}
+	default:
+		return nil, fmt.Errorf("expected a path or a mapping")
+	}
+	if build.Context == "" {
+		build.Context = "."
+	}
+
+	// the build context must stay inside the space
+	context := filepath.Join(c.baseDir, build.Context)
+	if rel, err := filepath.Rel(c.baseDir, context); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("context %q is outside of the space", build.Context)
+	}
+	build.Context = context
+	return build, nil
+}
+
+// environment accepts the list ("NAME=value") and the mapping form. Variables without a value would
+// come from the host running compose, which does not exist here, so they are skipped with a warning.
+func (c *composeConverter) environment(node *yaml.Node, path string) []corev1.EnvVar {
+	var envVars []corev1.EnvVar
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			envSplit := strings.SplitN(item.Value, "=", 2)
+			if len(envSplit) != 2 {
+				c.warn(item, path, "%q has no value and is ignored", item.Value)
+				continue
+			}
+			envVars = append(envVars, corev1.EnvVar{Name: envSplit[0], Value: envSplit[1]})
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Tag == "!!null" {
+				c.warn(key, path, "%q has no value and is ignored", key.Value)
+				continue
+			}
+			envVars = append(envVars, corev1.EnvVar{Name: key.Value, Value: value.Value})
+		}
+		sort.Slice(envVars, func(i, j int) bool { return envVars[i].Name < envVars[j].Name })
+	}
+	return envVars
+}
+
+// volume supports named volumes and tmpfs, bind mounts of the host are not available.
+func (c *composeConverter) volume(node *yaml.Node, path string) (Volume, bool) {
+	var source, target, volumeType string
+	var readOnly bool
+	switch node.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(node.Value, ":")
+		switch len(parts) {
+		case 1:
+			target = parts[0]
+		default:
+			source, target = parts[0], parts[1]
+			readOnly = len(parts) > 2 && strings.Contains(parts[2], "ro")
+		}
+		volumeType = "volume"
+		if strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~") {
+			volumeType = "bind"
+		}
+	case yaml.MappingNode:
+		var long struct {
+			Type     string `yaml:"type"`
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := node.Decode(&long); err != nil {
+			c.warn(node, path, "invalid volume: %v", err)
+			return Volume{}, false
+		}
+		volumeType, source, target, readOnly = long.Type, long.Source, long.Target, long.ReadOnly
+	}
+
+	switch volumeType {
+	case "bind":
+		c.warn(node, path, "bind mount %q is not supported and ignored, put the files into the image", source)
+		return Volume{}, false
+	case "tmpfs":
+		return Volume{Name: volumeName(target), Mount: target}, true
+	case "volume", "":
+		if source == "" {
+			return Volume{Name: volumeName(target), Mount: target, ReadOnly: readOnly}, true
+		}
+		size := c.volumes[source].Size
+		if size == "" {
+			c.warn(node, path, "volume %q has no x-size, its data is lost on restarts", source)
+		}
+		return Volume{Name: volumeName(source), Mount: target, Size: size, ReadOnly: readOnly}, true
+	default:
+		c.warn(node, path, "volume type %q is not supported and ignored", volumeType)
+		return Volume{}, false
+	}
+}
+
+var volumeNameInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func volumeName(name string) string {
+	result := strings.Trim(volumeNameInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(result) > 20 {
+		result = strings.Trim(result[:20], "-")
+	}
+	if result == "" {
+		result = "data"
+	}
+	return result
+}
+
+// parseComposePort reads "[host_ip:][published:]target[/protocol]" or the long form. The first port of
+// the primary service is served as HTTP; a port of another service is only published outside the
+// space with `x-global: true` in the long form.
+func parseComposePort(node *yaml.Node) (ExposePort, error) {
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Target    int32  `yaml:"target"`
+			Published string `yaml:"published"`
+			Protocol  string `yaml:"protocol"`
+			Global    bool   `yaml:"x-global"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return ExposePort{}, err
+		}
+		published, _ := strconv.Atoi(long.Published)
+		port := newComposePort(long.Target, int32(published), long.Protocol)
+		port.Global = long.Global
+		return port, nil
+	}
+
+	value, protocol := node.Value, ExposeProtocolTcp
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value, protocol = value[:i], value[i+1:]
+	}
+	parts := strings.Split(value, ":")
+	target, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return ExposePort{}, fmt.Errorf("invalid port %q", node.Value)
+	}
+	var published int
+	if len(parts) > 1 {
+		if published, err = strconv.Atoi(parts[len(parts)-2]); err != nil {
+			return ExposePort{}, fmt.Errorf("invalid published port %q", node.Value)
+		}
+	}
+	return newComposePort(int32(target), int32(published), protocol), nil
+}
+
+func newComposePort(target, published int32, protocol string) ExposePort {
+	if published == 0 {
+		published = target
+	}
+	protocol = strings.ToLower(protocol)
+	if protocol == "" {
+		protocol = ExposeProtocolTcp
+	}
+	return ExposePort{Port: target, As: published, Protocol: protocol}
+}
+
+// commandLine accepts the list and the string form of command and entrypoint.
+func commandLine(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var args []string
+		for _, item := range node.Content {
+			args = append(args, item.Value)
+		}
+		return args, nil
+	case yaml.ScalarNode:
+		return splitShellWords(node.Value)
+	}
+	return nil, nil
+}
+
+// splitShellWords splits a command line like a POSIX shell does, honouring quotes and backslashes.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var inWord bool
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func (h *composeHealthcheck) toProbe() (*corev1.Probe, error) {
+	var command []string
+	switch h.Test.Kind {
+	case yaml.ScalarNode:
+		command = []string{"/bin/sh", "-c", h.Test.Value}
+	case yaml.SequenceNode:
+		var test []string
+		for _, item := range h.Test.Content {
+			test = append(test, item.Value)
+		}
+		if len(test) == 0 {
+			return nil, fmt.Errorf("empty test")
+		}
+		switch test[0] {
+		case "NONE":
+			return nil, nil
+		case "CMD":
+			command = test[1:]
+		case "CMD-SHELL":
+			command = []string{"/bin/sh", "-c", strings.Join(test[1:], " ")}
+		default:
+			return nil, fmt.Errorf("test must start with CMD, CMD-SHELL or NONE")
+		}
+	default:
+		return nil, nil
+	}
+
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: command},
+		},
+		FailureThreshold: h.Retries,
+	}
+	for _, d := range []struct {
+		value  string
+		target *int32
+	}{
+		{h.Interval, &probe.PeriodSeconds},
+		{h.Timeout, &probe.TimeoutSeconds},
+		{h.StartPeriod, &probe.InitialDelaySeconds},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q", d.value)
+		}
+		// Kubernetes counts whole seconds, a sub-second value would turn into 0, the default
+		seconds := int32(duration.Seconds())
+		if seconds == 0 && duration > 0 {
+			seconds = 1
+		}
+		*d.target = seconds
+	}
+	return probe, nil
+}
+
+func (d *composeDeploy) toRequirements() (*corev1.ResourceRequirements, error) {
+	limits := d.Resources.Limits
+	reservations := d.Resources.Reservations
+	cpus, memory := limits.Cpus, limits.Memory
+	if cpus == "" {
+		cpus = reservations.Cpus
+	}
+	if memory == "" {
+		memory = reservations.Memory
+	}
+
+	list := make(corev1.ResourceList)
+	if cpus != "" {
+		quantity, err := resource.ParseQuantity(cpus)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus %q", cpus)
+		}
+		list[corev1.ResourceCPU] = quantity
+	}
+	if memory != "" {
+		quantity, err := composeByteQuantity(memory)
+		if err != nil {
+			return nil, err
+		}
+		list[corev1.ResourceMemory] = quantity
+	}
+	for _, device := range reservations.Devices {
+		for _, capability := range device.Capabilities {
+			if capability != "gpu" {
+				continue
+			}
+			count, err := strconv.ParseInt(device.Count, 10, 64)
+			if err != nil || count < 1 {
+				count = 1
+			}
+			list["nvidia.com/gpu"] = *resource.NewQuantity(count, resource.DecimalSI)
+		}
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &corev1.ResourceRequirements{Limits: list, Requests: list.DeepCopy()}, nil
+}
+
+var composeBytePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]?)i?b?$`)
+
+// composeByteQuantity converts Compose byte values like "512m" or "1gb", where m means megabytes.
+func composeByteQuantity(value string) (resource.Quantity, error) {
+	match := composeBytePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil {
+		return resource.Quantity{}, fmt.Errorf("invalid memory %q", value)
+	}
+	suffix := map[string]string{"": "", "k": "Ki", "m": "Mi", "g": "Gi", "t": "Ti"}[match[2]]
+	return resource.ParseQuantity(match[1] + suffix)
+}
@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"fmt"
 	"gopkg.in/errgo.v2/errors"
 	corev1 "k8s.io/api/core/v1"
 	"strings"
@@ -20,138 +21,17 @@ func (dy *DeployYamlV2) checkRequired() error {
 	return nil
 }
 
+// ServiceToK8sResource returns one ContainerResource per deployed service, ordered so that every service
+// comes after the services it depends on.
 func (dy *DeployYamlV2) ServiceToK8sResource() ([]ContainerResource, error) {
 	if err := dy.checkRequired(); err != nil {
 		return nil, err
 	}
-	var containers []ContainerResource
-	var waitDelete []string
-
-	for name, deployment := range dy.Deployment {
-		containerNew := new(ContainerResource)
-		if service, ok := dy.Services[name]; ok {
-			containerNew.Name = name
-
-			var depends []ContainerResource
-			for _, depend := range service.DependsOn {
-				if service, ok := dy.Services[depend]; ok {
-					container := new(ContainerResource)
-					container.Name = depend
-					container.ImageName = service.Image
-					if len(service.Command) > 0 {
-						container.Command = service.Command
-					}
-					if len(service.Args) > 0 {
-						container.Args = service.Args
-					}
-					if len(service.Env) > 0 {
-						var envVars []corev1.EnvVar
-						for _, env := range service.Env {
-							envSplit := strings.Split(strings.TrimSpace(env), "=")
-							envVars = append(envVars, corev1.EnvVar{
-								Name:  envSplit[0],
-								Value: envSplit[1],
-							})
-						}
-						container.Env = envVars
-					}
-					if len(service.Expose) > 0 {
-						var ports []corev1.ContainerPort
-						for _, expose := range service.Expose {
-							ports = append(ports, corev1.ContainerPort{
-								ContainerPort: int32(expose.Port),
-								Protocol:      getProtocol(expose.Protocol),
-							})
-						}
-						container.Ports = ports
-					}
-
-					if service.Config.Name != "" && service.Config.Path != "" {
-						container.VolumeMounts = ConfigFile{
-							Name: service.Config.Name,
-							Path: service.Config.Path,
-						}
-					}
-
-					if len(service.ReadyCmd) > 0 {
-						container.ReadyCmd = service.ReadyCmd
-					}
-
-					if deployment.Akash.Count != 0 {
-						container.Count = deployment.Akash.Count
-					}
-					if deployment.Lagrange.Count != 0 {
-						container.Count = deployment.Lagrange.Count
-					}
-
-					depends = append(depends, *container)
-					waitDelete = append(waitDelete, depend)
-				}
-			}
-			containerNew.Depends = depends
-			containerNew.ImageName = service.Image
-			if len(service.Command) > 0 {
-				containerNew.Command = service.Command
-			}
-			if len(service.Args) > 0 {
-				containerNew.Args = service.Args
-			}
-			if len(service.Env) > 0 {
-				var envVars []corev1.EnvVar
-				for _, env := range service.Env {
-					envSplit := strings.Split(strings.TrimSpace(env), "=")
-					envVars = append(envVars, corev1.EnvVar{
-						Name:  envSplit[0],
-						Value: envSplit[1],
-					})
-				}
-				containerNew.Env = envVars
-			}
-			if len(service.Expose) > 0 {
-				var ports []corev1.ContainerPort
-				for _, expose := range service.Expose {
-					ports = append(ports, corev1.ContainerPort{
-						ContainerPort: int32(expose.Port),
-						Protocol:      getProtocol(expose.Protocol),
-					})
-				}
-				containerNew.Ports = ports
-			}
-
-			if service.Config.Name != "" && service.Config.Path != "" {
-				containerNew.VolumeMounts = ConfigFile{
-					Name: service.Config.Name,
-					Path: service.Config.Path,
-				}
-			}
-			containerNew.Models = service.Models
-		}
-
-		containerNew.ResourceLimit = make(corev1.ResourceList)
-		if deployment.Akash.Count != 0 {
-			containerNew.Count = deployment.Akash.Count
-		}
-		if deployment.Lagrange.Count != 0 {
-			containerNew.Count = deployment.Lagrange.Count
-		}
-		containers = append(containers, *containerNew)
+	services := make(map[string]serviceSpec)
+	for name, service := range dy.Services {
+		services[name] = service
 	}
-
-	var result []ContainerResource
-	for _, c := range containers {
-		var flag bool
-		for _, needToDel := range waitDelete {
-			if c.Name == needToDel {
-				flag = true
-				break
-			}
-		}
-		if !flag {
-			result = append(result, c)
-		}
-	}
-
-	return result, nil
+	return orderResources(services, dy.Deployment, lastResource)
 }
 
 type Service struct {
@@ -162,6 +42,7 @@ type Service struct {
 	Env       []string `yaml:"env"`
 	Expose    []Expose `yaml:"expose"`
 	DependsOn []string `yaml:"depends-on"`
+	Sidecars  []string `yaml:"sidecars"`
 	Config    struct {
 		Name string `yaml:"name"`
 		Path string `yaml:"path"`
@@ -170,6 +51,81 @@ type Service struct {
 	Models   []ModelResource `yaml:"models"`
 }
 
+func (service Service) dependsOn() []string {
+	return service.DependsOn
+}
+
+func (service Service) sidecars() []string {
+	return service.Sidecars
+}
+
+func (service Service) toContainerResource(name string, deployment *Deployment) (ContainerResource, error) {
+	container := ContainerResource{
+		Name:          name,
+		ImageName:     service.Image,
+		DependsOn:     service.DependsOn,
+		ReadyCmd:      service.ReadyCmd,
+		Models:        service.Models,
+		ResourceLimit: make(corev1.ResourceList),
+	}
+	if len(service.Command) > 0 {
+		container.Command = service.Command
+	}
+	if len(service.Args) > 0 {
+		container.Args = service.Args
+	}
+	if len(service.Env) > 0 {
+		envVars, err := parseEnv(service.Env)
+		if err != nil {
+			return container, fmt.Errorf("service %s: %w", name, err)
+		}
+		container.Env = envVars
+	}
+	if len(service.Expose) > 0 {
+		var ports []corev1.ContainerPort
+		for _, expose := range service.Expose {
+			ports = append(ports, corev1.ContainerPort{
+				ContainerPort: int32(expose.Port),
+				Protocol:      getProtocol(expose.Protocol),
+			})
+		}
+		container.Ports = ports
+		container.Expose = toExposePorts(service.Expose)
+	}
+	if service.Config.Name != "" && service.Config.Path != "" {
+		container.VolumeMounts = ConfigFile{
+			Name: service.Config.Name,
+			Path: service.Config.Path,
+		}
+	}
+
+	if deployment != nil {
+		if deployment.Akash.Count != 0 {
+			container.Count = deployment.Akash.Count
+		}
+		if deployment.Lagrange.Count != 0 {
+			container.Count = deployment.Lagrange.Count
+		}
+	}
+	return container, nil
+}
+
+// parseEnv splits "NAME=value" entries at the first "=", the value may contain further "=".
+func parseEnv(env []string) ([]corev1.EnvVar, error) {
+	var envVars []corev1.EnvVar
+	for _, entry := range env {
+		envSplit := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(envSplit) != 2 || envSplit[0] == "" {
+			return nil, fmt.Errorf("invalid env entry %q, expected NAME=value", entry)
+		}
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  envSplit[0],
+			Value: envSplit[1],
+		})
+	}
+	return envVars, nil
+}
+
 type Expose struct {
 	Port int `yaml:"port"`
 	To   []struct {
@@ -213,9 +169,38 @@ type Deployment struct {
 	} `yaml:"lagrange"`
 }
 
+func toExposePorts(exposes []Expose) []ExposePort {
+	var result []ExposePort
+	for _, expose := range exposes {
+		protocol := strings.ToLower(strings.TrimSpace(expose.Protocol))
+		if protocol == "" {
+			protocol = ExposeProtocolHttp
+		}
+
+		var global bool
+		for _, to := range expose.To {
+			if to.Global {
+				global = true
+			}
+		}
+
+		as := int32(expose.As)
+		if as == 0 {
+			as = int32(expose.Port)
+		}
+		result = append(result, ExposePort{
+			Port:     int32(expose.Port),
+			As:       as,
+			Protocol: protocol,
+			Global:   global,
+		})
+	}
+	return result
+}
+
 func getProtocol(proto string) corev1.Protocol {
 	var result corev1.Protocol
-	switch proto {
+	switch strings.ToLower(proto) {
 	case "tcp":
 		result = corev1.ProtocolTCP
 	case "udp":
@@ -226,8 +211,11 @@ func getProtocol(proto string) corev1.Protocol {
 	return result
 }
 
+// ModelResource is a file fetched before the service starts and mounted at Dir/Name. Identical
+// files are downloaded once per node, Sha256 is verified when given.
 type ModelResource struct {
-	Name string `yaml:"name"`
-	Url  string `yaml:"url"`
-	Dir  string `yaml:"dir"`
+	Name   string `yaml:"name"`
+	Url    string `yaml:"url"`
+	Dir    string `yaml:"dir"`
+	Sha256 string `yaml:"sha256"`
 }
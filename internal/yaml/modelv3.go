@@ -0,0 +1,177 @@
+package yaml
+
+import (
+	"fmt"
+
+	"gopkg.in/errgo.v2/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// DeployYamlV3 extends version 2.0 with volumes, secrets, probes, replicas and resources per service.
+type DeployYamlV3 struct {
+	Version    string                `yaml:"version"`
+	Services   map[string]ServiceV3  `yaml:"services"`
+	Profiles   Profiles              `yaml:"profiles"`
+	Deployment map[string]Deployment `yaml:"deployment"`
+}
+
+func (dy *DeployYamlV3) ServiceToK8sResource() ([]ContainerResource, error) {
+	if len(dy.Services) <= 0 {
+		return nil, errors.New("at least one service must be defined")
+	}
+	services := make(map[string]serviceSpec)
+	for name, service := range dy.Services {
+		services[name] = service
+	}
+	return orderResources(services, dy.Deployment, lastResource)
+}
+
+type ServiceV3 struct {
+	Service   `yaml:",inline"`
+	Replicas  int32             `yaml:"replicas"`
+	Resources *ServiceResources `yaml:"resources"`
+	Volumes   []Volume          `yaml:"volumes"`
+	Secrets   []SecretRef       `yaml:"secrets"`
+	Probes    struct {
+		Readiness *Probe `yaml:"readiness"`
+		Liveness  *Probe `yaml:"liveness"`
+		Startup   *Probe `yaml:"startup"`
+	} `yaml:"probes"`
+}
+
+type ServiceResources struct {
+	Cpu     string `yaml:"cpu"`
+	Memory  string `yaml:"memory"`
+	Storage string `yaml:"storage"`
+	Gpu     int64  `yaml:"gpu"`
+}
+
+// Volume is an emptyDir, or a persistent volume claim of Size that survives redeploys.
+type Volume struct {
+	Name     string `yaml:"name"`
+	Mount    string `yaml:"mount"`
+	Size     string `yaml:"size"`
+	ReadOnly bool   `yaml:"read-only"`
+}
+
+// SecretRef refers to a kubernetes secret in the namespace of the space, either as env variable or as mounted files.
+type SecretRef struct {
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key"`
+	Env   string `yaml:"env"`
+	Mount string `yaml:"mount"`
+}
+
+type Probe struct {
+	Exec    []string `yaml:"exec"`
+	HttpGet *struct {
+		Path string `yaml:"path"`
+		Port int32  `yaml:"port"`
+	} `yaml:"http-get"`
+	TcpSocket *struct {
+		Port int32 `yaml:"port"`
+	} `yaml:"tcp-socket"`
+	InitialDelay     int32 `yaml:"initial-delay"`
+	Period           int32 `yaml:"period"`
+	Timeout          int32 `yaml:"timeout"`
+	FailureThreshold int32 `yaml:"failure-threshold"`
+}
+
+func (service ServiceV3) toContainerResource(name string, deployment *Deployment) (ContainerResource, error) {
+	container, err := service.Service.toContainerResource(name, deployment)
+	if err != nil {
+		return container, err
+	}
+
+	if service.Replicas > 0 {
+		container.Count = int(service.Replicas)
+	}
+
+	if service.Resources != nil {
+		requirements, err := service.Resources.toRequirements()
+		if err != nil {
+			return container, fmt.Errorf("service %s: %w", name, err)
+		}
+		container.Resources = requirements
+	}
+
+	container.Volumes = service.Volumes
+	container.Secrets = service.Secrets
+	for _, secret := range service.Secrets {
+		if secret.Env == "" && secret.Mount == "" {
+			return container, fmt.Errorf("service %s: secret %s needs env or mount", name, secret.Name)
+		}
+	}
+
+	if container.ReadinessProbe, err = service.Probes.Readiness.toK8s(); err != nil {
+		return container, fmt.Errorf("service %s: readiness probe: %w", name, err)
+	}
+	if container.LivenessProbe, err = service.Probes.Liveness.toK8s(); err != nil {
+		return container, fmt.Errorf("service %s: liveness probe: %w", name, err)
+	}
+	if container.StartupProbe, err = service.Probes.Startup.toK8s(); err != nil {
+		return container, fmt.Errorf("service %s: startup probe: %w", name, err)
+	}
+	return container, nil
+}
+
+func (r *ServiceResources) toRequirements() (*corev1.ResourceRequirements, error) {
+	list := make(corev1.ResourceList)
+	for resourceName, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              r.Cpu,
+		corev1.ResourceMemory:           r.Memory,
+		corev1.ResourceEphemeralStorage: r.Storage,
+	} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s quantity %q", resourceName, value)
+		}
+		list[resourceName] = quantity
+	}
+	if r.Gpu > 0 {
+		list["nvidia.com/gpu"] = *resource.NewQuantity(r.Gpu, resource.DecimalSI)
+	}
+	return &corev1.ResourceRequirements{
+		Limits:   list,
+		Requests: list.DeepCopy(),
+	}, nil
+}
+
+func (p *Probe) toK8s() (*corev1.Probe, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	var handlers int
+	probe := &corev1.Probe{
+		InitialDelaySeconds: p.InitialDelay,
+		PeriodSeconds:       p.Period,
+		TimeoutSeconds:      p.Timeout,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	if len(p.Exec) > 0 {
+		handlers++
+		probe.Exec = &corev1.ExecAction{Command: p.Exec}
+	}
+	if p.HttpGet != nil {
+		handlers++
+		path := p.HttpGet.Path
+		if path == "" {
+			path = "/"
+		}
+		probe.HTTPGet = &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt(int(p.HttpGet.Port))}
+	}
+	if p.TcpSocket != nil {
+		handlers++
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt(int(p.TcpSocket.Port))}
+	}
+	if handlers != 1 {
+		return nil, fmt.Errorf("exactly one of exec, http-get and tcp-socket must be set")
+	}
+	return probe, nil
+}
@@ -2,7 +2,7 @@ package yaml
 
 import (
 	"fmt"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	"os"
 )
@@ -11,16 +11,67 @@ type ContainerResource struct {
 	Name          string
 	Count         int
 	ImageName     string
+	Build         *BuildSpec
 	Command       []string
 	Args          []string
 	Env           []corev1.EnvVar
 	Ports         []corev1.ContainerPort
+	Expose        []ExposePort
 	ResourceLimit corev1.ResourceList
 	VolumeMounts  ConfigFile
-	Depends       []ContainerResource
+	DependsOn     []string
+	Sidecars      []ContainerResource
 	ReadyCmd      []string
 	GpuModel      string
 	Models        []ModelResource
+
+	Resources      *corev1.ResourceRequirements
+	Volumes        []Volume
+	Secrets        []SecretRef
+	ReadinessProbe *corev1.Probe
+	LivenessProbe  *corev1.Probe
+	StartupProbe   *corev1.Probe
+}
+
+// ExposePort is an `expose` entry of deploy.yaml. HTTP ports are published through the Ingress,
+// global TCP/UDP ports through a NodePort or LoadBalancer Service.
+type ExposePort struct {
+	Port     int32
+	As       int32
+	Protocol string
+	Global   bool
+}
+
+const (
+	ExposeProtocolHttp = "http"
+	ExposeProtocolTcp  = "tcp"
+	ExposeProtocolUdp  = "udp"
+)
+
+func (e ExposePort) IsHttp() bool {
+	return e.Protocol == ExposeProtocolHttp
+}
+
+// HttpPorts returns the ports published through the Ingress, the first one serves the space hostname.
+func (cr ContainerResource) HttpPorts() []ExposePort {
+	var ports []ExposePort
+	for _, e := range cr.Expose {
+		if e.IsHttp() {
+			ports = append(ports, e)
+		}
+	}
+	return ports
+}
+
+// L4Ports returns the TCP/UDP ports that are published outside the cluster.
+func (cr ContainerResource) L4Ports() []ExposePort {
+	var ports []ExposePort
+	for _, e := range cr.Expose {
+		if !e.IsHttp() && e.Global {
+			ports = append(ports, e)
+		}
+	}
+	return ports
 }
 
 type ConfigFile struct {
@@ -28,18 +79,20 @@ type ConfigFile struct {
 	Path string
 }
 
+// Parser turns one version of deploy.yaml into container resources.
 type Parser interface {
-	Parse(yamlFile []byte) error
+	Parse(node *yaml.Node) error
 	GetConfig() interface{}
+	ToContainerResources() ([]ContainerResource, error)
 }
 
 type ParserYamlV2 struct {
 	config DeployYamlV2
 }
 
-func (p *ParserYamlV2) Parse(yamlFile []byte) error {
+func (p *ParserYamlV2) Parse(node *yaml.Node) error {
 	var deploy DeployYamlV2
-	if err := yaml.Unmarshal(yamlFile, &deploy); err != nil {
+	if err := node.Decode(&deploy); err != nil {
 		return err
 	}
 	p.config = deploy
@@ -50,39 +103,62 @@ func (p *ParserYamlV2) GetConfig() interface{} {
 	return p.config
 }
 
-type Version struct {
-	Version string `yaml:"version"`
+func (p *ParserYamlV2) ToContainerResources() ([]ContainerResource, error) {
+	return p.config.ServiceToK8sResource()
 }
 
-func getYAMLFileVersion(yamlFile []byte) (string, error) {
-	var version Version
-	err := yaml.Unmarshal(yamlFile, &version)
-	if err != nil {
-		return "", err
+type ParserYamlV3 struct {
+	config DeployYamlV3
+}
+
+func (p *ParserYamlV3) Parse(node *yaml.Node) error {
+	var deploy DeployYamlV3
+	if err := node.Decode(&deploy); err != nil {
+		return err
 	}
-	return version.Version, nil
+	p.config = deploy
+	return nil
+}
+
+func (p *ParserYamlV3) GetConfig() interface{} {
+	return p.config
+}
+
+func (p *ParserYamlV3) ToContainerResources() ([]ContainerResource, error) {
+	return p.config.ServiceToK8sResource()
 }
 
 func HandlerYaml(yamlFilePath string) ([]ContainerResource, error) {
+	containerResources, _, err := LoadYaml(yamlFilePath)
+	return containerResources, err
+}
+
+// LoadYaml reads a deploy.yaml or a Docker Compose file. The warnings list the parts of a Compose
+// file that are not deployed.
+func LoadYaml(yamlFilePath string) ([]ContainerResource, []ValidationError, error) {
+	if IsComposeFile(yamlFilePath) {
+		containerResources, warnings, err := LoadCompose(yamlFilePath)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("failed unable to parse compose file, %w", err)
+		}
+		return containerResources, warnings, nil
+	}
+
 	yamlFile, err := os.ReadFile(yamlFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed unable to read file, %w", err)
+		return nil, nil, fmt.Errorf("failed unable to read file, %w", err)
 	}
 
-	var containerResources []ContainerResource
-	version, _ := getYAMLFileVersion(yamlFile)
-	switch version {
-	case "2.0":
-		parser := &ParserYamlV2{}
-		if err = parser.Parse(yamlFile); err != nil {
-			return nil, fmt.Errorf("failed unable to parse YAML file, %w", err)
-		}
-		containerResources, err = parser.config.ServiceToK8sResource()
-		if err != nil {
-			return nil, fmt.Errorf("failed unable to parse YAML file for k8s, %w", err)
-		}
-	default:
-		return nil, fmt.Errorf("not support yaml version: %d", version)
+	parser, _, err := load(yamlFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed unable to parse YAML file, %w", err)
 	}
-	return containerResources, err
+	containerResources, err := parser.ToContainerResources()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed unable to parse YAML file for k8s, %w", err)
+	}
+	if err = checkTemplates(containerResources, false); err != nil {
+		return nil, nil, fmt.Errorf("failed unable to parse YAML file, %w", err)
+	}
+	return containerResources, nil, nil
 }
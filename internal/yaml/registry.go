@@ -0,0 +1,155 @@
+package yaml
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+// SchemaVersion is a supported `version` of deploy.yaml: its JSON Schema and its parser.
+type SchemaVersion struct {
+	Version    string
+	SchemaFile string
+	NewParser  func() Parser
+
+	once   sync.Once
+	schema *jsonSchema
+	err    error
+}
+
+var schemaRegistry = make(map[string]*SchemaVersion)
+
+// RegisterSchema adds a deploy.yaml version, schemaFile is the name of its JSON Schema in schema/.
+func RegisterSchema(version, schemaFile string, newParser func() Parser) {
+	schemaRegistry[version] = &SchemaVersion{
+		Version:    version,
+		SchemaFile: schemaFile,
+		NewParser:  newParser,
+	}
+}
+
+func init() {
+	RegisterSchema("2.0", "deploy-v2.schema.json", func() Parser { return &ParserYamlV2{} })
+	RegisterSchema("3.0", "deploy-v3.schema.json", func() Parser { return &ParserYamlV3{} })
+}
+
+// SchemaVersions returns the supported versions, oldest first.
+func SchemaVersions() []string {
+	var versions []string
+	for version := range schemaRegistry {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// JsonSchema returns the published JSON Schema of a deploy.yaml version.
+func JsonSchema(version string) ([]byte, error) {
+	schemaVersion, ok := schemaRegistry[version]
+	if !ok {
+		return nil, fmt.Errorf("not support yaml version: %s, supported versions: %s", version, strings.Join(SchemaVersions(), ", "))
+	}
+	return schemaFiles.ReadFile("schema/" + schemaVersion.SchemaFile)
+}
+
+func (sv *SchemaVersion) jsonSchema() (*jsonSchema, error) {
+	sv.once.Do(func() {
+		var data []byte
+		data, sv.err = schemaFiles.ReadFile("schema/" + sv.SchemaFile)
+		if sv.err != nil {
+			return
+		}
+		sv.schema, sv.err = parseJsonSchema(data)
+	})
+	return sv.schema, sv.err
+}
+
+// ValidateFile checks a deploy.yaml against the schema of its version and returns the version.
+// Schema violations are returned as ValidationErrors.
+func ValidateFile(yamlFilePath string) (string, error) {
+	yamlFile, err := os.ReadFile(yamlFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed unable to read file, %w", err)
+	}
+	return Validate(yamlFile)
+}
+
+func Validate(yamlFile []byte) (string, error) {
+	parser, version, err := load(yamlFile)
+	if err != nil {
+		return version, err
+	}
+	containerResources, err := parser.ToContainerResources()
+	if err != nil {
+		return version, err
+	}
+	return version, checkTemplates(containerResources, false)
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// load validates yamlFile against the schema of its version and parses it.
+func load(yamlFile []byte) (Parser, string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &document); err != nil {
+		var line int
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			line, _ = strconv.Atoi(match[1])
+		}
+		return nil, "", ValidationErrors{{Line: line, Message: strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, "", ValidationErrors{{Line: document.Line, Column: document.Column, Message: "a deploy.yaml must be a mapping"}}
+	}
+	root := document.Content[0]
+
+	versionNode := mappingValue(root, "version")
+	if versionNode == nil {
+		return nil, "", ValidationErrors{{Line: root.Line, Column: root.Column, Message: `missing required key "version"`}}
+	}
+	version := versionNode.Value
+	schemaVersion, ok := schemaRegistry[version]
+	if !ok {
+		return nil, version, ValidationErrors{{
+			Line:    versionNode.Line,
+			Column:  versionNode.Column,
+			Path:    "version",
+			Message: fmt.Sprintf("not support yaml version: %s, supported versions: %s", version, strings.Join(SchemaVersions(), ", ")),
+		}}
+	}
+
+	schema, err := schemaVersion.jsonSchema()
+	if err != nil {
+		return nil, version, fmt.Errorf("failed load schema of version %s, %w", version, err)
+	}
+	validator := &schemaValidator{root: schema}
+	validator.validate(root, schema, "")
+	if len(validator.errors) > 0 {
+		return nil, version, validator.errors
+	}
+
+	parser := schemaVersion.NewParser()
+	if err = parser.Parse(root); err != nil {
+		return nil, version, err
+	}
+	return parser, version, nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package yaml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// serviceSpec is a service of any deploy.yaml version.
+type serviceSpec interface {
+	dependsOn() []string
+	sidecars() []string
+	toContainerResource(name string, deployment *Deployment) (ContainerResource, error)
+}
+
+// orderResources returns one ContainerResource per deployed service, ordered so that every service
+// comes after the services it depends on. A service runs in its own pod unless another service lists
+// it in `sidecars`, then it runs as an extra container of that service's pod. primary picks the service
+// serving the space hostname among the ordered ones.
+func orderResources(services map[string]serviceSpec, deployments map[string]Deployment, primary func([]ContainerResource) int) ([]ContainerResource, error) {
+	deployed := make(map[string]bool)
+	var collect func(name string)
+	collect = func(name string) {
+		if deployed[name] {
+			return
+		}
+		service, ok := services[name]
+		if !ok {
+			return
+		}
+		deployed[name] = true
+		for _, depend := range service.dependsOn() {
+			collect(depend)
+		}
+	}
+	for name := range deployments {
+		collect(name)
+	}
+
+	sidecarOf := make(map[string]string)
+	for name := range deployed {
+		for _, sidecar := range services[name].sidecars() {
+			if _, ok := services[sidecar]; !ok {
+				return nil, fmt.Errorf("service %s: sidecar %s is not defined", name, sidecar)
+			}
+			if owner, ok := sidecarOf[sidecar]; ok && owner != name {
+				return nil, fmt.Errorf("service %s is a sidecar of both %s and %s", sidecar, owner, name)
+			}
+			sidecarOf[sidecar] = name
+		}
+	}
+	for sidecar := range sidecarOf {
+		delete(deployed, sidecar)
+	}
+
+	order, err := dependencyOrder(services, deployed, sidecarOf)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ContainerResource
+	for _, name := range order {
+		container, err := toContainerResource(services, deployments, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, sidecarName := range services[name].sidecars() {
+			sidecar, err := toContainerResource(services, deployments, sidecarName)
+			if err != nil {
+				return nil, err
+			}
+			container.Sidecars = append(container.Sidecars, sidecar)
+		}
+		result = append(result, container)
+	}
+
+	// files that never say "http" keep the old behaviour: the first port of the primary service is
+	// served through the Ingress
+	var hasHttp bool
+	for _, cr := range result {
+		if len(cr.HttpPorts()) > 0 {
+			hasHttp = true
+		}
+	}
+	if !hasHttp && len(result) > 0 {
+		if cr := &result[primary(result)]; len(cr.Expose) > 0 {
+			cr.Expose[0].Protocol = ExposeProtocolHttp
+		}
+	}
+	return result, nil
+}
+
+// lastResource is the primary service of a deploy.yaml, the top-level one.
+func lastResource(result []ContainerResource) int {
+	return len(result) - 1
+}
+
+func toContainerResource(services map[string]serviceSpec, deployments map[string]Deployment, name string) (ContainerResource, error) {
+	var deployment *Deployment
+	if d, ok := deployments[name]; ok {
+		deployment = &d
+	}
+	return services[name].toContainerResource(name, deployment)
+}
+
+// dependencyOrder sorts the services topologically by depends-on, services of the same level by name.
+func dependencyOrder(services map[string]serviceSpec, deployed map[string]bool, sidecarOf map[string]string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+
+	var names []string
+	for name := range deployed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var order []string
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("depends-on cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+
+		depends := append([]string(nil), services[name].dependsOn()...)
+		sort.Strings(depends)
+		for _, depend := range depends {
+			if _, ok := services[depend]; !ok {
+				return fmt.Errorf("service %s depends on undefined service %s", name, depend)
+			}
+			if owner, ok := sidecarOf[depend]; ok {
+				return fmt.Errorf("service %s depends on %s, which is a sidecar of %s", name, depend, owner)
+			}
+			if err := visit(depend, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
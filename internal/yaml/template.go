@@ -0,0 +1,180 @@
+package yaml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Template variables that can be used in env values of deploy.yaml and compose files. They are
+// resolved at deploy time, "$${" is a literal "${".
+const (
+	TemplateSpaceUrl    = "SPACE_URL"
+	TemplateSpaceUuid   = "SPACE_UUID"
+	TemplateWallet      = "WALLET"
+	TemplateJobUuid     = "JOB_UUID"
+	TemplateServiceHost = "SERVICE_HOST"
+	TemplateSecret      = "SECRET"
+)
+
+var (
+	templatePattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}|\$\{`)
+	// composeVariablePattern is a compose variable with its modifier, e.g. VAR:-default.
+	composeVariablePattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?+])(.*))?$`)
+)
+
+// TemplateContext holds the values of the template variables of one deployment.
+type TemplateContext struct {
+	SpaceUrl  string
+	SpaceUuid string
+	Wallet    string
+	JobUuid   string
+	// ServiceHosts maps the services of the space to their in-cluster address.
+	ServiceHosts map[string]string
+	// Compose resolves the variables that are not template variables like docker compose does
+	// without any variable set, ${VAR:-default} becomes its default.
+	Compose bool
+}
+
+// Expand replaces the variables of value. ${SECRET:<name>} is not allowed here, secrets never
+// become part of a plain value.
+func (tc TemplateContext) Expand(value string) (string, error) {
+	var expandErr error
+	result := templatePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+		switch {
+		case match == "$${":
+			return "${"
+		case match == "${":
+			expandErr = fmt.Errorf("unterminated variable in %q", value)
+			return match
+		}
+
+		name, arg, _ := strings.Cut(match[2:len(match)-1], ":")
+		if tc.Compose && !isTemplateVariable(name) {
+			value, err := composeDefault(match[2 : len(match)-1])
+			if err != nil {
+				expandErr = err
+			}
+			return value
+		}
+		switch name {
+		case TemplateSpaceUrl:
+			return tc.SpaceUrl
+		case TemplateSpaceUuid:
+			return tc.SpaceUuid
+		case TemplateWallet:
+			return tc.Wallet
+		case TemplateJobUuid:
+			return tc.JobUuid
+		case TemplateServiceHost:
+			host, ok := tc.ServiceHosts[arg]
+			if !ok {
+				expandErr = fmt.Errorf("%s: service %q is not defined or has no port", match, arg)
+			}
+			return host
+		case TemplateSecret:
+			expandErr = fmt.Errorf("%s must be the whole value of an env variable", match)
+		default:
+			expandErr = fmt.Errorf("unknown variable %s", match)
+		}
+		return match
+	})
+	return result, expandErr
+}
+
+// ResolveEnv expands the env values of a service and its sidecars. An env variable whose value is
+// just ${SECRET:<name>} or ${SECRET:<name>/<key>} reads the key of that kubernetes secret, the key
+// defaults to the name.
+func (tc TemplateContext) ResolveEnv(cr *ContainerResource) error {
+	for i := range cr.Env {
+		env := &cr.Env[i]
+		if secretName, key, ok := secretReference(env.Value); ok {
+			env.Value = ""
+			env.ValueFrom = &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Key:                  key,
+				},
+			}
+			continue
+		}
+		value, err := tc.Expand(env.Value)
+		if err != nil {
+			return fmt.Errorf("service %s, env %s: %w", cr.Name, env.Name, err)
+		}
+		env.Value = value
+	}
+	for i := range cr.Sidecars {
+		if err := tc.ResolveEnv(&cr.Sidecars[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTemplateVariable(name string) bool {
+	switch name {
+	case TemplateSpaceUrl, TemplateSpaceUuid, TemplateWallet, TemplateJobUuid, TemplateServiceHost, TemplateSecret:
+		return true
+	}
+	return false
+}
+
+// composeDefault resolves a compose variable, none is set on the provider.
+func composeDefault(variable string) (string, error) {
+	match := composeVariablePattern.FindStringSubmatch(variable)
+	if match == nil {
+		return "", fmt.Errorf("invalid variable ${%s}", variable)
+	}
+	switch match[2] {
+	case ":-", "-":
+		return match[3], nil
+	case ":?", "?":
+		return "", fmt.Errorf("variable %s is required: %s", match[1], match[3])
+	}
+	return "", nil
+}
+
+func secretReference(value string) (string, string, bool) {
+	prefix := "${" + TemplateSecret + ":"
+	if !strings.HasPrefix(value, prefix) || !strings.HasSuffix(value, "}") || strings.Count(value, "${") != 1 {
+		return "", "", false
+	}
+	reference := value[len(prefix) : len(value)-1]
+	name, key, found := strings.Cut(reference, "/")
+	if !found {
+		key = name
+	}
+	if name == "" || key == "" {
+		return "", "", false
+	}
+	return name, key, true
+}
+
+// checkTemplates fails on unknown variables before anything is deployed. ${SERVICE_HOST:<name>} must
+// name a service with ports, sidecars and services without ports get no kubernetes Service.
+func checkTemplates(containerResources []ContainerResource, compose bool) error {
+	tc := TemplateContext{ServiceHosts: make(map[string]string), Compose: compose}
+	var all []ContainerResource
+	for _, cr := range containerResources {
+		all = append(all, cr)
+		all = append(all, cr.Sidecars...)
+		if len(cr.Expose) > 0 {
+			tc.ServiceHosts[cr.Name] = cr.Name
+		}
+	}
+	for _, cr := range all {
+		check := cr
+		check.Env = append([]corev1.EnvVar(nil), cr.Env...)
+		check.Sidecars = nil
+		if err := tc.ResolveEnv(&check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
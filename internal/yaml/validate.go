@@ -0,0 +1,344 @@
+package yaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem found in a deploy.yaml, located by line and column.
+type ValidationError struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	position := fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	if e.Column == 0 {
+		position = fmt.Sprintf("line %d", e.Line)
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", position, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", position, e.Path, e.Message)
+}
+
+// ValidationErrors holds every problem of a file, so they can be fixed in one go.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jsonSchema is the subset of JSON Schema (draft-07) used by the published deploy.yaml schemas.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 interface{}            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Required             []string               `json:"required"`
+	MinProperties        *int                   `json:"minProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Pattern              string                 `json:"pattern"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+
+	pattern    *regexp.Regexp
+	additional *jsonSchema
+	closed     bool
+}
+
+func parseJsonSchema(data []byte) (*jsonSchema, error) {
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *jsonSchema) compile() error {
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = pattern
+	}
+	if len(s.AdditionalProperties) > 0 {
+		if string(s.AdditionalProperties) == "false" {
+			s.closed = true
+		} else if string(s.AdditionalProperties) != "true" {
+			s.additional = new(jsonSchema)
+			if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+				return err
+			}
+		}
+	}
+
+	children := []*jsonSchema{s.Items, s.additional}
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range s.Definitions {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			types = append(types, fmt.Sprint(v))
+		}
+		return types
+	}
+	return nil
+}
+
+type schemaValidator struct {
+	root   *jsonSchema
+	errors ValidationErrors
+}
+
+func (v *schemaValidator) addError(node *yaml.Node, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *schemaValidator) resolve(schema *jsonSchema) *jsonSchema {
+	for schema != nil && schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/definitions/")
+		schema = v.root.Definitions[name]
+	}
+	return schema
+}
+
+func (v *schemaValidator) validate(node *yaml.Node, schema *jsonSchema, path string) {
+	schema = v.resolve(schema)
+	if schema == nil {
+		return
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if types := schema.types(); len(types) > 0 && !nodeHasType(node, types) {
+		v.addError(node, path, "expected %s, got %s", strings.Join(types, " or "), nodeTypeName(node))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.validateMapping(node, schema, path)
+	case yaml.SequenceNode:
+		if schema.Items != nil {
+			for i, item := range node.Content {
+				v.validate(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case yaml.ScalarNode:
+		v.validateScalar(node, schema, path)
+	}
+}
+
+func (v *schemaValidator) validateMapping(node *yaml.Node, schema *jsonSchema, path string) {
+	present := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+		present[key] = true
+
+		childPath := key
+		if path != "" {
+			childPath = path + "." + key
+		}
+		if property, ok := schema.Properties[key]; ok {
+			v.validate(valueNode, property, childPath)
+		} else if schema.additional != nil {
+			v.validate(valueNode, schema.additional, childPath)
+		} else if schema.closed {
+			v.addError(keyNode, path, "unknown key %q%s", key, suggestKey(key, schema.Properties))
+		}
+	}
+
+	for _, required := range schema.Required {
+		if !present[required] {
+			v.addError(node, path, "missing required key %q", required)
+		}
+	}
+	if schema.MinProperties != nil && len(present) < *schema.MinProperties {
+		v.addError(node, path, "at least %d entries must be defined", *schema.MinProperties)
+	}
+}
+
+func (v *schemaValidator) validateScalar(node *yaml.Node, schema *jsonSchema, path string) {
+	if len(schema.Enum) > 0 {
+		var allowed []string
+		var found bool
+		for _, e := range schema.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+			if fmt.Sprint(e) == node.Value {
+				found = true
+			}
+		}
+		if !found {
+			v.addError(node, path, "%q is not one of %s", node.Value, strings.Join(allowed, ", "))
+		}
+	}
+	if schema.pattern != nil && node.Tag == "!!str" && !schema.pattern.MatchString(node.Value) {
+		v.addError(node, path, "%q does not match %s", node.Value, schema.Pattern)
+	}
+	if schema.Minimum != nil || schema.Maximum != nil {
+		number, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil {
+			return
+		}
+		if schema.Minimum != nil && number < *schema.Minimum {
+			v.addError(node, path, "%s is less than %v", node.Value, *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			v.addError(node, path, "%s is greater than %v", node.Value, *schema.Maximum)
+		}
+	}
+}
+
+func nodeHasType(node *yaml.Node, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "object":
+			if node.Kind == yaml.MappingNode {
+				return true
+			}
+		case "array":
+			if node.Kind == yaml.SequenceNode {
+				return true
+			}
+		case "string":
+			if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+				return true
+			}
+		case "integer":
+			if node.Kind == yaml.ScalarNode && node.Tag == "!!int" {
+				return true
+			}
+		case "number":
+			if node.Kind == yaml.ScalarNode && (node.Tag == "!!int" || node.Tag == "!!float") {
+				if f, err := strconv.ParseFloat(node.Value, 64); err == nil && !math.IsInf(f, 0) {
+					return true
+				}
+			}
+		case "boolean":
+			if node.Kind == yaml.ScalarNode && node.Tag == "!!bool" {
+				return true
+			}
+		case "null":
+			if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nodeTypeName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.Tag {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+// suggestKey points at the known key a typo most likely meant.
+func suggestKey(key string, properties map[string]*jsonSchema) string {
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,15 @@
+// Package computingprovider holds the sample files of the repository, shipped with the binary so
+// that `computing-provider init` can write them to a new cp repo.
+package computingprovider
+
+import _ "embed"
+
+// ConfigSample is config.toml.sample, with its comments.
+//
+//go:embed config.toml.sample
+var ConfigSample []byte
+
+// ResourcePolicySample is resource_policy.json.
+//
+//go:embed resource_policy.json
+var ResourcePolicySample []byte
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/internal/bidding"
+	"github.com/lagrangedao/go-computing-provider/internal/capacity"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+func TestBidDecide(t *testing.T) {
+	policy := &bidding.Policy{
+		AutoBid:            true,
+		Tiers:              []string{"CPU", "NVIDIA A100"},
+		MinDuration:        600,
+		MaxDuration:        86400,
+		DenyWallets:        []string{"0xBAD"},
+		MinPricePerGpuHour: 1.5,
+		MaxJobsPerWallet:   3,
+		MaxUtilization:     0.9,
+	}
+	gpuJob := bidding.Job{Wallet: "0xabc", Tier: "NVIDIA-A100", Gpus: 2, Duration: 3600, PricePerHour: 4}
+
+	tests := []struct {
+		name   string
+		policy *bidding.Policy
+		job    bidding.Job
+		state  bidding.State
+		reason bidding.Reason
+	}{
+		{"default policy bids on anything", bidding.DefaultPolicy(), bidding.Job{Tier: "NVIDIA H100", Gpus: 8}, bidding.State{Utilization: 1}, ""},
+		{"accepted gpu job", policy, gpuJob, bidding.State{WalletJobs: 2, Utilization: 0.5}, ""},
+		{"bidding disabled", &bidding.Policy{}, gpuJob, bidding.State{}, bidding.ReasonBiddingDisabled},
+		{"tier not offered", policy, bidding.Job{Tier: "NVIDIA T4", Gpus: 1, Duration: 3600, PricePerHour: 2}, bidding.State{}, bidding.ReasonTierNotOffered},
+		{"cpu job", policy, bidding.Job{Tier: "cpu", Duration: 3600}, bidding.State{}, ""},
+		{"too short", policy, bidding.Job{Tier: "CPU", Duration: 60}, bidding.State{}, bidding.ReasonDurationTooShort},
+		{"too long", policy, bidding.Job{Tier: "CPU", Duration: 100000}, bidding.State{}, bidding.ReasonDurationTooLong},
+		{"denied wallet", policy, bidding.Job{Wallet: "0xbad", Tier: "CPU", Duration: 3600}, bidding.State{}, bidding.ReasonWalletDenied},
+		{"wallet not allowed", &bidding.Policy{AutoBid: true, AllowWallets: []string{"0xdef"}}, gpuJob, bidding.State{}, bidding.ReasonWalletNotAllowed},
+		{"price per gpu too low", policy, bidding.Job{Tier: "NVIDIA A100", Gpus: 2, Duration: 3600, PricePerHour: 2}, bidding.State{}, bidding.ReasonPriceTooLow},
+		{"no price given", policy, bidding.Job{Tier: "NVIDIA A100", Gpus: 2, Duration: 3600}, bidding.State{}, ""},
+		{"too many jobs of the wallet", policy, gpuJob, bidding.State{WalletJobs: 3}, bidding.ReasonTooManyJobs},
+		{"utilization too high", policy, gpuJob, bidding.State{Utilization: 0.95}, bidding.ReasonUtilizationTooHigh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := tt.policy.Decide(tt.job, tt.state)
+			if decision.Accept != (tt.reason == "") || decision.Reason != tt.reason {
+				t.Errorf("got %+v, want reason %q", decision, tt.reason)
+			}
+		})
+	}
+}
+
+func TestBidPolicyGpus(t *testing.T) {
+	cpuOnly := &bidding.Policy{AutoBid: true, Tiers: []string{"CPU"}}
+	if cpuOnly.AcceptsGpus() || cpuOnly.Offers("NVIDIA-A100") {
+		t.Errorf("a cpu only policy accepts gpus")
+	}
+	if !bidding.DefaultPolicy().AcceptsGpus() || !bidding.DefaultPolicy().Offers("NVIDIA-A100") {
+		t.Errorf("the default policy doesn't accept gpus")
+	}
+}
+
+func TestBidLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := bidding.LoadPolicy(filepath.Join(dir, "missing.toml"))
+	if err != nil || !policy.AutoBid {
+		t.Fatalf("missing policy: got %+v, %v", policy, err)
+	}
+
+	path := filepath.Join(dir, "bid_policy.toml")
+	content := "auto_bid = true\ntiers = [\"NVIDIA A100\"]\nmin_price_per_gpu_hour = 1.2\nmax_jobs_per_wallet = 2\n"
+	if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = bidding.LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.AutoBid || len(policy.Tiers) != 1 || policy.MinPricePerGpuHour != 1.2 || policy.MaxJobsPerWallet != 2 {
+		t.Errorf("got %+v", policy)
+	}
+
+	content = "min_duration = 100\nmax_duration = 10\nmax_utilization = 2\n"
+	if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bidding.LoadPolicy(path)
+	if err == nil || !strings.Contains(err.Error(), "max_duration") || !strings.Contains(err.Error(), "max_utilization") {
+		t.Errorf("all the problems should be reported, got %v", err)
+	}
+}
+
+func TestCapacityUtilization(t *testing.T) {
+	nodes := []coreV1.Node{fakeNode("n1", "8", "32Gi", "100Gi"), fakeNode("n2", "8", "32Gi", "100Gi")}
+	pods := []coreV1.Pod{
+		fakePod("n1", coreV1.PodRunning, map[string]string{"NVIDIA-A100": "true"}, []fakeContainer{{cpu: "4", memory: "8Gi", gpu: 1}}),
+	}
+	gpus := map[string]models.Gpu{"n1": fakeGpus("NVIDIA A100", "NVIDIA A100")}
+	cluster := capacity.Build(nodes, pods, gpus, capacity.Reservation{})
+
+	tests := []struct {
+		gpuModel    string
+		utilization float64
+	}{
+		{"", 0.25},
+		{"NVIDIA-A100", 0.5},
+		{"NVIDIA-T4", 1},
+	}
+	for _, tt := range tests {
+		if got := cluster.Utilization(tt.gpuModel); math.Abs(got-tt.utilization) > 1e-9 {
+			t.Errorf("%q: got %v, want %v", tt.gpuModel, got, tt.utilization)
+		}
+	}
+}
+
+func TestBidSamplePolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bid_policy.toml")
+	if err := os.WriteFile(path, []byte(bidding.SamplePolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := bidding.LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision := policy.Decide(bidding.Job{Wallet: "0xabc", Tier: "NVIDIA H100", Gpus: 8, Duration: 60}, bidding.State{WalletJobs: 10, Utilization: 1})
+	if !decision.Accept {
+		t.Errorf("the sample policy rejects %+v", decision)
+	}
+}
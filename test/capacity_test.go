@@ -0,0 +1,245 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/internal/capacity"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const gib = int64(1 << 30)
+
+func fakeNode(name, cpu, memory, storage string) coreV1.Node {
+	allocatable := coreV1.ResourceList{
+		coreV1.ResourceCPU:              resource.MustParse(cpu),
+		coreV1.ResourceMemory:           resource.MustParse(memory),
+		coreV1.ResourceEphemeralStorage: resource.MustParse(storage),
+	}
+	// capacity is more than allocatable, it must not be reported as free
+	capacity := allocatable.DeepCopy()
+	capacity[coreV1.ResourceMemory] = resource.MustParse("1Ti")
+	return coreV1.Node{
+		ObjectMeta: metaV1.ObjectMeta{Name: name},
+		Status:     coreV1.NodeStatus{Allocatable: allocatable, Capacity: capacity},
+	}
+}
+
+type fakeContainer struct {
+	cpu, memory, storage string
+	gpu                  int64
+}
+
+func (c fakeContainer) container() coreV1.Container {
+	requests := coreV1.ResourceList{}
+	if c.cpu != "" {
+		requests[coreV1.ResourceCPU] = resource.MustParse(c.cpu)
+	}
+	if c.memory != "" {
+		requests[coreV1.ResourceMemory] = resource.MustParse(c.memory)
+	}
+	if c.storage != "" {
+		requests[coreV1.ResourceEphemeralStorage] = resource.MustParse(c.storage)
+	}
+	if c.gpu > 0 {
+		requests["nvidia.com/gpu"] = *resource.NewQuantity(c.gpu, resource.DecimalSI)
+	}
+	return coreV1.Container{Resources: coreV1.ResourceRequirements{Requests: requests}}
+}
+
+func fakePod(node string, phase coreV1.PodPhase, selector map[string]string, containers []fakeContainer, inits ...fakeContainer) coreV1.Pod {
+	pod := coreV1.Pod{
+		Spec:   coreV1.PodSpec{NodeName: node, NodeSelector: selector},
+		Status: coreV1.PodStatus{Phase: phase},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, c.container())
+	}
+	for _, c := range inits {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, c.container())
+	}
+	return pod
+}
+
+func fakeGpus(products ...string) models.Gpu {
+	gpu := models.Gpu{AttachedGpus: len(products)}
+	for _, product := range products {
+		gpu.Details = append(gpu.Details, models.GpuDetail{ProductName: product})
+	}
+	return gpu
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		name        string
+		nodes       []coreV1.Node
+		pods        []coreV1.Pod
+		gpus        map[string]models.Gpu
+		reservation capacity.Reservation
+		free        capacity.Resources
+		freeGpus    map[string]int64
+	}{
+		{
+			name:     "empty node is free up to allocatable",
+			nodes:    []coreV1.Node{fakeNode("n1", "8", "32Gi", "100Gi")},
+			free:     capacity.Resources{CpuMilli: 8000, Memory: 32 * gib, Storage: 100 * gib},
+			freeGpus: map[string]int64{},
+		},
+		{
+			name:  "requests of running and pending pods count, ended pods don't",
+			nodes: []coreV1.Node{fakeNode("n1", "8", "32Gi", "100Gi")},
+			pods: []coreV1.Pod{
+				fakePod("n1", coreV1.PodRunning, nil, []fakeContainer{{cpu: "1500m", memory: "4Gi", storage: "10Gi"}, {cpu: "500m", memory: "1Gi"}}),
+				fakePod("n1", coreV1.PodPending, nil, []fakeContainer{{cpu: "1", memory: "1Gi"}}),
+				fakePod("n1", coreV1.PodSucceeded, nil, []fakeContainer{{cpu: "4", memory: "16Gi"}}),
+				fakePod("", coreV1.PodPending, nil, []fakeContainer{{cpu: "4", memory: "16Gi"}}),
+			},
+			free:     capacity.Resources{CpuMilli: 5000, Memory: 26 * gib, Storage: 90 * gib},
+			freeGpus: map[string]int64{},
+		},
+		{
+			name:  "an init container larger than the containers counts",
+			nodes: []coreV1.Node{fakeNode("n1", "8", "32Gi", "100Gi")},
+			pods: []coreV1.Pod{
+				fakePod("n1", coreV1.PodRunning, nil, []fakeContainer{{cpu: "1", memory: "1Gi"}}, fakeContainer{cpu: "4", memory: "512Mi"}),
+			},
+			free:     capacity.Resources{CpuMilli: 4000, Memory: 31 * gib, Storage: 100 * gib},
+			freeGpus: map[string]int64{},
+		},
+		{
+			name:  "gpus are counted per model of the node",
+			nodes: []coreV1.Node{fakeNode("n1", "16", "64Gi", "100Gi"), fakeNode("n2", "16", "64Gi", "100Gi")},
+			pods: []coreV1.Pod{
+				fakePod("n1", coreV1.PodRunning, map[string]string{"NVIDIA-A100": "true"}, []fakeContainer{{gpu: 1}}),
+				fakePod("n2", coreV1.PodRunning, nil, []fakeContainer{{gpu: 1}}),
+			},
+			gpus: map[string]models.Gpu{
+				"n1": fakeGpus("NVIDIA A100", "NVIDIA A100", "NVIDIA T4"),
+				"n2": fakeGpus("NVIDIA A100"),
+			},
+			free:     capacity.Resources{CpuMilli: 32000, Memory: 128 * gib, Storage: 200 * gib},
+			freeGpus: map[string]int64{"NVIDIA-A100": 1, "NVIDIA-T4": 1},
+		},
+		{
+			name:  "reservations are taken from the free resources, never below zero",
+			nodes: []coreV1.Node{fakeNode("n1", "4", "16Gi", "50Gi"), fakeNode("n2", "4", "16Gi", "50Gi")},
+			gpus:  map[string]models.Gpu{"n1": fakeGpus("NVIDIA A100"), "n2": fakeGpus("NVIDIA A100")},
+			reservation: capacity.Reservation{
+				Resources: capacity.Resources{CpuMilli: 6000, Memory: 40 * gib, Storage: 10 * gib},
+				Gpus:      map[string]int64{"NVIDIA-A100": 1, "NVIDIA-H100": 2},
+			},
+			free:     capacity.Resources{CpuMilli: 2000, Memory: 0, Storage: 90 * gib},
+			freeGpus: map[string]int64{"NVIDIA-A100": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := capacity.Build(tt.nodes, tt.pods, tt.gpus, tt.reservation)
+			if free := cluster.Free(); free != tt.free {
+				t.Errorf("free: got %+v, want %+v", free, tt.free)
+			}
+			freeGpus := cluster.FreeGpus()
+			if len(freeGpus) != len(tt.freeGpus) {
+				t.Errorf("free gpus: got %v, want %v", freeGpus, tt.freeGpus)
+			}
+			for model, count := range tt.freeGpus {
+				if freeGpus[model] != count {
+					t.Errorf("free gpus of %s: got %d, want %d", model, freeGpus[model], count)
+				}
+			}
+		})
+	}
+}
+
+func TestCapacityNodeResources(t *testing.T) {
+	nodes := []coreV1.Node{fakeNode("n1", "8", "15872Mi", "100Gi")}
+	pods := []coreV1.Pod{fakePod("n1", coreV1.PodRunning, nil, []fakeContainer{{cpu: "1500m", memory: "1536Mi", gpu: 1}})}
+	gpus := map[string]models.Gpu{"n1": fakeGpus("NVIDIA A100", "NVIDIA A100")}
+
+	report := capacity.Build(nodes, pods, gpus, capacity.Reservation{}).NodeResources()
+	if len(report) != 1 {
+		t.Fatalf("report: %+v", report)
+	}
+	node := report[0]
+	tests := []struct {
+		name      string
+		got, want string
+	}{
+		{"cpu total", node.Cpu.Total, "8"},
+		{"cpu used rounds up", node.Cpu.Used, "2"},
+		{"cpu free rounds down", node.Cpu.Free, "6"},
+		{"memory total keeps the fraction", node.Memory.Total, "15.50 GiB"},
+		{"memory used", node.Memory.Used, "1.50 GiB"},
+		{"memory free", node.Memory.Free, "14.00 GiB"},
+		{"storage free", node.Storage.Free, "100.00 GiB"},
+		{"first gpu", string(node.Gpu.Details[0].Status), string(models.Available)},
+		{"second gpu", string(node.Gpu.Details[1].Status), string(models.Occupied)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestCapacitySatisfies(t *testing.T) {
+	cluster := capacity.Build([]coreV1.Node{fakeNode("n1", "8", "32Gi", "100Gi")}, nil, nil, capacity.Reservation{})
+
+	tests := []struct {
+		name    string
+		policy  models.ResourcePolicy
+		want    bool
+		wantErr bool
+	}{
+		{"no quota", models.ResourcePolicy{}, true, false},
+		{"enough", models.ResourcePolicy{Cpu: models.CpuQuota{Quota: 8}, Memory: models.Quota{Quota: 32, Unit: "GiB"}, Storage: models.Quota{Quota: 100, Unit: "GiB"}}, true, false},
+		{"too little cpu", models.ResourcePolicy{Cpu: models.CpuQuota{Quota: 9}}, false, false},
+		{"storage is compared with its own quota", models.ResourcePolicy{Memory: models.Quota{Quota: 1, Unit: "GiB"}, Storage: models.Quota{Quota: 101, Unit: "GiB"}}, false, false},
+		{"memory in MiB", models.ResourcePolicy{Memory: models.Quota{Quota: 32769, Unit: "MiB"}}, false, false},
+		{"unknown unit", models.ResourcePolicy{Memory: models.Quota{Quota: 1, Unit: "bananas"}}, false, true},
+	}
+	for _, tt := range tests {
+		got, err := cluster.Satisfies(tt.policy)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: got %v %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestCapacityFits(t *testing.T) {
+	nodes := []coreV1.Node{fakeNode("n1", "4", "16Gi", "100Gi"), fakeNode("n2", "8", "32Gi", "100Gi")}
+	gpus := map[string]models.Gpu{"n1": fakeGpus("NVIDIA A100"), "n2": fakeGpus("NVIDIA T4", "NVIDIA T4")}
+	a100 := capacity.Reservation{Resources: capacity.Resources{CpuMilli: 2000, Memory: 8 * gib}, Gpus: map[string]int64{"NVIDIA-A100": 1}}
+	t4 := capacity.Reservation{Resources: capacity.Resources{CpuMilli: 2000, Memory: 8 * gib}, Gpus: map[string]int64{"NVIDIA-T4": 1}}
+
+	tests := []struct {
+		name     string
+		reserved []capacity.Reservation
+		job      capacity.Reservation
+		fits     bool
+	}{
+		{"free gpu", nil, a100, true},
+		{"the only gpu of the model is reserved", []capacity.Reservation{a100}, a100, false},
+		{"another model is still free", []capacity.Reservation{a100}, t4, true},
+		{"both gpus of the model are reserved", []capacity.Reservation{t4, t4}, t4, false},
+		{"unknown model", nil, capacity.Reservation{Gpus: map[string]int64{"NVIDIA-H100": 1}}, false},
+		{"cpu job on the largest node", nil, capacity.Reservation{Resources: capacity.Resources{CpuMilli: 6000, Memory: 24 * gib}}, true},
+		{"cpu job larger than any node", nil, capacity.Reservation{Resources: capacity.Resources{CpuMilli: 10000}}, false},
+		{"gpu node without the cpu left", nil, capacity.Reservation{Resources: capacity.Resources{CpuMilli: 6000}, Gpus: map[string]int64{"NVIDIA-A100": 1}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total := capacity.Reservation{}
+			for _, reservation := range tt.reserved {
+				total = total.Add(reservation)
+			}
+			err := capacity.Build(nodes, nil, gpus, total).Fits(tt.job)
+			if (err == nil) != tt.fits {
+				t.Errorf("fits: got %v, want %v", err, tt.fits)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	computing2 "github.com/lagrangedao/go-computing-provider/internal/computing"
+)
+
+// TestCertServiceObtain runs against a local Pebble, e.g.
+//
+//	docker run -p 14000:14000 -p 8055:8055 ... letsencrypt/pebble + letsencrypt/pebble-challtestsrv
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CHALLTESTSRV=http://localhost:8055 go test ./test -run TestCertServiceObtain
+func TestCertServiceObtain(t *testing.T) {
+	directoryUrl := os.Getenv("PEBBLE_DIRECTORY")
+	challTestSrv := os.Getenv("PEBBLE_CHALLTESTSRV")
+	if directoryUrl == "" || challTestSrv == "" {
+		t.Skip("PEBBLE_DIRECTORY and PEBBLE_CHALLTESTSRV are not set")
+	}
+
+	cpRepo := t.TempDir()
+	config := fmt.Sprintf(`
+[API]
+MultiAddress = "/ip4/127.0.0.1/tcp/8085"
+Domain = ".example.test"
+RedisUrl = "redis://127.0.0.1:6379"
+[LAG]
+ServerUrl = "http://127.0.0.1"
+AccessToken = "test"
+[MCS]
+ApiKey = "test"
+BucketName = "test"
+Network = "polygon.mumbai"
+FileCachePath = "/tmp"
+[Registry]
+[ACME]
+Enable = true
+Email = "cp@example.test"
+DirectoryUrl = "%s"
+InsecureSkipVerify = true
+DnsProvider = "challtestsrv"
+ChallTestSrvUrl = "%s"
+`, directoryUrl, challTestSrv)
+	if err := os.WriteFile(filepath.Join(cpRepo, "config.toml"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.InitConfig(cpRepo); err != nil {
+		t.Fatal(err)
+	}
+
+	certService, err := computing2.NewCertService(cpRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, err := certService.Obtain(context.TODO(), "*.example.test", "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cert.VerifyHostname("abc.example.test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestChallTestSrvCleanUp checks that cleaning up one challenge keeps the other value of the name,
+// as a wildcard and an apex authorization share it.
+func TestChallTestSrvCleanUp(t *testing.T) {
+	var lock sync.Mutex
+	records := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Host  string `json:"host"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/set-txt":
+			records[body.Host] = append(records[body.Host], body.Value)
+		case "/clear-txt":
+			delete(records, body.Host)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := computing2.NewDNSProvider(conf.ACME{DnsProvider: "challtestsrv", ChallTestSrvUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fqdn := "_acme-challenge.example.test"
+	for _, value := range []string{"wildcard", "apex"} {
+		if err = provider.Present(ctx, fqdn, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = provider.CleanUp(ctx, fqdn, "wildcard"); err != nil {
+		t.Fatal(err)
+	}
+	if values := records[fqdn+"."]; !reflect.DeepEqual(values, []string{"apex"}) {
+		t.Errorf("values after the clean up: %v", values)
+	}
+}
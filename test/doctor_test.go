@@ -0,0 +1,73 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/internal/computing"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+)
+
+func selfSignedCert(t *testing.T, host string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestCheckCertificate(t *testing.T) {
+	inAYear := time.Now().Add(365 * 24 * time.Hour)
+	validCert, validKey := selfSignedCert(t, "*.example.test", inAYear)
+	soonCert, soonKey := selfSignedCert(t, "*.example.test", time.Now().Add(3*24*time.Hour))
+	expiredCert, expiredKey := selfSignedCert(t, "*.example.test", time.Now().Add(-time.Hour))
+	otherCert, otherKey := selfSignedCert(t, "*.other.test", inAYear)
+
+	tests := []struct {
+		name   string
+		cert   []byte
+		key    []byte
+		domain string
+		want   models.CheckStatus
+	}{
+		{"valid", validCert, validKey, "example.test", models.CheckPass},
+		{"no domain", otherCert, otherKey, "", models.CheckPass},
+		{"expires soon", soonCert, soonKey, "example.test", models.CheckWarn},
+		{"expired", expiredCert, expiredKey, "example.test", models.CheckFail},
+		{"other domain", otherCert, otherKey, "example.test", models.CheckFail},
+		{"key of another certificate", validCert, otherKey, "example.test", models.CheckFail},
+		{"not pem", []byte("cert"), []byte("key"), "example.test", models.CheckFail},
+	}
+	for _, tt := range tests {
+		result := computing.CheckCertificate(tt.cert, tt.key, tt.domain)
+		if result.Status != tt.want {
+			t.Errorf("%s: got %s (%s), want %s", tt.name, result.Status, result.Message, tt.want)
+		}
+		if result.Name != "tls" {
+			t.Errorf("%s: got name %q", tt.name, result.Name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lagrangedao/go-computing-provider/internal/gateway"
+)
+
+type fakeGatewayStore struct {
+	routes map[string]*gateway.Route
+	usage  map[string]gateway.Usage
+}
+
+func (s *fakeGatewayStore) RouteByKey(keyHash string) (*gateway.Route, error) {
+	if route, ok := s.routes[keyHash]; ok {
+		return route, nil
+	}
+	return nil, gateway.ErrUnknownKey
+}
+
+func (s *fakeGatewayStore) AddUsage(spaceUuid string, usage gateway.Usage) error {
+	total := s.usage[spaceUuid]
+	total.Requests += usage.Requests
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	s.usage[spaceUuid] = total
+	return nil
+}
+
+func gatewayServer(t *testing.T) (*httptest.Server, *fakeGatewayStore, string) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("the api key is passed to the space")
+		}
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "data: {\"choices\": [{\"delta\": {\"content\": \"%d\"}}]}\n\n", i)
+				w.(http.Flusher).Flush()
+			}
+			fmt.Fprint(w, "data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 5, \"completion_tokens\": 3}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		case "/v1/embeddings":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"data": [{"embedding": [0.1, 0.2]}], "usage": {"prompt_tokens": 4, "total_tokens": 4}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	apiKey, err := gateway.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeGatewayStore{
+		routes: map[string]*gateway.Route{
+			gateway.HashKey(apiKey): {SpaceUuid: "space-1", Model: "meta-llama/Llama-2-7b-chat-hf", Upstream: upstream.URL},
+		},
+		usage: make(map[string]gateway.Usage),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	gateway.New(store).Register(r.Group("/v1"))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, store, apiKey
+}
+
+func gatewayPost(t *testing.T, url, apiKey, body string) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestGatewayStreamsAndMeters(t *testing.T) {
+	server, store, apiKey := gatewayServer(t)
+
+	resp, body := gatewayPost(t, server.URL+"/v1/chat/completions", apiKey, `{"model": "meta-llama/Llama-2-7b-chat-hf", "stream": true}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if strings.Count(body, "data: ") != 5 || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("events are not passed through: %q", body)
+	}
+
+	resp, _ = gatewayPost(t, server.URL+"/v1/embeddings", apiKey, `{"model": "meta-llama/Llama-2-7b-chat-hf", "input": "hi"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	usage := store.usage["space-1"]
+	if usage.Requests != 2 || usage.PromptTokens != 9 || usage.CompletionTokens != 3 || usage.TotalTokens != 12 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestGatewayRejects(t *testing.T) {
+	server, store, apiKey := gatewayServer(t)
+
+	if resp, _ := gatewayPost(t, server.URL+"/v1/completions", "sk-lag-unknown", `{"model": "meta-llama/Llama-2-7b-chat-hf"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unknown key: status %d", resp.StatusCode)
+	}
+	resp, body := gatewayPost(t, server.URL+"/v1/completions", apiKey, `{"model": "gpt2"}`)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "model_not_found") {
+		t.Fatalf("other model: status %d %s", resp.StatusCode, body)
+	}
+	if len(store.usage) != 0 {
+		t.Fatalf("rejected requests are metered: %+v", store.usage)
+	}
+}
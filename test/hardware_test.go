@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lagrangedao/go-computing-provider/internal/hardware"
+	"github.com/lagrangedao/go-computing-provider/internal/models"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const cannedDcgmMetrics = `# HELP DCGM_FI_DEV_FB_FREE Framebuffer memory free (in MiB).
+# TYPE DCGM_FI_DEV_FB_FREE gauge
+DCGM_FI_DEV_FB_FREE{gpu="1",UUID="GPU-b",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-1",DCGM_FI_DRIVER_VERSION="535.104.05"} 40000
+DCGM_FI_DEV_FB_FREE{gpu="0",UUID="GPU-a",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-1",DCGM_FI_DRIVER_VERSION="535.104.05"} 30000
+# TYPE DCGM_FI_DEV_FB_USED gauge
+DCGM_FI_DEV_FB_USED{gpu="0",UUID="GPU-a",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-1",DCGM_FI_DRIVER_VERSION="535.104.05"} 10960
+DCGM_FI_DEV_FB_USED{gpu="1",UUID="GPU-b",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-1",DCGM_FI_DRIVER_VERSION="535.104.05"} 960
+DCGM_FI_DEV_GPU_TEMP{gpu="0",UUID="GPU-a",modelName="NVIDIA A100-SXM4-40GB"} 33
+a line that is not a metric
+`
+
+func TestParseDcgm(t *testing.T) {
+	gpu := hardware.ParseDcgm([]byte(cannedDcgmMetrics))
+	if gpu.AttachedGpus != 2 || gpu.DriverVersion != "535.104.05" {
+		t.Fatalf("gpu: %+v", gpu)
+	}
+	first := gpu.Details[0]
+	if first.ProductName != "NVIDIA A100-SXM4-40GB" || first.FbMemoryUsage.Total != "40960 MiB" || first.FbMemoryUsage.Used != "10960 MiB" {
+		t.Errorf("first gpu: %+v", first)
+	}
+	if gpu.Details[1].FbMemoryUsage.Free != "40000 MiB" {
+		t.Errorf("second gpu: %+v", gpu.Details[1])
+	}
+	if empty := hardware.ParseDcgm([]byte("# no metrics\n")); len(empty.Details) != 0 {
+		t.Errorf("gpus without metrics: %+v", empty)
+	}
+}
+
+func TestParseExporter(t *testing.T) {
+	gpu, err := hardware.ParseExporter([]byte(`{"machine_id": "m", "gpu": {"driver_version": "535.104.05", "cuda_version": "12.2", "attached_gpus": 1,
+		"details": [{"product_name": "NVIDIA GeForce RTX 3080", "fb_memory_usage": {"total": "10240 MiB", "used": "0 MiB", "free": "10240 MiB"}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gpu.CudaVersion != "12.2" || len(gpu.Details) != 1 || gpu.Details[0].ProductName != "NVIDIA GeForce RTX 3080" {
+		t.Errorf("gpu: %+v", gpu)
+	}
+	if _, err = hardware.ParseExporter([]byte("I0501 12:00:00 collecting...")); err == nil {
+		t.Errorf("parsed a log line")
+	}
+}
+
+func TestNodeGpu(t *testing.T) {
+	node := &coreV1.Node{
+		ObjectMeta: metaV1.ObjectMeta{Name: "node-1", Labels: map[string]string{
+			"nvidia.com/gpu.product":       "NVIDIA-A100-SXM4-40GB",
+			"nvidia.com/gpu.memory":        "40960",
+			"nvidia.com/cuda.driver.major": "535",
+			"nvidia.com/cuda.driver.minor": "104",
+			"nvidia.com/cuda.driver.rev":   "05",
+		}},
+		Status: coreV1.NodeStatus{Capacity: coreV1.ResourceList{"nvidia.com/gpu": resource.MustParse("2")}},
+	}
+	gpu := hardware.NodeGpu(node)
+	if gpu.AttachedGpus != 2 || gpu.DriverVersion != "535.104.05" {
+		t.Fatalf("gpu: %+v", gpu)
+	}
+	if gpu.Details[0].ProductName != "NVIDIA A100 SXM4 40GB" || gpu.Details[0].FbMemoryUsage.Total != "40960 MiB" {
+		t.Errorf("detail: %+v", gpu.Details[0])
+	}
+
+	cpuNode := &coreV1.Node{ObjectMeta: metaV1.ObjectMeta{Name: "node-2"}}
+	if gpu := hardware.NodeGpu(cpuNode); len(gpu.Details) != 0 {
+		t.Errorf("gpus of a cpu node: %+v", gpu)
+	}
+}
+
+type fakeCollector struct {
+	name  string
+	gpus  map[string]models.Gpu
+	err   error
+	calls int
+}
+
+func (f *fakeCollector) Name() string {
+	return f.name
+}
+
+func (f *fakeCollector) Collect(ctx context.Context) (map[string]models.Gpu, error) {
+	f.calls++
+	return f.gpus, f.err
+}
+
+func gpuOf(product string) models.Gpu {
+	return models.Gpu{AttachedGpus: 1, Details: []models.GpuDetail{{ProductName: product}}}
+}
+
+func TestHardwareFirstOf(t *testing.T) {
+	exporter := &fakeCollector{name: "exporter", gpus: map[string]models.Gpu{"node-1": gpuOf("NVIDIA A100")}}
+	labels := &fakeCollector{name: "labels", gpus: map[string]models.Gpu{"node-1": gpuOf("NVIDIA-GPU"), "node-2": gpuOf("NVIDIA T4")}}
+
+	gpus, err := hardware.FirstOf(exporter, labels).Collect(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gpus["node-1"].Details[0].ProductName != "NVIDIA A100" || gpus["node-2"].Details[0].ProductName != "NVIDIA T4" {
+		t.Errorf("gpus: %+v", gpus)
+	}
+
+	broken := &fakeCollector{name: "dcgm", err: errors.New("connection refused")}
+	if _, err = hardware.FirstOf(broken, labels).Collect(context.TODO()); err != nil {
+		t.Errorf("failed with a working collector: %v", err)
+	}
+	if _, err = hardware.FirstOf(broken).Collect(context.TODO()); err == nil {
+		t.Errorf("no error when all collectors failed")
+	}
+}
+
+func TestHardwareCache(t *testing.T) {
+	collector := &fakeCollector{name: "exporter", gpus: map[string]models.Gpu{"node-1": gpuOf("NVIDIA A100")}}
+	cache := hardware.NewCache(collector, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if gpus, err := cache.Collect(context.TODO()); err != nil || len(gpus) != 1 {
+			t.Fatalf("collect: %v %v", gpus, err)
+		}
+	}
+	if collector.calls != 1 {
+		t.Errorf("collected %d times within the ttl", collector.calls)
+	}
+
+	expired := hardware.NewCache(collector, 0)
+	expired.Collect(context.TODO())
+	collector.err = errors.New("timeout")
+	gpus, err := expired.Collect(context.TODO())
+	if err == nil || len(gpus) != 1 {
+		t.Errorf("a failed collection should serve the last result with its error: %v %v", gpus, err)
+	}
+}
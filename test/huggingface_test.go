@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/internal/huggingface"
+)
+
+func hubStub(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hf_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/models/openai/whisper-tiny":
+			w.Write([]byte(`{"id": "openai/whisper-tiny", "pipeline_tag": "automatic-speech-recognition", "library_name": "transformers"}`))
+		case "/api/models/gpt2":
+			w.Write([]byte(`{"modelId": "gpt2", "pipeline_tag": "text-generation", "tags": ["pytorch", "transformers"]}`))
+		case "/api/models/someone/no-task":
+			w.Write([]byte(`{"id": "someone/no-task", "library_name": "timm"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHuggingfaceModelInfo(t *testing.T) {
+	client := huggingface.NewClient(hubStub(t).URL, "hf_test")
+
+	info, err := client.ModelInfo(context.Background(), "openai/whisper-tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Task != "automatic-speech-recognition" || info.Framework != "transformers" {
+		t.Fatalf("unexpected model info: %+v", info)
+	}
+
+	info, err = client.ModelInfo(context.Background(), "gpt2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelId != "gpt2" || info.Framework != "transformers" {
+		t.Fatalf("framework is not taken from the tags: %+v", info)
+	}
+}
+
+func TestHuggingfaceModelInfoErrors(t *testing.T) {
+	server := hubStub(t)
+
+	if _, err := huggingface.NewClient(server.URL, "hf_test").ModelInfo(context.Background(), "missing/model"); !errors.Is(err, huggingface.ErrModelNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := huggingface.NewClient(server.URL, "").ModelInfo(context.Background(), "gpt2"); !errors.Is(err, huggingface.ErrModelNotFound) {
+		t.Fatalf("expected not found without token, got %v", err)
+	}
+	if _, err := huggingface.NewClient(server.URL, "hf_test").ModelInfo(context.Background(), "someone/no-task"); err == nil {
+		t.Fatalf("expected an error for a model without task")
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	"github.com/lagrangedao/go-computing-provider/internal/inference"
+)
+
+func TestRuntimeCatalogLookup(t *testing.T) {
+	catalog, err := inference.NewCatalog([]conf.Runtime{
+		{Framework: "transformers", Image: "lagrange/transformers:1.0"},
+		{Framework: "transformers", Accelerator: "gpu", Image: "lagrange/transformers-cuda:1.0"},
+		{Framework: "transformers", Task: "text-generation", Accelerator: "gpu", Image: "lagrange/tgi:1.4"},
+		{Framework: "transformers", Task: "text-generation", Accelerator: "NVIDIA-A100", Image: "lagrange/tgi-a100:1.4"},
+		{Framework: "diffusers", Accelerator: "gpu", Image: "lagrange/diffusers:0.25"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		framework, task, accelerator string
+		image                        string
+	}{
+		{"transformers", "fill-mask", inference.Accelerator(0, ""), "lagrange/transformers:1.0"},
+		{"transformers", "fill-mask", inference.Accelerator(1, "NVIDIA-T4"), "lagrange/transformers-cuda:1.0"},
+		{"transformers", "text-generation", inference.Accelerator(1, "NVIDIA-T4"), "lagrange/tgi:1.4"},
+		{"transformers", "text-generation", inference.Accelerator(2, "NVIDIA-A100"), "lagrange/tgi-a100:1.4"},
+		{"diffusers", "text-to-image", inference.Accelerator(1, "NVIDIA-A100"), "lagrange/diffusers:0.25"},
+		{"diffusers", "text-to-image", inference.Accelerator(0, ""), ""},
+	}
+	for _, tt := range tests {
+		runtime, ok := catalog.Lookup(tt.framework, tt.task, tt.accelerator)
+		if ok != (tt.image != "") || runtime.Image != tt.image {
+			t.Errorf("%s/%s on %s: got %q, want %q", tt.framework, tt.task, tt.accelerator, runtime.Image, tt.image)
+		}
+	}
+}
+
+func TestRuntimeCatalogRejectsUnversionedImages(t *testing.T) {
+	for _, image := range []string{"lagrange/transformers", "lagrange/transformers:latest", "registry:5000/transformers"} {
+		if _, err := inference.NewCatalog([]conf.Runtime{{Framework: "transformers", Image: image}}); err == nil {
+			t.Errorf("image %s is accepted", image)
+		}
+	}
+	if _, err := inference.NewCatalog([]conf.Runtime{{Framework: "transformers", Image: "registry:5000/transformers@sha256:abc"}}); err != nil {
+		t.Errorf("digest is rejected: %v", err)
+	}
+}
+
+func TestRuntimeCatalogWarmNodes(t *testing.T) {
+	catalog, _ := inference.NewCatalog(nil)
+	catalog.SetWarm([]string{"lagrange/transformers:1.0", "python:3.11"}, map[string][]string{
+		"node-b": {"docker.io/lagrange/transformers:1.0", "docker.io/library/python:3.11"},
+		"node-a": {"docker.io/lagrange/transformers:1.0"},
+		"node-c": {"docker.io/lagrange/transformers:0.9"},
+	})
+	if nodes := catalog.WarmNodes("lagrange/transformers:1.0"); !reflect.DeepEqual(nodes, []string{"node-a", "node-b"}) {
+		t.Fatalf("unexpected warm nodes %v", nodes)
+	}
+	if nodes := catalog.WarmNodes("python:3.11"); !reflect.DeepEqual(nodes, []string{"node-b"}) {
+		t.Fatalf("unexpected warm nodes %v", nodes)
+	}
+}
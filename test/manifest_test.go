@@ -0,0 +1,258 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/conf"
+	computing2 "github.com/lagrangedao/go-computing-provider/internal/computing"
+	"github.com/lagrangedao/go-computing-provider/internal/manifest"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func manifestPolicy() manifest.Policy {
+	return manifest.Policy{
+		Namespace:    "ns-0xabc",
+		Labels:       map[string]string{"lad_app": "space-1"},
+		NodeSelector: map[string]string{"NVIDIA-A100": "true"},
+		Limits: coreV1.ResourceList{
+			coreV1.ResourceCPU:    resource.MustParse("4"),
+			coreV1.ResourceMemory: resource.MustParse("8Gi"),
+		},
+	}
+}
+
+func TestManifestPolicyEnforce(t *testing.T) {
+	objects, err := manifest.Decode([]byte(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: kube-system
+spec:
+  ports:
+    - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: web}
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      containers:
+        - name: web
+          image: nginx
+          resources:
+            limits: {cpu: "1"}
+        - name: sidecar
+          image: busybox
+`), "web.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = manifestPolicy().Enforce(objects); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment := objects[1].Object.(*appV1.Deployment)
+	if deployment.Namespace != "ns-0xabc" || objects[0].Object.(*coreV1.Service).Namespace != "ns-0xabc" {
+		t.Fatalf("namespace is not forced")
+	}
+	if deployment.Labels["lad_app"] != "space-1" || deployment.Spec.Template.Labels["lad_app"] != "space-1" {
+		t.Fatalf("labels are not forced: %v", deployment.Spec.Template.Labels)
+	}
+	if deployment.Spec.Template.Spec.NodeSelector["NVIDIA-A100"] != "true" {
+		t.Fatalf("node selector is not forced: %v", deployment.Spec.Template.Spec.NodeSelector)
+	}
+	// 4 cpus - 2 replicas x 1 cpu, shared by the 2 replicas of the sidecar
+	sidecar := deployment.Spec.Template.Spec.Containers[1]
+	if cpu := sidecar.Resources.Limits.Cpu().String(); cpu != "1" {
+		t.Fatalf("unexpected cpu share %s", cpu)
+	}
+	if memory := sidecar.Resources.Requests.Memory().String(); memory != "2Gi" {
+		t.Fatalf("unexpected memory share %s", memory)
+	}
+}
+
+func TestManifestPolicyViolations(t *testing.T) {
+	objects, err := manifest.Decode([]byte(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: raw
+spec:
+  containers:
+    - name: raw
+      image: busybox
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: public
+spec:
+  type: NodePort
+  ports:
+    - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: greedy
+spec:
+  selector:
+    matchLabels: {app: greedy}
+  template:
+    metadata:
+      labels: {app: greedy}
+    spec:
+      hostNetwork: true
+      containers:
+        - name: greedy
+          image: busybox
+          ports:
+            - containerPort: 80
+              hostPort: 30080
+          resources:
+            limits: {cpu: "8"}
+`), "bad.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = manifestPolicy().Enforce(objects)
+	violations, ok := err.(manifest.Violations)
+	if !ok || len(violations) != 5 {
+		t.Fatalf("expected 5 violations, got %v", err)
+	}
+}
+
+func TestManifestRenderChart(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: demo\nversion: 0.1.0\n",
+		"values.yaml": "port: 8080\n",
+		"templates/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}
+  annotations:
+    host: {{ .Values.lagrange.hostName }}
+spec:
+  ports:
+    - port: {{ .Values.port }}
+`,
+		"templates/NOTES.txt": "deployed",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := manifest.RenderChart(dir, "space-1", "ns-0xabc", map[string]interface{}{
+		"lagrange": map[string]interface{}{"hostName": "abc.example.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, port, ok := manifest.FirstService(objects)
+	if len(objects) != 1 || !ok || name != "space-1" || port != 8080 {
+		t.Fatalf("unexpected objects: %+v", objects)
+	}
+	if host := objects[0].Object.(*coreV1.Service).Annotations["host"]; host != "abc.example.org" {
+		t.Fatalf("values are not injected: %s", host)
+	}
+}
+
+func TestManifestDecodeOldApiVersion(t *testing.T) {
+	_, err := manifest.Decode([]byte(`
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: old
+`), "old.yaml")
+	if err == nil || !strings.Contains(err.Error(), "use batch/v1") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestManifestToK8sReplacesPreviousDeploy(t *testing.T) {
+	cpRepo := t.TempDir()
+	config := `
+[API]
+MultiAddress = "/ip4/127.0.0.1/tcp/8085"
+Domain = ".example.test"
+RedisUrl = "redis://127.0.0.1:6379"
+[LAG]
+ServerUrl = "http://127.0.0.1"
+AccessToken = "test"
+[MCS]
+ApiKey = "test"
+BucketName = "test"
+Network = "polygon.mumbai"
+FileCachePath = "/tmp"
+[LOG]
+CrtFile = "/tmp/cp.crt"
+KeyFile = "/tmp/cp.key"
+[Registry]
+[Manifest]
+Enable = true
+`
+	if err := os.WriteFile(filepath.Join(cpRepo, "config.toml"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.InitConfig(cpRepo); err != nil {
+		t.Fatal(err)
+	}
+
+	manifestFile := filepath.Join(t.TempDir(), "web.yaml")
+	if err := os.WriteFile(manifestFile, []byte(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deleter fails, so the deploy stops right after it replaced the previous one, without a cluster
+	errDelete := errors.New("delete failed")
+	var deleted []string
+	deploy := computing2.NewDeploy("job-1", "abc.example.test", "0xABC", "CPU only · 2 vCPU · 4 GiB", 3600).
+		WithSpaceInfo("space-1", "web").
+		WithManifests("", []string{manifestFile}).
+		WithJobDeleter(func(namespace, spaceUuid string) error {
+			deleted = append(deleted, namespace+"/"+spaceUuid)
+			return errDelete
+		})
+
+	if err := deploy.ManifestToK8s(); !errors.Is(err, errDelete) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"ns-0xabc/space-1"}) {
+		t.Fatalf("unexpected deletes: %v", deleted)
+	}
+	if !deploy.Replaced() {
+		t.Fatalf("deploy is not marked as replaced")
+	}
+}
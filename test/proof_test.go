@@ -0,0 +1,181 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lagrangedao/go-computing-provider/internal/proof"
+)
+
+func mineNonce(blockData string, exp int) (string, string) {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(blockData + nonce))
+		zeros := 0
+		for _, b := range sum {
+			if b != 0 {
+				for ; b&0x80 == 0; b <<= 1 {
+					zeros++
+				}
+				break
+			}
+			zeros += 8
+		}
+		if zeros >= exp {
+			return nonce, hex.EncodeToString(sum[:])
+		}
+	}
+}
+
+func TestProofRegistry(t *testing.T) {
+	registry := proof.NewRegistry()
+	if err := registry.Register(proof.Method{Name: "empty"}); err == nil {
+		t.Errorf("registered a method without image")
+	}
+	if err := registry.Register(proof.Mine()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := registry.Lookup("gpu"); !errors.Is(err, proof.ErrUnknownMethod) {
+		t.Errorf("lookup of an unknown method: %v", err)
+	}
+	method, err := registry.Lookup("mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method.Timeout <= 0 {
+		t.Errorf("method without timeout")
+	}
+	if names := registry.Names(); len(names) != 1 || names[0] != "mine" {
+		t.Errorf("names: %v", names)
+	}
+}
+
+func TestMineProof(t *testing.T) {
+	mine := proof.Mine()
+	params, _ := json.Marshal(proof.MineParams{BlockData: "block", Exp: 8})
+
+	env, err := mine.Env(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["BLOCK_DATA"] != "block" || env["EXP"] != "8" {
+		t.Errorf("env: %v", env)
+	}
+	for _, invalid := range []string{`{"exp": 8}`, `{"block_data": "block", "exp": 251}`, `[]`} {
+		if _, err := mine.Env(json.RawMessage(invalid)); err == nil {
+			t.Errorf("accepted params %s", invalid)
+		}
+	}
+
+	nonce, hash := mineNonce("block", 8)
+	output := "mining...\n" + `{"nonce": "` + nonce + `", "hash": "` + hash + `"}` + "\n"
+	result, err := mine.Parse(params, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(proof.MineResult).Nonce != nonce || !result.(proof.MineResult).Verified {
+		t.Errorf("result: %+v", result)
+	}
+
+	textLog := "start mining, exp: 8\nnonce: " + nonce + "\nhash: " + hash + "\ndone\n"
+	if result, err = mine.Parse(params, textLog); err != nil || result.(proof.MineResult).Hash != hash {
+		t.Errorf("result of a text log: %+v, %v", result, err)
+	}
+	result, err = mine.Parse(params, "no result\n")
+	if err != nil || result.(proof.MineResult).Verified || result.(proof.MineResult).Log != "no result\n" {
+		t.Errorf("a log without result should be returned as it is: %+v, %v", result, err)
+	}
+	forged := `{"nonce": "` + nonce + `1", "hash": "` + hash + `"}`
+	if _, err = mine.Parse(params, forged); err == nil {
+		t.Errorf("accepted a hash of another nonce")
+	}
+	harder, _ := json.Marshal(proof.MineParams{BlockData: "block", Exp: 64})
+	if _, err = mine.Parse(harder, output); err == nil {
+		t.Errorf("accepted a hash below the difficulty")
+	}
+}
+
+const cannedGpuBenchmark = `Downloading...
+{"devices": [{"uuid": "GPU-5a9d3c1e-0b7f-4c3e-9a61-2f8e7d6c5b4a", "name": "NVIDIA A100-PCIE-40GB"}], "tflops": 182.4471, "nonce": "0123456789abcdef0123"}
+`
+
+func TestGpuProof(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := proof.NewSigner(key)
+	gpu := proof.Gpu(signer)
+	params, _ := json.Marshal(proof.GpuParams{GpuModel: "NVIDIA A100", Nonce: "0123456789abcdef0123"})
+
+	env, err := gpu.Env(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["NONCE"] != "0123456789abcdef0123" {
+		t.Errorf("env: %v", env)
+	}
+	if selector := gpu.NodeSelector(params); selector["NVIDIA-A100"] != "true" {
+		t.Errorf("node selector: %v", selector)
+	}
+	if _, err = gpu.Env(json.RawMessage(`{"gpu_model": "NVIDIA A100", "nonce": "short"}`)); err == nil {
+		t.Errorf("accepted a short nonce")
+	}
+
+	result, err := gpu.Parse(params, cannedGpuBenchmark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := result.(*proof.GpuAttestation)
+	if attestation.NodeId != signer.NodeId() || len(attestation.Devices) != 1 || attestation.Tflops != 182.4471 {
+		t.Errorf("attestation: %+v", attestation)
+	}
+
+	// the platform gets the attestation as JSON
+	data, _ := json.Marshal(attestation)
+	var received proof.GpuAttestation
+	json.Unmarshal(data, &received)
+	if err = received.Verify(); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	received.Tflops = 312
+	if err = received.Verify(); err == nil {
+		t.Errorf("verified a tampered throughput")
+	}
+
+	other, _ := crypto.GenerateKey()
+	forged, _ := proof.AttestGpu(proof.NewSigner(other), proof.GpuParams{GpuModel: "NVIDIA A100", Nonce: attestation.Nonce},
+		proof.GpuBenchmark{Devices: attestation.Devices, Tflops: attestation.Tflops, Nonce: attestation.Nonce})
+	forged.NodeId = attestation.NodeId
+	if err = forged.Verify(); err == nil {
+		t.Errorf("verified an attestation signed by another node")
+	}
+}
+
+func TestGpuProofRejects(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	gpu := proof.Gpu(proof.NewSigner(key))
+
+	tests := []struct {
+		name   string
+		params proof.GpuParams
+		output string
+	}{
+		{"other model", proof.GpuParams{GpuModel: "NVIDIA H100", Nonce: "0123456789abcdef0123"}, cannedGpuBenchmark},
+		{"other nonce", proof.GpuParams{GpuModel: "NVIDIA A100", Nonce: "fedcba9876543210fedc"}, cannedGpuBenchmark},
+		{"no devices", proof.GpuParams{GpuModel: "NVIDIA A100", Nonce: "0123456789abcdef0123"}, `{"devices": [], "tflops": 10, "nonce": "0123456789abcdef0123"}`},
+		{"no result", proof.GpuParams{GpuModel: "NVIDIA A100", Nonce: "0123456789abcdef0123"}, "RuntimeError: CUDA error: no kernel image is available"},
+	}
+	for _, tt := range tests {
+		params, _ := json.Marshal(tt.params)
+		if _, err := gpu.Parse(params, tt.output); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
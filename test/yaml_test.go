@@ -0,0 +1,452 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lagrangedao/go-computing-provider/internal/yaml"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func writeDeployYaml(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHandlerYamlMultiService(t *testing.T) {
+	path := writeDeployYaml(t, `
+version: "2.0"
+services:
+  web:
+    image: nginx
+    depends-on:
+      - api
+    expose:
+      - port: 80
+        as: 80
+        to:
+          - global: true
+  api:
+    image: api
+    depends-on:
+      - db
+    sidecars:
+      - cache
+    expose:
+      - port: 8080
+  db:
+    image: postgres
+    ready-cmd: ["pg_isready"]
+    expose:
+      - port: 5432
+        protocol: tcp
+  cache:
+    image: redis
+deployment:
+  web:
+    lagrange:
+      count: 1
+`)
+
+	resources, err := yaml.HandlerYaml(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, cr := range resources {
+		order = append(order, cr.Name)
+	}
+	if len(order) != 3 || order[0] != "db" || order[1] != "api" || order[2] != "web" {
+		t.Fatalf("unexpected startup order: %v", order)
+	}
+	if len(resources[1].Sidecars) != 1 || resources[1].Sidecars[0].Name != "cache" {
+		t.Fatalf("cache should be a sidecar of api: %+v", resources[1].Sidecars)
+	}
+	if len(resources[0].HttpPorts()) != 0 || len(resources[2].HttpPorts()) != 1 {
+		t.Fatalf("only web and api should have http ports")
+	}
+}
+
+func TestHandlerYamlDependsOnCycle(t *testing.T) {
+	path := writeDeployYaml(t, `
+version: "2.0"
+services:
+  a:
+    image: a
+    depends-on: [b]
+  b:
+    image: b
+    depends-on: [a]
+deployment:
+  a:
+    lagrange:
+      count: 1
+`)
+
+	if _, err := yaml.HandlerYaml(path); err == nil {
+		t.Fatal("expected a depends-on cycle error")
+	}
+}
+
+func TestValidateYamlErrors(t *testing.T) {
+	_, err := yaml.Validate([]byte(`version: "2.0"
+services:
+  web:
+    image: nginx
+    imagee: nginx
+    env:
+      - BROKEN
+deployment:
+  web:
+    lagrange:
+      count: 1
+`))
+	validationErrors, ok := err.(yaml.ValidationErrors)
+	if !ok || len(validationErrors) != 2 {
+		t.Fatalf("expected 2 validation errors, got %v", err)
+	}
+	if e := validationErrors[0]; e.Line != 5 || e.Column != 5 || e.Path != "services.web" {
+		t.Fatalf("unexpected position of the unknown key: %+v", e)
+	}
+	if e := validationErrors[1]; e.Line != 7 || e.Column != 9 {
+		t.Fatalf("unexpected position of the env entry: %+v", e)
+	}
+}
+
+func TestValidateYamlVersion(t *testing.T) {
+	_, err := yaml.Validate([]byte(`version: "9.0"
+services: {}
+`))
+	if err == nil || !strings.Contains(err.Error(), "not support yaml version: 9.0") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHandlerYamlV3(t *testing.T) {
+	path := writeDeployYaml(t, `
+version: "3.0"
+services:
+  app:
+    image: app
+    env:
+      - URL=http://a?b=c
+    replicas: 2
+    resources:
+      cpu: 500m
+      memory: 1Gi
+    volumes:
+      - name: data
+        mount: /data
+        size: 5Gi
+    secrets:
+      - name: app-secret
+        key: token
+        env: TOKEN
+    probes:
+      liveness:
+        http-get:
+          path: /health
+          port: 8080
+    expose:
+      - port: 8080
+deployment:
+  app:
+    lagrange:
+      count: 1
+`)
+
+	resources, err := yaml.HandlerYaml(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := resources[0]
+	if app.Env[0].Value != "http://a?b=c" {
+		t.Fatalf("env value cut at '=': %q", app.Env[0].Value)
+	}
+	if app.Count != 2 || len(app.Volumes) != 1 || len(app.Secrets) != 1 {
+		t.Fatalf("unexpected resource: %+v", app)
+	}
+	if app.LivenessProbe == nil || app.LivenessProbe.HTTPGet.Path != "/health" {
+		t.Fatalf("liveness probe missing: %+v", app.LivenessProbe)
+	}
+	if cpu := app.Resources.Limits.Cpu().String(); cpu != "500m" {
+		t.Fatalf("unexpected cpu limit %s", cpu)
+	}
+}
+
+func TestLoadCompose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err := os.WriteFile(path, []byte(`
+version: "3.8"
+services:
+  web:
+    build:
+      context: ./web
+      args:
+        MODE: prod
+    command: npm run "start prod"
+    environment:
+      API_URL: http://api:8080
+      FROM_HOST:
+    ports:
+      - "80:3000"
+    depends_on:
+      api:
+        condition: service_healthy
+  api:
+    image: api:1.0
+    restart: always
+    ports:
+      - target: 5000
+        published: 5000
+        protocol: udp
+        x-global: true
+      - "5432:5432"
+    expose:
+      - "8080"
+    volumes:
+      - data:/data
+      - ./conf:/etc/conf
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost:8080"]
+      interval: 10s
+      retries: 3
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+        reservations:
+          devices:
+            - capabilities: [gpu]
+              count: 1
+volumes:
+  data:
+    x-size: 5Gi
+networks:
+  default: {}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, warnings, err := yaml.LoadYaml(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 || resources[0].Name != "api" || resources[1].Name != "web" {
+		t.Fatalf("unexpected startup order: %+v", resources)
+	}
+
+	api, web := resources[0], resources[1]
+	if web.Build == nil || web.Build.Context != filepath.Join(dir, "web") || *web.Build.Args["MODE"] != "prod" {
+		t.Fatalf("unexpected build context: %+v", web.Build)
+	}
+	if len(web.Args) != 3 || web.Args[2] != "start prod" {
+		t.Fatalf("unexpected command: %q", web.Args)
+	}
+	if len(web.Env) != 1 || web.Env[0].Name != "API_URL" {
+		t.Fatalf("unexpected env: %+v", web.Env)
+	}
+	if ports := web.HttpPorts(); len(ports) != 1 || ports[0].Port != 3000 || ports[0].As != 80 {
+		t.Fatalf("the first port of web should be served over http: %+v", web.Expose)
+	}
+	if ports := api.L4Ports(); len(ports) != 1 || ports[0].Protocol != "udp" {
+		t.Fatalf("only the opted in port of api should be published: %+v", api.Expose)
+	}
+	if len(api.Ports) != 3 {
+		t.Fatalf("every port of api should be reachable inside the space: %+v", api.Ports)
+	}
+	if len(api.Volumes) != 1 || api.Volumes[0].Size != "5Gi" {
+		t.Fatalf("unexpected volumes: %+v", api.Volumes)
+	}
+	if api.ReadinessProbe == nil || api.ReadinessProbe.PeriodSeconds != 10 || api.ReadinessProbe.FailureThreshold != 3 {
+		t.Fatalf("unexpected readiness probe: %+v", api.ReadinessProbe)
+	}
+	if memory := api.Resources.Limits.Memory().String(); memory != "512Mi" {
+		t.Fatalf("unexpected memory limit %s", memory)
+	}
+	if gpu := api.Resources.Limits["nvidia.com/gpu"]; gpu.Value() != 1 {
+		t.Fatalf("unexpected gpu limit %s", gpu.String())
+	}
+
+	// FROM_HOST, restart, the bind mount and networks
+	if len(warnings) != 4 {
+		t.Fatalf("expected 4 warnings, got %v", warnings)
+	}
+
+	// a worker started after the web service doesn't take its hostname
+	if err = os.WriteFile(path, []byte(`
+services:
+  web:
+    image: nginx
+    ports: ["80:80"]
+    healthcheck:
+      test: ["CMD", "true"]
+      timeout: 500ms
+  worker:
+    image: worker:1.0
+    depends_on: [web]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resources, _, err = yaml.LoadYaml(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 || resources[0].Name != "web" || resources[1].Name != "worker" {
+		t.Fatalf("unexpected startup order: %+v", resources)
+	}
+	web = resources[0]
+	if ports := web.HttpPorts(); len(ports) != 1 || ports[0].Port != 80 || !ports[0].Global {
+		t.Fatalf("the port of web should be served over http: %+v", web.Expose)
+	}
+	if web.ReadinessProbe == nil || web.ReadinessProbe.TimeoutSeconds != 1 {
+		t.Fatalf("a sub-second timeout should round up to 1s: %+v", web.ReadinessProbe)
+	}
+}
+
+func TestLoadComposeBuildOutsideSpace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := os.WriteFile(path, []byte(`
+services:
+  app:
+    build: ../..
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := yaml.LoadYaml(path); err == nil {
+		t.Fatal("expected an error for a build context outside of the space")
+	}
+}
+
+func TestTemplateResolveEnv(t *testing.T) {
+	tc := yaml.TemplateContext{
+		SpaceUrl:     "https://abc.example.org",
+		SpaceUuid:    "space-1",
+		Wallet:       "0xabc",
+		JobUuid:      "job-1",
+		ServiceHosts: map[string]string{"db": "10.0.0.5"},
+	}
+	cr := yaml.ContainerResource{Name: "web", Env: []corev1.EnvVar{
+		{Name: "NEXTAUTH_URL", Value: "${SPACE_URL}/api/auth"},
+		{Name: "DB", Value: "postgres://${SERVICE_HOST:db}:5432/${SPACE_UUID}"},
+		{Name: "TOKEN", Value: "${SECRET:api-token/token}"},
+		{Name: "LITERAL", Value: "$${HOME}"},
+	}}
+	if err := tc.ResolveEnv(&cr); err != nil {
+		t.Fatal(err)
+	}
+	if cr.Env[0].Value != "https://abc.example.org/api/auth" || cr.Env[1].Value != "postgres://10.0.0.5:5432/space-1" {
+		t.Fatalf("unexpected env: %+v", cr.Env)
+	}
+	if ref := cr.Env[2].ValueFrom.SecretKeyRef; cr.Env[2].Value != "" || ref.Name != "api-token" || ref.Key != "token" {
+		t.Fatalf("unexpected secret reference: %+v", cr.Env[2])
+	}
+	if cr.Env[3].Value != "${HOME}" {
+		t.Fatalf("unexpected escaped value: %q", cr.Env[3].Value)
+	}
+
+	for _, value := range []string{"${UNKNOWN}", "${SERVICE_HOST:cache}", "Bearer ${SECRET:api-token}", "${SPACE_URL"} {
+		cr := yaml.ContainerResource{Name: "web", Env: []corev1.EnvVar{{Name: "V", Value: value}}}
+		if err := tc.ResolveEnv(&cr); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestHandlerYamlUnknownTemplate(t *testing.T) {
+	path := writeDeployYaml(t, `
+version: "2.0"
+services:
+  web:
+    image: nginx
+    env:
+      - API=http://${SERVICE_HOST:web}
+      - URL=${SPACE_ULR}
+    expose:
+      - port: 80
+deployment:
+  web:
+    lagrange:
+      count: 1
+`)
+	if _, err := yaml.HandlerYaml(path); err == nil || !strings.Contains(err.Error(), "SPACE_ULR") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHandlerYamlServiceHostOfSidecar(t *testing.T) {
+	path := writeDeployYaml(t, `
+version: "2.0"
+services:
+  web:
+    image: nginx
+    sidecars:
+      - cache
+    env:
+      - CACHE=${SERVICE_HOST:cache}
+    expose:
+      - port: 80
+  cache:
+    image: redis
+deployment:
+  web:
+    lagrange:
+      count: 1
+`)
+	if _, err := yaml.HandlerYaml(path); err == nil || !strings.Contains(err.Error(), "SERVICE_HOST:cache") {
+		t.Fatalf("expected an error for the address of a sidecar, got %v", err)
+	}
+}
+
+func TestComposeTemplateVariables(t *testing.T) {
+	tc := yaml.TemplateContext{
+		SpaceUrl:     "https://abc.example.org",
+		ServiceHosts: map[string]string{"db": "svc-1-db.ns.svc"},
+		Compose:      true,
+	}
+	cr := yaml.ContainerResource{Name: "web", Env: []corev1.EnvVar{
+		{Name: "URL", Value: "${SPACE_URL}"},
+		{Name: "DB", Value: "${SERVICE_HOST:db}:${DB_PORT:-5432}"},
+		{Name: "MODE", Value: "${MODE-prod}"},
+		{Name: "UNSET", Value: "${UNSET}"},
+	}}
+	if err := tc.ResolveEnv(&cr); err != nil {
+		t.Fatal(err)
+	}
+	if cr.Env[0].Value != "https://abc.example.org" || cr.Env[1].Value != "svc-1-db.ns.svc:5432" || cr.Env[2].Value != "prod" || cr.Env[3].Value != "" {
+		t.Fatalf("unexpected env: %+v", cr.Env)
+	}
+
+	required := yaml.ContainerResource{Name: "web", Env: []corev1.EnvVar{{Name: "TOKEN", Value: "${TOKEN:?set the token}"}}}
+	if err := tc.ResolveEnv(&required); err == nil {
+		t.Fatal("expected an error for a required variable")
+	}
+}
+
+func TestValidateYamlModelSha256(t *testing.T) {
+	_, err := yaml.Validate([]byte(`version: "2.0"
+services:
+  llm:
+    image: llm
+    models:
+      - name: weights.bin
+        url: https://example.org/weights.bin
+        dir: /models
+        sha256: not-a-checksum
+deployment:
+  llm:
+    lagrange:
+      count: 1
+`))
+	validationErrors, ok := err.(yaml.ValidationErrors)
+	if !ok || len(validationErrors) != 1 || validationErrors[0].Path != "services.llm.models[0].sha256" {
+		t.Fatalf("expected an invalid sha256, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	certMutex   sync.RWMutex
+	certCurrent *tls.Certificate
+)
+
+// LoadCertificate replaces the certificate served by ServeHttp. Connections
+// opened after the call use the new certificate, so renewals need no restart.
+func LoadCertificate(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	certMutex.Lock()
+	defer certMutex.Unlock()
+	certCurrent = &cert
+	return nil
+}
+
+// LoadCertificateFile is LoadCertificate for PEM files on disk.
+func LoadCertificateFile(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if len(cert.Certificate) > 0 {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	certMutex.Lock()
+	defer certMutex.Unlock()
+	certCurrent = &cert
+	return nil
+}
+
+// CertificateExpiry returns the NotAfter of the served certificate, or the zero time if none is loaded.
+func CertificateExpiry() time.Time {
+	certMutex.RLock()
+	defer certMutex.RUnlock()
+	if certCurrent == nil || certCurrent.Leaf == nil {
+		return time.Time{}
+	}
+	return certCurrent.Leaf.NotAfter
+}
+
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMutex.RLock()
+	defer certMutex.RUnlock()
+	if certCurrent == nil {
+		return nil, errors.New("no certificate loaded yet")
+	}
+	return certCurrent, nil
+}
@@ -55,6 +55,21 @@ const (
 	ProofParamError   = 8001
 	ProofReadLogError = 8002
 	ProofError        = 8003
+
+	CustomDomainParamError   = 8101
+	CustomDomainVerifyError  = 8102
+	CustomDomainError        = 8103
+	CustomDomainUnauthorized = 8104
+
+	GatewayParamError   = 8201
+	GatewayError        = 8202
+	GatewayUnauthorized = 8203
+
+	BatchParamError = 8301
+	BatchError      = 8302
+	BatchRejected   = 8303
+
+	DoctorUnauthorized = 8401
 )
 
 var codeMsg = map[int]string{
@@ -62,4 +77,16 @@ var codeMsg = map[int]string{
 
 	ProofReadLogError: "An error occurred while read the log of proof",
 	ProofError:        "An error occurred while executing the calculation task",
+
+	CustomDomainVerifyError:  "The ownership of the domain could not be verified",
+	CustomDomainError:        "An error occurred while attaching the custom domain",
+	CustomDomainUnauthorized: "The request is not signed by the owner of the space",
+
+	GatewayError:        "An error occurred while reading the gateway data of the space",
+	GatewayUnauthorized: "The request is not signed by the owner of the space",
+
+	BatchError:    "An error occurred while reading the batch job",
+	BatchRejected: "The batch job is rejected by the bid policy",
+
+	DoctorUnauthorized: "The request is not made by the provider",
 }
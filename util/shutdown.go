@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/filswan/go-mcs-sdk/mcs/api/common/logs"
 	"github.com/lagrangedao/go-computing-provider/conf"
@@ -58,17 +59,26 @@ func ServeHttp(h http.Handler, name string, addr string) (StopFunc, error) {
 		Addr:              addr,
 		Handler:           h,
 		ReadHeaderTimeout: 60 * time.Second,
+		TLSConfig: &tls.Config{
+			GetCertificate: getCertificate,
+		},
 	}
 
 	go func() {
 		certFile := conf.GetConfig().LOG.CrtFile
 		keyFile := conf.GetConfig().LOG.KeyFile
-		if _, err := os.Stat(certFile); err != nil {
+		if _, err := os.Stat(certFile); err == nil {
+			if err = LoadCertificateFile(certFile, keyFile); err != nil {
+				logs.GetLogger().Fatalf("failed load the wss authentication certificate, error: %v", err)
+				return
+			}
+		} else if !conf.GetConfig().ACME.Enable {
 			logs.GetLogger().Fatalf("need to manually generate the wss authentication certificate.")
 			return
 		}
 
-		if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		// the certificate is served through TLSConfig.GetCertificate, so ACME renewals take effect without a restart
+		if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logs.GetLogger().Fatalf("service: %s, listen: %s\n", name, err)
 		}
 
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"os"
+	"time"
+)
+
+// WatchFile calls onChange whenever the modification time or the size of path changes, as seen by
+// polling it every interval. A file that appears or disappears is a change too.
+func WatchFile(path string, interval time.Duration, onChange func()) {
+	stamp := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	go func() {
+		lastMod, lastSize := stamp()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			mod, size := stamp()
+			if !mod.Equal(lastMod) || size != lastSize {
+				lastMod, lastSize = mod, size
+				onChange()
+			}
+		}
+	}()
+}